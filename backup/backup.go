@@ -15,19 +15,41 @@ import (
  */
 func initializeFlags() {
 	backupDir = flag.String("backupdir", "", "The absolute path of the directory to which all backup files will be written")
-	compressionLevel = flag.Int("compression-level", 0, "Level of compression to use during data backup. Valid values are between 1 and 9.")
+	compressionLevel = flag.Int("compression-level", 0, "Level of compression to use during data backup. Valid values are between 1 and 9 for gzip, 1 and 9 for lz4, and 1 and 19 for zstd.")
+	compressionType = flag.String("compression-type", "gzip", "Type of compression to use during data backup. Valid values are gzip, lz4, zstd, and none.")
+	dataDelimiter = flag.String("data-delimiter", "", "The delimiter to use for the text data format.  Defaults to a tab character.")
+	dataFormat = flag.String("data-format", "csv", "The format in which to write table data. Valid values are csv, text, and binary.  The binary format requires GPDB 6 or later.")
+	dataNullString = flag.String("data-null-string", "", "The string that represents a null value in the text data format.  Defaults to \\N.")
 	dataOnly = flag.Bool("data-only", false, "Only back up data, do not back up metadata")
 	dbname = flag.String("dbname", "", "The database to be backed up")
 	debug = flag.Bool("debug", false, "Print verbose and debug log messages")
+	diskSpaceWarningThreshold = flag.Int("disk-space-warning-threshold", 90, "Warn if a backup would leave a segment filesystem at least this percent full")
+	dryRun = flag.Bool("dry-run", false, "Report the tables that would be backed up, their sizes, and the free space on each segment, without backing anything up")
+	encrypt = flag.Bool("encrypt", false, "Encrypt data files and metadata files on the master.  Requires --encryption-key-file or --encryption-key-command.")
+	encryptionKeyCommand = flag.String("encryption-key-command", "", "A command that writes the key to use for encryption to stdout")
+	encryptionKeyFile = flag.String("encryption-key-file", "", "A file containing the key to use for encryption")
 	flag.Var(&excludeSchemas, "exclude-schema", "Do not back up only the specified schema(s). --exclude-schema can be specified multiple times.")
+	flag.Var(&excludeSchemaPatterns, "exclude-schema-pattern", "Do not back up schemas whose names match the specified glob pattern. --exclude-schema-pattern can be specified multiple times.")
 	excludeTableFile = flag.String("exclude-table-file", "", "A file containing a list of fully-qualified tables to be excluded from the backup")
+	flag.Var(&excludeTablePatterns, "exclude-table-pattern", "Do not back up tables whose fully-qualified names match the specified glob pattern. --exclude-table-pattern can be specified multiple times.")
+	fromTimestamp = flag.String("from-timestamp", "", "The timestamp of the backup to use as the base for an incremental backup")
+	includeExternalData = flag.Bool("include-external-data", false, "Back up the data read from readable external tables, including external leaf partitions")
 	flag.Var(&includeSchemas, "include-schema", "Back up only the specified schema(s). --include-schema can be specified multiple times.")
-	includeTableFile = flag.String("include-table-file", "", "A file containing a list of fully-qualified tables to be included in the backup")
+	flag.Var(&includeSchemaPatterns, "include-schema-pattern", "Back up only schemas whose names match the specified glob pattern. --include-schema-pattern can be specified multiple times.")
+	includeTableFile = flag.String("include-table-file", "", "A file containing a list of fully-qualified tables to be included in the backup, each optionally followed by a WHERE clause limiting the rows backed up")
+	flag.Var(&includeTablePatterns, "include-table-pattern", "Back up only tables whose fully-qualified names match the specified glob pattern. --include-table-pattern can be specified multiple times.")
+	incremental = flag.Bool("incremental", false, "Only back up data for append-optimized tables that have been modified since the backup specified by --from-timestamp")
+	numJobs = flag.Int("jobs", 1, "The number of parallel connections to use when backing up table data.  If greater than 1, an additional connection is used for metadata.")
 	leafPartitionData = flag.Bool("leaf-partition-data", false, "For partition tables, create one data file per leaf partition instead of one data file for the whole table")
+	maskingRulesFile = flag.String("masking-rules-file", "", "A YAML file of rules for masking the values of columns as their data is backed up")
 	metadataOnly = flag.Bool("metadata-only", false, "Only back up metadata, do not back up data")
+	nativeSSH = flag.Bool("native-ssh", false, "Run commands on the segment hosts over one SSH connection to each host, instead of running ssh for each segment")
 	noCompression = flag.Bool("no-compression", false, "Disable compression of data files")
+	noDiskSpaceCheck = flag.Bool("no-disk-space-check", false, "Do not check that the segments have enough free disk space for the backup")
+	pluginConfigFile = flag.String("plugin-config", "", "The configuration file to use for a plugin that will store backup files in external storage")
 	printVersion = flag.Bool("version", false, "Print version number and exit")
 	quiet = flag.Bool("quiet", false, "Suppress non-warning, non-error log messages")
+	resume = flag.String("resume", "", "The timestamp of an interrupted backup to resume.  All other options must match those of the interrupted backup.")
 	singleDataFile = flag.Bool("single-data-file", false, "Back up all data to a single file instead of one per table")
 	verbose = flag.Bool("verbose", false, "Print verbose log messages")
 	withStats = flag.Bool("with-stats", false, "Back up query plan statistics")
@@ -56,21 +78,56 @@ func DoFlagValidation() {
 // This function handles setup that must be done after parsing flags.
 func DoSetup() {
 	SetLoggerVerbosity()
+	utils.InitializeSignalHandler(CancelQueriesInProgress, "backup")
 	timestamp := utils.CurrentTimestamp()
-	utils.CreateBackupLockFile(timestamp)
-	logger.Info("Starting backup of database %s", *dbname)
+	if *resume != "" {
+		timestamp = *resume
+	}
+	if !*dryRun {
+		timestampLockFile = utils.CreateBackupLockFile(timestamp)
+	}
+	if *resume != "" {
+		logger.Info("Resuming backup %s of database %s", timestamp, *dbname)
+	} else {
+		logger.Info("Starting backup of database %s", *dbname)
+	}
 	InitializeConnection()
 
 	InitializeFilterLists()
+	if *maskingRulesFile != "" {
+		maskingRules = ReadMaskingRules(*maskingRulesFile)
+		maskingHashSalt = NewMaskingHashSalt()
+	}
 	InitializeBackupReport()
 	validateFilterLists()
 
 	segConfig := utils.GetSegmentConfiguration(connection)
 	segPrefix := utils.GetSegPrefix(connection)
 	globalCluster = utils.NewCluster(segConfig, *backupDir, timestamp, segPrefix)
+	if *nativeSSH {
+		globalCluster.Executor = utils.NewSSHExecutor()
+	}
+	if *dryRun {
+		globalTOC = &utils.TOC{}
+		globalTOC.InitializeEntryMap()
+		return
+	}
 	globalCluster.CreateBackupDirectoriesOnAllHosts()
+	if *pluginConfigFile != "" {
+		pluginConfig = utils.ReadPluginConfig(*pluginConfigFile)
+		pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
+		pluginConfig.CopyPluginConfigToAllHosts(globalCluster, *pluginConfigFile)
+		pluginConfig.SetupPluginForBackup(globalCluster)
+	}
+	if *encrypt {
+		utils.InitializeEncryption(globalCluster, *encryptionKeyFile, *encryptionKeyCommand)
+		backupReport.EncryptionKeyFingerprint = utils.NewEncryptionKeyFingerprint()
+	}
 	globalTOC = &utils.TOC{}
 	globalTOC.InitializeEntryMap()
+	if *resume != "" {
+		InitializeResume()
+	}
 }
 
 func DoBackup() {
@@ -78,35 +135,105 @@ func DoBackup() {
 
 	objectCounts = make(map[string]int, 0)
 
+	var baseConfig *utils.BackupConfig
+	var baseTOC *utils.TOC
+	if *incremental && backupProgress == nil {
+		logger.Info("Using backup %s as the base for an incremental backup", *fromTimestamp)
+		baseConfig, baseTOC = GetIncrementalBaseBackup()
+	}
+
 	metadataTables, dataTables, tableDefs := RetrieveAndProcessTables()
-	CheckTablesContainData(dataTables, tableDefs)
+	if *dryRun {
+		DoDryRun(metadataTables, dataTables, tableDefs, baseConfig, baseTOC)
+		return
+	}
 	metadataFilename := globalCluster.GetMetadataFilePath()
-	logger.Verbose("Metadata will be written to %s", metadataFilename)
-	metadataFile := utils.NewFileWithByteCountFromFile(metadataFilename)
-	defer metadataFile.Close()
-	if !*dataOnly {
-		isTableFiltered := len(includeTables) > 0 || len(excludeTables) > 0
-		if isTableFiltered {
-			backupTablePredata(metadataFile, metadataTables, tableDefs)
+	if backupProgress == nil {
+		CheckTablesContainData(dataTables, tableDefs)
+		backupSetTables := dataTables
+		var baseRestorePlan []utils.RestorePlanEntry
+		if !backupReport.MetadataOnly {
+			if *leafPartitionData {
+				globalTOC.IncrementalMetadata.AO = GetAOIncrementalMetadata(connection)
+			}
+			if *incremental {
+				backupSetTables = FilterTablesForIncremental(baseConfig, baseTOC, globalTOC, dataTables)
+				baseRestorePlan = baseConfig.RestorePlan
+				logger.Info("Backing up data for %d of %d tables modified since backup %s", len(backupSetTables), len(dataTables), *fromTimestamp)
+			}
+			// Data backed up with a plugin is not stored in the backup directories
+			if !*noDiskSpaceCheck && pluginConfig == nil {
+				CheckDiskSpaceForBackup(dataTables, backupSetTables)
+			}
+		}
+		logger.Verbose("Metadata will be written to %s", metadataFilename)
+		metadataFile := utils.NewFileWithByteCountFromFile(metadataFilename)
+		defer metadataFile.Close()
+		if !*dataOnly {
+			isTableFiltered := len(includeTables) > 0 || len(excludeTables) > 0
+			if isTableFiltered {
+				backupTablePredata(metadataFile, metadataTables, tableDefs)
+			} else {
+				backupGlobal(metadataFile)
+				backupPredata(metadataFile, metadataTables, tableDefs)
+				backupPostdata(metadataFile)
+			}
 		} else {
-			backupGlobal(metadataFile)
-			backupPredata(metadataFile, metadataTables, tableDefs)
-			backupPostdata(metadataFile)
+			BackupSessionGUCs(metadataFile)
+		}
+		metadataFile.Close()
+		utils.CheckCanceled()
+
+		if !backupReport.MetadataOnly {
+			backupReport.RestorePlan = PopulateRestorePlan(backupSetTables, tableDefs, baseRestorePlan, dataTables)
+			AddTableDataEntriesToTOC(backupSetTables, tableDefs)
+			backupProgress = NewBackupProgress()
+			backupProgress.WriteToFile(globalCluster.GetBackupFilePath("progress"))
+			backupData(backupSetTables, tableDefs)
 		}
 	} else {
-		BackupSessionGUCs(metadataFile)
-	}
-
-	if !backupReport.MetadataOnly {
-		backupData(dataTables, tableDefs)
+		objectCounts = backupProgress.ObjectCounts
+		if !backupProgress.DataComplete {
+			backedUp := GetTablesAlreadyBackedUp()
+			tableRowCounts = GetRowCountsAlreadyBackedUp()
+			tablesToResume := GetTablesToResume(dataTables, tableDefs, backedUp)
+			LogResumeInfo(len(tablesToResume), len(backedUp))
+			if *singleDataFile {
+				PrepareSegmentDataFilesForResume(backedUp)
+			}
+			AddResumedSnapshot(tablesToResume, utils.System.Now().Format("2006-01-02 15:04:05"))
+			backupProgress.WriteToFile(globalCluster.GetBackupFilePath("progress"))
+			backupData(tablesToResume, tableDefs)
+		}
 	}
 
 	if *withStats {
+		utils.CheckCanceled()
 		backupStatistics(metadataTables)
 	}
 
+	utils.CheckCanceled()
+	globalTOC.MetadataChecksum = utils.GetFileChecksum(metadataFilename)
+	if *withStats {
+		globalTOC.StatisticsChecksum = utils.GetFileChecksum(globalCluster.GetStatisticsFilePath())
+	}
 	globalTOC.WriteToFileAndMakeReadOnly(globalCluster.GetTOCFilePath())
-	connection.Commit()
+	backupReport.TOCChecksum = utils.GetFileChecksum(globalCluster.GetTOCFilePath())
+	if pluginConfig != nil {
+		pluginConfig.BackupFile(globalCluster, metadataFilename)
+		pluginConfig.BackupFile(globalCluster, globalCluster.GetTOCFilePath())
+		if *withStats {
+			pluginConfig.BackupFile(globalCluster, globalCluster.GetStatisticsFilePath())
+		}
+	}
+	for connNum := 0; connNum < connection.NumConns; connNum++ {
+		if connection.Tx[connNum] != nil {
+			connection.Commit(connNum)
+		}
+	}
+	if backupProgress != nil {
+		RemoveBackupProgressFiles()
+	}
 }
 
 func backupGlobal(metadataFile *utils.FileWithByteCount) {
@@ -198,10 +325,24 @@ func backupTablePredata(metadataFile *utils.FileWithByteCount, tables []Relation
 func backupData(tables []Relation, tableDefs map[uint32]TableDefinition) {
 	logger.Info("Writing data to file")
 	BackupData(tables, tableDefs)
-	AddTableDataEntriesToTOC(tables, tableDefs)
 	if *singleDataFile {
+		tableSegmentBytes = GetTableSegmentBytes()
 		globalCluster.MoveSegmentTOCsAndMakeReadOnly()
+		globalTOC.SegmentTOCChecksums = globalCluster.GetSegmentTOCChecksums()
+		if pluginConfig != nil {
+			pluginConfig.BackupSegmentTOCs(globalCluster)
+		}
+	} else {
+		if pluginConfig == nil {
+			AddDataFileChecksumsToTOC()
+		}
+		if usingCompression, _ := utils.GetCompressionParameters(); usingCompression {
+			AddDataByteCountsToTOC()
+		}
+		tableSegmentBytes = GetTableSegmentBytes()
 	}
+	backupProgress.DataComplete = true
+	backupProgress.WriteToFile(globalCluster.GetBackupFilePath("progress"))
 	logger.Info("Data backup complete")
 }
 
@@ -225,21 +366,33 @@ func backupStatistics(tables []Relation) {
 }
 
 func DoTeardown() {
-	errStr := ""
-	if err := recover(); err != nil {
-		errStr = fmt.Sprintf("%v", err)
+	err := recover()
+	if err != nil {
 		fmt.Println(err)
 	}
-	errMsg, exitCode := utils.ParseErrorMessage(errStr)
+	errMsg, exitCode := utils.ParseErrorMessage(err)
 	if connection != nil {
 		connection.Close()
 	}
+	if usingEncryption, _ := utils.GetEncryptionParameters(); usingEncryption {
+		utils.CleanUpEncryptionKeyOnAllHosts(globalCluster)
+	}
+	if timestampLockFile != "" {
+		time.Sleep(time.Second) // We sleep for 1 second to ensure multiple backups do not start within the same second.
+		err := os.Remove(timestampLockFile)
+		if err != nil {
+			logger.Warn("Failed to remove lock file %s.", timestampLockFile)
+		}
+	}
+	if utils.WasCanceled() && *singleDataFile && backupProgress != nil {
+		logger.Info("The segment table of contents files have been kept so that the backup can be resumed with --resume %s", globalCluster.Timestamp)
+	}
 
 	/*
 	 * Only create a report file if we fail after the cluster is initialized
 	 * and a backup directory exists in which to create the report file.
 	 */
-	if globalCluster.Timestamp != "" {
+	if globalCluster.Timestamp != "" && !*dryRun {
 		_, statErr := os.Stat(globalCluster.GetDirForContent(-1))
 		if statErr != nil { // Even if this isn't os.IsNotExist, don't try to write a report file in case of further errors
 			os.Exit(exitCode)
@@ -247,20 +400,25 @@ func DoTeardown() {
 		reportFilename := globalCluster.GetReportFilePath()
 		configFilename := globalCluster.GetConfigFilePath()
 
-		time.Sleep(time.Second) // We sleep for 1 second to ensure multiple backups do not start within the same second.
-		timestampLockFile := fmt.Sprintf("/tmp/%s.lck", globalCluster.Timestamp)
-		err := os.Remove(timestampLockFile)
-		if err != nil {
-			logger.Warn("Failed to remove lock file %s.", timestampLockFile)
-		}
-
 		endTime := time.Now()
 		backupReport.WriteConfigFile(configFilename)
-		backupReport.WriteReportFile(reportFilename, globalCluster.Timestamp, objectCounts, endTime, errMsg)
+		backupReport.WriteReportFile(reportFilename, globalCluster.Timestamp, objectCounts, endTime, errMsg, exitCode)
+		jsonReportFilename := globalCluster.GetJSONReportFilePath()
+		jsonReport := backupReport.ConstructJSONReport(globalCluster.Timestamp, objectCounts, GetTableReports(), endTime, errMsg, exitCode)
+		jsonReport.WriteToFileAndMakeReadOnly(jsonReportFilename)
 		utils.EmailReport(globalCluster)
+		AddBackupToHistory(endTime, errMsg, exitCode)
+		if pluginConfig != nil {
+			if exitCode == 0 {
+				pluginConfig.BackupFile(globalCluster, configFilename, true)
+				pluginConfig.BackupFile(globalCluster, reportFilename, true)
+				pluginConfig.BackupFile(globalCluster, jsonReportFilename, true)
+			}
+			pluginConfig.CleanupPluginForBackup(globalCluster)
+		}
 	}
 
-	if exitCode == 0 {
+	if exitCode == 0 && !*dryRun {
 		logger.Info("Backup completed successfully")
 	}
 	os.Exit(exitCode)
@@ -6,15 +6,14 @@ package backup
 
 import (
 	"fmt"
+	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/greenplum-db/gpbackup/utils"
 )
 
-var (
-	tableDelim = ","
-)
-
 func ConstructTableAttributesList(columnDefs []ColumnDefinition) string {
 	names := make([]string, 0)
 	for _, col := range columnDefs {
@@ -26,47 +25,205 @@ func ConstructTableAttributesList(columnDefs []ColumnDefinition) string {
 	return ""
 }
 
+/*
+ * External tables have no data of their own, but with --include-external-data
+ * the rows read from readable external tables are backed up like those of any
+ * other table.
+ */
+func BacksUpTableData(tableDef TableDefinition) bool {
+	if !tableDef.IsExternal {
+		return true
+	}
+	return *includeExternalData && !tableDef.ExtTableDef.Writable
+}
+
 func AddTableDataEntriesToTOC(tables []Relation, tableDefs map[uint32]TableDefinition) {
 	for _, table := range tables {
-		if !tableDefs[table.Oid].IsExternal {
+		if BacksUpTableData(tableDefs[table.Oid]) {
 			attributes := ConstructTableAttributesList(tableDefs[table.Oid].ColumnDefs)
 			globalTOC.AddMasterDataEntry(table.Schema, table.Name, table.Oid, attributes)
+			entry := &globalTOC.DataEntries[len(globalTOC.DataEntries)-1]
+			entry.IsExternal = tableDefs[table.Oid].IsExternal
+			if predicate, ok := tablePredicates[table.FQN()]; ok {
+				entry.Predicate = predicate
+			}
+		}
+	}
+}
+
+/*
+ * Data files streamed to a plugin are never written to the segments, so their
+ * checksums cannot be computed here; single-data-file backups record a checksum
+ * for each table in the segment TOC files instead.
+ */
+func AddDataFileChecksumsToTOC() {
+	if len(globalTOC.DataEntries) == 0 {
+		return
+	}
+	checksums := globalCluster.GetDataFileChecksums()
+	for i, entry := range globalTOC.DataEntries {
+		globalTOC.DataEntries[i].Checksums = make(map[int]string, len(checksums))
+		for contentID, segmentChecksums := range checksums {
+			backupFile := path.Base(globalCluster.GetTableBackupFilePath(contentID, entry.Oid, false))
+			globalTOC.DataEntries[i].Checksums[contentID] = segmentChecksums[backupFile]
+		}
+	}
+}
+
+func AddDataByteCountsToTOC() {
+	if len(globalTOC.DataEntries) == 0 {
+		return
+	}
+	byteCounts := globalCluster.ReadSegmentByteCounts()
+	for i, entry := range globalTOC.DataEntries {
+		for contentID, segmentByteCounts := range byteCounts {
+			byteCount, ok := segmentByteCounts[entry.Oid]
+			if !ok {
+				continue
+			}
+			if globalTOC.DataEntries[i].Bytes == nil {
+				globalTOC.DataEntries[i].Bytes = make(map[int]uint64, len(byteCounts))
+				globalTOC.DataEntries[i].CompressedBytes = make(map[int]uint64, len(byteCounts))
+			}
+			globalTOC.DataEntries[i].Bytes[contentID] = byteCount.Bytes
+			globalTOC.DataEntries[i].CompressedBytes[contentID] = byteCount.CompressedBytes
+		}
+	}
+}
+
+/*
+ * The size of the data for each table is the size before compression.  In
+ * single-data-file backups, it is taken from the segment TOC files, and in
+ * compressed backups with a data file per table, from the sizes recorded in the
+ * TOC by AddDataByteCountsToTOC.  Otherwise, it is the size of the data file
+ * for the table, which is unknown for data stored with a plugin.
+ */
+func GetTableSegmentBytes() map[uint32]map[int]uint64 {
+	segmentBytes := make(map[uint32]map[int]uint64, len(globalTOC.DataEntries))
+	if len(globalTOC.DataEntries) == 0 {
+		return segmentBytes
+	}
+	if *singleDataFile {
+		for contentID, toc := range globalCluster.ReadSegmentTOCs() {
+			for oid, entry := range toc.DataEntries {
+				if segmentBytes[uint32(oid)] == nil {
+					segmentBytes[uint32(oid)] = make(map[int]uint64, 0)
+				}
+				segmentBytes[uint32(oid)][contentID] = entry.EndByte - entry.StartByte
+			}
+		}
+		return segmentBytes
+	}
+	var sizes map[int]map[string]uint64
+	if pluginConfig == nil {
+		sizes = globalCluster.GetDataFileSizes()
+	}
+	for _, entry := range globalTOC.DataEntries {
+		if len(entry.Bytes) > 0 {
+			segmentBytes[entry.Oid] = entry.Bytes
+			continue
+		}
+		if sizes == nil {
+			continue
+		}
+		segmentBytes[entry.Oid] = make(map[int]uint64, len(sizes))
+		for contentID, segmentSizes := range sizes {
+			backupFile := path.Base(globalCluster.GetTableBackupFilePath(contentID, entry.Oid, false))
+			segmentBytes[entry.Oid][contentID] = segmentSizes[backupFile]
 		}
 	}
+	return segmentBytes
 }
 
-func CopyTableOut(connection *utils.DBConn, table Relation, backupFile string) {
+func CopyTableOut(connection *utils.DBConn, table Relation, backupFile string, whichConn int) int64 {
 	usingCompression, compressionProgram := utils.GetCompressionParameters()
+	usingEncryption, encryptionProgram := utils.GetEncryptionParameters()
 	copyCommand := ""
 	if *singleDataFile {
-		/*
-		 * The segment TOC files are always written to the segment data directory for
-		 * performance reasons, in case the user-specified directory is on a mounted
-		 * drive.  It will be copied to a user-specified directory, if any, once all
-		 * of the data is backed up.
-		 */
-		tocFile := globalCluster.GetSegmentTOCFilePath("<SEG_DATA_DIR>", "<SEGID>")
-		helperCommand := fmt.Sprintf("$GPHOME/bin/gpbackup_helper --oid=%d --toc-file=%s --content=<SEGID>", table.Oid, tocFile)
-		copyCommand = fmt.Sprintf("PROGRAM '%s >> %s'", helperCommand, backupFile)
-	} else if usingCompression {
-		copyCommand = fmt.Sprintf("PROGRAM '%s > %s'", compressionProgram.CompressCommand, backupFile)
+		// The helper agent on each segment appends the data to the data file and records it in the segment TOC
+		copyCommand = fmt.Sprintf("PROGRAM '%s'", utils.ConstructHelperAgentCopyProgram(backupFile, table.Oid, false))
 	} else {
-		copyCommand = fmt.Sprintf("'%s'", backupFile)
+		commands := make([]string, 0)
+		if usingCompression {
+			// The helper records the sizes of the data for the TOC and writes the data file itself when nothing follows it
+			compressCommand := fmt.Sprintf("%s --oid=%d --byte-count-file=%s", compressionProgram.CompressCommand, table.Oid, globalCluster.GetSegmentByteCountPathForCopyCommand())
+			if !usingEncryption && pluginConfig == nil {
+				compressCommand += fmt.Sprintf(" --data-file=%s", backupFile)
+			}
+			commands = append(commands, compressCommand)
+		}
+		if usingEncryption {
+			commands = append(commands, encryptionProgram.EncryptCommand)
+		}
+		if pluginConfig != nil {
+			commands = append(commands, pluginConfig.GetBackupDataCommand(backupFile))
+		}
+		if len(commands) == 0 {
+			copyCommand = fmt.Sprintf("'%s'", backupFile)
+		} else if usingEncryption && pluginConfig == nil {
+			copyCommand = fmt.Sprintf("PROGRAM '%s > %s'", utils.ConstructPipeline(commands), backupFile)
+		} else {
+			copyCommand = fmt.Sprintf("PROGRAM '%s'", utils.ConstructPipeline(commands))
+		}
 	}
-	query := fmt.Sprintf("COPY %s TO %s WITH CSV DELIMITER '%s' ON SEGMENT IGNORE EXTERNAL PARTITIONS;", table.ToString(), copyCommand, tableDelim)
-	connection.MustExec(query)
+	copyOptions := utils.GetDataFormat().CopyOptions()
+	query := fmt.Sprintf("COPY %s TO %s WITH %s ON SEGMENT IGNORE EXTERNAL PARTITIONS;", table.ToString(), copyCommand, copyOptions)
+	selectList, isMasked := maskedSelectLists[table.Oid]
+	predicate, hasPredicate := tablePredicates[table.FQN()]
+	// External tables cannot be copied from directly, so their rows are copied from a query
+	if isMasked || hasPredicate || externalDataTables[table.Oid] {
+		// The columns are selected in the column order used for the table itself
+		if !isMasked {
+			selectList = "*"
+		}
+		whereClause := ""
+		if hasPredicate {
+			whereClause = fmt.Sprintf(" WHERE %s", predicate)
+		}
+		query = fmt.Sprintf("COPY (SELECT %s FROM %s%s) TO %s WITH %s ON SEGMENT;", selectList, table.ToString(), whereClause, copyCommand, copyOptions)
+	}
+	result, err := connection.Exec(query, whichConn)
+	utils.CheckError(err)
+	numRows, _ := result.RowsAffected()
+	return numRows
+}
+
+func BackupSingleTableData(table Relation, backupFile string, tableNum uint32, totalTables int, whichConn int) {
+	if logger.GetVerbosity() > utils.LOGINFO {
+		// No progress bar at this log level, so we note table count here
+		logger.Verbose("Writing data for table %s to file (table %d of %d)", table.ToString(), tableNum, totalTables)
+	} else {
+		logger.Verbose("Writing data for table %s to file", table.ToString())
+	}
+	if !*singleDataFile {
+		backupFile = globalCluster.GetTableBackupFilePathForCopyCommand(table.Oid, false)
+	}
+	numRows := CopyTableOut(connection, table, backupFile, whichConn)
+	RecordTableDataBackedUp(table.Oid, numRows)
 }
 
+/*
+ * If there are multiple connections, connection 0 is reserved for metadata and
+ * the data for each table is backed up by the first available worker connection.
+ */
 func BackupDataForAllTables(tables []Relation, tableDefs map[uint32]TableDefinition) {
 	numExtTables := 0
-	numRegTables := 1
-	totalExtTables := 0
+	var numRegTables uint32 = 1
+	dataTables := make([]Relation, 0)
+	externalDataTables = make(map[uint32]bool, 0)
 	for _, table := range tables {
-		if tableDefs[table.Oid].IsExternal {
-			totalExtTables++
+		tableDef := tableDefs[table.Oid]
+		if BacksUpTableData(tableDef) {
+			dataTables = append(dataTables, table)
+			if tableDef.IsExternal {
+				externalDataTables[table.Oid] = true
+			}
+		} else if *leafPartitionData || tableDef.PartitionType != "l" {
+			logger.Verbose("Skipping data backup of table %s because it is an external table.", table.ToString())
+			numExtTables++
 		}
 	}
-	totalRegTables := len(tables) - totalExtTables
+	totalRegTables := len(dataTables)
 	dataProgressBar := utils.NewProgressBar(totalRegTables, "Tables backed up: ", utils.PB_INFO)
 	dataProgressBar.Start()
 	backupFile := ""
@@ -74,26 +231,37 @@ func BackupDataForAllTables(tables []Relation, tableDefs map[uint32]TableDefinit
 		backupFile = globalCluster.GetSegmentPipePathForCopyCommand()
 	}
 
-	for _, table := range tables {
-		tableDef := tableDefs[table.Oid]
-		isExternal := tableDef.IsExternal
-		if !isExternal {
-			if logger.GetVerbosity() > utils.LOGINFO {
-				// No progress bar at this log level, so we note table count here
-				logger.Verbose("Writing data for table %s to file (table %d of %d)", table.ToString(), numRegTables, totalRegTables)
-			} else {
-				logger.Verbose("Writing data for table %s to file", table.ToString())
-			}
-			if !*singleDataFile {
-				backupFile = globalCluster.GetTableBackupFilePathForCopyCommand(table.Oid, false)
-			}
-			CopyTableOut(connection, table, backupFile)
+	if connection.NumConns == 1 {
+		for _, table := range dataTables {
+			utils.CheckCanceled()
+			BackupSingleTableData(table, backupFile, numRegTables, totalRegTables, 0)
 			numRegTables++
 			dataProgressBar.Increment()
-		} else if *leafPartitionData || tableDef.PartitionType != "l" {
-			logger.Verbose("Skipping data backup of table %s because it is an external table.", table.ToString())
-			numExtTables++
 		}
+	} else {
+		tasks := make(chan Relation, len(dataTables))
+		var workerPool sync.WaitGroup
+		var workerPanic utils.WorkerPanic
+		for i := 1; i < connection.NumConns; i++ {
+			workerPool.Add(1)
+			go func(whichConn int) {
+				defer workerPool.Done()
+				defer workerPanic.Recover()
+				for table := range tasks {
+					if workerPanic.ShouldStop() {
+						return
+					}
+					BackupSingleTableData(table, backupFile, atomic.AddUint32(&numRegTables, 1)-1, totalRegTables, whichConn)
+					dataProgressBar.Increment()
+				}
+			}(i)
+		}
+		for _, table := range dataTables {
+			tasks <- table
+		}
+		close(tasks)
+		workerPool.Wait()
+		workerPanic.Repanic()
 	}
 	dataProgressBar.Finish()
 	printDataBackupWarnings(numExtTables)
@@ -115,7 +283,7 @@ func printDataBackupWarnings(numExtTables int) {
 func CheckTablesContainData(tables []Relation, tableDefs map[uint32]TableDefinition) {
 	if !backupReport.MetadataOnly {
 		for _, table := range tables {
-			if !tableDefs[table.Oid].IsExternal {
+			if BacksUpTableData(tableDefs[table.Oid]) {
 				return
 			}
 		}
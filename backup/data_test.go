@@ -4,6 +4,7 @@ import (
 	"regexp"
 
 	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
@@ -44,22 +45,47 @@ var _ = Describe("backup/data tests", func() {
 			Expect(toc.DataEntries).To(Equal(expectedDataEntries))
 		})
 		It("does not add an entry for an external table to the TOC", func() {
+			backup.SetIncludeExternalData(false)
 			columnDefs := []backup.ColumnDefinition{{Oid: 1, Name: "a"}}
 			tableDefs := map[uint32]backup.TableDefinition{1: {ColumnDefs: columnDefs, IsExternal: true}}
 			tables := []backup.Relation{{Oid: 1, Schema: "public", Name: "table"}}
 			backup.AddTableDataEntriesToTOC(tables, tableDefs)
 			Expect(toc.DataEntries).To(BeNil())
 		})
+		It("adds an entry for a readable external table to the TOC if external data is included", func() {
+			backup.SetIncludeExternalData(true)
+			defer backup.SetIncludeExternalData(false)
+			columnDefs := []backup.ColumnDefinition{{Oid: 1, Name: "a"}}
+			tableDefs := map[uint32]backup.TableDefinition{
+				1: {ColumnDefs: columnDefs, IsExternal: true},
+				2: {ColumnDefs: columnDefs, IsExternal: true, ExtTableDef: backup.ExternalTableDefinition{Writable: true}},
+			}
+			tables := []backup.Relation{{Oid: 1, Schema: "public", Name: "ext"}, {Oid: 2, Schema: "public", Name: "writable_ext"}}
+			backup.AddTableDataEntriesToTOC(tables, tableDefs)
+			expectedDataEntries := []utils.MasterDataEntry{{Schema: "public", Name: "ext", Oid: 1, AttributeString: "(a)", IsExternal: true}}
+			Expect(toc.DataEntries).To(Equal(expectedDataEntries))
+		})
 	})
 	Describe("CopyTableOut", func() {
 		It("will back up a table to its own file with compression", func() {
 			backup.SetSingleDataFile(false)
 			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", CompressCommand: "gzip -c -8", DecompressCommand: "gzip -d -c", Extension: ".gz"})
 			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
-			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'gzip -c -8 > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'gzip -c -8 --oid=3456 --byte-count-file=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_byte_counts --data-file=<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("will back up a table to its own file with compression and encryption", func() {
+			backup.SetSingleDataFile(false)
+			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", CompressCommand: "gzip -c -8", DecompressCommand: "gzip -d -c", Extension: ".gz"})
+			utils.SetEncryptionParameters(true, utils.Encryption{EncryptCommand: "openssl enc"})
+			defer utils.SetEncryptionParameters(false, utils.Encryption{})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'set -o pipefail; gzip -c -8 --oid=3456 --byte-count-file=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_byte_counts | openssl enc > <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
-			backup.CopyTableOut(connection, testTable, filename)
+			backup.CopyTableOut(connection, testTable, filename, 0)
 		})
 		It("will back up a table to its own file without compression", func() {
 			backup.SetSingleDataFile(false)
@@ -68,16 +94,158 @@ var _ = Describe("backup/data tests", func() {
 			execStr := regexp.QuoteMeta("COPY public.foo TO '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
-			backup.CopyTableOut(connection, testTable, filename)
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("returns the number of rows backed up", func() {
+			backup.SetSingleDataFile(false)
+			utils.SetCompressionParameters(false, utils.Compression{})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			mock.ExpectExec("COPY public.foo TO (.*)").WillReturnResult(sqlmock.NewResult(0, 42))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			Expect(backup.CopyTableOut(connection, testTable, filename, 0)).To(Equal(int64(42)))
 		})
 		It("will back up a table to a single file", func() {
 			backup.SetSingleDataFile(true)
 			utils.SetCompressionParameters(false, utils.Compression{})
 			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
-			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM '$GPHOME/bin/gpbackup_helper --oid=3456 --toc-file=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_toc.yaml --content=<SEGID> >> <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status && mkfifo <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && { (while test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status; do sleep 1; done; test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && dd if=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 of=/dev/null iflag=nonblock) < /dev/null > /dev/null 2>&1 & } && echo 3456 1<> <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_control && cat > <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && rm -f <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("passes the data for a compressed backup to the helper agent to be compressed as a frame", func() {
+			backup.SetSingleDataFile(true)
+			utils.InitializeCompressionParameters(true, "zstd", 3)
+			utils.SetCompressedFrames(true)
+			defer utils.SetCompressedFrames(false)
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status && mkfifo <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && { (while test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status; do sleep 1; done; test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && dd if=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 of=/dev/null iflag=nonblock) < /dev/null > /dev/null 2>&1 & } && echo 3456 1<> <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_control && cat > <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && rm -f <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("will back up a table to a plugin with compression", func() {
+			backup.SetSingleDataFile(false)
+			backup.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", ConfigPath: "/tmp/20170101010101_plugin_config.yaml"})
+			defer backup.SetPluginConfig(nil)
+			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", CompressCommand: "gzip -c -1", DecompressCommand: "gzip -d -c", Extension: ".gz"})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM 'set -o pipefail; gzip -c -1 --oid=3456 --byte-count-file=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_byte_counts | /tmp/plugin.sh backup_data /tmp/20170101010101_plugin_config.yaml <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("will back up a table to a plugin without compression", func() {
+			backup.SetSingleDataFile(false)
+			backup.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", ConfigPath: "/tmp/20170101010101_plugin_config.yaml"})
+			defer backup.SetPluginConfig(nil)
+			utils.SetCompressionParameters(false, utils.Compression{})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY public.foo TO PROGRAM '/tmp/plugin.sh backup_data /tmp/20170101010101_plugin_config.yaml <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+	})
+	Describe("CopyTableOut with a predicate", func() {
+		BeforeEach(func() {
+			backup.SetTablePredicates(map[string]string{"public.foo": "id > 10"})
+		})
+		AfterEach(func() {
+			backup.SetTablePredicates(nil)
+		})
+		It("will back up the rows matching the predicate to their own file", func() {
+			backup.SetSingleDataFile(false)
+			utils.SetCompressionParameters(false, utils.Compression{})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY (SELECT * FROM public.foo WHERE id > 10) TO '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("will back up the rows matching the predicate to a single file", func() {
+			backup.SetSingleDataFile(true)
+			utils.SetCompressionParameters(false, utils.Compression{})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY (SELECT * FROM public.foo WHERE id > 10) TO PROGRAM 'test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status && mkfifo <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && { (while test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status; do sleep 1; done; test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && dd if=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 of=/dev/null iflag=nonblock) < /dev/null > /dev/null 2>&1 & } && echo 3456 1<> <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_control && cat > <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && rm -f <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("will back up other tables in full", func() {
+			backup.SetSingleDataFile(false)
+			utils.SetCompressionParameters(false, utils.Compression{})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3457, Schema: "public", Name: "bar", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY public.bar TO '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3457' WITH CSV DELIMITER ',' ON SEGMENT IGNORE EXTERNAL PARTITIONS;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3457"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+	})
+	Describe("CopyTableOut with a data format", func() {
+		BeforeEach(func() {
+			backup.SetSingleDataFile(false)
+			utils.SetCompressionParameters(false, utils.Compression{})
+		})
+		AfterEach(func() {
+			utils.SetDataFormat(utils.NewDataFormat("", "", ""))
+		})
+		It("will back up a table in the text format", func() {
+			utils.SetDataFormat(utils.NewDataFormat("text", "|", ""))
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta(`COPY public.foo TO '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH DELIMITER E'|' NULL E'\\N' ON SEGMENT IGNORE EXTERNAL PARTITIONS;`)
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("will back up the rows matching a predicate in the binary format", func() {
+			utils.SetDataFormat(utils.NewDataFormat("binary", "", ""))
+			backup.SetTablePredicates(map[string]string{"public.foo": "id > 10"})
+			defer backup.SetTablePredicates(nil)
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY (SELECT * FROM public.foo WHERE id > 10) TO '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH BINARY ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+	})
+	Describe("CopyTableOut for an external table", func() {
+		It("will back up the rows read from an external table", func() {
+			backup.SetExternalDataTables(map[uint32]bool{3456: true})
+			defer backup.SetExternalDataTables(nil)
+			backup.SetSingleDataFile(false)
+			utils.SetCompressionParameters(false, utils.Compression{})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "ext", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY (SELECT * FROM public.ext) TO '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+	})
+	Describe("CopyTableOut with masked columns", func() {
+		BeforeEach(func() {
+			backup.SetMaskedSelectLists(map[uint32]string{3456: "id, md5(email::text)::text AS email"})
+			backup.SetSingleDataFile(false)
+			utils.SetCompressionParameters(false, utils.Compression{})
+		})
+		AfterEach(func() {
+			backup.SetMaskedSelectLists(nil)
+			backup.SetTablePredicates(nil)
+		})
+		It("will back up the masked columns of a table", func() {
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY (SELECT id, md5(email::text)::text AS email FROM public.foo) TO '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
-			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101"
-			backup.CopyTableOut(connection, testTable, filename)
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			backup.CopyTableOut(connection, testTable, filename, 0)
+		})
+		It("will back up the masked columns of the rows matching a predicate", func() {
+			backup.SetTablePredicates(map[string]string{"public.foo": "id > 10"})
+			testTable := backup.Relation{SchemaOid: 2345, Oid: 3456, Schema: "public", Name: "foo", DependsUpon: nil, Inherits: nil}
+			execStr := regexp.QuoteMeta("COPY (SELECT id, md5(email::text)::text AS email FROM public.foo WHERE id > 10) TO '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			backup.CopyTableOut(connection, testTable, filename, 0)
 		})
 	})
 	Describe("CheckDBContainsData", func() {
@@ -93,6 +261,7 @@ var _ = Describe("backup/data tests", func() {
 			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeTrue())
 		})
 		It("changes backup type to metadata if only external tables in database", func() {
+			backup.SetIncludeExternalData(false)
 			tableDef := backup.TableDefinition{IsExternal: true}
 			backup.CheckTablesContainData(testTable, map[uint32]backup.TableDefinition{0: tableDef})
 			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeTrue())
@@ -103,9 +272,59 @@ var _ = Describe("backup/data tests", func() {
 			backup.CheckTablesContainData([]backup.Relation{}, map[uint32]backup.TableDefinition{})
 			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeTrue())
 		})
+		It("does not change backup type if external table data is included", func() {
+			backup.SetIncludeExternalData(true)
+			defer backup.SetIncludeExternalData(false)
+			tableDef := backup.TableDefinition{IsExternal: true}
+			backup.CheckTablesContainData(testTable, map[uint32]backup.TableDefinition{0: tableDef})
+			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeFalse())
+		})
 		It("does not change backup type if tables present in database", func() {
 			backup.CheckTablesContainData(testTable, map[uint32]backup.TableDefinition{0: backup.TableDefinition{}})
 			Expect(backup.GetReport().BackupConfig.MetadataOnly).To(BeFalse())
 		})
 	})
+	Describe("AddDataByteCountsToTOC and GetTableSegmentBytes", func() {
+		var toc *utils.TOC
+		var testExecutor *testutils.TestExecutor
+		BeforeEach(func() {
+			backup.SetSingleDataFile(false)
+			toc = &utils.TOC{DataEntries: []utils.MasterDataEntry{{Schema: "public", Name: "foo", Oid: 1}, {Schema: "public", Name: "bar", Oid: 2}}}
+			backup.SetTOC(toc)
+			testExecutor = &testutils.TestExecutor{}
+			cluster := testutils.SetDefaultSegmentConfiguration()
+			cluster.Executor = testExecutor
+			backup.SetCluster(cluster)
+		})
+		It("records the sizes of the data counted by the helper, keeping the last line for each table", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{Stdouts: map[int]string{
+				0: "1 100 10\n2 200 20\n1 300 30\n",
+				1: "1 400 40\n",
+			}}
+			backup.AddDataByteCountsToTOC()
+			Expect(toc.DataEntries[0].Bytes).To(Equal(map[int]uint64{0: 300, 1: 400}))
+			Expect(toc.DataEntries[0].CompressedBytes).To(Equal(map[int]uint64{0: 30, 1: 40}))
+			Expect(toc.DataEntries[1].Bytes).To(Equal(map[int]uint64{0: 200}))
+			Expect(toc.DataEntries[1].CompressedBytes).To(Equal(map[int]uint64{0: 20}))
+		})
+		It("reports the sizes of the data before compression, falling back to the sizes of the data files", func() {
+			toc.DataEntries[0].Bytes = map[int]uint64{0: 300, 1: 400}
+			testExecutor.ClusterOutput = &utils.RemoteOutput{Stdouts: map[int]string{
+				0: "1000 /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_2.gz\n",
+				1: "2000 /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_2.gz\n",
+			}}
+			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", Extension: ".gz"})
+			defer utils.SetCompressionParameters(false, utils.Compression{})
+			segmentBytes := backup.GetTableSegmentBytes()
+			Expect(segmentBytes).To(Equal(map[uint32]map[int]uint64{1: {0: 300, 1: 400}, 2: {0: 1000, 1: 2000}}))
+		})
+		It("only reports the sizes counted by the helper for data stored with a plugin", func() {
+			backup.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh"})
+			defer backup.SetPluginConfig(nil)
+			toc.DataEntries[0].Bytes = map[int]uint64{0: 300, 1: 400}
+			segmentBytes := backup.GetTableSegmentBytes()
+			Expect(segmentBytes).To(Equal(map[uint32]map[int]uint64{1: {0: 300, 1: 400}}))
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+		})
+	})
 })
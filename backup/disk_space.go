@@ -0,0 +1,81 @@
+package backup
+
+/*
+ * This file contains functions related to estimating the size of the data in
+ * a backup and checking that the segments have enough disk space for it.
+ */
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+/*
+ * This returns the total size of each table across all segments and the total
+ * size of the data for all tables on each segment.  Unless leaf partitions are
+ * backed up separately, the data for a partition table includes the data of
+ * all of its partitions.
+ */
+func GetDataSizes(tables []Relation) (map[uint32]uint64, map[int]uint64) {
+	tableSizes := make(map[uint32]uint64, len(tables))
+	segmentSizes := make(map[int]uint64, 0)
+	if len(tables) == 0 {
+		return tableSizes, segmentSizes
+	}
+	descendants := make(map[uint32][]uint32, 0)
+	if !*leafPartitionData {
+		descendants = GetPartitionDescendants(connection)
+	}
+	tableForOid := make(map[uint32]uint32, 0)
+	oids := make([]string, 0)
+	for _, table := range tables {
+		tableForOid[table.Oid] = table.Oid
+		oids = append(oids, strconv.FormatUint(uint64(table.Oid), 10))
+		for _, child := range descendants[table.Oid] {
+			tableForOid[child] = table.Oid
+			oids = append(oids, strconv.FormatUint(uint64(child), 10))
+		}
+	}
+	for _, size := range GetSegmentTableSizes(connection, oids) {
+		tableSizes[tableForOid[size.Oid]] += size.Size
+		segmentSizes[size.ContentID] += size.Size
+	}
+	return tableSizes, segmentSizes
+}
+
+/*
+ * The space required is estimated from the on-disk sizes of the tables whose
+ * data will actually be backed up, which excludes the tables an incremental
+ * backup skips.  The size of all of the data tables is recorded in the backup
+ * config so that gprestore can check the space for the restore, as restoring
+ * an incremental backup restores the data of every table.
+ *
+ * Compressed data files are usually much smaller than the tables, but by how
+ * much depends on the data, so a lack of space is only reported as a warning
+ * for compressed backups.
+ */
+func CheckDiskSpaceForBackup(dataTables []Relation, backupSetTables []Relation) {
+	logger.Verbose("Estimating the size of the data to be backed up")
+	_, segmentSizes := GetDataSizes(dataTables)
+	backupReport.SegmentDataSizes = segmentSizes
+	requiredSizes := segmentSizes
+	if len(backupSetTables) < len(dataTables) {
+		_, requiredSizes = GetDataSizes(backupSetTables)
+	}
+	freeSpace := globalCluster.GetFreeSpaceForBackupDirectories()
+	problems, warnings := utils.CheckDiskSpace(globalCluster, requiredSizes, freeSpace, *diskSpaceWarningThreshold)
+	for _, warning := range warnings {
+		logger.Warn(warning)
+	}
+	if usingCompression, _ := utils.GetCompressionParameters(); usingCompression {
+		for _, problem := range problems {
+			logger.Warn("%s.  The backup may still fit, as its data will be compressed.", problem)
+		}
+		return
+	}
+	if len(problems) > 0 {
+		logger.Fatal(utils.NewDiskSpaceError("Not enough free disk space for the backup:\n%s\nUse --no-disk-space-check to back up anyway.", strings.Join(problems, "\n")), "")
+	}
+}
@@ -0,0 +1,90 @@
+package backup_test
+
+import (
+	"database/sql/driver"
+
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("backup/disk_space tests", func() {
+	foo := backup.Relation{Oid: 1, Schema: "public", Name: "foo"}
+	part := backup.Relation{Oid: 2, Schema: "public", Name: "part"}
+	BeforeEach(func() {
+		backup.SetConnection(connection)
+		backup.SetCluster(testutils.SetDefaultSegmentConfiguration())
+	})
+	Describe("GetDataSizes", func() {
+		It("adds the sizes of the partitions of a partition table to the size of the table", func() {
+			backup.SetLeafPartitionData(false)
+			partitionRows := sqlmock.NewRows([]string{"parentoid", "childoid"}).
+				AddRow([]driver.Value{"2", "3"}...).
+				AddRow([]driver.Value{"2", "4"}...)
+			sizeRows := sqlmock.NewRows([]string{"oid", "contentid", "size"}).
+				AddRow([]driver.Value{"1", "0", "100"}...).
+				AddRow([]driver.Value{"1", "1", "200"}...).
+				AddRow([]driver.Value{"2", "0", "0"}...).
+				AddRow([]driver.Value{"3", "0", "1000"}...).
+				AddRow([]driver.Value{"4", "1", "2000"}...)
+			mock.ExpectQuery(`SELECT (.*)`).WillReturnRows(partitionRows)
+			mock.ExpectQuery(`SELECT (.*)`).WillReturnRows(sizeRows)
+			tableSizes, segmentSizes := backup.GetDataSizes([]backup.Relation{foo, part})
+			Expect(tableSizes).To(Equal(map[uint32]uint64{1: 300, 2: 3000}))
+			Expect(segmentSizes).To(Equal(map[int]uint64{0: 1100, 1: 2200}))
+		})
+		It("does not query anything if there are no tables", func() {
+			tableSizes, segmentSizes := backup.GetDataSizes([]backup.Relation{})
+			Expect(tableSizes).To(BeEmpty())
+			Expect(segmentSizes).To(BeEmpty())
+		})
+	})
+	Describe("CheckDiskSpaceForBackup", func() {
+		var testExecutor *testutils.TestExecutor
+		BeforeEach(func() {
+			backup.SetLeafPartitionData(true)
+			backup.SetDiskSpaceWarningThreshold(90)
+			backup.SetReport(&utils.Report{})
+			utils.SetCompressionParameters(false, utils.Compression{})
+			testExecutor = &testutils.TestExecutor{ClusterOutput: &utils.RemoteOutput{Stdouts: map[int]string{
+				0: "/dev/sda1 1000 0 1 100% /data",
+				1: "/dev/sdb1 1000 0 1000 0% /data2",
+			}}}
+			cluster := testutils.SetDefaultSegmentConfiguration()
+			cluster.Executor = testExecutor
+			backup.SetCluster(cluster)
+		})
+		AfterEach(func() {
+			utils.SetCompressionParameters(false, utils.Compression{})
+		})
+		expectSizeQuery := func() {
+			sizeRows := sqlmock.NewRows([]string{"oid", "contentid", "size"}).
+				AddRow([]driver.Value{"1", "0", "4096"}...).
+				AddRow([]driver.Value{"2", "1", "4096"}...)
+			mock.ExpectQuery(`SELECT (.*)`).WillReturnRows(sizeRows)
+		}
+		It("panics if there is not enough space for an uncompressed backup", func() {
+			expectSizeQuery()
+			defer testutils.ShouldPanicWithMessage("Not enough free disk space for the backup")
+			backup.CheckDiskSpaceForBackup([]backup.Relation{foo, part}, []backup.Relation{foo, part})
+		})
+		It("only warns if there is not enough space for a compressed backup", func() {
+			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", CompressCommand: "gzip -c -1", DecompressCommand: "gzip -d -c", Extension: ".gz"})
+			expectSizeQuery()
+			backup.CheckDiskSpaceForBackup([]backup.Relation{foo, part}, []backup.Relation{foo, part})
+			Expect(logfile).To(gbytes.Say("Segments 0 on host localhost require 4.0 KB on /data, which has 1.0 KB available of 1000.0 KB.  The backup may still fit, as its data will be compressed."))
+			Expect(backup.GetReport().SegmentDataSizes).To(Equal(map[int]uint64{0: 4096, 1: 4096}))
+		})
+		It("only requires space for the tables an incremental backup will back up", func() {
+			expectSizeQuery()
+			mock.ExpectQuery(`SELECT (.*)`).WillReturnRows(sqlmock.NewRows([]string{"oid", "contentid", "size"}).AddRow([]driver.Value{"2", "1", "4096"}...))
+			backup.CheckDiskSpaceForBackup([]backup.Relation{foo, part}, []backup.Relation{part})
+			Expect(backup.GetReport().SegmentDataSizes).To(Equal(map[int]uint64{0: 4096, 1: 4096}))
+		})
+	})
+})
@@ -0,0 +1,84 @@
+package backup
+
+/*
+ * This file contains structs and functions related to reporting what a backup
+ * would contain with --dry-run, without writing any backup files.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+/*
+ * The sizes are the on-disk sizes of the tables, so the data files written by
+ * an actual backup will usually be smaller, especially if they are compressed.
+ */
+func DoDryRun(metadataTables []Relation, dataTables []Relation, tableDefs map[uint32]TableDefinition, baseConfig *utils.BackupConfig, baseTOC *utils.TOC) {
+	logger.Info("Performing a dry run; no backup files will be written")
+	backupSetTables := make([]Relation, 0)
+	if !backupReport.MetadataOnly {
+		for _, table := range dataTables {
+			if BacksUpTableData(tableDefs[table.Oid]) {
+				backupSetTables = append(backupSetTables, table)
+			}
+		}
+		if *incremental {
+			globalTOC.IncrementalMetadata.AO = GetAOIncrementalMetadata(connection)
+			backupSetTables = FilterTablesForIncremental(baseConfig, baseTOC, globalTOC, backupSetTables)
+		}
+	}
+	tableSizes, segmentSizes := GetDataSizes(backupSetTables)
+	freeSpace := make(map[int]utils.FilesystemSpace, 0)
+	if len(backupSetTables) > 0 {
+		freeSpace = globalCluster.GetFreeSpaceForBackupDirectories()
+	}
+	PrintDryRunReport(os.Stdout, len(metadataTables), backupSetTables, tableSizes, segmentSizes, freeSpace)
+	problems, warnings := utils.CheckDiskSpace(globalCluster, segmentSizes, freeSpace, *diskSpaceWarningThreshold)
+	for _, message := range append(problems, warnings...) {
+		logger.Warn(message)
+	}
+	logger.Info("Dry run complete")
+}
+
+func PrintDryRunReport(writer io.Writer, numMetadataTables int, tables []Relation, tableSizes map[uint32]uint64, segmentSizes map[int]uint64, freeSpace map[int]utils.FilesystemSpace) {
+	fmt.Fprintf(writer, "\nTables whose metadata would be backed up: %d\n", numMetadataTables)
+	fmt.Fprintf(writer, "Tables whose data would be backed up: %d\n", len(tables))
+	if len(tables) == 0 {
+		return
+	}
+
+	sortedTables := make([]Relation, len(tables))
+	copy(sortedTables, tables)
+	sort.Slice(sortedTables, func(i, j int) bool {
+		return sortedTables[i].FQN() < sortedTables[j].FQN()
+	})
+	fmt.Fprintln(writer)
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "TABLE\tSIZE")
+	var totalSize uint64
+	for _, table := range sortedTables {
+		fmt.Fprintf(tabWriter, "%s\t%s\n", table.FQN(), utils.FormatSize(tableSizes[table.Oid]))
+		totalSize += tableSizes[table.Oid]
+	}
+	tabWriter.Flush()
+	fmt.Fprintf(writer, "\nTotal data size: %s\n\n", utils.FormatSize(totalSize))
+
+	contentIDs := make([]int, 0, len(freeSpace))
+	for contentID := range freeSpace {
+		contentIDs = append(contentIDs, contentID)
+	}
+	sort.Ints(contentIDs)
+	tabWriter = tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "SEGMENT\tHOST\tDATA SIZE\tAVAILABLE\tFILESYSTEM")
+	for _, contentID := range contentIDs {
+		space := freeSpace[contentID]
+		fmt.Fprintf(tabWriter, "%d\t%s\t%s\t%s\t%s\n", contentID, globalCluster.GetHostForContent(contentID), utils.FormatSize(segmentSizes[contentID]), utils.FormatSize(space.AvailableBytes), space.MountPoint)
+	}
+	tabWriter.Flush()
+}
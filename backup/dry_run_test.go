@@ -0,0 +1,38 @@
+package backup_test
+
+import (
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("backup/dry_run tests", func() {
+	foo := backup.Relation{Oid: 1, Schema: "public", Name: "foo"}
+	part := backup.Relation{Oid: 2, Schema: "public", Name: "part"}
+	BeforeEach(func() {
+		backup.SetCluster(testutils.SetDefaultSegmentConfiguration())
+	})
+	Describe("PrintDryRunReport", func() {
+		It("prints the size of each table and the sizes and free space for each segment", func() {
+			freeSpace := map[int]utils.FilesystemSpace{0: {MountPoint: "/data", AvailableBytes: 2048}, 1: {MountPoint: "/data", AvailableBytes: 2048}}
+			backup.PrintDryRunReport(buffer, 3, []backup.Relation{part, foo}, map[uint32]uint64{1: 300, 2: 3000}, map[int]uint64{0: 1100, 1: 2200}, freeSpace)
+			Expect(buffer).To(gbytes.Say(`Tables whose metadata would be backed up: 3
+Tables whose data would be backed up: 2
+
+TABLE        SIZE
+public.foo   300 B
+public.part  2.9 KB
+
+Total data size: 3.2 KB
+
+SEGMENT  HOST       DATA SIZE  AVAILABLE  FILESYSTEM
+0        localhost  1.1 KB     2.0 KB     /data
+1        localhost  2.1 KB     2.0 KB     /data
+`))
+		})
+	})
+})
@@ -11,38 +11,72 @@ import "github.com/greenplum-db/gpbackup/utils"
  * Non-flag variables
  */
 var (
-	backupReport  *utils.Report
-	connection    *utils.DBConn
-	globalCluster utils.Cluster
-	globalTOC     *utils.TOC
-	logger        *utils.Logger
-	objectCounts  map[string]int
-	version       string
+	backupProgress     *BackupProgress
+	backupReport       *utils.Report
+	connection         *utils.DBConn
+	globalCluster      utils.Cluster
+	externalDataTables map[uint32]bool
+	globalTOC          *utils.TOC
+	logger             *utils.Logger
+	maskedSelectLists  map[uint32]string
+	maskingHashSalt    string
+	maskingRules       []MaskingRule
+	objectCounts       map[string]int
+	patternMatches     []utils.PatternMatch
+	pluginConfig       *utils.PluginConfig
+	resumeByteCounts   map[int]utils.ResumeByteCount
+	tablePredicates    map[string]string
+	tableRowCounts     map[uint32]int64
+	tableSegmentBytes  map[uint32]map[int]uint64
+	timestampLockFile  string
+	version            string
 )
 
 /*
  * Command-line flags
  */
 var (
-	backupDir         *string
-	compressionLevel  *int
-	dataOnly          *bool
-	dbname            *string
-	debug             *bool
-	excludeSchemas    utils.ArrayFlags
-	excludeTableFile  *string
-	excludeTables     utils.ArrayFlags
-	includeSchemas    utils.ArrayFlags
-	includeTableFile  *string
-	includeTables     utils.ArrayFlags
-	leafPartitionData *bool
-	metadataOnly      *bool
-	noCompression     *bool
-	printVersion      *bool
-	quiet             *bool
-	singleDataFile    *bool
-	verbose           *bool
-	withStats         *bool
+	backupDir                 *string
+	compressionLevel          *int
+	compressionType           *string
+	dataDelimiter             *string
+	dataFormat                *string
+	dataNullString            *string
+	dataOnly                  *bool
+	dbname                    *string
+	debug                     *bool
+	diskSpaceWarningThreshold *int
+	dryRun                    *bool
+	encrypt                   *bool
+	encryptionKeyCommand      *string
+	encryptionKeyFile         *string
+	excludeSchemaPatterns     utils.ArrayFlags
+	excludeSchemas            utils.ArrayFlags
+	excludeTableFile          *string
+	excludeTablePatterns      utils.ArrayFlags
+	excludeTables             utils.ArrayFlags
+	fromTimestamp             *string
+	includeExternalData       *bool
+	includeSchemaPatterns     utils.ArrayFlags
+	includeSchemas            utils.ArrayFlags
+	includeTableFile          *string
+	includeTablePatterns      utils.ArrayFlags
+	includeTables             utils.ArrayFlags
+	incremental               *bool
+	leafPartitionData         *bool
+	maskingRulesFile          *string
+	metadataOnly              *bool
+	nativeSSH                 *bool
+	noCompression             *bool
+	noDiskSpaceCheck          *bool
+	numJobs                   *int
+	pluginConfigFile          *string
+	printVersion              *bool
+	quiet                     *bool
+	resume                    *string
+	singleDataFile            *bool
+	verbose                   *bool
+	withStats                 *bool
 )
 
 /*
@@ -57,6 +91,14 @@ func SetCluster(cluster utils.Cluster) {
 	globalCluster = cluster
 }
 
+func SetDiskSpaceWarningThreshold(threshold int) {
+	diskSpaceWarningThreshold = &threshold
+}
+
+func SetEncrypt(which bool) {
+	encrypt = &which
+}
+
 func SetExcludeSchemas(schemas []string) {
 	excludeSchemas = schemas
 }
@@ -73,14 +115,54 @@ func SetIncludeTables(tables []string) {
 	includeTables = tables
 }
 
+func SetMaskedSelectLists(selectLists map[uint32]string) {
+	maskedSelectLists = selectLists
+}
+
+func SetMaskingHashSalt(salt string) {
+	maskingHashSalt = salt
+}
+
+func SetMaskingRules(rules []MaskingRule) {
+	maskingRules = rules
+}
+
+func SetTablePredicates(predicates map[string]string) {
+	tablePredicates = predicates
+}
+
+func SetFromTimestamp(timestamp string) {
+	fromTimestamp = &timestamp
+}
+
+func SetIncludeExternalData(which bool) {
+	includeExternalData = &which
+}
+
+func SetExternalDataTables(oids map[uint32]bool) {
+	externalDataTables = oids
+}
+
+func SetIncremental(which bool) {
+	incremental = &which
+}
+
 func SetLeafPartitionData(which bool) {
 	leafPartitionData = &which
 }
 
+func SetNumJobs(jobs int) {
+	numJobs = &jobs
+}
+
 func SetLogger(log *utils.Logger) {
 	logger = log
 }
 
+func SetPluginConfig(config *utils.PluginConfig) {
+	pluginConfig = config
+}
+
 func SetReport(report *utils.Report) {
 	backupReport = report
 }
@@ -89,10 +171,26 @@ func GetReport() *utils.Report {
 	return backupReport
 }
 
+func SetBackupProgress(progress *BackupProgress) {
+	backupProgress = progress
+}
+
+func SetResume(timestamp string) {
+	resume = &timestamp
+}
+
 func SetSingleDataFile(which bool) {
 	singleDataFile = &which
 }
 
+func SetTableRowCounts(rowCounts map[uint32]int64) {
+	tableRowCounts = rowCounts
+}
+
+func SetTableSegmentBytes(segmentBytes map[uint32]map[int]uint64) {
+	tableSegmentBytes = segmentBytes
+}
+
 func SetTOC(toc *utils.TOC) {
 	globalTOC = toc
 }
@@ -0,0 +1,123 @@
+package backup
+
+/*
+ * This file contains structs and functions related to taking incremental
+ * backups of append-optimized tables.
+ */
+
+import (
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * The base backup must be in the same backup directory as the current backup,
+ * so we can reuse the current cluster information to find its files.
+ */
+func GetIncrementalBaseBackup() (*utils.BackupConfig, *utils.TOC) {
+	baseCluster := globalCluster
+	baseCluster.Timestamp = *fromTimestamp
+	configFilename := baseCluster.GetConfigFilePath()
+	tocFilename := baseCluster.GetTOCFilePath()
+	if !utils.FileExistsAndIsReadable(configFilename) || !utils.FileExistsAndIsReadable(tocFilename) {
+		logger.Fatal(errors.Errorf("Backup %s does not exist or is not readable in the current backup directory; cannot use it as the base for an incremental backup", *fromTimestamp), "")
+	}
+	baseConfig := utils.ReadConfigFile(configFilename)
+	ValidateIncrementalBaseBackup(baseConfig)
+	utils.VerifyFileChecksum(tocFilename, baseConfig.TOCChecksum)
+	baseTOC := utils.NewTOC(tocFilename)
+	for i := range baseConfig.RestorePlan {
+		if baseConfig.RestorePlan[i].Timestamp == *fromTimestamp {
+			baseConfig.RestorePlan[i].TOCChecksum = baseConfig.TOCChecksum
+		}
+	}
+	return baseConfig, baseTOC
+}
+
+func ValidateIncrementalBaseBackup(baseConfig *utils.BackupConfig) {
+	errMsg := ""
+	if baseConfig.DatabaseName != backupReport.DatabaseName {
+		errMsg = "it is a backup of a different database"
+	} else if baseConfig.MetadataOnly || baseConfig.DataOnly {
+		errMsg = "it is a metadata-only or data-only backup"
+	} else if !baseConfig.LeafPartitionData {
+		errMsg = "it was not taken with the --leaf-partition-data flag"
+	} else if baseConfig.Compressed != backupReport.Compressed || baseConfig.CompressionType != backupReport.CompressionType {
+		errMsg = "its compression settings do not match those of the current backup"
+	} else if baseConfig.GetDataFormat() != backupReport.GetDataFormat() {
+		errMsg = "its data format does not match that of the current backup"
+	} else if baseConfig.SingleDataFile != backupReport.SingleDataFile {
+		errMsg = "its --single-data-file setting does not match that of the current backup"
+	} else if baseConfig.Encrypted != backupReport.Encrypted || (baseConfig.Encrypted && !utils.MatchEncryptionKeyFingerprint(baseConfig.EncryptionKeyFingerprint)) {
+		errMsg = "it was not encrypted with the same key as the current backup"
+	} else if baseConfig.MaskingRulesChecksum != backupReport.MaskingRulesChecksum {
+		errMsg = "its data was not masked with the same masking rules as the current backup"
+	}
+	if errMsg != "" {
+		logger.Fatal(errors.Errorf("Backup %s cannot be used as the base for an incremental backup because %s", *fromTimestamp, errMsg), "")
+	}
+	// Every backup in an incremental chain uses the same key for its checksums
+	backupReport.EncryptionKeyFingerprint = baseConfig.EncryptionKeyFingerprint
+}
+
+/*
+ * Heap tables are always backed up in their entirety.  An append-optimized
+ * table is only backed up if its modcount or last DDL timestamp has changed
+ * since the base backup, or if its data cannot be found in the base backup's
+ * restore plan.
+ */
+func FilterTablesForIncremental(baseConfig *utils.BackupConfig, baseTOC *utils.TOC, currentTOC *utils.TOC, tables []Relation) []Relation {
+	baseTableFQNs := make(map[string]bool, 0)
+	for _, entry := range baseConfig.RestorePlan {
+		for _, fqn := range entry.TableFQNs {
+			baseTableFQNs[fqn] = true
+		}
+	}
+	filteredTables := make([]Relation, 0)
+	for _, table := range tables {
+		fqn := table.FQN()
+		currentAOEntry, isAOTable := currentTOC.IncrementalMetadata.AO[fqn]
+		if !isAOTable {
+			filteredTables = append(filteredTables, table)
+			continue
+		}
+		baseAOEntry, inBaseBackup := baseTOC.IncrementalMetadata.AO[fqn]
+		if !inBaseBackup || !baseTableFQNs[fqn] || baseAOEntry != currentAOEntry {
+			filteredTables = append(filteredTables, table)
+		}
+	}
+	return filteredTables
+}
+
+/*
+ * The restore plan for the current backup consists of the base backup's restore
+ * plan, with any tables backed up in the current backup or no longer present in
+ * the database removed, followed by an entry for the current backup.  External
+ * tables are only included in the plan if their data is in the backup.
+ */
+func PopulateRestorePlan(changedTables []Relation, tableDefs map[uint32]TableDefinition, restorePlan []utils.RestorePlanEntry, allTables []Relation) []utils.RestorePlanEntry {
+	currentEntry := utils.RestorePlanEntry{Timestamp: globalCluster.Timestamp, TableFQNs: make([]string, 0)}
+	changedTableFQNs := make(map[string]bool, len(changedTables))
+	for _, table := range changedTables {
+		if BacksUpTableData(tableDefs[table.Oid]) {
+			currentEntry.TableFQNs = append(currentEntry.TableFQNs, table.FQN())
+			changedTableFQNs[table.FQN()] = true
+		}
+	}
+	allTableFQNs := make(map[string]bool, len(allTables))
+	for _, table := range allTables {
+		allTableFQNs[table.FQN()] = true
+	}
+
+	newRestorePlan := make([]utils.RestorePlanEntry, 0)
+	for _, entry := range restorePlan {
+		tableFQNs := make([]string, 0)
+		for _, fqn := range entry.TableFQNs {
+			if !changedTableFQNs[fqn] && allTableFQNs[fqn] {
+				tableFQNs = append(tableFQNs, fqn)
+			}
+		}
+		newRestorePlan = append(newRestorePlan, utils.RestorePlanEntry{Timestamp: entry.Timestamp, TableFQNs: tableFQNs, TOCChecksum: entry.TOCChecksum})
+	}
+	return append(newRestorePlan, currentEntry)
+}
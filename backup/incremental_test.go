@@ -0,0 +1,129 @@
+package backup_test
+
+import (
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/incremental tests", func() {
+	heapTable := backup.Relation{Oid: 1, Schema: "public", Name: "heap"}
+	aoTable := backup.Relation{Oid: 2, Schema: "public", Name: "ao"}
+	coTable := backup.Relation{Oid: 3, Schema: "public", Name: "co"}
+	extTable := backup.Relation{Oid: 4, Schema: "public", Name: "ext"}
+	tableDefs := map[uint32]backup.TableDefinition{4: {IsExternal: true}}
+
+	Describe("FilterTablesForIncremental", func() {
+		var baseConfig *utils.BackupConfig
+		var baseTOC, currentTOC *utils.TOC
+		BeforeEach(func() {
+			baseConfig = &utils.BackupConfig{RestorePlan: []utils.RestorePlanEntry{
+				{Timestamp: "20161230010101", TableFQNs: []string{"public.heap", "public.ao", "public.co"}},
+			}}
+			baseTOC = &utils.TOC{IncrementalMetadata: utils.IncrementalEntries{AO: map[string]utils.AOEntry{
+				"public.ao": {Modcount: 5, LastDDLTimestamp: "2016-12-30 01:01:01"},
+				"public.co": {Modcount: 3, LastDDLTimestamp: "2016-12-30 01:01:01"},
+			}}}
+			currentTOC = &utils.TOC{IncrementalMetadata: utils.IncrementalEntries{AO: map[string]utils.AOEntry{
+				"public.ao": {Modcount: 5, LastDDLTimestamp: "2016-12-30 01:01:01"},
+				"public.co": {Modcount: 3, LastDDLTimestamp: "2016-12-30 01:01:01"},
+			}}}
+		})
+		It("always includes heap tables", func() {
+			tables := backup.FilterTablesForIncremental(baseConfig, baseTOC, currentTOC, []backup.Relation{heapTable, aoTable, coTable})
+			Expect(tables).To(Equal([]backup.Relation{heapTable}))
+		})
+		It("includes an AO table whose modcount has changed", func() {
+			currentTOC.IncrementalMetadata.AO["public.ao"] = utils.AOEntry{Modcount: 6, LastDDLTimestamp: "2016-12-30 01:01:01"}
+			tables := backup.FilterTablesForIncremental(baseConfig, baseTOC, currentTOC, []backup.Relation{aoTable, coTable})
+			Expect(tables).To(Equal([]backup.Relation{aoTable}))
+		})
+		It("includes an AO table whose last DDL timestamp has changed", func() {
+			currentTOC.IncrementalMetadata.AO["public.co"] = utils.AOEntry{Modcount: 3, LastDDLTimestamp: "2016-12-31 01:01:01"}
+			tables := backup.FilterTablesForIncremental(baseConfig, baseTOC, currentTOC, []backup.Relation{aoTable, coTable})
+			Expect(tables).To(Equal([]backup.Relation{coTable}))
+		})
+		It("includes an AO table that is not in the base backup", func() {
+			delete(baseTOC.IncrementalMetadata.AO, "public.ao")
+			tables := backup.FilterTablesForIncremental(baseConfig, baseTOC, currentTOC, []backup.Relation{aoTable, coTable})
+			Expect(tables).To(Equal([]backup.Relation{aoTable}))
+		})
+		It("includes an AO table whose data is not in the base backup's restore plan", func() {
+			baseConfig.RestorePlan[0].TableFQNs = []string{"public.heap", "public.ao"}
+			tables := backup.FilterTablesForIncremental(baseConfig, baseTOC, currentTOC, []backup.Relation{aoTable, coTable})
+			Expect(tables).To(Equal([]backup.Relation{coTable}))
+		})
+	})
+	Describe("PopulateRestorePlan", func() {
+		BeforeEach(func() {
+			cluster := testutils.SetDefaultSegmentConfiguration()
+			cluster.Timestamp = "20170101010101"
+			backup.SetCluster(cluster)
+		})
+		It("creates a single-entry restore plan for a full backup, omitting external tables", func() {
+			backup.SetIncludeExternalData(false)
+			tables := []backup.Relation{heapTable, aoTable, extTable}
+			restorePlan := backup.PopulateRestorePlan(tables, tableDefs, nil, tables)
+			expectedPlan := []utils.RestorePlanEntry{{Timestamp: "20170101010101", TableFQNs: []string{"public.heap", "public.ao"}}}
+			Expect(restorePlan).To(Equal(expectedPlan))
+		})
+		It("removes changed and dropped tables from earlier entries of the restore plan and keeps their TOC checksums", func() {
+			basePlan := []utils.RestorePlanEntry{
+				{Timestamp: "20161230010101", TableFQNs: []string{"public.heap", "public.ao", "public.dropped"}, TOCChecksum: "abc"},
+				{Timestamp: "20161231010101", TableFQNs: []string{"public.co"}, TOCChecksum: "def"},
+			}
+			allTables := []backup.Relation{heapTable, aoTable, coTable}
+			restorePlan := backup.PopulateRestorePlan([]backup.Relation{heapTable, coTable}, tableDefs, basePlan, allTables)
+			expectedPlan := []utils.RestorePlanEntry{
+				{Timestamp: "20161230010101", TableFQNs: []string{"public.ao"}, TOCChecksum: "abc"},
+				{Timestamp: "20161231010101", TableFQNs: []string{}, TOCChecksum: "def"},
+				{Timestamp: "20170101010101", TableFQNs: []string{"public.heap", "public.co"}},
+			}
+			Expect(restorePlan).To(Equal(expectedPlan))
+		})
+	})
+	Describe("ValidateIncrementalBaseBackup", func() {
+		var baseConfig *utils.BackupConfig
+		BeforeEach(func() {
+			backup.SetReport(&utils.Report{BackupConfig: utils.BackupConfig{DatabaseName: "testdb", Compressed: true}})
+			backup.SetFromTimestamp("20161230010101")
+			baseConfig = &utils.BackupConfig{DatabaseName: "testdb", Compressed: true, LeafPartitionData: true}
+		})
+		It("passes if the base backup is compatible with the current backup", func() {
+			backup.ValidateIncrementalBaseBackup(baseConfig)
+		})
+		It("panics if the base backup is of a different database", func() {
+			baseConfig.DatabaseName = "otherdb"
+			defer testutils.ShouldPanicWithMessage("Backup 20161230010101 cannot be used as the base for an incremental backup because it is a backup of a different database")
+			backup.ValidateIncrementalBaseBackup(baseConfig)
+		})
+		It("panics if the base backup was not taken with --leaf-partition-data", func() {
+			baseConfig.LeafPartitionData = false
+			defer testutils.ShouldPanicWithMessage("it was not taken with the --leaf-partition-data flag")
+			backup.ValidateIncrementalBaseBackup(baseConfig)
+		})
+		It("panics if the base backup has different compression settings", func() {
+			baseConfig.Compressed = false
+			defer testutils.ShouldPanicWithMessage("its compression settings do not match those of the current backup")
+			backup.ValidateIncrementalBaseBackup(baseConfig)
+		})
+		It("passes if the base backup was taken before the data format was recorded and the current backup uses CSV", func() {
+			backup.GetReport().DataFormat = "csv"
+			backup.GetReport().DataDelimiter = ","
+			backup.ValidateIncrementalBaseBackup(baseConfig)
+		})
+		It("panics if the base backup was masked with different masking rules", func() {
+			backup.GetReport().MaskingRulesChecksum = "0123abcd"
+			defer testutils.ShouldPanicWithMessage("its data was not masked with the same masking rules as the current backup")
+			backup.ValidateIncrementalBaseBackup(baseConfig)
+		})
+		It("panics if the base backup has a different data format", func() {
+			baseConfig.DataFormat = "text"
+			defer testutils.ShouldPanicWithMessage("its data format does not match that of the current backup")
+			backup.ValidateIncrementalBaseBackup(baseConfig)
+		})
+	})
+})
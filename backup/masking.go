@@ -0,0 +1,229 @@
+package backup
+
+/*
+ * This file contains structs and functions related to masking the values of
+ * columns as their data is backed up.
+ */
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	MASK_NULL       = "null"
+	MASK_HASH       = "hash"
+	MASK_CONSTANT   = "constant"
+	MASK_EXPRESSION = "expression"
+)
+
+// The length of the hex MD5 digest to which the hash transform maps each value
+const MASK_HASH_LENGTH = 32
+
+/*
+ * Column is a fully-qualified column name in the format schema.table.column,
+ * quoted as for a table filter.  Value holds the constant for the constant
+ * transform and the SQL expression for the expression transform; an
+ * expression may refer to any column of the table by name.
+ */
+type MaskingRule struct {
+	Column    string
+	Transform string
+	Value     string
+}
+
+func ReadMaskingRules(filename string) []MaskingRule {
+	rules := make([]MaskingRule, 0)
+	contents, err := utils.System.ReadFile(filename)
+	utils.CheckError(err)
+	err = yaml.Unmarshal(contents, &rules)
+	if err != nil {
+		logger.Fatal(err, "Unable to parse masking rules file %s", filename)
+	}
+	seenColumns := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if len(utils.SplitQualifiedName(rule.Column)) != 3 {
+			logger.Fatal(utils.NewFlagError("Column %s in masking rules file %s is not correctly fully-qualified.  Please ensure that it is in the format schema.table.column and it is quoted appropriately.", rule.Column, filename), "")
+		}
+		if seenColumns[rule.Column] {
+			logger.Fatal(utils.NewFlagError("Column %s has more than one masking rule in masking rules file %s", rule.Column, filename), "")
+		}
+		seenColumns[rule.Column] = true
+		switch rule.Transform {
+		case MASK_NULL, MASK_HASH:
+		case MASK_CONSTANT, MASK_EXPRESSION:
+			if rule.Value == "" {
+				logger.Fatal(utils.NewFlagError("The %s masking rule for column %s must specify a value", rule.Transform, rule.Column), "")
+			}
+		default:
+			logger.Fatal(utils.NewFlagError("Unknown masking transform %s for column %s.  Valid transforms are null, hash, constant, and expression.", rule.Transform, rule.Column), "")
+		}
+	}
+	return rules
+}
+
+/*
+ * The checksum of the rules file is recorded in the backup config, so that an
+ * incremental backup cannot reuse the data of a base backup that was masked
+ * differently, or not masked at all.
+ */
+func GetMaskingRulesChecksum(filename string) string {
+	contents, err := utils.System.ReadFile(filename)
+	utils.CheckError(err)
+	return fmt.Sprintf("%x", sha256.Sum256(contents))
+}
+
+/*
+ * The hash transform salts each value with a random secret chosen for each
+ * backup, so that equal values are hashed alike within a backup and can still
+ * be joined on, but a hash cannot be reversed by hashing guesses at the value.
+ * The secret is not recorded with the backup, though it appears in the COPY
+ * commands for masked tables.  A resumed backup chooses a new secret, so values
+ * hashed before and after the interruption do not match.
+ */
+func NewMaskingHashSalt() string {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	utils.CheckError(err)
+	return fmt.Sprintf("%x", salt)
+}
+
+/*
+ * Each masked column is replaced by an expression cast back to the column's
+ * type, so that the masked data can be restored into the original table
+ * definition with a normal restore.
+ */
+func GetMaskingExpression(rule MaskingRule, column ColumnDefinition) string {
+	switch rule.Transform {
+	case MASK_NULL:
+		return fmt.Sprintf("NULL::%s", column.Type)
+	case MASK_HASH:
+		return fmt.Sprintf("md5('%s' || %s::text)::%s", maskingHashSalt, column.Name, column.Type)
+	case MASK_CONSTANT:
+		return fmt.Sprintf("'%s'::%s", strings.Replace(rule.Value, "'", "''", -1), column.Type)
+	default:
+		return fmt.Sprintf("(%s)::%s", rule.Value, column.Type)
+	}
+}
+
+var characterTypeLengthRegex = regexp.MustCompile(`^character(?: varying)?\((\d+)\)$`)
+
+// A hash cast to a shorter character type would be truncated, making collisions likely
+func canHoldMaskingHash(typeName string) bool {
+	if typeName != "text" && !strings.HasPrefix(typeName, "character") {
+		return false
+	}
+	matches := characterTypeLengthRegex.FindStringSubmatch(typeName)
+	if matches == nil {
+		return true
+	}
+	length, err := strconv.Atoi(matches[1])
+	return err == nil && length >= MASK_HASH_LENGTH
+}
+
+/*
+ * The rows of a table are copied out and restored on the segment that held
+ * them, so the columns of its distribution key must keep their values.
+ */
+func getDistributionKeyColumns(distPolicy string) map[string]bool {
+	keyColumns := make(map[string]bool, 0)
+	if !strings.HasPrefix(distPolicy, "DISTRIBUTED BY (") {
+		return keyColumns
+	}
+	columnList := strings.TrimSuffix(strings.TrimPrefix(distPolicy, "DISTRIBUTED BY ("), ")")
+	for _, column := range utils.SplitColumnList(columnList) {
+		keyColumns[column] = true
+	}
+	return keyColumns
+}
+
+/*
+ * This returns the select list used to copy the data for a table, with each
+ * masked column replaced by its masking expression, in the same column order
+ * as the attribute list recorded for the table in the table of contents.
+ */
+func ConstructMaskedSelectList(tableFQN string, tableDef TableDefinition, rules map[string]MaskingRule) string {
+	keyColumns := getDistributionKeyColumns(tableDef.DistPolicy)
+	selectList := make([]string, 0, len(tableDef.ColumnDefs))
+	for _, column := range tableDef.ColumnDefs {
+		columnFQN := fmt.Sprintf("%s.%s", tableFQN, column.Name)
+		rule, ok := rules[columnFQN]
+		if !ok {
+			selectList = append(selectList, column.Name)
+			continue
+		}
+		if keyColumns[column.Name] {
+			logger.Fatal(errors.Errorf("Cannot mask column %s, as it is part of the distribution key of table %s", columnFQN, tableFQN), "")
+		}
+		if rule.Transform == MASK_NULL && column.NotNull {
+			logger.Fatal(errors.Errorf("Cannot apply the null masking rule to column %s, as it has a NOT NULL constraint", columnFQN), "")
+		}
+		if rule.Transform == MASK_HASH && !canHoldMaskingHash(column.Type) {
+			logger.Fatal(errors.Errorf("Cannot apply the hash masking rule to column %s, as it has type %s.  Only columns of character types that can hold %d characters can be hashed.", columnFQN, column.Type, MASK_HASH_LENGTH), "")
+		}
+		selectList = append(selectList, fmt.Sprintf("%s AS %s", GetMaskingExpression(rule, column), column.Name))
+	}
+	return strings.Join(selectList, ", ")
+}
+
+/*
+ * Every rule must apply to a column of a table whose data is backed up on its
+ * own, so a typo in the rules file cannot leave a column unmasked.  Rules are
+ * matched against every data table, including those an incremental backup
+ * later skips because they are unchanged since the base backup.  Each
+ * masked select list is checked by running a query that returns no rows, so
+ * that an invalid constant or expression is caught before the backup starts.
+ */
+func InitializeMasking(dataTables []Relation, tableDefs map[uint32]TableDefinition) {
+	rulesByColumn := make(map[string]MaskingRule, len(maskingRules))
+	for _, rule := range maskingRules {
+		rulesByColumn[rule.Column] = rule
+	}
+	maskedSelectLists = make(map[uint32]string, 0)
+	maskedColumns := make(map[string]string, 0)
+	for _, table := range dataTables {
+		tableDef := tableDefs[table.Oid]
+		if !BacksUpTableData(tableDef) {
+			continue
+		}
+		isMasked := false
+		for _, column := range tableDef.ColumnDefs {
+			columnFQN := fmt.Sprintf("%s.%s", table.FQN(), column.Name)
+			if rule, ok := rulesByColumn[columnFQN]; ok {
+				isMasked = true
+				maskedColumns[columnFQN] = rule.Transform
+			}
+		}
+		if !isMasked {
+			continue
+		}
+		selectList := ConstructMaskedSelectList(table.FQN(), tableDef, rulesByColumn)
+		_, err := connection.Exec(fmt.Sprintf("SELECT %s FROM %s LIMIT 0;", selectList, table.FQN()))
+		if err != nil {
+			logger.Fatal(errors.Errorf("Invalid masking rules for table %s: %v", table.FQN(), err), "")
+		}
+		maskedSelectLists[table.Oid] = selectList
+	}
+	for _, rule := range maskingRules {
+		if _, ok := maskedColumns[rule.Column]; !ok {
+			logger.Fatal(errors.Errorf("Masking rule for column %s does not match a column of any table whose data is being backed up.  For partition tables with --leaf-partition-data, rules must be specified for each leaf partition.", rule.Column), "")
+		}
+	}
+	backupReport.MaskedColumns = maskedColumns
+	columns := make([]string, 0, len(maskedColumns))
+	for column := range maskedColumns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	logger.Info("Masking data for %d columns in %d tables", len(maskedColumns), len(maskedSelectLists))
+	logger.Verbose("Masked columns: %s", strings.Join(columns, ", "))
+}
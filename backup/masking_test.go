@@ -0,0 +1,193 @@
+package backup_test
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/masking tests", func() {
+	Describe("ReadMaskingRules", func() {
+		var fileContents string
+		BeforeEach(func() {
+			utils.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte(fileContents), nil
+			}
+		})
+		AfterEach(func() {
+			utils.System = utils.InitializeSystemFunctions()
+		})
+		It("reads a file of masking rules", func() {
+			fileContents = `
+- column: public.customers.email
+  transform: hash
+- column: '"Sales"."Customers"."Phone Number"'
+  transform: expression
+  value: "'555-' || right(phone, 4)"
+- column: public.customers.name
+  transform: constant
+  value: REDACTED
+- column: public.customers.ssn
+  transform: "null"
+`
+			rules := backup.ReadMaskingRules("rules.yaml")
+			Expect(rules).To(Equal([]backup.MaskingRule{
+				{Column: "public.customers.email", Transform: "hash"},
+				{Column: `"Sales"."Customers"."Phone Number"`, Transform: "expression", Value: "'555-' || right(phone, 4)"},
+				{Column: "public.customers.name", Transform: "constant", Value: "REDACTED"},
+				{Column: "public.customers.ssn", Transform: "null"},
+			}))
+		})
+		It("panics if a column is not fully-qualified", func() {
+			fileContents = `
+- column: customers.email
+  transform: hash
+`
+			defer testutils.ShouldPanicWithMessage("Column customers.email in masking rules file rules.yaml is not correctly fully-qualified.")
+			backup.ReadMaskingRules("rules.yaml")
+		})
+		It("panics if a column has more than one rule", func() {
+			fileContents = `
+- column: public.customers.email
+  transform: hash
+- column: public.customers.email
+  transform: "null"
+`
+			defer testutils.ShouldPanicWithMessage("Column public.customers.email has more than one masking rule in masking rules file rules.yaml")
+			backup.ReadMaskingRules("rules.yaml")
+		})
+		It("panics if a transform is unknown", func() {
+			fileContents = `
+- column: public.customers.email
+  transform: shuffle
+`
+			defer testutils.ShouldPanicWithMessage("Unknown masking transform shuffle for column public.customers.email.")
+			backup.ReadMaskingRules("rules.yaml")
+		})
+		It("panics if a constant transform has no value", func() {
+			fileContents = `
+- column: public.customers.name
+  transform: constant
+`
+			defer testutils.ShouldPanicWithMessage("The constant masking rule for column public.customers.name must specify a value")
+			backup.ReadMaskingRules("rules.yaml")
+		})
+	})
+	Describe("NewMaskingHashSalt", func() {
+		It("returns a different random salt each time", func() {
+			salt := backup.NewMaskingHashSalt()
+			Expect(salt).To(MatchRegexp("^[0-9a-f]{32}$"))
+			Expect(backup.NewMaskingHashSalt()).ToNot(Equal(salt))
+		})
+	})
+	Describe("GetMaskingRulesChecksum", func() {
+		AfterEach(func() {
+			utils.System = utils.InitializeSystemFunctions()
+		})
+		It("returns the SHA-256 checksum of the rules file", func() {
+			utils.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte("- column: public.customers.email\n  transform: hash\n"), nil
+			}
+			Expect(backup.GetMaskingRulesChecksum("rules.yaml")).To(HaveLen(64))
+			utils.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte(""), nil
+			}
+			Expect(backup.GetMaskingRulesChecksum("rules.yaml")).To(Equal("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
+		})
+	})
+	Describe("ConstructMaskedSelectList", func() {
+		tableDef := backup.TableDefinition{DistPolicy: `DISTRIBUTED BY (region, "Account, ID")`, ColumnDefs: []backup.ColumnDefinition{
+			{Name: "id", Type: "integer", NotNull: true},
+			{Name: "email", Type: "character varying(64)"},
+			{Name: "name", Type: "text"},
+			{Name: "ssn", Type: "character(11)"},
+			{Name: "phone", Type: "text"},
+			{Name: "region", Type: "text"},
+			{Name: `"Account, ID"`, Type: "text"},
+		}}
+		BeforeEach(func() {
+			backup.SetMaskingHashSalt("0123456789abcdef")
+		})
+		AfterEach(func() {
+			backup.SetMaskingHashSalt("")
+		})
+		It("replaces each masked column with its masking expression", func() {
+			rules := map[string]backup.MaskingRule{
+				"public.customers.email": {Column: "public.customers.email", Transform: "hash"},
+				"public.customers.name":  {Column: "public.customers.name", Transform: "constant", Value: "O'Brien"},
+				"public.customers.ssn":   {Column: "public.customers.ssn", Transform: "null"},
+				"public.customers.phone": {Column: "public.customers.phone", Transform: "expression", Value: "'555-' || right(phone, 4)"},
+			}
+			selectList := backup.ConstructMaskedSelectList("public.customers", tableDef, rules)
+			Expect(selectList).To(Equal(`id, md5('0123456789abcdef' || email::text)::character varying(64) AS email, 'O''Brien'::text AS name, NULL::character(11) AS ssn, ('555-' || right(phone, 4))::text AS phone, region, "Account, ID"`))
+		})
+		It("panics if a NOT NULL column would be masked with null", func() {
+			rules := map[string]backup.MaskingRule{"public.customers.id": {Column: "public.customers.id", Transform: "null"}}
+			defer testutils.ShouldPanicWithMessage("Cannot apply the null masking rule to column public.customers.id, as it has a NOT NULL constraint")
+			backup.ConstructMaskedSelectList("public.customers", tableDef, rules)
+		})
+		It("panics if a column of a non-character type would be hashed", func() {
+			rules := map[string]backup.MaskingRule{"public.customers.id": {Column: "public.customers.id", Transform: "hash"}}
+			defer testutils.ShouldPanicWithMessage("Cannot apply the hash masking rule to column public.customers.id, as it has type integer.")
+			backup.ConstructMaskedSelectList("public.customers", tableDef, rules)
+		})
+		It("panics if a column of a character type too short for the hash would be hashed", func() {
+			rules := map[string]backup.MaskingRule{"public.customers.ssn": {Column: "public.customers.ssn", Transform: "hash"}}
+			defer testutils.ShouldPanicWithMessage("Cannot apply the hash masking rule to column public.customers.ssn, as it has type character(11).  Only columns of character types that can hold 32 characters can be hashed.")
+			backup.ConstructMaskedSelectList("public.customers", tableDef, rules)
+		})
+		DescribeTable("panics if a column of the distribution key would be masked", func(column string) {
+			columnFQN := "public.customers." + column
+			rules := map[string]backup.MaskingRule{columnFQN: {Column: columnFQN, Transform: "constant", Value: "x"}}
+			defer testutils.ShouldPanicWithMessage(fmt.Sprintf("Cannot mask column %s, as it is part of the distribution key of table public.customers", columnFQN))
+			backup.ConstructMaskedSelectList("public.customers", tableDef, rules)
+		},
+			Entry("an unquoted column", "region"),
+			Entry("a quoted column containing a comma", `"Account, ID"`),
+		)
+	})
+	Describe("InitializeMasking", func() {
+		customers := backup.Relation{Oid: 1, Schema: "public", Name: "customers"}
+		orders := backup.Relation{Oid: 2, Schema: "public", Name: "orders"}
+		tableDefs := map[uint32]backup.TableDefinition{
+			1: {ColumnDefs: []backup.ColumnDefinition{{Name: "id", Type: "integer"}, {Name: "email", Type: "text"}}},
+			2: {ColumnDefs: []backup.ColumnDefinition{{Name: "id", Type: "integer"}}},
+		}
+		BeforeEach(func() {
+			backup.SetConnection(connection)
+			backup.SetReport(&utils.Report{})
+			backup.SetMaskingHashSalt("0123456789abcdef")
+		})
+		AfterEach(func() {
+			backup.SetMaskingRules(nil)
+			backup.SetMaskedSelectLists(nil)
+			backup.SetMaskingHashSalt("")
+		})
+		It("records the select list and masked columns for each masked table", func() {
+			backup.SetMaskingRules([]backup.MaskingRule{{Column: "public.customers.email", Transform: "hash"}})
+			mock.ExpectExec(regexp.QuoteMeta("SELECT id, md5('0123456789abcdef' || email::text)::text AS email FROM public.customers LIMIT 0;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			backup.InitializeMasking([]backup.Relation{customers, orders}, tableDefs)
+			Expect(backup.GetReport().MaskedColumns).To(Equal(map[string]string{"public.customers.email": "hash"}))
+		})
+		It("panics if a rule does not match any column being backed up", func() {
+			backup.SetMaskingRules([]backup.MaskingRule{{Column: "public.orders.email", Transform: "hash"}})
+			defer testutils.ShouldPanicWithMessage("Masking rule for column public.orders.email does not match a column of any table whose data is being backed up.")
+			backup.InitializeMasking([]backup.Relation{customers, orders}, tableDefs)
+		})
+		It("panics if a masked select list is invalid", func() {
+			backup.SetMaskingRules([]backup.MaskingRule{{Column: "public.customers.email", Transform: "expression", Value: "nosuchfunc(email)"}})
+			mock.ExpectExec(regexp.QuoteMeta("SELECT id, (nosuchfunc(email))::text AS email FROM public.customers LIMIT 0;")).WillReturnError(errors.New("function nosuchfunc(text) does not exist"))
+			defer testutils.ShouldPanicWithMessage("Invalid masking rules for table public.customers: function nosuchfunc(text) does not exist")
+			backup.InitializeMasking([]backup.Relation{customers, orders}, tableDefs)
+		})
+	})
+})
@@ -0,0 +1,99 @@
+package backup
+
+/*
+ * This file contains structs and functions related to executing specific
+ * queries to gather information needed for incremental backups.
+ */
+
+import (
+	"fmt"
+
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+func GetAOIncrementalMetadata(connection *utils.DBConn) map[string]utils.AOEntry {
+	segTableFQNs := getAOSegTableFQNs(connection)
+	modCounts := getModCounts(connection, segTableFQNs)
+	lastDDLTimestamps := getLastDDLTimestamps(connection)
+	aoTableEntries := make(map[string]utils.AOEntry, len(segTableFQNs))
+	for aoTableFQN := range segTableFQNs {
+		aoTableEntries[aoTableFQN] = utils.AOEntry{
+			Modcount:         modCounts[aoTableFQN],
+			LastDDLTimestamp: lastDDLTimestamps[aoTableFQN],
+		}
+	}
+	return aoTableEntries
+}
+
+/*
+ * Each append-optimized table has an auxiliary "aoseg" table in the pg_aoseg
+ * schema, whose modcount column is incremented on every modification of the
+ * corresponding segment file.
+ */
+func getAOSegTableFQNs(connection *utils.DBConn) map[string]string {
+	query := fmt.Sprintf(`
+SELECT
+	quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS aotablefqn,
+	'pg_aoseg.' || quote_ident(seg.relname) AS aosegtablefqn
+FROM pg_class c
+JOIN pg_namespace n ON c.relnamespace = n.oid
+JOIN pg_appendonly a ON c.oid = a.relid
+JOIN pg_class seg ON a.segrelid = seg.oid
+WHERE %s
+AND c.relstorage IN ('ao', 'co');`, tableAndSchemaFilterClause())
+
+	results := make([]struct {
+		AOTableFQN    string
+		AOSegTableFQN string
+	}, 0)
+	err := connection.Select(&results, query)
+	utils.CheckError(err)
+	resultMap := make(map[string]string, len(results))
+	for _, result := range results {
+		resultMap[result.AOTableFQN] = result.AOSegTableFQN
+	}
+	return resultMap
+}
+
+func getModCounts(connection *utils.DBConn, segTableFQNs map[string]string) map[string]int64 {
+	modCounts := make(map[string]int64, len(segTableFQNs))
+	for aoTableFQN, segTableFQN := range segTableFQNs {
+		query := fmt.Sprintf("SELECT COALESCE(sum(modcount), 0) AS modcount FROM gp_dist_random('%s');", segTableFQN)
+		var modCount int64
+		err := connection.Get(&modCount, query)
+		utils.CheckError(err)
+		modCounts[aoTableFQN] = modCount
+	}
+	return modCounts
+}
+
+/*
+ * Operations like TRUNCATE or ALTER TABLE may rewrite an append-optimized
+ * table without changing its modcount, so we also track the last time that
+ * such an operation was performed on each table.
+ */
+func getLastDDLTimestamps(connection *utils.DBConn) map[string]string {
+	query := fmt.Sprintf(`
+SELECT
+	quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS aotablefqn,
+	max(l.statime)::text AS lastddltimestamp
+FROM pg_class c
+JOIN pg_namespace n ON c.relnamespace = n.oid
+JOIN pg_stat_last_operation l ON c.oid = l.objid
+WHERE %s
+AND c.relstorage IN ('ao', 'co')
+AND l.staactionname IN ('CREATE', 'ALTER', 'TRUNCATE')
+GROUP BY n.nspname, c.relname;`, tableAndSchemaFilterClause())
+
+	results := make([]struct {
+		AOTableFQN       string
+		LastDDLTimestamp string
+	}, 0)
+	err := connection.Select(&results, query)
+	utils.CheckError(err)
+	resultMap := make(map[string]string, len(results))
+	for _, result := range results {
+		resultMap[result.AOTableFQN] = result.LastDDLTimestamp
+	}
+	return resultMap
+}
@@ -36,6 +36,50 @@ WHERE quote_ident(n.nspname) || '.' || quote_ident(c.relname) IN (%s)`, tableLis
 	return utils.SelectStringSlice(connection, query)
 }
 
+func GetUserSchemaNames(connection *utils.DBConn) []string {
+	query := fmt.Sprintf(`
+SELECT
+	n.nspname AS string
+FROM pg_namespace n
+WHERE %s
+ORDER BY n.nspname;`, UserSchemaClause("n"))
+	return utils.SelectStringSlice(connection, query)
+}
+
+/*
+ * This returns the names of all tables in the schemas being backed up that
+ * may be passed as table filters, for matching against table filter patterns.
+ * Intermediate partition tables cannot be used as table filters, so they are
+ * left out.
+ */
+func GetFilterableTableFQNs(connection *utils.DBConn) []string {
+	query := fmt.Sprintf(`
+SELECT
+	c.oid,
+	quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS name
+FROM pg_class c
+JOIN pg_namespace n
+	ON c.relnamespace = n.oid
+WHERE %s
+AND relkind = 'r'
+ORDER BY n.nspname, c.relname;`, SchemaFilterClause("n"))
+	results := make([]struct {
+		Oid  uint32
+		Name string
+	}, 0)
+	err := connection.Select(&results, query)
+	utils.CheckError(err)
+
+	partTableMap := GetPartitionTableMap(connection)
+	tableFQNs := make([]string, 0)
+	for _, table := range results {
+		if partTableMap[table.Oid] != "i" {
+			tableFQNs = append(tableFQNs, table.Name)
+		}
+	}
+	return tableFQNs
+}
+
 func GetAllUserTables(connection *utils.DBConn) []Relation {
 	if len(includeTables) > 0 {
 		return GetUserTablesWithIncludeFiltering(connection)
@@ -189,6 +233,56 @@ JOIN (
 	return SelectAsOidToStringMap(connection, query)
 }
 
+// This returns a map of each parent partition table to all of its descendant partitions
+func GetPartitionDescendants(connection *utils.DBConn) map[uint32][]uint32 {
+	query := `
+SELECT
+	p.parrelid AS parentoid,
+	r.parchildrelid AS childoid
+FROM pg_partition p
+JOIN pg_partition_rule r
+	ON p.oid = r.paroid
+WHERE p.paristemplate = false;`
+	results := make([]struct {
+		ParentOid uint32
+		ChildOid  uint32
+	}, 0)
+	err := connection.Select(&results, query)
+	utils.CheckError(err)
+	descendants := make(map[uint32][]uint32, 0)
+	for _, result := range results {
+		descendants[result.ParentOid] = append(descendants[result.ParentOid], result.ChildOid)
+	}
+	return descendants
+}
+
+type SegmentTableSize struct {
+	Oid       uint32
+	ContentID int
+	Size      uint64
+}
+
+/*
+ * This returns the on-disk size of each of the given tables on each segment,
+ * including the size of its TOAST table but not the size of its indexes.
+ */
+func GetSegmentTableSizes(connection *utils.DBConn, oids []string) []SegmentTableSize {
+	results := make([]SegmentTableSize, 0)
+	if len(oids) == 0 {
+		return results
+	}
+	query := fmt.Sprintf(`
+SELECT
+	c.oid,
+	c.gp_segment_id AS contentid,
+	pg_relation_size(c.oid) + CASE WHEN c.reltoastrelid = 0 THEN 0 ELSE pg_relation_size(c.reltoastrelid) END AS size
+FROM gp_dist_random('pg_class') c
+WHERE c.oid IN (%s);`, strings.Join(oids, ", "))
+	err := connection.Select(&results, query)
+	utils.CheckError(err)
+	return results
+}
+
 type ColumnDefinition struct {
 	Oid         uint32 `db:"attrelid"`
 	Num         int    `db:"attnum"`
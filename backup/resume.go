@@ -0,0 +1,281 @@
+package backup
+
+/*
+ * This file contains structs and functions related to recording the progress
+ * of a backup and resuming a backup that was interrupted while backing up data.
+ */
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+ * The progress file is written once all metadata has been backed up, and again
+ * once all data has been backed up, and holds everything needed to finish the
+ * backup.  The table of contents it holds has a data entry for every table in
+ * the backup set, in the order in which they appear in the final TOC.  The
+ * tables whose data has been backed up are recorded separately, one per line,
+ * so that the progress file need not be rewritten after every table.
+ */
+type BackupProgress struct {
+	BackupConfig     utils.BackupConfig
+	TOC              *utils.TOC
+	ObjectCounts     map[string]int
+	DataComplete     bool
+	ResumedSnapshots []utils.ResumedSnapshot
+}
+
+var dataProgressLock sync.Mutex
+
+func NewBackupProgress() *BackupProgress {
+	return &BackupProgress{
+		BackupConfig: backupReport.BackupConfig,
+		TOC:          globalTOC,
+		ObjectCounts: objectCounts,
+	}
+}
+
+func ReadBackupProgress(filename string) *BackupProgress {
+	progress := &BackupProgress{}
+	contents, err := utils.System.ReadFile(filename)
+	utils.CheckError(err)
+	err = yaml.Unmarshal(contents, progress)
+	if err != nil {
+		logger.Fatal(err, "Unable to parse backup progress file %s", filename)
+	}
+	progress.TOC.InitializeEntryMap()
+	return progress
+}
+
+// The file is replaced rather than overwritten, so an interruption cannot leave it partially written
+func (progress *BackupProgress) WriteToFile(filename string) {
+	progressFile := utils.MustOpenFileForAtomicWriting(filename)
+	contents, _ := yaml.Marshal(progress)
+	utils.MustPrintBytes(progressFile, contents)
+	progressFile.Close()
+}
+
+/*
+ * Progress is only recorded once the progress file has been written, so no
+ * progress is recorded for metadata-only backups.  The number of rows backed
+ * up for each table is recorded with its oid, for the report.
+ */
+func RecordTableDataBackedUp(oid uint32, numRows int64) {
+	dataProgressLock.Lock()
+	defer dataProgressLock.Unlock()
+	if tableRowCounts == nil {
+		tableRowCounts = make(map[uint32]int64, 0)
+	}
+	tableRowCounts[oid] = numRows
+	if backupProgress == nil {
+		return
+	}
+	progressFile := utils.MustOpenFileForWriting(globalCluster.GetBackupFilePath("data progress"), true)
+	defer progressFile.Close()
+	utils.MustPrintf(progressFile, "%d %d\n", oid, numRows)
+}
+
+func GetTablesAlreadyBackedUp() map[uint32]bool {
+	backedUp := make(map[uint32]bool, 0)
+	for oid := range GetRowCountsAlreadyBackedUp() {
+		backedUp[oid] = true
+	}
+	return backedUp
+}
+
+func GetRowCountsAlreadyBackedUp() map[uint32]int64 {
+	rowCounts := make(map[uint32]int64, 0)
+	filename := globalCluster.GetBackupFilePath("data progress")
+	if !utils.FileExistsAndIsReadable(filename) {
+		return rowCounts
+	}
+	for _, line := range utils.ReadLinesFromFile(filename) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		oid, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			continue
+		}
+		var numRows int64
+		if len(fields) > 1 {
+			numRows, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+		rowCounts[uint32(oid)] = numRows
+	}
+	return rowCounts
+}
+
+func RemoveBackupProgressFiles() {
+	for _, filename := range []string{globalCluster.GetBackupFilePath("progress"), globalCluster.GetBackupFilePath("data progress")} {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			logger.Warn("Unable to remove backup progress file %s", filename)
+		}
+	}
+}
+
+/*
+ * This is called during setup, after the cluster, report, and TOC for the new
+ * backup have been initialized, and replaces the state that would have been
+ * created by backing up metadata with the state recorded in the progress file.
+ */
+func InitializeResume() {
+	progressFilename := globalCluster.GetBackupFilePath("progress")
+	if !utils.FileExistsAndIsReadable(progressFilename) {
+		logger.Fatal(errors.Errorf("Backup %s cannot be resumed because no progress was recorded for it.  Only backups interrupted while backing up data can be resumed.", *resume), "")
+	}
+	progress := ReadBackupProgress(progressFilename)
+	ValidateResumedBackup(&progress.BackupConfig)
+	backupProgress = progress
+	globalTOC = progress.TOC
+	backupReport.RestorePlan = progress.BackupConfig.RestorePlan
+	backupReport.ResumedSnapshots = progress.ResumedSnapshots
+	backupReport.SegmentDataSizes = progress.BackupConfig.SegmentDataSizes
+
+	// The config and report files are made read-only when the interrupted backup exits
+	utils.System.Chmod(globalCluster.GetConfigFilePath(), 0644)
+	utils.System.Chmod(globalCluster.GetReportFilePath(), 0644)
+}
+
+func ValidateResumedBackup(config *utils.BackupConfig) {
+	errMsg := ""
+	if config.DatabaseName != backupReport.DatabaseName {
+		errMsg = "it is a backup of a different database"
+	} else if config.MetadataOnly {
+		errMsg = "it is a metadata-only backup"
+	} else if config.DataOnly != backupReport.DataOnly || config.WithStatistics != backupReport.WithStatistics ||
+		config.SchemaFiltered != backupReport.SchemaFiltered || config.TableFiltered != backupReport.TableFiltered ||
+		config.LeafPartitionData != backupReport.LeafPartitionData || config.Incremental != backupReport.Incremental ||
+		config.IncludeExternalData != backupReport.IncludeExternalData {
+		errMsg = "its options do not match those of the current backup"
+	} else if config.Compressed != backupReport.Compressed || config.CompressionType != backupReport.CompressionType {
+		errMsg = "its compression settings do not match those of the current backup"
+	} else if config.CompressedFrames != backupReport.CompressedFrames {
+		errMsg = "its data files were compressed by an earlier version of gpbackup"
+	} else if config.GetDataFormat() != backupReport.GetDataFormat() {
+		errMsg = "its data format does not match that of the current backup"
+	} else if config.SingleDataFile != backupReport.SingleDataFile {
+		errMsg = "its --single-data-file setting does not match that of the current backup"
+	} else if config.Encrypted != backupReport.Encrypted || (config.Encrypted && !utils.MatchEncryptionKeyFingerprint(config.EncryptionKeyFingerprint)) {
+		errMsg = "it was not encrypted with the same key as the current backup"
+	} else if config.MaskingRulesChecksum != backupReport.MaskingRulesChecksum {
+		errMsg = "its data was not masked with the same masking rules as the current backup"
+	} else if config.SingleDataFile && pluginConfig != nil {
+		errMsg = "single-data-file backups stored with a plugin cannot be resumed"
+	}
+	if errMsg != "" {
+		logger.Fatal(errors.Errorf("Backup %s cannot be resumed because %s", *resume, errMsg), "")
+	}
+	backupReport.EncryptionKeyFingerprint = config.EncryptionKeyFingerprint
+}
+
+/*
+ * This returns the tables in the backup set whose data has not yet been backed
+ * up, in the current snapshot.  Every such table must still exist with the same
+ * columns, since its metadata was backed up in the original snapshot.
+ */
+func GetTablesToResume(dataTables []Relation, tableDefs map[uint32]TableDefinition, backedUp map[uint32]bool) []Relation {
+	currentTables := make(map[uint32]Relation, len(dataTables))
+	for _, table := range dataTables {
+		currentTables[table.Oid] = table
+	}
+	tablesToResume := make([]Relation, 0)
+	for _, entry := range globalTOC.DataEntries {
+		if backedUp[entry.Oid] {
+			continue
+		}
+		fqn := utils.MakeFQN(entry.Schema, entry.Name)
+		table, ok := currentTables[entry.Oid]
+		if !ok {
+			logger.Fatal(errors.Errorf("Table %s has been dropped or is no longer in the backup set, so backup %s cannot be resumed", fqn, *resume), "")
+		}
+		if ConstructTableAttributesList(tableDefs[entry.Oid].ColumnDefs) != entry.AttributeString {
+			logger.Fatal(errors.Errorf("The columns of table %s have changed, so backup %s cannot be resumed", fqn, *resume), "")
+		}
+		tablesToResume = append(tablesToResume, table)
+	}
+	return tablesToResume
+}
+
+/*
+ * A table backed up in an earlier resumed snapshot but interrupted again is
+ * listed only under the snapshot in which its data was finally backed up.
+ */
+func AddResumedSnapshot(tables []Relation, startTime string) {
+	tableFQNs := make([]string, 0, len(tables))
+	resumedTables := make(map[string]bool, len(tables))
+	for _, table := range tables {
+		tableFQNs = append(tableFQNs, table.FQN())
+		resumedTables[table.FQN()] = true
+	}
+	snapshots := make([]utils.ResumedSnapshot, 0)
+	for _, snapshot := range backupProgress.ResumedSnapshots {
+		remainingTables := make([]string, 0)
+		for _, fqn := range snapshot.Tables {
+			if !resumedTables[fqn] {
+				remainingTables = append(remainingTables, fqn)
+			}
+		}
+		if len(remainingTables) > 0 {
+			snapshots = append(snapshots, utils.ResumedSnapshot{StartTime: snapshot.StartTime, Tables: remainingTables})
+		}
+	}
+	if len(tables) > 0 {
+		snapshots = append(snapshots, utils.ResumedSnapshot{StartTime: startTime, Tables: tableFQNs})
+	}
+	backupProgress.ResumedSnapshots = snapshots
+	backupReport.ResumedSnapshots = snapshots
+}
+
+/*
+ * For single-data-file backups, the data for the tables already backed up
+ * must be kept in each segment's data file.  The data for a table whose
+ * backup was interrupted may be partially written on some segments, so only
+ * the data up to the end of the last table backed up on every segment is kept.
+ */
+func PrepareSegmentDataFilesForResume(backedUp map[uint32]bool) {
+	if len(backedUp) == 0 {
+		globalCluster.PrepareSegmentDataFilesForResume(nil)
+		return
+	}
+	resumeByteCounts = GetResumeByteCounts(globalCluster.ReadSegmentTOCs(), backedUp)
+	globalCluster.PrepareSegmentDataFilesForResume(resumeByteCounts)
+}
+
+func GetResumeByteCounts(segmentTOCs map[int]*utils.SegmentTOC, backedUp map[uint32]bool) map[int]utils.ResumeByteCount {
+	byteCounts := make(map[int]utils.ResumeByteCount, len(segmentTOCs))
+	for contentID, toc := range segmentTOCs {
+		var byteCount utils.ResumeByteCount
+		for oid := range backedUp {
+			entry, ok := toc.DataEntries[uint(oid)]
+			if !ok {
+				logger.Fatal(errors.Errorf("Segment %d: No data for table with oid %d in segment table of contents, so backup %s cannot be resumed", contentID, oid, *resume), "")
+			}
+			if entry.EndByte > byteCount.Bytes {
+				byteCount.Bytes = entry.EndByte
+			}
+			if entry.CompressedEndByte > byteCount.CompressedBytes {
+				byteCount.CompressedBytes = entry.CompressedEndByte
+			}
+		}
+		byteCounts[contentID] = byteCount
+	}
+	return byteCounts
+}
+
+func LogResumeInfo(numTables int, numBackedUp int) {
+	logger.Info("Resuming backup %s with data for %d of %d tables remaining", *resume, numTables, numTables+numBackedUp)
+	if numTables > 0 {
+		logger.Warn("The data for the remaining tables will be backed up in a different snapshot; see %s for a list of these tables", globalCluster.GetReportFilePath())
+	}
+	logger.Verbose("Data for %d tables was backed up before backup %s was interrupted", numBackedUp, *resume)
+}
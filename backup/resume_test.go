@@ -0,0 +1,126 @@
+package backup_test
+
+import (
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/resume tests", func() {
+	fooTable := backup.Relation{Oid: 1, Schema: "public", Name: "foo"}
+	barTable := backup.Relation{Oid: 2, Schema: "public", Name: "bar"}
+	bazTable := backup.Relation{Oid: 3, Schema: "public", Name: "baz"}
+	tableDefs := map[uint32]backup.TableDefinition{
+		1: {ColumnDefs: []backup.ColumnDefinition{{Name: "i"}}},
+		2: {ColumnDefs: []backup.ColumnDefinition{{Name: "i"}}},
+		3: {ColumnDefs: []backup.ColumnDefinition{{Name: "i"}, {Name: "j"}}},
+	}
+
+	BeforeEach(func() {
+		backup.SetResume("20170101010101")
+		toc := &utils.TOC{DataEntries: []utils.MasterDataEntry{
+			{Schema: "public", Name: "foo", Oid: 1, AttributeString: "(i)"},
+			{Schema: "public", Name: "bar", Oid: 2, AttributeString: "(i)"},
+			{Schema: "public", Name: "baz", Oid: 3, AttributeString: "(i,j)"},
+		}}
+		backup.SetTOC(toc)
+	})
+	Describe("GetTablesToResume", func() {
+		It("returns the tables whose data has not been backed up, in TOC order", func() {
+			tables := backup.GetTablesToResume([]backup.Relation{bazTable, barTable, fooTable}, tableDefs, map[uint32]bool{2: true})
+			Expect(tables).To(Equal([]backup.Relation{fooTable, bazTable}))
+		})
+		It("panics if a table has been dropped", func() {
+			defer testutils.ShouldPanicWithMessage("Table public.baz has been dropped or is no longer in the backup set, so backup 20170101010101 cannot be resumed")
+			backup.GetTablesToResume([]backup.Relation{fooTable, barTable}, tableDefs, map[uint32]bool{})
+		})
+		It("panics if the columns of a table have changed", func() {
+			changedDefs := map[uint32]backup.TableDefinition{1: tableDefs[1], 2: tableDefs[2], 3: {ColumnDefs: []backup.ColumnDefinition{{Name: "i"}}}}
+			defer testutils.ShouldPanicWithMessage("The columns of table public.baz have changed, so backup 20170101010101 cannot be resumed")
+			backup.GetTablesToResume([]backup.Relation{fooTable, barTable, bazTable}, changedDefs, map[uint32]bool{})
+		})
+	})
+	Describe("AddResumedSnapshot", func() {
+		var progress *backup.BackupProgress
+		BeforeEach(func() {
+			backup.SetReport(&utils.Report{})
+			progress = &backup.BackupProgress{}
+			backup.SetBackupProgress(progress)
+		})
+		AfterEach(func() {
+			backup.SetBackupProgress(nil)
+		})
+		It("records the tables backed up in the new snapshot", func() {
+			backup.AddResumedSnapshot([]backup.Relation{barTable, bazTable}, "2017-01-01 02:02:02")
+			Expect(progress.ResumedSnapshots).To(Equal([]utils.ResumedSnapshot{{StartTime: "2017-01-01 02:02:02", Tables: []string{"public.bar", "public.baz"}}}))
+			Expect(backup.GetReport().ResumedSnapshots).To(Equal(progress.ResumedSnapshots))
+		})
+		It("removes tables backed up again from earlier snapshots", func() {
+			progress.ResumedSnapshots = []utils.ResumedSnapshot{{StartTime: "2017-01-01 02:02:02", Tables: []string{"public.bar", "public.baz"}}}
+			backup.AddResumedSnapshot([]backup.Relation{bazTable}, "2017-01-01 03:03:03")
+			Expect(progress.ResumedSnapshots).To(Equal([]utils.ResumedSnapshot{
+				{StartTime: "2017-01-01 02:02:02", Tables: []string{"public.bar"}},
+				{StartTime: "2017-01-01 03:03:03", Tables: []string{"public.baz"}},
+			}))
+		})
+	})
+	Describe("GetResumeByteCounts", func() {
+		segmentTOCs := map[int]*utils.SegmentTOC{
+			0: {LastByteRead: 300, DataEntries: map[uint]utils.SegmentDataEntry{1: {StartByte: 0, EndByte: 100}, 2: {StartByte: 100, EndByte: 150}, 3: {StartByte: 150, EndByte: 300}}},
+			1: {LastByteRead: 80, DataEntries: map[uint]utils.SegmentDataEntry{1: {StartByte: 0, EndByte: 50}, 2: {StartByte: 50, EndByte: 80}}},
+		}
+		It("returns the end of the data for the last table backed up on each segment", func() {
+			Expect(backup.GetResumeByteCounts(segmentTOCs, map[uint32]bool{1: true, 2: true})).To(Equal(map[int]utils.ResumeByteCount{0: {Bytes: 150}, 1: {Bytes: 80}}))
+		})
+		It("returns the end of the compressed frame for the last table backed up on each segment", func() {
+			frameTOCs := map[int]*utils.SegmentTOC{
+				0: {LastByteRead: 300, LastCompressedByteRead: 90, CompressionType: "gzip", DataEntries: map[uint]utils.SegmentDataEntry{1: {StartByte: 0, EndByte: 100, CompressedStartByte: 0, CompressedEndByte: 30}, 2: {StartByte: 100, EndByte: 300, CompressedStartByte: 30, CompressedEndByte: 90}}},
+			}
+			Expect(backup.GetResumeByteCounts(frameTOCs, map[uint32]bool{1: true})).To(Equal(map[int]utils.ResumeByteCount{0: {Bytes: 100, CompressedBytes: 30}}))
+		})
+		It("panics if a segment has no data for a table that was backed up", func() {
+			defer testutils.ShouldPanicWithMessage("Segment 1: No data for table with oid 3 in segment table of contents, so backup 20170101010101 cannot be resumed")
+			backup.GetResumeByteCounts(segmentTOCs, map[uint32]bool{3: true})
+		})
+	})
+	Describe("ValidateResumedBackup", func() {
+		var config utils.BackupConfig
+		BeforeEach(func() {
+			config = utils.BackupConfig{DatabaseName: "testdb", Compressed: true, CompressionType: "gzip", SingleDataFile: true}
+			backup.SetReport(&utils.Report{BackupConfig: config})
+			backup.SetPluginConfig(nil)
+		})
+		It("does not panic if the options of the backups match", func() {
+			backup.ValidateResumedBackup(&config)
+		})
+		It("panics if the backup is of a different database", func() {
+			config.DatabaseName = "otherdb"
+			defer testutils.ShouldPanicWithMessage("Backup 20170101010101 cannot be resumed because it is a backup of a different database")
+			backup.ValidateResumedBackup(&config)
+		})
+		It("panics if the masking rules differ", func() {
+			config.MaskingRulesChecksum = "0123abcd"
+			defer testutils.ShouldPanicWithMessage("its data was not masked with the same masking rules as the current backup")
+			backup.ValidateResumedBackup(&config)
+		})
+		It("panics if the compression settings differ", func() {
+			config.CompressionType = "zstd"
+			defer testutils.ShouldPanicWithMessage("Backup 20170101010101 cannot be resumed because its compression settings do not match those of the current backup")
+			backup.ValidateResumedBackup(&config)
+		})
+		It("panics if the data files of one backup are compressed in frames and the other are not", func() {
+			config.CompressedFrames = true
+			defer testutils.ShouldPanicWithMessage("Backup 20170101010101 cannot be resumed because its data files were compressed by an earlier version of gpbackup")
+			backup.ValidateResumedBackup(&config)
+		})
+		It("panics if a single-data-file backup uses a plugin", func() {
+			backup.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin"})
+			defer backup.SetPluginConfig(nil)
+			defer testutils.ShouldPanicWithMessage("Backup 20170101010101 cannot be resumed because single-data-file backups stored with a plugin cannot be resumed")
+			backup.ValidateResumedBackup(&config)
+		})
+	})
+})
@@ -2,9 +2,9 @@ package backup
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/greenplum-db/gpbackup/utils"
-	"github.com/pkg/errors"
 )
 
 /*
@@ -16,6 +16,7 @@ func validateFilterLists() {
 	ValidateFilterSchemas(connection, includeSchemas)
 	ValidateFilterTables(connection, excludeTables)
 	ValidateFilterTables(connection, includeTables)
+	ValidateTablePredicates(connection, tablePredicates)
 }
 
 func ValidateFilterSchemas(connection *utils.DBConn, schemaList utils.ArrayFlags) {
@@ -27,7 +28,7 @@ func ValidateFilterSchemas(connection *utils.DBConn, schemaList utils.ArrayFlags
 			schemaSet := utils.NewIncludeSet(resultSchemas)
 			for _, schema := range schemaList {
 				if !schemaSet.MatchesFilter(schema) {
-					logger.Fatal(nil, "Schema %s does not exist", schema)
+					logger.Fatal(utils.NewFlagError("Schema %s does not exist", schema), "")
 				}
 			}
 		}
@@ -60,15 +61,79 @@ WHERE quote_ident(n.nspname) || '.' || quote_ident(c.relname) IN (%s)`, quotedTa
 		for _, table := range tableList {
 			tableOid := tableMap[table]
 			if tableOid == 0 {
-				logger.Fatal(nil, "Table %s does not exist", table)
+				logger.Fatal(utils.NewFlagError("Table %s does not exist", table), "")
 			}
 			if partTableMap[tableOid] == "i" {
-				logger.Fatal(nil, "Cannot filter on %s, as it is an intermediate partition table.  Only parent partition tables and leaf partition tables may be specified.", table)
+				logger.Fatal(utils.NewFlagError("Cannot filter on %s, as it is an intermediate partition table.  Only parent partition tables and leaf partition tables may be specified.", table), "")
 			}
 		}
 	}
 }
 
+/*
+ * Each predicate is checked by running a query that returns no rows, so that a
+ * typo in a predicate is caught before the backup starts rather than when the
+ * data for that table is backed up.
+ */
+func ValidateTablePredicates(connection *utils.DBConn, predicates map[string]string) {
+	tables := make([]string, 0, len(predicates))
+	for table := range predicates {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		query := fmt.Sprintf("SELECT * FROM %s WHERE %s LIMIT 0;", table, predicates[table])
+		_, err := connection.Exec(query)
+		if err != nil {
+			logger.Fatal(utils.NewFlagError("Invalid predicate for table %s: %v", table, err), "")
+		}
+	}
+}
+
+/*
+ * A predicate restricts the rows copied from a single table, so it must be
+ * given for a table whose data is backed up on its own.  With
+ * --leaf-partition-data, the data for a partition table is backed up from its
+ * leaf partitions, so predicates must be given for those instead.
+ */
+func ValidateTablePredicatesApplyToDataTables(dataTables []Relation, tableDefs map[uint32]TableDefinition) {
+	dataTableSet := utils.NewEmptyIncludeSet()
+	for _, table := range dataTables {
+		if BacksUpTableData(tableDefs[table.Oid]) {
+			dataTableSet.Add(table.FQN())
+		}
+	}
+	for table := range tablePredicates {
+		if !dataTableSet.MatchesFilter(table) {
+			logger.Fatal(utils.NewFlagError("Cannot back up a subset of the rows of table %s, as its data is not backed up from that table directly.  Predicates may not be specified for external tables without --include-external-data, or for partition tables when --leaf-partition-data is used.", table), "")
+		}
+	}
+}
+
+/*
+ * The rows of a table with a predicate or masked columns are copied from a
+ * query on the table, which cannot skip the external partitions of a partition
+ * table the way COPY ... IGNORE EXTERNAL PARTITIONS does, so such tables must
+ * be backed up from their leaf partitions with --leaf-partition-data instead.
+ */
+func ValidateTablesWithExternalPartitions(dataTables []Relation, extPartitions []PartitionInfo) {
+	hasExternalPartitions := make(map[uint32]bool, 0)
+	for _, partInfo := range extPartitions {
+		hasExternalPartitions[partInfo.ParentRelationOid] = true
+	}
+	for _, table := range dataTables {
+		if !hasExternalPartitions[table.Oid] {
+			continue
+		}
+		if _, ok := tablePredicates[table.FQN()]; ok {
+			logger.Fatal(utils.NewFlagError("Cannot back up a subset of the rows of table %s, as it has external partitions.  Use --leaf-partition-data and specify predicates for its leaf partitions instead.", table.FQN()), "")
+		}
+		if _, ok := maskedSelectLists[table.Oid]; ok {
+			logger.Fatal(utils.NewFlagError("Cannot mask the data of table %s, as it has external partitions.  Use --leaf-partition-data and specify masking rules for its leaf partitions instead.", table.FQN()), "")
+		}
+	}
+}
+
 func ValidateFlagCombinations() {
 	utils.CheckMandatoryFlags("dbname")
 
@@ -78,19 +143,106 @@ func ValidateFlagCombinations() {
 	utils.CheckExclusiveFlags("exclude-schema", "include-schema")
 	utils.CheckExclusiveFlags("exclude-schema", "exclude-table-file", "include-table-file")
 	utils.CheckExclusiveFlags("exclude-table-file", "leaf-partition-data")
+	utils.CheckExclusiveFlags("include-schema", "include-table-pattern")
+	utils.CheckExclusiveFlags("include-schema-pattern", "include-table-file")
+	utils.CheckExclusiveFlags("include-schema-pattern", "include-table-pattern")
+	utils.CheckExclusiveFlags("exclude-schema", "include-schema-pattern")
+	utils.CheckExclusiveFlags("exclude-schema-pattern", "include-schema")
+	utils.CheckExclusiveFlags("exclude-schema-pattern", "include-schema-pattern")
+	utils.CheckExclusiveFlags("exclude-schema", "exclude-table-pattern", "include-table-pattern")
+	utils.CheckExclusiveFlags("exclude-schema-pattern", "exclude-table-file", "include-table-file")
+	utils.CheckExclusiveFlags("exclude-schema-pattern", "exclude-table-pattern", "include-table-pattern")
+	utils.CheckExclusiveFlags("exclude-table-file", "include-table-pattern")
+	utils.CheckExclusiveFlags("exclude-table-pattern", "include-table-file")
+	utils.CheckExclusiveFlags("exclude-table-pattern", "leaf-partition-data")
 	utils.CheckExclusiveFlags("metadata-only", "leaf-partition-data")
 	utils.CheckExclusiveFlags("metadata-only", "single-data-file")
 	utils.CheckExclusiveFlags("no-compression", "compression-level")
+	utils.CheckExclusiveFlags("no-compression", "compression-type")
+	utils.CheckExclusiveFlags("data-only", "incremental")
+	utils.CheckExclusiveFlags("metadata-only", "incremental")
+	utils.CheckExclusiveFlags("jobs", "metadata-only", "single-data-file")
+	utils.CheckExclusiveFlags("encryption-key-command", "encryption-key-file")
+	utils.CheckExclusiveFlags("metadata-only", "resume")
+	utils.CheckExclusiveFlags("metadata-only", "masking-rules-file")
+	utils.CheckExclusiveFlags("masking-rules-file", "with-stats")
+	utils.CheckExclusiveFlags("metadata-only", "include-external-data")
+	utils.CheckExclusiveFlags("metadata-only", "data-format")
+	utils.CheckExclusiveFlags("dry-run", "plugin-config")
+	utils.CheckExclusiveFlags("dry-run", "resume")
+	utils.CheckExclusiveFlags("metadata-only", "no-disk-space-check")
+	utils.CheckExclusiveFlags("no-disk-space-check", "disk-space-warning-threshold")
 }
 
-func ValidateCompressionLevel(compressionLevel int) {
+func ValidateCompressionTypeAndLevel(compressionType string, compressionLevel int) {
+	if compressionType == "none" {
+		if compressionLevel != 0 {
+			logger.Fatal(utils.NewFlagError("Cannot specify a compression level with compression type none"), "")
+		}
+		return
+	}
+	maxLevel, ok := utils.GetMaxCompressionLevel(compressionType)
+	if !ok {
+		logger.Fatal(utils.NewFlagError("Unknown compression type %s.  Valid types are gzip, lz4, zstd, and none.", compressionType), "")
+	}
 	//We treat 0 as a default value and so assume the flag is not set if it is 0
-	if compressionLevel < 0 || compressionLevel > 9 {
-		logger.Fatal(errors.Errorf("Compression level must be between 1 and 9"), "")
+	if compressionLevel < 0 || compressionLevel > maxLevel {
+		logger.Fatal(utils.NewFlagError("Compression level must be between 1 and %d", maxLevel), "")
+	}
+}
+
+func ValidateIncrementalFlags() {
+	if *incremental {
+		if !*leafPartitionData {
+			logger.Fatal(utils.NewFlagError("--leaf-partition-data must be specified with --incremental"), "")
+		}
+		if *fromTimestamp == "" {
+			logger.Fatal(utils.NewFlagError("--from-timestamp must be specified with --incremental"), "")
+		}
+	} else if *fromTimestamp != "" {
+		logger.Fatal(utils.NewFlagError("--from-timestamp may only be specified with --incremental"), "")
+	}
+	if *fromTimestamp != "" && !utils.IsValidTimestamp(*fromTimestamp) {
+		logger.Fatal(utils.NewFlagError("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", *fromTimestamp), "")
 	}
 }
 
+func ValidateNumJobs(jobs int) {
+	if jobs < 1 {
+		logger.Fatal(utils.NewFlagError("The number of jobs must be at least 1"), "")
+	}
+}
+
+func ValidateEncryptionFlags() {
+	hasKey := *encryptionKeyFile != "" || *encryptionKeyCommand != ""
+	if *encrypt && !hasKey {
+		logger.Fatal(utils.NewFlagError("--encryption-key-file or --encryption-key-command must be specified with --encrypt"), "")
+	} else if !*encrypt && hasKey {
+		logger.Fatal(utils.NewFlagError("--encryption-key-file and --encryption-key-command may only be specified with --encrypt"), "")
+	}
+}
+
+func ValidateResumeFlags() {
+	if *resume != "" && !utils.IsValidTimestamp(*resume) {
+		logger.Fatal(utils.NewFlagError("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", *resume), "")
+	}
+}
+
+func ValidateFilterPatternFlags() {
+	utils.ValidateFilterPatterns("exclude-schema-pattern", excludeSchemaPatterns)
+	utils.ValidateFilterPatterns("exclude-table-pattern", excludeTablePatterns)
+	utils.ValidateFilterPatterns("include-schema-pattern", includeSchemaPatterns)
+	utils.ValidateFilterPatterns("include-table-pattern", includeTablePatterns)
+}
+
 func ValidateFlagValues() {
 	utils.ValidateBackupDir(*backupDir)
-	ValidateCompressionLevel(*compressionLevel)
+	ValidateFilterPatternFlags()
+	ValidateCompressionTypeAndLevel(*compressionType, *compressionLevel)
+	utils.ValidateDataFormat(*dataFormat, *dataDelimiter, *dataNullString)
+	ValidateNumJobs(*numJobs)
+	ValidateIncrementalFlags()
+	ValidateEncryptionFlags()
+	ValidateResumeFlags()
+	utils.ValidateDiskSpaceWarningThreshold(*diskSpaceWarningThreshold)
 }
@@ -1,6 +1,9 @@
 package backup_test
 
 import (
+	"errors"
+	"regexp"
+
 	"github.com/greenplum-db/gpbackup/backup"
 	"github.com/greenplum-db/gpbackup/testutils"
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -118,20 +121,103 @@ var _ = Describe("backup/validate tests", func() {
 			})
 		})
 	})
-	Describe("ValidateCompressionLevel", func() {
+	Describe("ValidateCompressionTypeAndLevel", func() {
 		It("validates a compression level between 1 and 9", func() {
 			compressLevel := 5
-			backup.ValidateCompressionLevel(compressLevel)
+			backup.ValidateCompressionTypeAndLevel("gzip", compressLevel)
 		})
 		It("panics if given a compression level < 0", func() {
 			compressLevel := -2
 			defer testutils.ShouldPanicWithMessage("Compression level must be between 1 and 9")
-			backup.ValidateCompressionLevel(compressLevel)
+			backup.ValidateCompressionTypeAndLevel("gzip", compressLevel)
 		})
 		It("panics if given a compression level > 9", func() {
 			compressLevel := 11
 			defer testutils.ShouldPanicWithMessage("Compression level must be between 1 and 9")
-			backup.ValidateCompressionLevel(compressLevel)
+			backup.ValidateCompressionTypeAndLevel("gzip", compressLevel)
+		})
+		It("validates a zstd compression level between 1 and 19", func() {
+			backup.ValidateCompressionTypeAndLevel("zstd", 19)
+		})
+		It("panics if given a zstd compression level > 19", func() {
+			defer testutils.ShouldPanicWithMessage("Compression level must be between 1 and 19")
+			backup.ValidateCompressionTypeAndLevel("zstd", 20)
+		})
+		It("panics if given an lz4 compression level > 9", func() {
+			defer testutils.ShouldPanicWithMessage("Compression level must be between 1 and 9")
+			backup.ValidateCompressionTypeAndLevel("lz4", 12)
+		})
+		It("panics if given a compression level with compression type none", func() {
+			defer testutils.ShouldPanicWithMessage("Cannot specify a compression level with compression type none")
+			backup.ValidateCompressionTypeAndLevel("none", 3)
+		})
+		It("panics if given an unknown compression type", func() {
+			defer testutils.ShouldPanicWithMessage("Unknown compression type bzip2")
+			backup.ValidateCompressionTypeAndLevel("bzip2", 0)
+		})
+	})
+	Describe("ValidateNumJobs", func() {
+		It("validates a number of jobs greater than 0", func() {
+			backup.ValidateNumJobs(4)
+		})
+		It("panics if given a number of jobs < 1", func() {
+			defer testutils.ShouldPanicWithMessage("The number of jobs must be at least 1")
+			backup.ValidateNumJobs(0)
+		})
+	})
+	Describe("ValidateTablePredicates", func() {
+		It("passes if there are no predicates", func() {
+			backup.ValidateTablePredicates(connection, map[string]string{})
+		})
+		It("passes if every predicate is valid", func() {
+			mock.ExpectExec(regexp.QuoteMeta("SELECT * FROM public.bar WHERE j = 'x' LIMIT 0;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(regexp.QuoteMeta("SELECT * FROM public.foo WHERE i > 10 LIMIT 0;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			backup.ValidateTablePredicates(connection, map[string]string{"public.foo": "i > 10", "public.bar": "j = 'x'"})
+		})
+		It("panics if a predicate is invalid", func() {
+			mock.ExpectExec(regexp.QuoteMeta("SELECT * FROM public.foo WHERE k > 10 LIMIT 0;")).WillReturnError(errors.New(`column "k" does not exist`))
+			defer testutils.ShouldPanicWithMessage(`Invalid predicate for table public.foo: column "k" does not exist`)
+			backup.ValidateTablePredicates(connection, map[string]string{"public.foo": "k > 10"})
+		})
+	})
+	Describe("ValidateTablePredicatesApplyToDataTables", func() {
+		dataTables := []backup.Relation{backup.BasicRelation("public", "foo"), backup.BasicRelation("public", "ext")}
+		tableDefs := map[uint32]backup.TableDefinition{0: {}}
+		AfterEach(func() {
+			backup.SetTablePredicates(nil)
+		})
+		It("passes if every predicate is for a table whose data is backed up", func() {
+			backup.SetTablePredicates(map[string]string{"public.foo": "i > 10"})
+			backup.ValidateTablePredicatesApplyToDataTables(dataTables, tableDefs)
+		})
+		It("panics if a predicate is for a table whose data is not backed up directly", func() {
+			backup.SetTablePredicates(map[string]string{"public.parent": "i > 10"})
+			defer testutils.ShouldPanicWithMessage("Cannot back up a subset of the rows of table public.parent")
+			backup.ValidateTablePredicatesApplyToDataTables(dataTables, tableDefs)
+		})
+	})
+	Describe("ValidateTablesWithExternalPartitions", func() {
+		parent := backup.Relation{Oid: 1, Schema: "public", Name: "parent"}
+		foo := backup.Relation{Oid: 2, Schema: "public", Name: "foo"}
+		extPartitions := []backup.PartitionInfo{{ParentRelationOid: 1, RelationOid: 3, IsExternal: true}}
+		AfterEach(func() {
+			backup.SetTablePredicates(nil)
+			backup.SetMaskedSelectLists(nil)
+		})
+		It("passes if no table with external partitions has a predicate or masked columns", func() {
+			backup.SetTablePredicates(map[string]string{"public.foo": "i > 10"})
+			backup.SetMaskedSelectLists(map[uint32]string{2: "i, NULL::integer AS j"})
+			backup.ValidateTablesWithExternalPartitions([]backup.Relation{parent, foo}, extPartitions)
+		})
+		It("panics if a table with external partitions has a predicate", func() {
+			backup.SetTablePredicates(map[string]string{"public.parent": "i > 10"})
+			defer testutils.ShouldPanicWithMessage("Cannot back up a subset of the rows of table public.parent, as it has external partitions.")
+			backup.ValidateTablesWithExternalPartitions([]backup.Relation{parent, foo}, extPartitions)
+		})
+		It("panics if a table with external partitions has masked columns", func() {
+			backup.SetMaskedSelectLists(map[uint32]string{1: "i, NULL::integer AS j"})
+			defer testutils.ShouldPanicWithMessage("Cannot mask the data of table public.parent, as it has external partitions.")
+			backup.ValidateTablesWithExternalPartitions([]backup.Relation{parent, foo}, extPartitions)
 		})
 	})
 })
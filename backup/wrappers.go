@@ -2,7 +2,10 @@ package backup
 
 import (
 	"fmt"
+	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/greenplum-db/gpbackup/utils"
 )
@@ -28,35 +31,118 @@ func SetLoggerVerbosity() {
 	}
 }
 
+/*
+ * When backing up data in parallel, connection 0 is used only for metadata and
+ * connections 1 through numJobs are used for data, so that the workers can
+ * begin their transactions after the metadata connection has locked all tables.
+ */
 func InitializeConnection() {
 	connection = utils.NewDBConn(*dbname)
-	connection.Connect(1)
-	connection.MustExec("SET application_name TO 'gpbackup'")
+	numConns := 1
+	if *numJobs > 1 {
+		numConns = *numJobs + 1
+	}
+	connection.Connect(numConns)
+	for connNum := 0; connNum < connection.NumConns; connNum++ {
+		connection.MustExec("SET application_name TO 'gpbackup'", connNum)
+	}
+	connection.RecordBackendPIDs()
 	connection.SetDatabaseVersion()
 	InitializeMetadataParams(connection)
 	connection.Begin()
-	SetSessionGUCs()
+	SetSessionGUCs(0)
 }
 
-func SetSessionGUCs() {
+// This is called by the signal handler when the backup is canceled
+func CancelQueriesInProgress() {
+	if connection == nil {
+		return
+	}
+	err := connection.CancelQueries()
+	if err != nil {
+		logger.Warn("Unable to cancel queries in progress: %v", err)
+	}
+}
+
+func SetSessionGUCs(whichConn int) {
 	// These GUCs ensure the dumps portability accross systems
-	connection.MustExec("SET search_path TO pg_catalog")
-	connection.MustExec("SET statement_timeout = 0")
-	connection.MustExec("SET DATESTYLE = ISO")
+	connection.MustExec("SET search_path TO pg_catalog", whichConn)
+	connection.MustExec("SET statement_timeout = 0", whichConn)
+	connection.MustExec("SET DATESTYLE = ISO", whichConn)
 	if connection.Version.AtLeast("5") {
-		connection.MustExec("SET synchronize_seqscans TO off")
+		connection.MustExec("SET synchronize_seqscans TO off", whichConn)
 	}
 	if connection.Version.AtLeast("6") {
-		connection.MustExec("SET INTERVALSTYLE = POSTGRES")
+		connection.MustExec("SET INTERVALSTYLE = POSTGRES", whichConn)
+	}
+}
+
+// The first GPDB version that supports exporting and importing snapshots
+const SNAPSHOT_EXPORT_MIN_VERSION = "6.21.0"
+
+/*
+ * On GPDB versions that support exporting snapshots, each data connection
+ * imports the snapshot of connection 0, so the data is backed up as of the
+ * same snapshot as the metadata.
+ *
+ * Older versions do not support exporting snapshots, so to ensure that all of
+ * the data connections see the same data, we block writes to every table in
+ * the backup set from a separate connection while the data connections
+ * establish their snapshots.  The EXCLUSIVE lock mode still allows concurrent
+ * reads, and does not conflict with the ACCESS SHARE locks already held by
+ * connection 0.
+ */
+func SynchronizeWorkerSnapshots(tables []Relation, tableDefs map[uint32]TableDefinition) {
+	logger.Verbose("Synchronizing snapshots for %d data connections", connection.NumConns-1)
+	if connection.Version.AtLeast(SNAPSHOT_EXPORT_MIN_VERSION) {
+		snapshotID := utils.SelectString(connection, "SELECT pg_export_snapshot() AS string", 0)
+		for connNum := 1; connNum < connection.NumConns; connNum++ {
+			connection.Begin(connNum)
+			// The snapshot must be set before the first query in the transaction
+			connection.MustExec(fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s'", snapshotID), connNum)
+			SetSessionGUCs(connNum)
+		}
+		return
 	}
+	lockConnection := utils.NewDBConn(connection.DBName)
+	lockConnection.Driver = connection.Driver
+	lockConnection.Connect(1)
+	defer lockConnection.Close()
+	lockConnection.MustExec("SET application_name TO 'gpbackup'")
+	lockConnection.Begin()
+	for _, table := range tables {
+		if !tableDefs[table.Oid].IsExternal {
+			lockConnection.MustExec(fmt.Sprintf("LOCK TABLE %s IN EXCLUSIVE MODE", table.ToString()))
+		}
+	}
+	for connNum := 1; connNum < connection.NumConns; connNum++ {
+		connection.Begin(connNum)
+		SetSessionGUCs(connNum)
+		// The snapshot for a SERIALIZABLE transaction is taken by its first query
+		connection.MustExec("SELECT 1", connNum)
+	}
+	lockConnection.Commit()
 }
 
 func InitializeBackupReport() {
 	dbname := utils.SelectString(connection, fmt.Sprintf("select quote_ident(datname) AS string FROM pg_database where datname='%s'", connection.DBName))
+	format := utils.NewDataFormat(*dataFormat, *dataDelimiter, *dataNullString)
+	utils.EnsureDataFormatIsSupported(format, connection.Version)
+	utils.SetDataFormat(format)
 	config := utils.BackupConfig{
-		DatabaseName:    dbname,
-		DatabaseVersion: connection.Version.VersionString,
-		BackupVersion:   version,
+		DatabaseName:        dbname,
+		DatabaseVersion:     connection.Version.VersionString,
+		BackupVersion:       version,
+		LeafPartitionData:   *leafPartitionData,
+		Incremental:         *incremental,
+		Encrypted:           *encrypt,
+		IncludeExternalData: *includeExternalData,
+		DataFormat:          format.Name,
+		DataDelimiter:       format.Delimiter,
+		DataNullString:      format.NullString,
+	}
+	if *maskingRulesFile != "" {
+		config.MaskingRulesChecksum = GetMaskingRulesChecksum(*maskingRulesFile)
 	}
 	dbSize := ""
 	if !*metadataOnly {
@@ -64,13 +150,69 @@ func InitializeBackupReport() {
 	}
 
 	backupReport = &utils.Report{
-		DatabaseSize: dbSize,
-		BackupConfig: config,
+		DatabaseSize:    dbSize,
+		PatternMatches:  patternMatches,
+		TablePredicates: tablePredicates,
+		BackupConfig:    config,
 	}
-	utils.InitializeCompressionParameters(!*noCompression, *compressionLevel)
+	utils.InitializeCompressionParameters(!*noCompression, *compressionType, *compressionLevel)
 	isSchemaFiltered := len(includeSchemas) > 0 || len(excludeSchemas) > 0
 	isTableFiltered := len(includeTables) > 0 || len(excludeTables) > 0
 	backupReport.ConstructBackupParamsStringFromFlags(*dataOnly, *metadataOnly, isSchemaFiltered, isTableFiltered, *singleDataFile, *withStats)
+	utils.SetCompressedFrames(backupReport.CompressedFrames)
+	if *incremental {
+		backupReport.BackupParamsString += fmt.Sprintf("\nIncremental Base Timestamp: %s", *fromTimestamp)
+	}
+	if *encrypt {
+		backupReport.BackupParamsString += "\nEncrypted: Yes"
+	}
+	if *includeExternalData {
+		backupReport.BackupParamsString += "\nExternal Table Data: Yes"
+	}
+	if format.Name != utils.DATA_FORMAT_CSV {
+		backupReport.BackupParamsString += fmt.Sprintf("\nData Format: %s", format.Name)
+	}
+}
+
+// This lists each table whose data was backed up, in the order of the data entries in the TOC
+func GetTableReports() []utils.TableReport {
+	tables := make([]utils.TableReport, 0)
+	if globalTOC == nil {
+		return tables
+	}
+	for _, entry := range globalTOC.DataEntries {
+		numRows, ok := tableRowCounts[entry.Oid]
+		if !ok {
+			continue
+		}
+		tables = append(tables, utils.TableReport{
+			Name:         utils.MakeFQN(entry.Schema, entry.Name),
+			Rows:         numRows,
+			SegmentBytes: tableSegmentBytes[entry.Oid],
+			Predicate:    entry.Predicate,
+			External:     entry.IsExternal,
+		})
+	}
+	return tables
+}
+
+func AddBackupToHistory(endTime time.Time, errMsg string, exitCode int) {
+	entry := utils.BackupHistoryEntry{
+		Timestamp:    globalCluster.Timestamp,
+		Status:       utils.GetStatusString(errMsg, exitCode),
+		CommandLine:  strings.Join(os.Args, " "),
+		BackupDir:    *backupDir,
+		SegPrefix:    globalCluster.UserSpecifiedSegPrefix,
+		PluginConfig: *pluginConfigFile,
+		EndTime:      endTime.Format("2006-01-02 15:04:05"),
+		SizeInBytes:  globalCluster.GetBackupSizeOnAllHosts(),
+		BackupConfig: backupReport.BackupConfig,
+	}
+	historyFilename := utils.GetBackupHistoryFilePath(globalCluster)
+	logger.Verbose("Adding backup %s to backup history file %s", globalCluster.Timestamp, historyFilename)
+	utils.UpdateBackupHistory(historyFilename, func(history *utils.BackupHistory) {
+		history.AddEntry(entry)
+	})
 }
 
 func InitializeFilterLists() {
@@ -78,8 +220,63 @@ func InitializeFilterLists() {
 		excludeTables = utils.ReadLinesFromFile(*excludeTableFile)
 	}
 	if *includeTableFile != "" {
-		includeTables = utils.ReadLinesFromFile(*includeTableFile)
+		includeTables, tablePredicates = ParseIncludeTableLines(utils.ReadLinesFromFile(*includeTableFile))
+	}
+	ResolveFilterPatterns()
+}
+
+/*
+ * Each line of the include table file holds a fully-qualified table name,
+ * optionally followed by a WHERE clause restricting the rows whose data is
+ * backed up, e.g. "sales.orders WHERE order_date > now() - interval '90 days'".
+ * The table name ends at the first whitespace outside of double quotes.
+ */
+func ParseIncludeTableLines(lines []string) ([]string, map[string]string) {
+	tables := make([]string, 0)
+	predicates := make(map[string]string, 0)
+	wherePattern := regexp.MustCompile(`(?is)^WHERE\s+(.*\S)`)
+	for _, line := range lines {
+		table, rest := utils.SplitAtWhitespaceOutsideQuotes(line)
+		tables = append(tables, table)
+		if rest == "" {
+			continue
+		}
+		matches := wherePattern.FindStringSubmatch(rest)
+		if matches == nil {
+			logger.Fatal(utils.NewFlagError("Invalid line in include table file: %s.  Each line must contain a fully-qualified table name, optionally followed by WHERE and a predicate.", line), "")
+		}
+		predicates[table] = matches[1]
+	}
+	return tables, predicates
+}
+
+/*
+ * Pattern filters are resolved against the catalog and the matching names are
+ * added to the corresponding filter lists, so that the rest of the backup
+ * treats them exactly like schemas and tables passed by name.  Schema patterns
+ * are resolved first so that table patterns only match tables in the schemas
+ * being backed up.
+ */
+func ResolveFilterPatterns() {
+	if len(excludeSchemaPatterns) > 0 || len(includeSchemaPatterns) > 0 {
+		schemas := GetUserSchemaNames(connection)
+		excludeSchemas = addPatternMatchesToFilterList(excludeSchemas, "exclude-schema-pattern", excludeSchemaPatterns, schemas)
+		includeSchemas = addPatternMatchesToFilterList(includeSchemas, "include-schema-pattern", includeSchemaPatterns, schemas)
+	}
+	if len(excludeTablePatterns) > 0 || len(includeTablePatterns) > 0 {
+		tables := GetFilterableTableFQNs(connection)
+		excludeTables = addPatternMatchesToFilterList(excludeTables, "exclude-table-pattern", excludeTablePatterns, tables)
+		includeTables = addPatternMatchesToFilterList(includeTables, "include-table-pattern", includeTablePatterns, tables)
+	}
+}
+
+// The matches for each pattern flag are recorded for the backup report
+func addPatternMatchesToFilterList(filterList []string, flagName string, patterns []string, names []string) []string {
+	filterList, matches := utils.AddPatternMatchesToFilterList(filterList, flagName, patterns, names)
+	if len(patterns) > 0 {
+		patternMatches = append(patternMatches, utils.PatternMatch{Flag: flagName, Patterns: patterns, Matches: matches})
 	}
+	return filterList
 }
 
 /*
@@ -105,6 +302,14 @@ func RetrieveAndProcessTables() ([]Relation, []Relation, map[uint32]TableDefinit
 	}
 	tableDefs := ConstructDefinitionsForTables(connection, tables)
 	metadataTables, dataTables := SplitTablesByPartitionType(tables, tableDefs, userPassedIncludeTables)
+	ValidateTablePredicatesApplyToDataTables(dataTables, tableDefs)
+	if len(maskingRules) > 0 {
+		InitializeMasking(dataTables, tableDefs)
+	}
+	if len(tablePredicates) > 0 || len(maskedSelectLists) > 0 {
+		extPartitions, _ := GetExternalPartitionInfo(connection)
+		ValidateTablesWithExternalPartitions(dataTables, extPartitions)
+	}
 	objectCounts["Tables"] = len(metadataTables)
 
 	return metadataTables, dataTables, tableDefs
@@ -440,10 +645,20 @@ func BackupData(tables []Relation, tableDefs map[uint32]TableDefinition) {
 	if *singleDataFile {
 		globalCluster.CreateSegmentPipesOnAllHosts()
 		defer globalCluster.CleanUpSegmentPipesOnAllHosts()
-		globalCluster.ReadFromSegmentPipes()
-		defer globalCluster.CleanUpSegmentTailProcesses()
+		if resumeByteCounts != nil {
+			globalCluster.StartBackupHelperAgentsForResume(resumeByteCounts)
+		} else {
+			globalCluster.StartBackupHelperAgents(pluginConfig)
+		}
+		defer globalCluster.CleanUpHelperAgents()
+	}
+	if connection.NumConns > 1 {
+		SynchronizeWorkerSnapshots(tables, tableDefs)
 	}
 	BackupDataForAllTables(tables, tableDefs)
+	if *singleDataFile {
+		globalCluster.StopHelperAgents()
+	}
 }
 
 func BackupStatistics(statisticsFile *utils.FileWithByteCount, tables []Relation) {
@@ -0,0 +1,89 @@
+package backup_test
+
+import (
+	"regexp"
+
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+
+	"github.com/greenplum-db/gpbackup/backup"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("backup/wrappers tests", func() {
+	Describe("GetTableReports", func() {
+		BeforeEach(func() {
+			backup.SetBackupProgress(nil)
+			backup.SetTableRowCounts(nil)
+			backup.SetTableSegmentBytes(nil)
+		})
+		It("lists the tables whose data was backed up with their row counts and sizes", func() {
+			toc := &utils.TOC{DataEntries: []utils.MasterDataEntry{
+				{Schema: "public", Name: "foo", Oid: 1},
+				{Schema: "public", Name: "bar", Oid: 2, Predicate: "i > 5"},
+				{Schema: "public", Name: "ext", Oid: 3, IsExternal: true},
+			}}
+			backup.SetTOC(toc)
+			backup.RecordTableDataBackedUp(2, 5)
+			backup.RecordTableDataBackedUp(1, 100)
+			backup.SetTableSegmentBytes(map[uint32]map[int]uint64{1: {0: 1024, 1: 2048}, 2: {0: 10, 1: 20}})
+			Expect(backup.GetTableReports()).To(Equal([]utils.TableReport{
+				{Name: "public.foo", Rows: 100, SegmentBytes: map[int]uint64{0: 1024, 1: 2048}},
+				{Name: "public.bar", Rows: 5, SegmentBytes: map[int]uint64{0: 10, 1: 20}, Predicate: "i > 5"},
+			}))
+		})
+		It("returns no tables if the TOC has not been initialized", func() {
+			backup.SetTOC(nil)
+			Expect(backup.GetTableReports()).To(BeEmpty())
+		})
+	})
+	Describe("ParseIncludeTableLines", func() {
+		It("parses lines containing only table names", func() {
+			tables, predicates := backup.ParseIncludeTableLines([]string{"public.foo", `"Mixed Case"."table one"`})
+			Expect(tables).To(Equal([]string{"public.foo", `"Mixed Case"."table one"`}))
+			Expect(predicates).To(BeEmpty())
+		})
+		It("parses lines containing table names followed by predicates", func() {
+			tables, predicates := backup.ParseIncludeTableLines([]string{
+				"sales.orders WHERE order_date > now() - interval '90 days'",
+				"public.foo",
+				`"my schema"."my table"   where  "some column" = 'a b'  `,
+			})
+			Expect(tables).To(Equal([]string{"sales.orders", "public.foo", `"my schema"."my table"`}))
+			Expect(predicates).To(Equal(map[string]string{
+				"sales.orders":           "order_date > now() - interval '90 days'",
+				`"my schema"."my table"`: `"some column" = 'a b'`,
+			}))
+		})
+		It("panics if a table name is followed by something other than a predicate", func() {
+			defer testutils.ShouldPanicWithMessage("Invalid line in include table file: public.foo id > 10.")
+			backup.ParseIncludeTableLines([]string{"public.foo id > 10"})
+		})
+		It("panics if WHERE is not followed by a predicate", func() {
+			defer testutils.ShouldPanicWithMessage("Invalid line in include table file: public.foo WHERE.")
+			backup.ParseIncludeTableLines([]string{"public.foo WHERE"})
+		})
+	})
+	Describe("SynchronizeWorkerSnapshots", func() {
+		tables := []backup.Relation{{Oid: 1, Schema: "public", Name: "foo"}}
+		tableDefs := map[uint32]backup.TableDefinition{1: {}}
+		BeforeEach(func() {
+			connection, mock = testutils.CreateAndConnectMockDB(2)
+		})
+		It("imports the snapshot of connection 0 in the data connections", func() {
+			testutils.SetDBVersion(connection, "6.21.0")
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT pg_export_snapshot() AS string")).WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("00000005-1"))
+			testutils.ExpectBegin(mock)
+			mock.ExpectExec(regexp.QuoteMeta("SET TRANSACTION SNAPSHOT '00000005-1'")).WillReturnResult(sqlmock.NewResult(0, 0))
+			for i := 0; i < 5; i++ {
+				mock.ExpectExec("SET (.*)").WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			backup.SynchronizeWorkerSnapshots(tables, tableDefs)
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+			Expect(connection.Tx[1]).ToNot(BeNil())
+		})
+	})
+})
@@ -268,6 +268,17 @@ var _ = Describe("backup end to end integration tests", func() {
 
 			os.RemoveAll(backupdir)
 		})
+		It("runs gpbackup and gprestore with jobs flag with a single data file", func() {
+			backupdir := "/tmp/parallel_single_data_file"
+			timestamp := gpbackup(gpbackupPath, "-backupdir", backupdir, "-single-data-file")
+			gprestore(gprestorePath, timestamp, "-redirect", "restoredb", "-backupdir", backupdir, "-jobs", "4")
+
+			assertTablesCreated(restoreConn, 30)
+			assertDataRestored(restoreConn, schema2TupleCounts)
+			assertDataRestored(restoreConn, publicSchemaTupleCounts)
+
+			os.RemoveAll(backupdir)
+		})
 		It("runs gpbackup and gprestore with include-schema restore flag with a single data file", func() {
 			backupdir := "/tmp/include_schema"
 			timestamp := gpbackup(gpbackupPath, "-backupdir", backupdir, "-single-data-file")
@@ -0,0 +1,15 @@
+// +build gpbackup_manager
+
+package main
+
+import (
+	. "github.com/greenplum-db/gpbackup/manager"
+)
+
+func main() {
+	defer DoTeardown()
+	DoInit()
+	DoValidation()
+	DoSetup()
+	DoManage()
+}
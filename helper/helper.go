@@ -2,19 +2,38 @@ package helper
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/greenplum-db/gpbackup/utils"
 )
 
 var (
-	content *int
-	logger  *utils.Logger
-	oid     *uint
-	restore *bool
-	tocFile *string
+	backupAgent      *bool
+	byteCountFile    *string
+	compress         *bool
+	compressionLevel *int
+	compressionType  *string
+	content          *int
+	dataFile         *string
+	decompress       *bool
+	logger           *utils.Logger
+	oid              *uint
+	pipeFile         *string
+	restoreAgent     *bool
+	tocFile          *string
+	verify           *bool
 )
 
 /*
@@ -23,27 +42,53 @@ var (
 
 func DoHelper() {
 	InitializeGlobals()
-	if *restore {
-		doRestoreHelper()
+	if *compress {
+		doCompressHelper()
+	} else if *decompress {
+		doDecompressHelper()
+	} else if *backupAgent {
+		doBackupAgent()
+	} else if *restoreAgent {
+		doRestoreAgent()
+	} else if *verify {
+		doVerifyHelper()
 	} else {
-		doBackupHelper()
+		logger.Fatal(nil, "One of --backup-agent, --restore-agent, --compress, --decompress, or --verify must be specified")
 	}
 }
 
 func InitializeGlobals() {
+	backupAgent = flag.Bool("backup-agent", false, "Back up the data for each table passed to the agent through its pipes, writing the data to stdout and recording it in the table of contents file")
+	byteCountFile = flag.String("byte-count-file", "", "Absolute path to the file to which the sizes of the data before and after compression are appended, with --compress")
+	compress = flag.Bool("compress", false, "Compress the data read from stdin to stdout")
+	compressionLevel = flag.Int("compression-level", 1, "Level of compression to use")
+	compressionType = flag.String("compression-type", "", "Type of compression of the data written or read; when backing up, the data for each table is compressed as a separate frame, and when restoring, the data read is decompressed as a single stream")
 	content = flag.Int("content", -2, "Content ID of the corresponding segment")
+	dataFile = flag.String("data-file", "", "Read the data from this file instead of stdin, or with --compress, write the compressed data to it instead of stdout")
+	decompress = flag.Bool("decompress", false, "Decompress the data read from stdin to stdout")
 	logger = utils.InitializeLogging("gpbackup_helper", "")
-	oid = flag.Uint("oid", 0, "Oid of the table being processed")
-	restore = flag.Bool("restore", false, "Read in table according to offset in table of contents file")
+	oid = flag.Uint("oid", 0, "Oid of the table whose data is compressed, with --compress")
+	pipeFile = flag.String("pipe-file", "", "Absolute path to the segment data pipe, to which the names of the agent's control pipe, table pipes, and status files are suffixed")
+	restoreAgent = flag.Bool("restore-agent", false, "Restore the data for each table requested from the agent through its pipes, according to the table of contents file")
 	tocFile = flag.String("toc-file", "", "Absolute path to the table of contents file")
+	verify = flag.Bool("verify", false, "Check the data read from stdin against the table of contents file")
 	flag.Parse()
 	utils.InitializeSystemFunctions()
 }
 
+func SetCompression(compressType string, level int) {
+	compressionType = &compressType
+	compressionLevel = &level
+}
+
 func SetContent(id int) {
 	content = &id
 }
 
+func SetDataFile(name string) {
+	dataFile = &name
+}
+
 func SetFilename(name string) {
 	tocFile = &name
 }
@@ -52,21 +97,224 @@ func SetLogger(log *utils.Logger) {
 	logger = log
 }
 
-func SetOid(newoid uint) {
-	oid = &newoid
+func SetPipeFile(name string) {
+	pipeFile = &name
+}
+
+/*
+ * Compression helper functions
+ */
+
+/*
+ * Compression is done by the helper, rather than by running the command-line
+ * utility for each type, so that errors are reported the same way for every
+ * type and the sizes of the data before and after compression are known.
+ */
+func doCompressHelper() {
+	utils.InitializeCompressionParameters(true, *compressionType, *compressionLevel)
+	writer := utils.System.Stdout
+	var file *os.File
+	if *dataFile != "" {
+		var err error
+		file, err = os.OpenFile(*dataFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			logger.Fatal(err, "Segment %d: Unable to create data file %s", *content, *dataFile)
+		}
+		writer = file
+	}
+	numBytes, numCompressedBytes, _ := CompressAndCountBytes(*oid, utils.System.Stdin, writer)
+	if file != nil {
+		if err := file.Close(); err != nil {
+			logger.Fatal(err, "Segment %d: Unable to write data file %s", *content, *dataFile)
+		}
+	}
+	log("Compressed %d bytes to %d bytes", numBytes, numCompressedBytes)
+	if *byteCountFile != "" {
+		AppendByteCounts(*byteCountFile, *oid, numBytes, numCompressedBytes)
+	}
+}
+
+/*
+ * The sizes of the data for each table are appended to a file shared by all of
+ * the tables on the segment, which gpbackup reads once all of the data has been
+ * backed up; see ReadSegmentByteCounts.  Each line is written with a single
+ * write to a file opened for appending, so lines written by helpers running at
+ * the same time are not interleaved.
+ */
+func AppendByteCounts(filename string, tableOid uint, numBytes uint64, numCompressedBytes uint64) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = file.Write([]byte(fmt.Sprintf("%d %d %d\n", tableOid, numBytes, numCompressedBytes)))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		logger.Fatal(err, "Segment %d: Unable to record size of data for table with oid %d in %s", *content, tableOid, filename)
+	}
+}
+
+func doDecompressHelper() {
+	var input io.Reader = utils.System.Stdin
+	if *dataFile != "" {
+		file, err := os.Open(*dataFile)
+		if err != nil {
+			logger.Fatal(err, "Segment %d: Unable to open data file %s", *content, *dataFile)
+		}
+		defer file.Close()
+		input = file
+	}
+	reader, err := utils.NewDecompressionReader(bufio.NewReader(input), *compressionType)
+	if err != nil {
+		logger.Fatal(nil, "Segment %d: Error decompressing data: %s", *content, err.Error())
+	}
+	numBytes, err := io.Copy(utils.System.Stdout, reader)
+	if err != nil {
+		logger.Fatal(nil, "Segment %d: Error decompressing data: %s", *content, err.Error())
+	}
+	log("Decompressed %d bytes", numBytes)
+}
+
+/*
+ * Helper agent functions
+ */
+
+/*
+ * A helper agent is started on each segment for the whole of the data backup
+ * or restore of a single-data-file backup, rather than a helper being run by
+ * COPY for each table.  The agent reads one command per line from its control
+ * pipe: either the oid of a table, whose data is then passed between COPY and
+ * the agent through a pipe created by COPY for that table, or "done", once all
+ * of the tables have been processed.
+ *
+ * Any error is written to the agent's error file, which is checked by COPY for
+ * each table and by the master once the agent has finished.
+ */
+func ProcessAgentCommands(commands io.Reader, processTable func(tableOid uint)) {
+	scanner := bufio.NewScanner(commands)
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "done" {
+			return
+		}
+		tableOid, err := strconv.ParseUint(command, 10, 32)
+		if err != nil {
+			logger.Fatal(nil, "Segment %d: Invalid helper agent command %q", *content, command)
+		}
+		processTable(uint(tableOid))
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Fatal(err, "Segment %d: Unable to read helper agent commands", *content)
+	}
+}
+
+/*
+ * The control pipe is opened for writing as well as reading, so that the agent
+ * does not reach the end of the pipe each time a COPY finishes writing to it.
+ */
+func openControlPipe() io.Reader {
+	controlPipe, err := os.OpenFile(*pipeFile+"_control", os.O_RDWR, 0)
+	if err != nil {
+		logger.Fatal(err, "Segment %d: Unable to open helper agent control pipe", *content)
+	}
+	return controlPipe
+}
+
+// Opening the pipe blocks until COPY opens the other end of it
+func openTablePipe(tableOid uint, flag int) *os.File {
+	tablePipe, err := os.OpenFile(fmt.Sprintf("%s_%d", *pipeFile, tableOid), flag, 0)
+	if err != nil {
+		logger.Fatal(err, "Segment %d: Unable to open pipe for table with oid %d", *content, tableOid)
+	}
+	return tablePipe
+}
+
+/*
+ * The agent's pid is recorded so that the master can stop it if the backup or
+ * restore fails, in which case onStop is called before the agent exits.
+ */
+func startAgent(onStop func()) {
+	err := ioutil.WriteFile(*pipeFile+"_pid", []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+	if err != nil {
+		logger.Fatal(err, "Segment %d: Unable to write helper agent pid file", *content)
+	}
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signalChan
+		log("Received %s, stopping helper agent", sig)
+		if onStop != nil {
+			onStop()
+		}
+		releaseTablePipes()
+		os.Exit(utils.EXIT_CANCELED)
+	}()
+}
+
+/*
+ * An error that stops the agent is written to the agent's error file, which
+ * fails every COPY started afterwards.
+ */
+func ReportAgentError() {
+	if err := recover(); err != nil {
+		errMsg, _ := utils.ParseErrorMessage(err)
+		ioutil.WriteFile(*pipeFile+"_error", []byte(errMsg+"\n"), 0644)
+		releaseTablePipes()
+		panic(err)
+	}
+}
+
+/*
+ * Before the agent exits early, it opens and closes the pipe of each table that
+ * COPY has created but the agent has not processed, so that COPY fails instead
+ * of waiting for the agent to open the pipe.  The pipes are opened without
+ * waiting, which for writing only succeeds once COPY has opened the pipe for
+ * reading, so they are opened both ways.  Opening the pipe of a table that is
+ * being processed has no effect on it.
+ */
+func releaseTablePipes() {
+	tablePipes, _ := filepath.Glob(*pipeFile + "_[0-9]*")
+	for _, tablePipe := range tablePipes {
+		if info, err := os.Lstat(tablePipe); err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+			continue
+		}
+		for _, flag := range []int{os.O_RDONLY, os.O_WRONLY} {
+			if file, err := os.OpenFile(tablePipe, flag|syscall.O_NONBLOCK, 0); err == nil {
+				file.Close()
+			}
+		}
+	}
 }
 
 /*
  * Backup helper functions
  */
 
-func doBackupHelper() {
-	toc, lastRead := ReadOrCreateTOC()
-	numBytes := ReadAndCountBytes()
-	lastProcessed := lastRead + numBytes
-	toc.AddSegmentDataEntry(*oid, lastRead, lastProcessed)
-	toc.LastByteRead = lastProcessed
-	toc.WriteToFile(*tocFile)
+// This guards the segment TOC, which the agent writes when it is stopped
+var tocLock sync.Mutex
+
+/*
+ * The segment TOC is kept in memory while the tables are backed up and is only
+ * written once the agent finishes, whether or not it succeeds, or when it is
+ * stopped, so that a failed backup can be resumed from the tables recorded.
+ */
+func doBackupAgent() {
+	defer ReportAgentError()
+	toc, _ := ReadOrCreateTOC()
+	if *compressionType != "" {
+		utils.InitializeCompressionParameters(true, *compressionType, *compressionLevel)
+		toc.CompressionType = *compressionType
+	}
+	writeTOC := func() {
+		tocLock.Lock()
+		defer tocLock.Unlock()
+		toc.WriteToFile(*tocFile)
+	}
+	startAgent(writeTOC)
+	defer writeTOC()
+	ProcessAgentCommands(openControlPipe(), func(tableOid uint) {
+		BackupTableFromPipe(toc, tableOid)
+	})
 }
 
 func ReadOrCreateTOC() (*utils.SegmentTOC, uint64) {
@@ -83,42 +331,315 @@ func ReadOrCreateTOC() (*utils.SegmentTOC, uint64) {
 	return toc, lastRead
 }
 
-func ReadAndCountBytes() uint64 {
-	reader := bufio.NewReader(utils.System.Stdin)
-	numBytes, _ := io.Copy(utils.System.Stdout, reader)
-	return uint64(numBytes)
+func BackupTableFromPipe(toc *utils.SegmentTOC, tableOid uint) {
+	tablePipe := openTablePipe(tableOid, os.O_RDONLY)
+	defer tablePipe.Close()
+	BackupTableData(toc, tableOid, tablePipe, utils.System.Stdout)
+}
+
+/*
+ * The table's data is appended to the data written for the tables backed up
+ * before it, compressed as a separate frame if the TOC has a compression type.
+ */
+func BackupTableData(toc *utils.SegmentTOC, tableOid uint, reader io.Reader, writer io.Writer) {
+	if toc.CompressionType != "" {
+		numBytes, numCompressedBytes, checksum := CompressAndCountBytes(tableOid, reader, writer)
+		tocLock.Lock()
+		defer tocLock.Unlock()
+		lastRead, lastCompressed := toc.LastByteRead, toc.LastCompressedByteRead
+		toc.AddCompressedSegmentDataEntry(tableOid, lastRead, lastRead+numBytes, lastCompressed, lastCompressed+numCompressedBytes, checksum)
+		toc.LastByteRead = lastRead + numBytes
+		toc.LastCompressedByteRead = lastCompressed + numCompressedBytes
+		return
+	}
+	numBytes, checksum := ReadAndCountBytes(tableOid, reader, writer)
+	tocLock.Lock()
+	defer tocLock.Unlock()
+	lastRead := toc.LastByteRead
+	toc.AddSegmentDataEntry(tableOid, lastRead, lastRead+numBytes, checksum)
+	toc.LastByteRead = lastRead + numBytes
+}
+
+func ReadAndCountBytes(tableOid uint, reader io.Reader, writer io.Writer) (uint64, string) {
+	hash := sha256.New()
+	numBytes, err := io.Copy(io.MultiWriter(writer, hash), bufio.NewReader(reader))
+	if err != nil {
+		logger.Fatal(nil, "Segment %d: Error backing up table with oid %d: %s", *content, tableOid, err.Error())
+	}
+	return uint64(numBytes), fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+/*
+ * This compresses the data read as a single frame, returning the number of
+ * bytes read and written and the checksum of the uncompressed data.
+ */
+func CompressAndCountBytes(tableOid uint, reader io.Reader, writer io.Writer) (uint64, uint64, string) {
+	_, compression := utils.GetCompressionParameters()
+	compressedWriter := &byteCountWriter{writer: writer}
+	compressionWriter, err := utils.NewCompressionWriter(compressedWriter, compression.Name, compression.Level)
+	if err != nil {
+		logger.Fatal(nil, "Segment %d: Error compressing table with oid %d: %s", *content, tableOid, err.Error())
+	}
+	hash := sha256.New()
+	numBytes, err := io.Copy(io.MultiWriter(compressionWriter, hash), bufio.NewReader(reader))
+	if err == nil {
+		err = compressionWriter.Close()
+	}
+	if err != nil {
+		logger.Fatal(nil, "Segment %d: Error compressing table with oid %d: %s", *content, tableOid, err.Error())
+	}
+	return uint64(numBytes), compressedWriter.numBytes, fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+type byteCountWriter struct {
+	writer   io.Writer
+	numBytes uint64
+}
+
+func (w *byteCountWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.numBytes += uint64(n)
+	return n, err
 }
 
 /*
  * Restore helper functions
  */
 
-func doRestoreHelper() {
+func doRestoreAgent() {
+	defer ReportAgentError()
 	toc := utils.NewSegmentTOC(*tocFile)
-	startByte, endByte := GetBoundsForTable(toc)
-	CopyByteRange(startByte, endByte)
+	if toc.CompressionType != "" {
+		utils.InitializeCompressionParameters(true, toc.CompressionType, 0)
+	}
+	source := OpenDataSource(toc)
+	startAgent(nil)
+	RestoreTablesToPipes(toc, source, openControlPipe())
 }
 
-func GetBoundsForTable(toc *utils.SegmentTOC) (int64, int64) {
-	segmentDataEntry := toc.DataEntries[*oid]
-	startByte := int64(segmentDataEntry.StartByte)
-	endByte := int64(segmentDataEntry.EndByte)
-	return startByte, endByte
+/*
+ * When the data file can be read at any offset, each table is restored as soon
+ * as it is requested, so that gprestore can restore several tables at once
+ * with --jobs.  Otherwise the tables are restored one at a time, in the order
+ * in which they are requested.
+ */
+func RestoreTablesToPipes(toc *utils.SegmentTOC, source *DataSource, commands io.Reader) {
+	var tables sync.WaitGroup
+	ProcessAgentCommands(commands, func(tableOid uint) {
+		if !source.IsRandomAccess() {
+			RestoreTableToPipe(toc, source, tableOid)
+			return
+		}
+		tables.Add(1)
+		go func() {
+			defer tables.Done()
+			RestoreTableToPipe(toc, source, tableOid)
+		}()
+	})
+	tables.Wait()
 }
 
-func CopyByteRange(startByte int64, endByte int64) {
-	reader := bufio.NewReader(utils.System.Stdin)
-	discard := int(startByte)
-	count := endByte - startByte
-	log("Copying bytes for table with oid %d; discarding next %d bytes, copying %d bytes", *oid, discard, count)
-	reader.Discard(discard)
-	_, err := io.CopyN(utils.System.Stdout, reader, count)
+/*
+ * The checksum can only be verified once all of the table's data has been
+ * passed to COPY, so the agent writes an error file for a table that fails
+ * verification before closing its pipe, and COPY checks for that file once it
+ * has read the table's data.  No data from such a table is loaded.  An error
+ * restoring one table does not stop the agent, so that the tables being
+ * restored along with it are not affected.
+ */
+func RestoreTableToPipe(toc *utils.SegmentTOC, source *DataSource, tableOid uint) {
+	var tablePipe *os.File
+	defer func() {
+		reportTableError(tableOid, recover())
+		if tablePipe != nil {
+			tablePipe.Close()
+		}
+	}()
+	tablePipe = openTablePipe(tableOid, os.O_WRONLY)
+	RestoreTableData(toc, source, tableOid, tablePipe)
+}
+
+func reportTableError(tableOid uint, err interface{}) {
+	if err == nil {
+		return
+	}
+	errMsg, _ := utils.ParseErrorMessage(err)
+	ioutil.WriteFile(fmt.Sprintf("%s_%d_error", *pipeFile, tableOid), []byte(errMsg+"\n"), 0644)
+}
+
+/*
+ * The data for each table is read either from the data file, at the offsets
+ * recorded in the segment TOC, or from a stream such as the decrypted data
+ * file on stdin, in which case the tables must be read one at a time in the
+ * order in which they were backed up.  Any number of tables may be read from
+ * the data file at once.
+ */
+type DataSource struct {
+	file     io.ReaderAt
+	stream   *bufio.Reader
+	position uint64
+	current  *io.LimitedReader
+}
+
+/*
+ * Data compressed as a single stream, as in compressed backups taken before
+ * frames were written, can only be read as a stream, even from the data file.
+ */
+func OpenDataSource(toc *utils.SegmentTOC) *DataSource {
+	var input io.Reader = utils.System.Stdin
+	if *dataFile != "" {
+		file, err := os.Open(*dataFile)
+		if err != nil {
+			logger.Fatal(err, "Segment %d: Unable to open data file %s", *content, *dataFile)
+		}
+		if *compressionType == "" {
+			return &DataSource{file: file}
+		}
+		input = file
+	}
+	if *compressionType != "" {
+		reader, err := utils.NewDecompressionReader(bufio.NewReader(input), *compressionType)
+		if err != nil {
+			logger.Fatal(nil, "Segment %d: Error decompressing data: %s", *content, err.Error())
+		}
+		input = reader
+	}
+	return &DataSource{stream: bufio.NewReader(input)}
+}
 
-	log("Finished copying bytes for table with oid %d", *oid)
+func (source *DataSource) IsRandomAccess() bool {
+	return source.file != nil
+}
+
+func (source *DataSource) ReadRange(tableOid uint, startByte uint64, endByte uint64) io.Reader {
+	log("Reading bytes %d to %d for table with oid %d", startByte, endByte, tableOid)
+	if source.file != nil {
+		return io.NewSectionReader(source.file, int64(startByte), int64(endByte-startByte))
+	}
+	if source.current != nil {
+		// Any data of the previous range that was not read is skipped
+		io.Copy(ioutil.Discard, source.current)
+	}
+	if startByte < source.position {
+		logger.Fatal(nil, "Segment %d: Data for table with oid %d was requested out of order; it starts at byte %d, but the data has been read up to byte %d", *content, tableOid, startByte, source.position)
+	}
+	_, err := source.stream.Discard(int(startByte - source.position))
 	if err != nil {
-		logger.Fatal(nil, "Segment %d: Error copying table with oid %d: %s", *content, *oid, err.Error())
+		logger.Fatal(nil, "Segment %d: Error reading data for table with oid %d: %s", *content, tableOid, err.Error())
 	}
+	source.position = endByte
+	source.current = &io.LimitedReader{R: source.stream, N: int64(endByte - startByte)}
+	return source.current
+}
+
+// A table missing from the segment TOC would otherwise be restored with no data
+func RestoreTableData(toc *utils.SegmentTOC, source *DataSource, tableOid uint, writer io.Writer) {
+	entry, ok := toc.DataEntries[tableOid]
+	if !ok {
+		logger.Fatal(nil, "Segment %d: Table of contents file %s has no entry for table with oid %d", *content, *tocFile, tableOid)
+	}
+	if toc.CompressionType != "" {
+		DecompressFrame(tableOid, source.ReadRange(tableOid, entry.CompressedStartByte, entry.CompressedEndByte), entry, writer)
+		return
+	}
+	copyAndVerifyData(tableOid, source.ReadRange(tableOid, entry.StartByte, entry.EndByte), int64(entry.EndByte-entry.StartByte), entry.Checksum, writer)
+}
 
+// An empty checksum, as in backups taken before checksums were recorded, is not verified
+func copyAndVerifyData(tableOid uint, reader io.Reader, count int64, checksum string, writer io.Writer) {
+	hash := sha256.New()
+	_, err := io.CopyN(io.MultiWriter(writer, hash), reader, count)
+
+	log("Finished copying bytes for table with oid %d", tableOid)
+	if err != nil {
+		logger.Fatal(nil, "Segment %d: Error copying table with oid %d: %s", *content, tableOid, err.Error())
+	}
+	if actualChecksum := fmt.Sprintf("%x", hash.Sum(nil)); checksum != "" && actualChecksum != checksum {
+		logger.Fatal(nil, "Segment %d: Checksum verification failed for table with oid %d: expected %s, found %s", *content, tableOid, checksum, actualChecksum)
+	}
+}
+
+func DecompressFrame(tableOid uint, frame io.Reader, entry utils.SegmentDataEntry, writer io.Writer) {
+	_, compression := utils.GetCompressionParameters()
+	reader, err := utils.NewDecompressionReader(frame, compression.Name)
+	if err != nil {
+		logger.Fatal(nil, "Segment %d: Error decompressing table with oid %d: %s", *content, tableOid, err.Error())
+	}
+	copyAndVerifyData(tableOid, reader, int64(entry.EndByte-entry.StartByte), entry.Checksum, writer)
+	extraBytes, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		logger.Fatal(nil, "Segment %d: Error decompressing table with oid %d: %s", *content, tableOid, err.Error())
+	}
+	if extraBytes > 0 {
+		logger.Fatal(nil, "Segment %d: Frame for table with oid %d holds %d more bytes of data than recorded in the table of contents", *content, tableOid, extraBytes)
+	}
+}
+
+/*
+ * Verify helper functions
+ */
+
+func doVerifyHelper() {
+	toc := utils.NewSegmentTOC(*tocFile)
+	for _, line := range VerifyDataAgainstTOC(toc) {
+		fmt.Fprintln(utils.System.Stdout, line)
+	}
+}
+
+/*
+ * This reads the entire data stream for a segment and checks each table's byte
+ * range against the segment TOC, returning "OK <oid>" or "FAILED <oid>: <reason>"
+ * for each table and "FAILED: <reason>" for any problem with the stream itself.
+ */
+func VerifyDataAgainstTOC(toc *utils.SegmentTOC) []string {
+	results := make([]string, 0)
+	oids := make([]uint, 0, len(toc.DataEntries))
+	for oid := range toc.DataEntries {
+		oids = append(oids, oid)
+	}
+	sort.Slice(oids, func(i, j int) bool {
+		return toc.DataEntries[oids[i]].StartByte < toc.DataEntries[oids[j]].StartByte
+	})
+
+	reader := bufio.NewReader(utils.System.Stdin)
+	var position uint64
+	truncated := false
+	for _, oid := range oids {
+		entry := toc.DataEntries[oid]
+		if entry.StartByte > entry.EndByte || entry.EndByte > toc.LastByteRead {
+			results = append(results, fmt.Sprintf("FAILED %d: byte range %d-%d does not fit in %d bytes of data", oid, entry.StartByte, entry.EndByte, toc.LastByteRead))
+			continue
+		}
+		if entry.StartByte < position {
+			results = append(results, fmt.Sprintf("FAILED %d: byte range %d-%d overlaps the data for another table", oid, entry.StartByte, entry.EndByte))
+			continue
+		}
+		if truncated {
+			results = append(results, fmt.Sprintf("FAILED %d: data ends before byte %d", oid, entry.StartByte))
+			continue
+		}
+		discarded, _ := reader.Discard(int(entry.StartByte - position))
+		position += uint64(discarded)
+		hash := sha256.New()
+		copied, _ := io.CopyN(hash, reader, int64(entry.EndByte-entry.StartByte))
+		position += uint64(copied)
+		if position != entry.EndByte {
+			truncated = true
+			results = append(results, fmt.Sprintf("FAILED %d: data ends at byte %d, before the end of byte range %d-%d", oid, position, entry.StartByte, entry.EndByte))
+			continue
+		}
+		if checksum := fmt.Sprintf("%x", hash.Sum(nil)); entry.Checksum != "" && checksum != entry.Checksum {
+			results = append(results, fmt.Sprintf("FAILED %d: checksum of byte range %d-%d does not match", oid, entry.StartByte, entry.EndByte))
+			continue
+		}
+		results = append(results, fmt.Sprintf("OK %d", oid))
+	}
+	remaining, _ := io.Copy(ioutil.Discard, reader)
+	position += uint64(remaining)
+	if position != toc.LastByteRead {
+		results = append(results, fmt.Sprintf("FAILED: found %d bytes of data, but the table of contents records %d bytes", position, toc.LastByteRead))
+	}
+	return results
 }
 
 /*
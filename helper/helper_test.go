@@ -1,11 +1,18 @@
 package helper_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/greenplum-db/gpbackup/helper"
+	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -33,14 +40,16 @@ var _ = Describe("helper/helper", func() {
 		It("Returns correct number of bytes read", func() {
 			fmt.Fprintln(stdinWrite, "some text")
 			stdinWrite.Close()
-			bytesRead := helper.ReadAndCountBytes()
+			bytesRead, checksum := helper.ReadAndCountBytes(1, stdinRead, stdout)
 			Expect(bytesRead).To(Equal(uint64(10)))
+			Expect(checksum).To(Equal("a23e5fdcd7b276bdd81aa1a0b7b963101863dd3f61ff57935f8c5ba462681ea6"))
 			Expect(stdout).To(gbytes.Say("some text\n"))
 		})
 		It("Returns 0 if no bytes read", func() {
 			stdinWrite.Close()
-			bytesRead := helper.ReadAndCountBytes()
+			bytesRead, checksum := helper.ReadAndCountBytes(1, stdinRead, stdout)
 			Expect(bytesRead).To(Equal(uint64(0)))
+			Expect(checksum).To(Equal("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"))
 			Expect(stdout).To(gbytes.Say(""))
 		})
 		Describe("ReadOrCreateTOC", func() {
@@ -61,12 +70,13 @@ dataentries:
     endbyte: 10
   3:
     startbyte: 10
-    endbyte: 15`), nil
+    endbyte: 15
+    checksum: abc`), nil
 				}
 				expectedDataEntries := map[uint]utils.SegmentDataEntry{
 					1: {StartByte: 0, EndByte: 5},
 					2: {StartByte: 5, EndByte: 10},
-					3: {StartByte: 10, EndByte: 15},
+					3: {StartByte: 10, EndByte: 15, Checksum: "abc"},
 				}
 				toc, lastRead := helper.ReadOrCreateTOC()
 				Expect(lastRead).To(Equal(uint64(15)))
@@ -82,39 +92,358 @@ dataentries:
 				Expect((*toc).DataEntries).To(Not(BeNil()))
 			})
 		})
-		Describe("GetBoundsForTable", func() {
-			It("returns the start and end byte from the TOC", func() {
-				helper.SetFilename("filename")
-				toc := &utils.SegmentTOC{}
-				toc.DataEntries = map[uint]utils.SegmentDataEntry{
-					1: {StartByte: 0, EndByte: 5},
-					2: {StartByte: 5, EndByte: 10},
-					3: {StartByte: 10, EndByte: 15},
-				}
-				helper.SetOid(3)
-				startByte, endByte := helper.GetBoundsForTable(toc)
-				Expect(startByte).To(Equal(int64(10)))
-				Expect(endByte).To(Equal(int64(15)))
+		Describe("RestoreTableData", func() {
+			var toc *utils.SegmentTOC
+			BeforeEach(func() {
+				helper.SetContent(1)
+				helper.SetDataFile("")
+				helper.SetCompression("", 0)
+				toc = &utils.SegmentTOC{LastByteRead: 10, DataEntries: map[uint]utils.SegmentDataEntry{
+					1: {StartByte: 0, EndByte: 3},
+					2: {StartByte: 3, EndByte: 5},
+					3: {StartByte: 0, EndByte: 5, Checksum: "ee82cc30585022ea5102dda1f747cbfe345c261dd7c2eabea8aa1ad4308bf790"},
+					4: {StartByte: 5, EndByte: 9},
+				}}
+			})
+			It("copies a table's byte range from the start of stdin", func() {
+				fmt.Fprintln(stdinWrite, "some text")
+				stdinWrite.Close()
+				helper.RestoreTableData(toc, helper.OpenDataSource(toc), 1, stdout)
+				Expect(logfile).To(gbytes.Say("Reading bytes 0 to 3 for table with oid 1"))
+				Expect(string(stdout.Contents())).To(Equal("som"))
+			})
+			It("copies the byte ranges of successive tables from stdin", func() {
+				fmt.Fprintln(stdinWrite, "some text")
+				stdinWrite.Close()
+				source := helper.OpenDataSource(toc)
+				helper.RestoreTableData(toc, source, 1, gbytes.NewBuffer())
+				helper.RestoreTableData(toc, source, 4, stdout)
+				Expect(string(stdout.Contents())).To(Equal("text"))
+			})
+			It("copies a byte range whose checksum matches", func() {
+				fmt.Fprintln(stdinWrite, "some text")
+				stdinWrite.Close()
+				helper.RestoreTableData(toc, helper.OpenDataSource(toc), 3, stdout)
+				Expect(string(stdout.Contents())).To(Equal("some "))
+			})
+			It("decompresses data compressed as a single stream before copying the byte range", func() {
+				helper.SetCompression("zstd", 0)
+				var compressed bytes.Buffer
+				writer, _ := utils.NewCompressionWriter(&compressed, "zstd", 1)
+				fmt.Fprintln(writer, "some text")
+				writer.Close()
+				go func(writer *os.File) {
+					writer.Write(compressed.Bytes())
+					writer.Close()
+				}(stdinWrite)
+				helper.RestoreTableData(toc, helper.OpenDataSource(toc), 4, stdout)
+				Expect(string(stdout.Contents())).To(Equal("text"))
+			})
+			It("copies the byte ranges of tables in any order from the data file", func() {
+				tempDir, _ := ioutil.TempDir("", "helper_test")
+				defer os.RemoveAll(tempDir)
+				filename := path.Join(tempDir, "gpbackup_1_20170101010101")
+				Expect(ioutil.WriteFile(filename, []byte("some text\n"), 0644)).To(Succeed())
+				helper.SetDataFile(filename)
+				source := helper.OpenDataSource(toc)
+				helper.RestoreTableData(toc, source, 4, stdout)
+				helper.RestoreTableData(toc, source, 1, stdout)
+				Expect(string(stdout.Contents())).To(Equal("textsom"))
+			})
+			It("panics if tables are requested out of order from stdin", func() {
+				fmt.Fprintln(stdinWrite, "some text")
+				stdinWrite.Close()
+				source := helper.OpenDataSource(toc)
+				helper.RestoreTableData(toc, source, 4, stdout)
+				defer testutils.ShouldPanicWithMessage("Segment 1: Data for table with oid 1 was requested out of order; it starts at byte 0, but the data has been read up to byte 9")
+				helper.RestoreTableData(toc, source, 1, stdout)
+			})
+			It("panics if the table has no entry in the TOC", func() {
+				helper.SetFilename("/data/gpseg1/gpbackup_1_20170101010101_toc.yaml")
+				fmt.Fprintln(stdinWrite, "some text")
+				stdinWrite.Close()
+				defer testutils.ShouldPanicWithMessage("Segment 1: Table of contents file /data/gpseg1/gpbackup_1_20170101010101_toc.yaml has no entry for table with oid 5")
+				helper.RestoreTableData(toc, helper.OpenDataSource(toc), 5, stdout)
+			})
+			It("panics if the checksum of the byte range does not match", func() {
+				entry := toc.DataEntries[3]
+				entry.Checksum = "0000"
+				toc.DataEntries[3] = entry
+				fmt.Fprintln(stdinWrite, "some text")
+				stdinWrite.Close()
+				defer testutils.ShouldPanicWithMessage("Segment 1: Checksum verification failed for table with oid 3")
+				helper.RestoreTableData(toc, helper.OpenDataSource(toc), 3, stdout)
 			})
 		})
-		Describe("CopyByteRange", func() {
+		Describe("compressed frames", func() {
+			var frames []byte
+			var toc *utils.SegmentTOC
+			compressFrame := func(data string) []byte {
+				var frame bytes.Buffer
+				writer := gzip.NewWriter(&frame)
+				writer.Write([]byte(data))
+				writer.Close()
+				return frame.Bytes()
+			}
 			BeforeEach(func() {
 				helper.SetContent(1)
-				helper.SetOid(3)
+				helper.SetDataFile("")
+				helper.SetCompression("", 0)
+				utils.InitializeCompressionParameters(true, "gzip", 1)
+				firstFrame := compressFrame("some ")
+				secondFrame := compressFrame("text\n")
+				frames = append(firstFrame, secondFrame...)
+				toc = &utils.SegmentTOC{LastByteRead: 10, LastCompressedByteRead: uint64(len(frames)), CompressionType: "gzip", DataEntries: map[uint]utils.SegmentDataEntry{
+					1: {StartByte: 0, EndByte: 5, CompressedStartByte: 0, CompressedEndByte: uint64(len(firstFrame))},
+					2: {StartByte: 5, EndByte: 10, CompressedStartByte: uint64(len(firstFrame)), CompressedEndByte: uint64(len(frames)), Checksum: "b9e68e1bea3e5b19ca6b2f98b73a54b73daafaa250484902e09982e07a12e733"},
+				}}
 			})
-			It("can copy a byte range without seeking", func() {
+			AfterEach(func() {
+				utils.SetCompressionParameters(false, utils.Compression{})
+			})
+			It("compresses the data read as a single frame", func() {
 				fmt.Fprintln(stdinWrite, "some text")
 				stdinWrite.Close()
-				helper.CopyByteRange(0, 5)
-				Expect(logfile).To(gbytes.Say("Copying bytes for table with oid 3; discarding next 0 bytes, copying 5 bytes"))
-				Expect(stdout).To(gbytes.Say("some "))
+				bytesRead, bytesWritten, checksum := helper.CompressAndCountBytes(1, stdinRead, stdout)
+				Expect(bytesRead).To(Equal(uint64(10)))
+				Expect(checksum).To(Equal("a23e5fdcd7b276bdd81aa1a0b7b963101863dd3f61ff57935f8c5ba462681ea6"))
+				compressed := stdout.Contents()
+				Expect(bytesWritten).To(Equal(uint64(len(compressed))))
+				reader, err := gzip.NewReader(bytes.NewReader(compressed))
+				Expect(err).ToNot(HaveOccurred())
+				contents, _ := ioutil.ReadAll(reader)
+				Expect(string(contents)).To(Equal("some text\n"))
 			})
-			It("can copy a byte range with seeking", func() {
-				fmt.Fprintln(stdinWrite, "some text")
+			It("appends the sizes of the data for each table to the byte count file", func() {
+				tempDir, _ := ioutil.TempDir("", "helper_test")
+				defer os.RemoveAll(tempDir)
+				filename := path.Join(tempDir, "gpbackup_1_20170101010101_byte_counts")
+				helper.AppendByteCounts(filename, 1, 10, 5)
+				helper.AppendByteCounts(filename, 2, 20, 8)
+				contents, err := ioutil.ReadFile(filename)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(Equal("1 10 5\n2 20 8\n"))
+			})
+			It("decompresses only the frame for the table from the data file", func() {
+				tempDir, _ := ioutil.TempDir("", "helper_test")
+				defer os.RemoveAll(tempDir)
+				filename := path.Join(tempDir, "gpbackup_1_20170101010101.gz")
+				Expect(ioutil.WriteFile(filename, frames, 0644)).To(Succeed())
+				helper.SetDataFile(filename)
+				helper.RestoreTableData(toc, helper.OpenDataSource(toc), 2, stdout)
+				Expect(string(stdout.Contents())).To(Equal("text\n"))
+			})
+			It("decompresses only the frame for the table from stdin", func() {
+				go func(writer *os.File) {
+					writer.Write(frames)
+					writer.Close()
+				}(stdinWrite)
+				helper.RestoreTableData(toc, helper.OpenDataSource(toc), 2, stdout)
+				Expect(string(stdout.Contents())).To(Equal("text\n"))
+			})
+			It("panics if the checksum of the decompressed frame does not match", func() {
+				entry := toc.DataEntries[2]
+				entry.Checksum = "0000"
+				toc.DataEntries[2] = entry
+				go func(writer *os.File) {
+					writer.Write(frames)
+					writer.Close()
+				}(stdinWrite)
+				defer testutils.ShouldPanicWithMessage("Segment 1: Checksum verification failed for table with oid 2")
+				helper.RestoreTableData(toc, helper.OpenDataSource(toc), 2, stdout)
+			})
+		})
+		Describe("helper agents", func() {
+			var tempDir, pipeFile string
+			BeforeEach(func() {
+				helper.SetContent(1)
+				helper.SetDataFile("")
+				helper.SetCompression("", 0)
+				tempDir, _ = ioutil.TempDir("", "helper_test")
+				pipeFile = path.Join(tempDir, "gpbackup_1_20170101010101_pipe")
+				helper.SetPipeFile(pipeFile)
+			})
+			AfterEach(func() {
+				os.RemoveAll(tempDir)
+				utils.SetCompressionParameters(false, utils.Compression{})
+			})
+			It("backs up the data for each table in the order requested, recording it in the TOC", func() {
+				Expect(ioutil.WriteFile(pipeFile+"_3", []byte("some "), 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(pipeFile+"_1", []byte("text\n"), 0644)).To(Succeed())
+				toc := &utils.SegmentTOC{DataEntries: map[uint]utils.SegmentDataEntry{}}
+				helper.ProcessAgentCommands(strings.NewReader("3\n1\ndone\n2\n"), func(tableOid uint) {
+					helper.BackupTableFromPipe(toc, tableOid)
+				})
+				Expect(string(stdout.Contents())).To(Equal("some text\n"))
+				Expect(toc.LastByteRead).To(Equal(uint64(10)))
+				Expect(toc.DataEntries).To(Equal(map[uint]utils.SegmentDataEntry{
+					3: {StartByte: 0, EndByte: 5, Checksum: "ee82cc30585022ea5102dda1f747cbfe345c261dd7c2eabea8aa1ad4308bf790"},
+					1: {StartByte: 5, EndByte: 10, Checksum: "b9e68e1bea3e5b19ca6b2f98b73a54b73daafaa250484902e09982e07a12e733"},
+				}))
+			})
+			It("compresses the data for each table as a separate frame", func() {
+				utils.InitializeCompressionParameters(true, "gzip", 1)
+				Expect(ioutil.WriteFile(pipeFile+"_3", []byte("some "), 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(pipeFile+"_1", []byte("text\n"), 0644)).To(Succeed())
+				toc := &utils.SegmentTOC{LastByteRead: 5, LastCompressedByteRead: 20, CompressionType: "gzip", DataEntries: map[uint]utils.SegmentDataEntry{}}
+				helper.ProcessAgentCommands(strings.NewReader("3\n1\n"), func(tableOid uint) {
+					helper.BackupTableFromPipe(toc, tableOid)
+				})
+				compressed := uint64(len(stdout.Contents()))
+				Expect(toc.LastByteRead).To(Equal(uint64(15)))
+				Expect(toc.LastCompressedByteRead).To(Equal(20 + compressed))
+				Expect(toc.DataEntries[3].StartByte).To(Equal(uint64(5)))
+				Expect(toc.DataEntries[3].CompressedStartByte).To(Equal(uint64(20)))
+				Expect(toc.DataEntries[1].CompressedStartByte).To(Equal(toc.DataEntries[3].CompressedEndByte))
+				Expect(toc.DataEntries[1].CompressedEndByte).To(Equal(20 + compressed))
+				reader, err := gzip.NewReader(bytes.NewReader(stdout.Contents()))
+				Expect(err).ToNot(HaveOccurred())
+				contents, _ := ioutil.ReadAll(reader)
+				Expect(string(contents)).To(Equal("some text\n"))
+			})
+			It("restores the data for each table requested to its pipe", func() {
+				filename := path.Join(tempDir, "gpbackup_1_20170101010101")
+				Expect(ioutil.WriteFile(filename, []byte("some text\n"), 0644)).To(Succeed())
+				helper.SetDataFile(filename)
+				Expect(ioutil.WriteFile(pipeFile+"_1", []byte{}, 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(pipeFile+"_2", []byte{}, 0644)).To(Succeed())
+				toc := &utils.SegmentTOC{LastByteRead: 10, DataEntries: map[uint]utils.SegmentDataEntry{
+					1: {StartByte: 0, EndByte: 5},
+					2: {StartByte: 5, EndByte: 10},
+				}}
+				helper.RestoreTablesToPipes(toc, helper.OpenDataSource(toc), strings.NewReader("2\n1\ndone\n"))
+				Expect(ioutil.ReadFile(pipeFile + "_1")).To(Equal([]byte("some ")))
+				Expect(ioutil.ReadFile(pipeFile + "_2")).To(Equal([]byte("text\n")))
+			})
+			It("restores tables requested at the same time from the data file without waiting for each other", func() {
+				filename := path.Join(tempDir, "gpbackup_1_20170101010101")
+				Expect(ioutil.WriteFile(filename, []byte("some text\n"), 0644)).To(Succeed())
+				helper.SetDataFile(filename)
+				Expect(syscall.Mkfifo(pipeFile+"_1", 0644)).To(Succeed())
+				Expect(syscall.Mkfifo(pipeFile+"_2", 0644)).To(Succeed())
+				toc := &utils.SegmentTOC{LastByteRead: 10, DataEntries: map[uint]utils.SegmentDataEntry{
+					1: {StartByte: 0, EndByte: 5},
+					2: {StartByte: 5, EndByte: 10},
+				}}
+				finished := make(chan bool)
+				go func() {
+					defer GinkgoRecover()
+					helper.RestoreTablesToPipes(toc, helper.OpenDataSource(toc), strings.NewReader("2\n1\ndone\n"))
+					close(finished)
+				}()
+				// The table requested first is read last, which would never finish if the tables were restored one at a time
+				Expect(ioutil.ReadFile(pipeFile + "_1")).To(Equal([]byte("some ")))
+				Expect(ioutil.ReadFile(pipeFile + "_2")).To(Equal([]byte("text\n")))
+				Eventually(finished).Should(BeClosed())
+			})
+			It("writes an error file for a table that fails verification and goes on to the next table", func() {
+				fmt.Fprint(stdinWrite, "some text\n")
+				stdinWrite.Close()
+				Expect(ioutil.WriteFile(pipeFile+"_1", []byte{}, 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(pipeFile+"_2", []byte{}, 0644)).To(Succeed())
+				toc := &utils.SegmentTOC{LastByteRead: 10, DataEntries: map[uint]utils.SegmentDataEntry{
+					1: {StartByte: 0, EndByte: 5, Checksum: "0000"},
+					2: {StartByte: 5, EndByte: 10},
+				}}
+				helper.RestoreTablesToPipes(toc, helper.OpenDataSource(toc), strings.NewReader("1\n2\ndone\n"))
+				contents, err := ioutil.ReadFile(pipeFile + "_1_error")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(ContainSubstring("Segment 1: Checksum verification failed for table with oid 1"))
+				Expect(ioutil.ReadFile(pipeFile + "_2")).To(Equal([]byte("text\n")))
+				Expect(pipeFile + "_2_error").ToNot(BeAnExistingFile())
+				Expect(pipeFile + "_error").ToNot(BeAnExistingFile())
+			})
+			It("restores a table requested at the same time as a table that fails verification", func() {
+				filename := path.Join(tempDir, "gpbackup_1_20170101010101")
+				Expect(ioutil.WriteFile(filename, []byte("some text\n"), 0644)).To(Succeed())
+				helper.SetDataFile(filename)
+				Expect(syscall.Mkfifo(pipeFile+"_1", 0644)).To(Succeed())
+				Expect(syscall.Mkfifo(pipeFile+"_2", 0644)).To(Succeed())
+				toc := &utils.SegmentTOC{LastByteRead: 10, DataEntries: map[uint]utils.SegmentDataEntry{
+					1: {StartByte: 0, EndByte: 5, Checksum: "0000"},
+					2: {StartByte: 5, EndByte: 10, Checksum: "b9e68e1bea3e5b19ca6b2f98b73a54b73daafaa250484902e09982e07a12e733"},
+				}}
+				finished := make(chan bool)
+				go func() {
+					defer GinkgoRecover()
+					helper.RestoreTablesToPipes(toc, helper.OpenDataSource(toc), strings.NewReader("1\n2\ndone\n"))
+					close(finished)
+				}()
+				// Both tables are being restored at once when the first one fails
+				Expect(ioutil.ReadFile(pipeFile + "_2")).To(Equal([]byte("text\n")))
+				Expect(ioutil.ReadFile(pipeFile + "_1")).To(Equal([]byte("some ")))
+				Eventually(finished).Should(BeClosed())
+				contents, err := ioutil.ReadFile(pipeFile + "_1_error")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(contents)).To(ContainSubstring("Segment 1: Checksum verification failed for table with oid 1"))
+				Expect(pipeFile + "_2_error").ToNot(BeAnExistingFile())
+				Expect(pipeFile + "_error").ToNot(BeAnExistingFile())
+			})
+			It("opens the pipes of the tables still waiting for it before exiting with an error", func() {
+				Expect(syscall.Mkfifo(pipeFile+"_2", 0644)).To(Succeed())
+				Expect(syscall.Mkfifo(pipeFile+"_3", 0644)).To(Succeed())
+				// These stand in for COPY reading from and writing to the pipes of tables the agent has not yet opened
+				reading, writing := make(chan bool), make(chan bool)
+				go func() {
+					ioutil.ReadFile(pipeFile + "_2")
+					close(reading)
+				}()
+				go func() {
+					ioutil.WriteFile(pipeFile+"_3", []byte("some text\n"), 0644)
+					close(writing)
+				}()
+				// Give the goroutines time to start waiting to open the pipes
+				time.Sleep(100 * time.Millisecond)
+				defer func() {
+					Eventually(reading).Should(BeClosed())
+					Eventually(writing).Should(BeClosed())
+				}()
+				defer func() {
+					contents, err := ioutil.ReadFile(pipeFile + "_error")
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(contents)).To(ContainSubstring(`Segment 1: Invalid helper agent command "stop"`))
+				}()
+				defer testutils.ShouldPanicWithMessage(`Segment 1: Invalid helper agent command "stop"`)
+				defer helper.ReportAgentError()
+				helper.ProcessAgentCommands(strings.NewReader("stop\n"), func(tableOid uint) {})
+			})
+			It("panics on an invalid command", func() {
+				defer testutils.ShouldPanicWithMessage(`Segment 1: Invalid helper agent command "stop"`)
+				helper.ProcessAgentCommands(strings.NewReader("stop\n"), func(tableOid uint) {})
+			})
+		})
+		Describe("VerifyDataAgainstTOC", func() {
+			var toc *utils.SegmentTOC
+			BeforeEach(func() {
+				toc = &utils.SegmentTOC{LastByteRead: 10, DataEntries: map[uint]utils.SegmentDataEntry{
+					1: {StartByte: 0, EndByte: 5, Checksum: "ee82cc30585022ea5102dda1f747cbfe345c261dd7c2eabea8aa1ad4308bf790"},
+					2: {StartByte: 5, EndByte: 10},
+				}}
+			})
+			It("reports every table as verified if the data matches the TOC", func() {
+				fmt.Fprint(stdinWrite, "some text\n")
+				stdinWrite.Close()
+				Expect(helper.VerifyDataAgainstTOC(toc)).To(Equal([]string{"OK 1", "OK 2"}))
+			})
+			It("reports a table whose checksum does not match", func() {
+				fmt.Fprint(stdinWrite, "same text\n")
+				stdinWrite.Close()
+				Expect(helper.VerifyDataAgainstTOC(toc)).To(Equal([]string{"FAILED 1: checksum of byte range 0-5 does not match", "OK 2"}))
+			})
+			It("reports tables whose data is truncated", func() {
+				fmt.Fprint(stdinWrite, "some te")
+				stdinWrite.Close()
+				Expect(helper.VerifyDataAgainstTOC(toc)).To(Equal([]string{
+					"OK 1",
+					"FAILED 2: data ends at byte 7, before the end of byte range 5-10",
+					"FAILED: found 7 bytes of data, but the table of contents records 10 bytes",
+				}))
+			})
+			It("reports a table whose byte range does not fit in the data", func() {
+				toc.DataEntries[3] = utils.SegmentDataEntry{StartByte: 10, EndByte: 15}
+				fmt.Fprint(stdinWrite, "some text\n")
 				stdinWrite.Close()
-				helper.CopyByteRange(3, 5)
-				Expect(logfile).To(gbytes.Say("Copying bytes for table with oid 3; discarding next 3 bytes, copying 2 bytes"))
-				Expect(stdout).To(gbytes.Say("e "))
+				Expect(helper.VerifyDataAgainstTOC(toc)).To(Equal([]string{"OK 1", "OK 2", "FAILED 3: byte range 10-15 does not fit in 10 bytes of data"}))
 			})
 		})
 	})
@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"fmt"
 	"sort"
 
 	"github.com/greenplum-db/gpbackup/backup"
@@ -336,6 +337,46 @@ PARTITION BY RANGE (year)
 			Expect(partTableMap[leaf33]).To(Equal("l"))
 		})
 	})
+	Describe("GetPartitionDescendants", func() {
+		It("maps a parent partition table to its intermediate and leaf partitions", func() {
+			createStmt := `CREATE TABLE summer_sales (id int, year int, month int)
+DISTRIBUTED BY (id)
+PARTITION BY RANGE (year)
+    SUBPARTITION BY RANGE (month)
+       SUBPARTITION TEMPLATE (
+        START (6) END (7) EVERY (1))
+( START (2015) END (2016) EVERY (1));
+`
+			testutils.AssertQueryRuns(connection, createStmt)
+			defer testutils.AssertQueryRuns(connection, "DROP TABLE summer_sales")
+
+			parent := testutils.OidFromObjectName(connection, "public", "summer_sales", backup.TYPE_RELATION)
+			intermediate := testutils.OidFromObjectName(connection, "public", "summer_sales_1_prt_1", backup.TYPE_RELATION)
+			leaf := testutils.OidFromObjectName(connection, "public", "summer_sales_1_prt_1_2_prt_1", backup.TYPE_RELATION)
+
+			descendants := backup.GetPartitionDescendants(connection)
+
+			Expect(descendants[parent]).To(ConsistOf(intermediate, leaf))
+		})
+	})
+	Describe("GetSegmentTableSizes", func() {
+		It("returns the size of a table on each segment", func() {
+			testutils.AssertQueryRuns(connection, "CREATE TABLE public.sizes_table(i int) DISTRIBUTED BY (i)")
+			defer testutils.AssertQueryRuns(connection, "DROP TABLE public.sizes_table")
+			testutils.AssertQueryRuns(connection, "INSERT INTO public.sizes_table SELECT generate_series(1, 1000)")
+			oid := testutils.OidFromObjectName(connection, "public", "sizes_table", backup.TYPE_RELATION)
+
+			sizes := backup.GetSegmentTableSizes(connection, []string{fmt.Sprintf("%d", oid)})
+
+			var totalSize uint64
+			for _, size := range sizes {
+				Expect(size.Oid).To(Equal(oid))
+				totalSize += size.Size
+			}
+			Expect(sizes).ToNot(BeEmpty())
+			Expect(totalSize).To(BeNumerically(">", 0))
+		})
+	})
 	Describe("GetColumnDefinitions", func() {
 		It("returns table attribute information for a heap table", func() {
 			testutils.AssertQueryRuns(connection, "CREATE TABLE atttable(a float, b text, c text NOT NULL, d int DEFAULT(5), e text)")
@@ -0,0 +1,133 @@
+package manager
+
+/*
+ * This file contains the functions implementing each gpbackup_manager command.
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func matchesDBName(entry utils.BackupHistoryEntry, name string) bool {
+	return name == "" || entry.DatabaseName == name || entry.DatabaseName == utils.QuoteIdent(name)
+}
+
+func ListBackups(history *utils.BackupHistory, name string) {
+	PrintBackupList(os.Stdout, history, name)
+}
+
+func PrintBackupList(writer io.Writer, history *utils.BackupHistory, name string) {
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "TIMESTAMP\tDATABASE\tSTATUS\tTYPE\tSIZE")
+	for _, entry := range history.Backups {
+		if !matchesDBName(entry, name) {
+			continue
+		}
+		backupType := "Full"
+		if entry.Incremental {
+			backupType = "Incremental"
+		}
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\t%s\n", entry.Timestamp, entry.DatabaseName, entry.Status, backupType, utils.FormatSize(entry.SizeInBytes))
+	}
+	tabWriter.Flush()
+}
+
+func DescribeBackup(history *utils.BackupHistory, timestamp string) {
+	entry := history.MustFindEntry(timestamp)
+	contents, err := yaml.Marshal(entry)
+	utils.CheckError(err)
+	fmt.Print(string(contents))
+}
+
+/*
+ * Backups are deleted from the directories recorded for them in the history,
+ * which may differ from the default directories if --backup-dir was used.
+ */
+func DeleteBackup(history *utils.BackupHistory, timestamp string) {
+	entry := history.MustFindEntry(timestamp)
+	if entry.Status == utils.BACKUP_STATUS_DELETED {
+		logger.Fatal(errors.Errorf("Backup %s has already been deleted", timestamp), "")
+	}
+	dependents := history.GetDependentBackups(timestamp)
+	if len(dependents) > 0 {
+		logger.Fatal(errors.Errorf("Backup %s cannot be deleted because the following incremental backups depend on it: %s", timestamp, strings.Join(dependents, ", ")), "")
+	}
+	deleteBackupFiles(entry)
+}
+
+func deleteBackupFiles(entry *utils.BackupHistoryEntry) {
+	logger.Info("Deleting backup %s", entry.Timestamp)
+	if entry.PluginConfig != "" {
+		logger.Warn("Backup %s was taken with plugin config file %s; any files stored by the plugin must be deleted separately", entry.Timestamp, entry.PluginConfig)
+	}
+	cluster := globalCluster
+	cluster.UserSpecifiedBackupDir = entry.BackupDir
+	cluster.UserSpecifiedSegPrefix = entry.SegPrefix
+	cluster.Timestamp = entry.Timestamp
+	cluster.DeleteBackupDirectoriesOnAllHosts()
+	entry.Status = utils.BACKUP_STATUS_DELETED
+}
+
+/*
+ * This returns the backups that should be pruned, newest first, so that
+ * incremental backups are deleted before the backups they depend on.  Only
+ * successful backups count towards --keep-count, and failed backups older
+ * than the oldest backup kept are pruned along with everything else.
+ */
+func GetBackupsToPrune(history *utils.BackupHistory, now time.Time) []string {
+	toPrune := make([]string, 0)
+	cutoff := now.AddDate(0, 0, -*keepDays)
+	kept := 0
+	for i := len(history.Backups) - 1; i >= 0; i-- {
+		entry := history.Backups[i]
+		if entry.Status == utils.BACKUP_STATUS_DELETED || !matchesDBName(entry, *dbname) {
+			continue
+		}
+		if *keepDays > 0 {
+			backupTime, err := time.ParseInLocation("20060102150405", entry.Timestamp, utils.System.Local)
+			if err != nil || !backupTime.Before(cutoff) {
+				continue
+			}
+		} else if kept < *keepCount {
+			if entry.Status == utils.BACKUP_STATUS_SUCCESS {
+				kept++
+			}
+			continue
+		}
+		toPrune = append(toPrune, entry.Timestamp)
+	}
+	return toPrune
+}
+
+/*
+ * The history is saved after each backup is deleted, so that a failure partway
+ * through a prune does not lose the record of the backups already deleted.
+ */
+func PruneBackups(history *utils.BackupHistory, now time.Time, saveHistory func()) {
+	toPrune := GetBackupsToPrune(history, now)
+	if len(toPrune) == 0 {
+		logger.Info("No backups to prune")
+		return
+	}
+	numDeleted := 0
+	for _, timestamp := range toPrune {
+		dependents := history.GetDependentBackups(timestamp)
+		if len(dependents) > 0 {
+			logger.Warn("Skipping backup %s because the following incremental backups depend on it: %s", timestamp, strings.Join(dependents, ", "))
+			continue
+		}
+		deleteBackupFiles(history.FindEntry(timestamp))
+		saveHistory()
+		numDeleted++
+	}
+	logger.Info("Pruned %d of %d backups", numDeleted, len(toPrune))
+}
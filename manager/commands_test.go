@@ -0,0 +1,138 @@
+package manager_test
+
+import (
+	"os/user"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("manager/commands tests", func() {
+	fullEntry := func(timestamp string, status string) utils.BackupHistoryEntry {
+		return utils.BackupHistoryEntry{Timestamp: timestamp, Status: status, BackupConfig: utils.BackupConfig{DatabaseName: "testdb", RestorePlan: []utils.RestorePlanEntry{{Timestamp: timestamp}}}}
+	}
+	incrementalEntry := func(timestamp string, base string) utils.BackupHistoryEntry {
+		entry := fullEntry(timestamp, utils.BACKUP_STATUS_SUCCESS)
+		entry.Incremental = true
+		entry.RestorePlan = []utils.RestorePlanEntry{{Timestamp: base}, {Timestamp: timestamp}}
+		return entry
+	}
+	now := time.Date(2017, 1, 10, 0, 0, 0, 0, time.Local)
+	var (
+		history      *utils.BackupHistory
+		testExecutor *testutils.TestExecutor
+	)
+
+	BeforeEach(func() {
+		utils.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+		utils.System.Local = time.Local
+		history = &utils.BackupHistory{}
+		history.AddEntry(fullEntry("20170101010101", utils.BACKUP_STATUS_SUCCESS))
+		history.AddEntry(incrementalEntry("20170102010101", "20170101010101"))
+		history.AddEntry(fullEntry("20170105010101", utils.BACKUP_STATUS_FAILURE))
+		history.AddEntry(fullEntry("20170106010101", utils.BACKUP_STATUS_SUCCESS))
+		history.AddEntry(fullEntry("20170108010101", utils.BACKUP_STATUS_SUCCESS))
+		testExecutor = &testutils.TestExecutor{ClusterOutput: &utils.RemoteOutput{}}
+		cluster := testutils.SetDefaultSegmentConfiguration()
+		cluster.Executor = testExecutor
+		manager.SetCluster(cluster)
+		manager.SetDBName("")
+		manager.SetKeepCount(0)
+		manager.SetKeepDays(0)
+	})
+	Describe("PrintBackupList", func() {
+		It("prints every backup in the history", func() {
+			history.Backups[0].SizeInBytes = 2048
+			buffer := gbytes.NewBuffer()
+			manager.PrintBackupList(buffer, history, "")
+			Expect(buffer).To(gbytes.Say(`TIMESTAMP\s+DATABASE\s+STATUS\s+TYPE\s+SIZE`))
+			Expect(buffer).To(gbytes.Say(`20170101010101\s+testdb\s+Success\s+Full\s+2.0 KB`))
+			Expect(buffer).To(gbytes.Say(`20170102010101\s+testdb\s+Success\s+Incremental\s+0 B`))
+			Expect(buffer).To(gbytes.Say(`20170105010101\s+testdb\s+Failure\s+Full`))
+		})
+		It("prints only backups of the specified database", func() {
+			history.Backups[0].DatabaseName = "otherdb"
+			buffer := gbytes.NewBuffer()
+			manager.PrintBackupList(buffer, history, "otherdb")
+			Expect(string(buffer.Contents())).To(ContainSubstring("20170101010101"))
+			Expect(string(buffer.Contents())).ToNot(ContainSubstring("20170102010101"))
+		})
+	})
+	Describe("DeleteBackup", func() {
+		It("deletes the backup directories on all hosts and marks the backup as deleted", func() {
+			manager.DeleteBackup(history, "20170108010101")
+			Expect(testExecutor.NumExecutions).To(Equal(1))
+			Expect(testExecutor.ClusterCommands[0][-1]).To(Equal([]string{"bash", "-c", "rm -rf gpseg-1/backups/20170108/20170108010101 && (rmdir gpseg-1/backups/20170108 2>/dev/null || true)"}))
+			Expect(history.FindEntry("20170108010101").Status).To(Equal(utils.BACKUP_STATUS_DELETED))
+		})
+		It("deletes the backup from the backup directory it was taken with", func() {
+			history.Backups[4].BackupDir = "/backups"
+			history.Backups[4].SegPrefix = "gpseg"
+			manager.DeleteBackup(history, "20170108010101")
+			Expect(testExecutor.ClusterCommands[0][0]).To(Equal([]string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@localhost", "rm -rf /backups/gpseg0/backups/20170108/20170108010101 && (rmdir /backups/gpseg0/backups/20170108 2>/dev/null || true)"}))
+		})
+		It("panics if the backup is not in the history", func() {
+			defer testutils.ShouldPanicWithMessage("Backup 20170109010101 was not found in the backup history")
+			manager.DeleteBackup(history, "20170109010101")
+		})
+		It("panics if an incremental backup depends on the backup", func() {
+			defer testutils.ShouldPanicWithMessage("Backup 20170101010101 cannot be deleted because the following incremental backups depend on it: 20170102010101")
+			manager.DeleteBackup(history, "20170101010101")
+		})
+		It("panics if the backup has already been deleted", func() {
+			history.Backups[4].Status = utils.BACKUP_STATUS_DELETED
+			defer testutils.ShouldPanicWithMessage("Backup 20170108010101 has already been deleted")
+			manager.DeleteBackup(history, "20170108010101")
+		})
+	})
+	Describe("GetBackupsToPrune", func() {
+		It("returns backups older than --keep-days, newest first", func() {
+			manager.SetKeepDays(5)
+			Expect(manager.GetBackupsToPrune(history, now)).To(Equal([]string{"20170102010101", "20170101010101"}))
+		})
+		It("returns all but the --keep-count most recent successful backups", func() {
+			manager.SetKeepCount(2)
+			Expect(manager.GetBackupsToPrune(history, now)).To(Equal([]string{"20170105010101", "20170102010101", "20170101010101"}))
+		})
+		It("ignores deleted backups and backups of other databases", func() {
+			manager.SetKeepCount(1)
+			manager.SetDBName("testdb")
+			history.Backups[3].Status = utils.BACKUP_STATUS_DELETED
+			history.Backups[2].DatabaseName = "otherdb"
+			Expect(manager.GetBackupsToPrune(history, now)).To(Equal([]string{"20170102010101", "20170101010101"}))
+		})
+	})
+	Describe("PruneBackups", func() {
+		It("deletes incremental backups before the backups they depend on", func() {
+			manager.SetKeepDays(5)
+			manager.PruneBackups(history, now, func() {})
+			Expect(testExecutor.NumExecutions).To(Equal(2))
+			Expect(history.FindEntry("20170101010101").Status).To(Equal(utils.BACKUP_STATUS_DELETED))
+			Expect(history.FindEntry("20170102010101").Status).To(Equal(utils.BACKUP_STATUS_DELETED))
+		})
+		It("skips backups on which a kept incremental backup depends", func() {
+			manager.SetKeepDays(8)
+			manager.PruneBackups(history, now, func() {})
+			Expect(testExecutor.NumExecutions).To(Equal(0))
+			Expect(history.FindEntry("20170101010101").Status).To(Equal(utils.BACKUP_STATUS_SUCCESS))
+			Expect(stdout).To(gbytes.Say("Skipping backup 20170101010101 because the following incremental backups depend on it: 20170102010101"))
+		})
+		It("saves the history after each backup is deleted", func() {
+			manager.SetKeepDays(5)
+			savedStatuses := make([][]string, 0)
+			manager.PruneBackups(history, now, func() {
+				savedStatuses = append(savedStatuses, []string{history.FindEntry("20170102010101").Status, history.FindEntry("20170101010101").Status})
+			})
+			Expect(savedStatuses).To(Equal([][]string{
+				{utils.BACKUP_STATUS_DELETED, utils.BACKUP_STATUS_SUCCESS},
+				{utils.BACKUP_STATUS_DELETED, utils.BACKUP_STATUS_DELETED},
+			}))
+		})
+	})
+})
@@ -0,0 +1,63 @@
+package manager
+
+import "github.com/greenplum-db/gpbackup/utils"
+
+/*
+ * This file contains global variables and setter functions for those variables
+ * used in testing.
+ */
+
+/*
+ * Non-flag variables
+ */
+
+var (
+	command       string
+	connection    *utils.DBConn
+	globalCluster utils.Cluster
+	logger        *utils.Logger
+	version       string
+)
+
+/*
+ * Command-line flags
+ */
+
+var (
+	dbname       *string
+	debug        *bool
+	keepCount    *int
+	keepDays     *int
+	printVersion *bool
+	quiet        *bool
+	timestamp    *string
+	verbose      *bool
+)
+
+/*
+ * Setter functions
+ */
+
+func SetCluster(cluster utils.Cluster) {
+	globalCluster = cluster
+}
+
+func SetDBName(name string) {
+	dbname = &name
+}
+
+func SetKeepCount(count int) {
+	keepCount = &count
+}
+
+func SetKeepDays(days int) {
+	keepDays = &days
+}
+
+func SetLogger(log *utils.Logger) {
+	logger = log
+}
+
+func SetTimestamp(ts string) {
+	timestamp = &ts
+}
@@ -0,0 +1,143 @@
+package manager
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/utils"
+)
+
+/*
+ * We define and initialize flags separately to avoid import conflicts in tests.
+ * The flag variables, and setter functions for them, are in global_variables.go.
+ */
+func initializeFlags() {
+	dbname = flag.String("dbname", "", "Only list or prune backups of the specified database")
+	debug = flag.Bool("debug", false, "Print verbose and debug log messages")
+	keepCount = flag.Int("keep-count", 0, "When pruning, keep the specified number of most recent successful backups and delete all older backups")
+	keepDays = flag.Int("keep-days", 0, "When pruning, delete all backups taken more than the specified number of days ago")
+	printVersion = flag.Bool("version", false, "Print version number and exit")
+	quiet = flag.Bool("quiet", false, "Suppress non-warning, non-error log messages")
+	timestamp = flag.String("timestamp", "", "The timestamp of the backup to describe or delete, in the format YYYYMMDDHHMMSS")
+	verbose = flag.Bool("verbose", false, "Print verbose log messages")
+}
+
+// This function handles setup that can be done before parsing flags.
+func DoInit() {
+	SetLogger(utils.InitializeLogging("gpbackup_manager", ""))
+	initializeFlags()
+}
+
+func printUsage() {
+	fmt.Println(`Usage: gpbackup_manager <command> [flags]
+
+Commands:
+  list       List all backups in the backup history
+  describe   Print the details of the backup specified by --timestamp
+  delete     Delete the backup specified by --timestamp from all hosts
+  prune      Delete backups according to --keep-days or --keep-count
+
+Flags:`)
+	flag.PrintDefaults()
+}
+
+/*
+ * The command precedes the flags, so flags are parsed starting from the second
+ * argument unless no command is given.
+ */
+func DoValidation() {
+	if len(os.Args) == 1 {
+		printUsage()
+		os.Exit(0)
+	}
+	args := os.Args[1:]
+	if !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if *printVersion {
+		fmt.Printf("gpbackup_manager %s\n", version)
+		os.Exit(0)
+	}
+	ValidateCommandAndFlags(command)
+}
+
+// This function handles setup that must be done after parsing flags.
+func DoSetup() {
+	SetLoggerVerbosity()
+	connection = utils.NewDBConn("postgres")
+	connection.Connect(1)
+	connection.MustExec("SET application_name TO 'gpbackup_manager'")
+	segConfig := utils.GetSegmentConfiguration(connection)
+	globalCluster = utils.NewCluster(segConfig, "", "", "")
+}
+
+func DoManage() {
+	historyFilename := utils.GetBackupHistoryFilePath(globalCluster)
+	switch command {
+	case "list":
+		ListBackups(utils.ReadBackupHistory(historyFilename), *dbname)
+	case "describe":
+		DescribeBackup(utils.ReadBackupHistory(historyFilename), *timestamp)
+	case "delete":
+		utils.UpdateBackupHistory(historyFilename, func(history *utils.BackupHistory) {
+			DeleteBackup(history, *timestamp)
+		})
+	case "prune":
+		utils.UpdateBackupHistory(historyFilename, func(history *utils.BackupHistory) {
+			PruneBackups(history, utils.System.Now(), func() { history.WriteToFile(historyFilename) })
+		})
+	}
+}
+
+func DoTeardown() {
+	err := recover()
+	if err != nil {
+		fmt.Println(err)
+	}
+	_, exitCode := utils.ParseErrorMessage(err)
+	if connection != nil {
+		connection.Close()
+	}
+	os.Exit(exitCode)
+}
+
+func SetLoggerVerbosity() {
+	if *quiet {
+		logger.SetVerbosity(utils.LOGERROR)
+	} else if *debug {
+		logger.SetVerbosity(utils.LOGDEBUG)
+	} else if *verbose {
+		logger.SetVerbosity(utils.LOGVERBOSE)
+	}
+}
+
+func ValidateCommandAndFlags(command string) {
+	utils.CheckExclusiveFlags("debug", "quiet", "verbose")
+	switch command {
+	case "list":
+		utils.CheckFlagsNotSetForCommand(command, "timestamp", "keep-days", "keep-count")
+	case "describe", "delete":
+		utils.CheckMandatoryFlags("timestamp")
+		utils.CheckFlagsNotSetForCommand(command, "dbname", "keep-days", "keep-count")
+		if !utils.IsValidTimestamp(*timestamp) {
+			logger.Fatal(utils.NewFlagError("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", *timestamp), "")
+		}
+	case "prune":
+		utils.CheckExclusiveFlags("keep-days", "keep-count")
+		utils.CheckFlagsNotSetForCommand(command, "timestamp")
+		if *keepDays == 0 && *keepCount == 0 {
+			logger.Fatal(utils.NewFlagError("One of --keep-days or --keep-count must be specified with a value of at least 1"), "")
+		}
+		if *keepDays < 0 || *keepCount < 0 {
+			logger.Fatal(utils.NewFlagError("The values of --keep-days and --keep-count must be at least 1"), "")
+		}
+	case "":
+		logger.Fatal(utils.NewFlagError("A command must be specified.  Valid commands are list, describe, delete, and prune."), "")
+	default:
+		logger.Fatal(utils.NewFlagError("Unrecognized command %s.  Valid commands are list, describe, delete, and prune.", command), "")
+	}
+}
@@ -0,0 +1,27 @@
+package manager_test
+
+import (
+	"testing"
+
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var (
+	logger  *utils.Logger
+	stdout  *gbytes.Buffer
+	stderr  *gbytes.Buffer
+	logfile *gbytes.Buffer
+)
+
+func TestManager(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "manager tests")
+}
+
+var _ = BeforeSuite(func() {
+	logger, stdout, stderr, logfile = testutils.SetupTestLogger()
+})
@@ -0,0 +1,69 @@
+package manager_test
+
+import (
+	"flag"
+
+	"github.com/greenplum-db/gpbackup/manager"
+	"github.com/greenplum-db/gpbackup/testutils"
+
+	. "github.com/onsi/ginkgo"
+)
+
+var _ = Describe("manager/manager tests", func() {
+	Describe("ValidateCommandAndFlags", func() {
+		parseFlags := func(args ...string) {
+			flag.CommandLine = flag.NewFlagSet("", flag.ContinueOnError)
+			dbname := flag.String("dbname", "", "")
+			keepCount := flag.Int("keep-count", 0, "")
+			keepDays := flag.Int("keep-days", 0, "")
+			timestamp := flag.String("timestamp", "", "")
+			flag.CommandLine.Parse(args)
+			manager.SetDBName(*dbname)
+			manager.SetKeepCount(*keepCount)
+			manager.SetKeepDays(*keepDays)
+			manager.SetTimestamp(*timestamp)
+		}
+		It("accepts --dbname with the list command", func() {
+			parseFlags("-dbname", "testdb")
+			manager.ValidateCommandAndFlags("list")
+		})
+		It("rejects --timestamp with the list command", func() {
+			parseFlags("-timestamp", "20170101010101")
+			defer testutils.ShouldPanicWithMessage("Flag timestamp cannot be used with the list command")
+			manager.ValidateCommandAndFlags("list")
+		})
+		It("rejects --keep-count with the list command", func() {
+			parseFlags("-keep-count", "2")
+			defer testutils.ShouldPanicWithMessage("Flag keep-count cannot be used with the list command")
+			manager.ValidateCommandAndFlags("list")
+		})
+		It("accepts --timestamp with the describe command", func() {
+			parseFlags("-timestamp", "20170101010101")
+			manager.ValidateCommandAndFlags("describe")
+		})
+		It("rejects --dbname with the describe command", func() {
+			parseFlags("-timestamp", "20170101010101", "-dbname", "testdb")
+			defer testutils.ShouldPanicWithMessage("Flag dbname cannot be used with the describe command")
+			manager.ValidateCommandAndFlags("describe")
+		})
+		It("rejects --keep-days with the delete command", func() {
+			parseFlags("-timestamp", "20170101010101", "-keep-days", "2")
+			defer testutils.ShouldPanicWithMessage("Flag keep-days cannot be used with the delete command")
+			manager.ValidateCommandAndFlags("delete")
+		})
+		It("accepts --keep-days and --dbname with the prune command", func() {
+			parseFlags("-keep-days", "2", "-dbname", "testdb")
+			manager.ValidateCommandAndFlags("prune")
+		})
+		It("rejects --timestamp with the prune command", func() {
+			parseFlags("-keep-days", "2", "-timestamp", "20170101010101")
+			defer testutils.ShouldPanicWithMessage("Flag timestamp cannot be used with the prune command")
+			manager.ValidateCommandAndFlags("prune")
+		})
+		It("rejects --keep-days and --keep-count together with the prune command", func() {
+			parseFlags("-keep-days", "2", "-keep-count", "2")
+			defer testutils.ShouldPanicWithMessage("The following flags may not be specified together: keep-days, keep-count")
+			manager.ValidateCommandAndFlags("prune")
+		})
+	})
+})
@@ -0,0 +1,72 @@
+#!/bin/bash
+
+set -o pipefail
+
+# This is an example plugin for gpbackup and gprestore that "stores" backup
+# files by copying them to a directory on the local filesystem of each host.
+# It is intended as a reference for plugin authors and for testing the plugin
+# interface, not for production use.  See README.md in this directory for the
+# full plugin API.
+#
+# The plugin config file should look like the following, where remote_directory
+# is the directory to which files will be copied:
+#
+#   executablepath: /path/to/example_plugin.bash
+#   options:
+#     remote_directory: /tmp/plugin_dest
+
+get_remote_directory() {
+  local config_file=$1
+  local remote_directory=$(grep "remote_directory:" "$config_file" | sed -e 's/^.*remote_directory:[[:space:]]*//' -e 's/[[:space:]]*$//')
+  echo "${remote_directory:-/tmp/plugin_dest}"
+}
+
+setup_plugin_for_backup() {
+  local remote_directory=$(get_remote_directory "$1")
+  mkdir -p "$remote_directory"
+}
+
+setup_plugin_for_restore() {
+  local remote_directory=$(get_remote_directory "$1")
+  test -d "$remote_directory"
+}
+
+cleanup_plugin_for_backup() {
+  :
+}
+
+cleanup_plugin_for_restore() {
+  :
+}
+
+backup_file() {
+  local remote_directory=$(get_remote_directory "$1")
+  cp "$2" "$remote_directory/$(basename "$2")"
+}
+
+restore_file() {
+  local remote_directory=$(get_remote_directory "$1")
+  cp "$remote_directory/$(basename "$2")" "$2"
+}
+
+backup_data() {
+  local remote_directory=$(get_remote_directory "$1")
+  cat - > "$remote_directory/$(basename "$2")"
+}
+
+restore_data() {
+  local remote_directory=$(get_remote_directory "$1")
+  cat "$remote_directory/$(basename "$2")"
+}
+
+case "$1" in
+  setup_plugin_for_backup|setup_plugin_for_restore|cleanup_plugin_for_backup|cleanup_plugin_for_restore|backup_file|restore_file|backup_data|restore_data)
+    command=$1
+    shift
+    $command "$@"
+    ;;
+  *)
+    echo "Unknown plugin command $1" >&2
+    exit 1
+    ;;
+esac
@@ -6,34 +6,208 @@ package restore
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/greenplum-db/gpbackup/utils"
 )
 
-var (
-	tableDelim = ","
-)
+var tablesRestoredLock sync.Mutex
 
-func CopyTableIn(connection *utils.DBConn, tableName string, tableAttributes string, backupFile string, singleDataFile bool, oid uint32, whichConn int) {
+func CopyTableIn(connection *utils.DBConn, tableName string, tableAttributes string, backupFile string, singleDataFile bool, oid uint32, whichConn int) int64 {
 	whichConn = connection.ValidateConnNum(whichConn)
 	usingCompression, compressionProgram := utils.GetCompressionParameters()
-	tocFile := globalCluster.GetSegmentTOCFilePath("<SEG_DATA_DIR>", "<SEGID>")
-	helperCommand := fmt.Sprintf("$GPHOME/bin/gpbackup_helper --restore --toc-file=%s --oid=%d --content=<SEGID>", tocFile, oid)
+	usingEncryption, encryptionProgram := utils.GetEncryptionParameters()
 	copyCommand := ""
-	// Error code returned for broken pipe
-	const SIGPIPE = "141"
-	if singleDataFile && usingCompression {
-		copyCommand = fmt.Sprintf(`PROGRAM 'set -o pipefail; %s %s | %s || test $? -eq %s'`, compressionProgram.DecompressCommand, backupFile, helperCommand, SIGPIPE)
-	} else if usingCompression {
-		copyCommand = fmt.Sprintf("PROGRAM '%s < %s'", compressionProgram.DecompressCommand, backupFile)
-	} else if singleDataFile {
-		copyCommand = fmt.Sprintf("PROGRAM '%s < %s'", helperCommand, backupFile)
+	if singleDataFile {
+		// The helper agent on each segment reads the table's data from the data file, decrypting and decompressing it as needed
+		copyCommand = fmt.Sprintf("PROGRAM '%s'", utils.ConstructHelperAgentCopyProgram(backupFile, oid, true))
 	} else {
-		copyCommand = fmt.Sprintf("'%s'", backupFile)
+		commands := make([]string, 0)
+		if pluginConfig != nil {
+			commands = append(commands, pluginConfig.GetRestoreDataCommand(backupFile))
+		}
+		if usingEncryption {
+			commands = append(commands, encryptionProgram.DecryptCommand)
+		}
+		if usingCompression {
+			decompressCommand := compressionProgram.DecompressCommand
+			// The helper reads the data file itself when nothing precedes it
+			if !usingEncryption && pluginConfig == nil {
+				decompressCommand += fmt.Sprintf(" --data-file=%s", backupFile)
+			}
+			commands = append(commands, decompressCommand)
+		}
+		if len(commands) == 0 {
+			copyCommand = fmt.Sprintf("'%s'", backupFile)
+		} else {
+			if usingEncryption && pluginConfig == nil {
+				commands[0] = fmt.Sprintf("%s < %s", commands[0], backupFile)
+			}
+			copyCommand = fmt.Sprintf("PROGRAM '%s'", utils.ConstructPipeline(commands))
+		}
 	}
-	query := fmt.Sprintf("COPY %s%s FROM %s WITH CSV DELIMITER '%s' ON SEGMENT;", tableName, tableAttributes, copyCommand, tableDelim)
-	_, err := connection.Exec(query, whichConn)
+	query := fmt.Sprintf("COPY %s%s FROM %s WITH %s ON SEGMENT;", tableName, tableAttributes, copyCommand, utils.GetDataFormat().CopyOptions())
+	result, err := connection.Exec(query, whichConn)
 	if err != nil {
 		logger.Fatal(err, "Error loading data into table %s", tableName)
 	}
+	numRows, _ := result.RowsAffected()
+	return numRows
+}
+
+// Tables are restored in parallel, so they are recorded for the report in the order in which they finish
+func RecordTableDataRestored(entry utils.MasterDataEntry, tableName string, numRows int64) {
+	tablesRestoredLock.Lock()
+	defer tablesRestoredLock.Unlock()
+	tablesRestored = append(tablesRestored, utils.TableReport{
+		Name:      tableName,
+		Rows:      numRows,
+		Predicate: entry.Predicate,
+		External:  entry.IsExternal,
+	})
+}
+
+/*
+ * Tables backed up with a predicate only have the rows matching that predicate
+ * in the backup, so the restored tables will not be complete copies.
+ */
+func WarnAboutPartialTableData(dataEntries []utils.MasterDataEntry) {
+	numPartial := 0
+	for _, entry := range dataEntries {
+		if entry.Predicate != "" {
+			logger.Verbose("Data for table %s was backed up with predicate WHERE %s", utils.MakeFQN(entry.Schema, entry.Name), entry.Predicate)
+			numPartial++
+		}
+	}
+	if numPartial > 0 {
+		logger.Warn("Data for %d tables was backed up with a predicate, so only a subset of the rows of those tables will be restored", numPartial)
+	}
+}
+
+/*
+ * Each line of the external data target file contains the fully-qualified name
+ * of an external table in the backup followed by the fully-qualified name of
+ * the table into which its data should be restored.
+ */
+func ParseExternalDataTargetLines(lines []string) map[string]string {
+	targets := make(map[string]string, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		source, target := utils.SplitAtWhitespaceOutsideQuotes(line)
+		if target == "" {
+			logger.Fatal(utils.NewFlagError("Invalid line in external data target file: %s.  Each line must contain the fully-qualified name of an external table followed by the fully-qualified name of the table into which its data will be restored.", line), "")
+		}
+		utils.ValidateFQNs([]string{source, target})
+		if _, ok := targets[source]; ok {
+			logger.Fatal(utils.NewFlagError("Table %s has more than one target in the external data target file", source), "")
+		}
+		targets[source] = target
+	}
+	return targets
+}
+
+/*
+ * Unless a target is specified, the data for an external table is restored
+ * into a new table in the same schema, named for the external table with a
+ * "_data" suffix.
+ */
+func GetDefaultExternalDataTarget(entry utils.MasterDataEntry) string {
+	name := entry.Name
+	if strings.HasSuffix(name, `"`) {
+		name = fmt.Sprintf(`%s_data"`, name[:len(name)-1])
+	} else {
+		name = fmt.Sprintf("%s_data", name)
+	}
+	return utils.MakeFQN(entry.Schema, name)
+}
+
+/*
+ * The data backed up from external tables is only restored if requested, as
+ * external tables cannot be loaded directly and the data must be restored into
+ * separate tables.
+ */
+func FilterExternalDataEntries(dataEntries []utils.MasterDataEntry) []utils.MasterDataEntry {
+	if *includeExternalData {
+		return dataEntries
+	}
+	filteredEntries := make([]utils.MasterDataEntry, 0, len(dataEntries))
+	numExternal := 0
+	for _, entry := range dataEntries {
+		if entry.IsExternal {
+			logger.Verbose("Skipping data for external table %s", utils.MakeFQN(entry.Schema, entry.Name))
+			numExternal++
+			continue
+		}
+		filteredEntries = append(filteredEntries, entry)
+	}
+	if numExternal > 0 {
+		logger.Warn("Skipping data for %d external tables.  Use --include-external-data to restore it.", numExternal)
+	}
+	return filteredEntries
+}
+
+/*
+ * The rows of an external table are backed up from whichever segments scanned
+ * them and restored ON SEGMENT to the same segments, so they can only be
+ * restored into a randomly distributed table.  This returns the given tables
+ * that are distributed in any other way.
+ */
+func GetNonRandomlyDistributedTables(tables []string) []string {
+	nonRandomCondition := "p.attrnums IS NOT NULL"
+	if connection.Version.AtLeast("6") {
+		nonRandomCondition = "pg_get_table_distributedby(c.oid) != 'DISTRIBUTED RANDOMLY'"
+	}
+	query := fmt.Sprintf(`
+SELECT
+	quote_ident(n.nspname) || '.' || quote_ident(c.relname) AS string
+FROM gp_distribution_policy p
+JOIN pg_class c ON p.localoid = c.oid
+JOIN pg_namespace n ON c.relnamespace = n.oid
+WHERE quote_ident(n.nspname) || '.' || quote_ident(c.relname) IN (%s)
+AND %s
+ORDER BY string`, utils.SliceToQuotedString(tables), nonRandomCondition)
+	return utils.SelectStringSlice(connection, query)
+}
+
+/*
+ * Each external table without a target in the external data target file gets
+ * a heap table with the same columns, which must not already exist.  Tables
+ * listed in the target file must already exist in the restore database and be
+ * randomly distributed.
+ */
+func CreateExternalDataTargets(dataEntries []utils.MasterDataEntry) {
+	namedTargets := make([]string, 0)
+	for _, entry := range dataEntries {
+		if target, ok := externalDataTargets[utils.MakeFQN(entry.Schema, entry.Name)]; ok && entry.IsExternal {
+			namedTargets = append(namedTargets, target)
+		}
+	}
+	if len(namedTargets) > 0 {
+		sort.Strings(namedTargets)
+		if nonRandomTargets := GetNonRandomlyDistributedTables(namedTargets); len(nonRandomTargets) > 0 {
+			logger.Fatal(utils.NewFlagError("Table %s in the external data target file is not distributed randomly.  The data of external tables can only be restored into randomly distributed tables.", nonRandomTargets[0]), "")
+		}
+	}
+	for _, entry := range dataEntries {
+		if !entry.IsExternal {
+			continue
+		}
+		source := utils.MakeFQN(entry.Schema, entry.Name)
+		if target, ok := externalDataTargets[source]; ok {
+			logger.Verbose("Restoring data for external table %s into table %s", source, target)
+			continue
+		}
+		target := GetDefaultExternalDataTarget(entry)
+		logger.Verbose("Creating table %s for the data of external table %s", target, source)
+		_, err := connection.Exec(fmt.Sprintf("CREATE TABLE %s (LIKE %s) DISTRIBUTED RANDOMLY;", target, source))
+		if err != nil {
+			logger.Fatal(err, "Unable to create table %s for the data of external table %s.  Use --external-data-target-file to restore its data into another table.", target, source)
+		}
+		externalDataTargets[source] = target
+	}
 }
@@ -1,12 +1,15 @@
 package restore_test
 
 import (
+	"errors"
 	"regexp"
 
 	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
 
@@ -14,7 +17,16 @@ var _ = Describe("restore/data tests", func() {
 	Describe("CopyTableIn", func() {
 		It("will restore a table from its own file with compression", func() {
 			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", CompressCommand: "gzip -c -1", DecompressCommand: "gzip -d -c", Extension: ".gz"})
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'gzip -d -c < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT;")
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'gzip -d -c --data-file=<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+			restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, false, 3456, 0)
+		})
+		It("will restore a table from its own file with compression and encryption", func() {
+			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", CompressCommand: "gzip -c -1", DecompressCommand: "gzip -d -c", Extension: ".gz"})
+			utils.SetEncryptionParameters(true, utils.Encryption{DecryptCommand: "openssl enc -d"})
+			defer utils.SetEncryptionParameters(false, utils.Encryption{})
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'set -o pipefail; openssl enc -d < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
 			restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, false, 3456, 0)
@@ -26,19 +38,136 @@ var _ = Describe("restore/data tests", func() {
 			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
 			restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, false, 3456, 0)
 		})
-		It("will restore a table from a single data file with compression", func() {
+		It("returns the number of rows restored", func() {
+			utils.SetCompressionParameters(false, utils.Compression{})
+			mock.ExpectExec("COPY public.foo(.*)").WillReturnResult(sqlmock.NewResult(0, 42))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			Expect(restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, false, 3456, 0)).To(Equal(int64(42)))
+		})
+		It("will restore a table from a single data file", func() {
+			utils.SetCompressionParameters(false, utils.Compression{})
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status && mkfifo <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && { (while test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status; do sleep 1; done; test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && dd if=/dev/null of=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 oflag=nonblock conv=nocreat) < /dev/null > /dev/null 2>&1 & } && echo 2 1<> <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_control && cat <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && rm -f <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			restore.CopyTableIn(connection, "public.foo", "(i,j)", "<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe", true, 2, 0)
+		})
+		It("leaves decryption and decompression of a single data file to the helper agent", func() {
 			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", CompressCommand: "gzip -c -1", DecompressCommand: "gzip -d -c", Extension: ".gz"})
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'set -o pipefail; gzip -d -c <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101.gz | $GPHOME/bin/gpbackup_helper --restore --toc-file=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_toc.yaml --oid=2 --content=<SEGID> || test $? -eq 141' WITH CSV DELIMITER ',' ON SEGMENT;")
+			utils.SetEncryptionParameters(true, utils.Encryption{DecryptCommand: "gpg --decrypt"})
+			defer utils.SetEncryptionParameters(false, utils.Encryption{})
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status && mkfifo <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && { (while test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status; do sleep 1; done; test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && dd if=/dev/null of=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 oflag=nonblock conv=nocreat) < /dev/null > /dev/null 2>&1 & } && echo 2 1<> <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_control && cat <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && rm -f <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_2_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			restore.CopyTableIn(connection, "public.foo", "(i,j)", "<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe", true, 2, 0)
+		})
+		It("will restore a table from a plugin with compression", func() {
+			restore.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", ConfigPath: "/tmp/20170101010101_plugin_config.yaml"})
+			defer restore.SetPluginConfig(nil)
+			utils.SetCompressionParameters(true, utils.Compression{Name: "gzip", CompressCommand: "gzip -c -1", DecompressCommand: "gzip -d -c", Extension: ".gz"})
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM 'set -o pipefail; /tmp/plugin.sh restore_data /tmp/20170101010101_plugin_config.yaml <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz | gzip -d -c' WITH CSV DELIMITER ',' ON SEGMENT;")
+			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456.gz"
+			restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, false, 3456, 0)
+		})
+		It("will restore a table backed up in the text format", func() {
+			utils.SetDataFormat(utils.NewDataFormat("text", "|", "NULL"))
+			defer utils.SetDataFormat(utils.NewDataFormat("", "", ""))
+			utils.SetCompressionParameters(false, utils.Compression{})
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM '<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH DELIMITER E'|' NULL E'NULL' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
-			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101.gz"
-			restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, true, 2, 0)
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, false, 3456, 0)
 		})
-		It("will restore a table from a single data file without compression", func() {
+		It("will restore a table from a plugin without compression", func() {
+			restore.SetPluginConfig(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", ConfigPath: "/tmp/20170101010101_plugin_config.yaml"})
+			defer restore.SetPluginConfig(nil)
 			utils.SetCompressionParameters(false, utils.Compression{})
-			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM '$GPHOME/bin/gpbackup_helper --restore --toc-file=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_toc.yaml --oid=2 --content=<SEGID> < <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101' WITH CSV DELIMITER ',' ON SEGMENT;")
+			execStr := regexp.QuoteMeta("COPY public.foo(i,j) FROM PROGRAM '/tmp/plugin.sh restore_data /tmp/20170101010101_plugin_config.yaml <SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456' WITH CSV DELIMITER ',' ON SEGMENT;")
 			mock.ExpectExec(execStr).WillReturnResult(sqlmock.NewResult(10, 0))
-			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101"
-			restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, true, 2, 0)
+			filename := "<SEG_DATA_DIR>/backups/20170101/20170101010101/gpbackup_<SEGID>_20170101010101_3456"
+			restore.CopyTableIn(connection, "public.foo", "(i,j)", filename, false, 3456, 0)
+		})
+	})
+	Describe("ParseExternalDataTargetLines", func() {
+		It("parses lines containing a source and target table", func() {
+			targets := restore.ParseExternalDataTargetLines([]string{
+				"public.ext public.ext_copy",
+				`"My Schema"."ext table"   public."ext table copy"  `,
+				"",
+			})
+			Expect(targets).To(Equal(map[string]string{
+				"public.ext":              "public.ext_copy",
+				`"My Schema"."ext table"`: `public."ext table copy"`,
+			}))
+		})
+		It("panics if a line does not contain a target table", func() {
+			defer testutils.ShouldPanicWithMessage("Invalid line in external data target file: public.ext.")
+			restore.ParseExternalDataTargetLines([]string{"public.ext"})
+		})
+		It("panics if a table has more than one target", func() {
+			defer testutils.ShouldPanicWithMessage("Table public.ext has more than one target in the external data target file")
+			restore.ParseExternalDataTargetLines([]string{"public.ext public.a", "public.ext public.b"})
+		})
+	})
+	Describe("GetDefaultExternalDataTarget", func() {
+		It("adds a suffix to an unquoted table name", func() {
+			entry := utils.MasterDataEntry{Schema: "public", Name: "ext"}
+			Expect(restore.GetDefaultExternalDataTarget(entry)).To(Equal("public.ext_data"))
+		})
+		It("adds a suffix inside the quotes of a quoted table name", func() {
+			entry := utils.MasterDataEntry{Schema: `"My Schema"`, Name: `"Ext Table"`}
+			Expect(restore.GetDefaultExternalDataTarget(entry)).To(Equal(`"My Schema"."Ext Table_data"`))
+		})
+	})
+	Describe("FilterExternalDataEntries", func() {
+		entries := []utils.MasterDataEntry{{Schema: "public", Name: "foo"}, {Schema: "public", Name: "ext", IsExternal: true}}
+		It("removes external table entries if external data is not included", func() {
+			restore.SetIncludeExternalData(false)
+			Expect(restore.FilterExternalDataEntries(entries)).To(Equal(entries[:1]))
+		})
+		It("keeps external table entries if external data is included", func() {
+			restore.SetIncludeExternalData(true)
+			defer restore.SetIncludeExternalData(false)
+			Expect(restore.FilterExternalDataEntries(entries)).To(Equal(entries))
+		})
+	})
+	Describe("CreateExternalDataTargets", func() {
+		entries := []utils.MasterDataEntry{
+			{Schema: "public", Name: "foo"},
+			{Schema: "public", Name: "ext", IsExternal: true},
+			{Schema: "public", Name: "ext2", IsExternal: true},
+		}
+		BeforeEach(func() {
+			restore.SetConnection(connection)
+		})
+		AfterEach(func() {
+			restore.SetExternalDataTargets(nil)
+		})
+		It("creates a table for each external table without a specified target", func() {
+			targets := map[string]string{"public.ext2": "public.ext2_copy"}
+			restore.SetExternalDataTargets(targets)
+			mock.ExpectQuery(regexp.QuoteMeta("IN ('public.ext2_copy')\nAND p.attrnums IS NOT NULL")).WillReturnRows(sqlmock.NewRows([]string{"string"}))
+			mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE public.ext_data (LIKE public.ext) DISTRIBUTED RANDOMLY;")).WillReturnResult(sqlmock.NewResult(0, 0))
+			restore.CreateExternalDataTargets(entries)
+			Expect(targets).To(Equal(map[string]string{"public.ext": "public.ext_data", "public.ext2": "public.ext2_copy"}))
+		})
+		It("checks the distribution of specified targets with the GPDB 6 catalog", func() {
+			testutils.SetDBVersion(connection, "6.0.0")
+			defer testutils.SetDBVersion(connection, "5.1.0")
+			restore.SetExternalDataTargets(map[string]string{"public.ext": "public.ext_copy", "public.ext2": "public.ext2_copy"})
+			mock.ExpectQuery(regexp.QuoteMeta("IN ('public.ext2_copy','public.ext_copy')\nAND pg_get_table_distributedby(c.oid) != 'DISTRIBUTED RANDOMLY'")).WillReturnRows(sqlmock.NewRows([]string{"string"}))
+			restore.CreateExternalDataTargets(entries)
+		})
+		It("panics if a specified target is not distributed randomly", func() {
+			restore.SetExternalDataTargets(map[string]string{"public.ext2": "public.ext2_copy"})
+			mock.ExpectQuery(regexp.QuoteMeta("AND p.attrnums IS NOT NULL")).WillReturnRows(sqlmock.NewRows([]string{"string"}).AddRow("public.ext2_copy"))
+			defer testutils.ShouldPanicWithMessage("Table public.ext2_copy in the external data target file is not distributed randomly.")
+			restore.CreateExternalDataTargets(entries)
+		})
+		It("panics if a table cannot be created", func() {
+			restore.SetExternalDataTargets(map[string]string{"public.ext2": "public.ext2_copy"})
+			mock.ExpectQuery(regexp.QuoteMeta("AND p.attrnums IS NOT NULL")).WillReturnRows(sqlmock.NewRows([]string{"string"}))
+			mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE public.ext_data (LIKE public.ext) DISTRIBUTED RANDOMLY;")).WillReturnError(errors.New(`relation "ext_data" already exists`))
+			defer testutils.ShouldPanicWithMessage(`relation "ext_data" already exists`)
+			restore.CreateExternalDataTargets(entries)
 		})
 	})
 })
@@ -1,6 +1,10 @@
 package restore
 
-import "github.com/greenplum-db/gpbackup/utils"
+import (
+	"time"
+
+	"github.com/greenplum-db/gpbackup/utils"
+)
 
 /*
  * This file contains global variables and setter functions for those variables
@@ -12,12 +16,20 @@ import "github.com/greenplum-db/gpbackup/utils"
  */
 
 var (
-	backupConfig  *utils.BackupConfig
-	connection    *utils.DBConn
-	globalCluster utils.Cluster
-	globalTOC     *utils.TOC
-	logger        *utils.Logger
-	version       string
+	backupConfig        *utils.BackupConfig
+	connection          *utils.DBConn
+	externalDataTargets map[string]string
+	globalCluster       utils.Cluster
+	globalTOC           *utils.TOC
+	logger              *utils.Logger
+	numStatementErrors  uint32
+	pluginConfig        *utils.PluginConfig
+	restoreStartTime    time.Time
+	tablesRestored      []utils.TableReport
+	version             string
+
+	verifyProblems  []string
+	verifyStartTime time.Time
 )
 
 /*
@@ -25,21 +37,32 @@ var (
  */
 
 var (
-	backupDir        *string
-	createdb         *bool
-	debug            *bool
-	includeSchemas   utils.ArrayFlags
-	includeTableFile *string
-	includeTables    utils.ArrayFlags
-	numJobs          *int
-	onErrorContinue  *bool
-	printVersion     *bool
-	quiet            *bool
-	redirect         *string
-	restoreGlobals   *bool
-	timestamp        *string
-	verbose          *bool
-	withStats        *bool
+	backupDir                 *string
+	createdb                  *bool
+	debug                     *bool
+	diskSpaceWarningThreshold *int
+	encryptionKeyCommand      *string
+	encryptionKeyFile         *string
+	externalDataTargetFile    *string
+	includeExternalData       *bool
+	includeSchemaPatterns     utils.ArrayFlags
+	includeSchemas            utils.ArrayFlags
+	includeTableFile          *string
+	includeTablePatterns      utils.ArrayFlags
+	includeTables             utils.ArrayFlags
+	nativeSSH                 *bool
+	noDiskSpaceCheck          *bool
+	numJobs                   *int
+	onErrorContinue           *bool
+	pluginConfigFile          *string
+	printVersion              *bool
+	quiet                     *bool
+	redirect                  *string
+	restoreGlobals            *bool
+	timestamp                 *string
+	verbose                   *bool
+	verify                    *bool
+	withStats                 *bool
 )
 
 /*
@@ -50,6 +73,10 @@ func SetBackupConfig(config *utils.BackupConfig) {
 	backupConfig = config
 }
 
+func SetDiskSpaceWarningThreshold(threshold int) {
+	diskSpaceWarningThreshold = &threshold
+}
+
 func SetConnection(conn *utils.DBConn) {
 	connection = conn
 }
@@ -58,6 +85,10 @@ func SetCluster(cluster utils.Cluster) {
 	globalCluster = cluster
 }
 
+func SetPluginConfig(config *utils.PluginConfig) {
+	pluginConfig = config
+}
+
 func SetLogger(log *utils.Logger) {
 	logger = log
 }
@@ -66,6 +97,14 @@ func SetOnErrorContinue(errContinue bool) {
 	onErrorContinue = &errContinue
 }
 
+func SetIncludeExternalData(which bool) {
+	includeExternalData = &which
+}
+
+func SetExternalDataTargets(targets map[string]string) {
+	externalDataTargets = targets
+}
+
 func SetNumJobs(jobs int) {
 	numJobs = &jobs
 }
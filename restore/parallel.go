@@ -48,20 +48,26 @@ func ExecuteStatements(statements []utils.StatementWithType, objectsTitle string
 	if !executeInParallel {
 		connNum := connection.ValidateConnNum(whichConn...)
 		for _, statement := range statements {
+			utils.CheckCanceled()
 			numErrors += executeStatement(statement, showProgressBar, shouldExecute, connNum)
 			progressBar.Increment()
 		}
 	} else {
 		tasks := make(chan utils.StatementWithType, len(statements))
 		var workerPool sync.WaitGroup
+		var workerPanic utils.WorkerPanic
 		for i := 0; i < connection.NumConns; i++ {
 			workerPool.Add(1)
 			go func(whichConn int) {
+				defer workerPool.Done()
+				defer workerPanic.Recover()
 				for statement := range tasks {
+					if workerPanic.ShouldStop() {
+						return
+					}
 					atomic.AddUint32(&numErrors, executeStatement(statement, showProgressBar, shouldExecute, whichConn))
 					progressBar.Increment()
 				}
-				workerPool.Done()
 			}(i)
 		}
 		for _, statement := range statements {
@@ -75,9 +81,11 @@ func ExecuteStatements(statements []utils.StatementWithType, objectsTitle string
 		}
 		close(tasks)
 		workerPool.Wait()
+		workerPanic.Repanic()
 	}
 	progressBar.Finish()
 	if numErrors > 0 {
+		atomic.AddUint32(&numStatementErrors, numErrors)
 		logger.Error("Encountered %d errors during metadata restore; see log file %s for a list of failed statements.", numErrors, logger.GetLogFilePath())
 	}
 }
@@ -7,10 +7,9 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/greenplum-db/gpbackup/utils"
-
-	"github.com/pkg/errors"
 )
 
 /*
@@ -21,16 +20,27 @@ func initializeFlags() {
 	backupDir = flag.String("backupdir", "", "The absolute path of the directory in which the backup files to be restored are located")
 	createdb = flag.Bool("createdb", false, "Create the database before metadata restore")
 	debug = flag.Bool("debug", false, "Print verbose and debug log messages")
+	diskSpaceWarningThreshold = flag.Int("disk-space-warning-threshold", 90, "Warn if a restore would leave a segment filesystem at least this percent full")
+	encryptionKeyCommand = flag.String("encryption-key-command", "", "A command that writes the key with which the backup was encrypted to stdout")
+	encryptionKeyFile = flag.String("encryption-key-file", "", "A file containing the key with which the backup was encrypted")
+	externalDataTargetFile = flag.String("external-data-target-file", "", "A file in which each line contains the fully-qualified name of an external table followed by the fully-qualified name of an existing, randomly distributed table into which its data will be restored.  Requires --include-external-data.")
+	includeExternalData = flag.Bool("include-external-data", false, "Restore the data backed up from external tables into heap tables")
 	flag.Var(&includeSchemas, "include-schema", "Restore only the specified schema(s). --include-schema can be specified multiple times.")
+	flag.Var(&includeSchemaPatterns, "include-schema-pattern", "Restore only schemas whose names match the specified glob pattern. --include-schema-pattern can be specified multiple times.")
 	includeTableFile = flag.String("include-table-file", "", "A file containing a list of fully-qualified tables to be restored")
+	flag.Var(&includeTablePatterns, "include-table-pattern", "Restore only tables whose fully-qualified names match the specified glob pattern. --include-table-pattern can be specified multiple times.")
 	numJobs = flag.Int("jobs", 1, "Number of parallel connections to use when restoring table data")
+	nativeSSH = flag.Bool("native-ssh", false, "Run commands on the segment hosts over one SSH connection to each host, instead of running ssh for each segment")
+	noDiskSpaceCheck = flag.Bool("no-disk-space-check", false, "Do not check that the segments have enough free disk space for the restore")
 	onErrorContinue = flag.Bool("on-error-continue", false, "Log errors and continue restore, instead of exiting on first error")
+	pluginConfigFile = flag.String("plugin-config", "", "The configuration file to use for a plugin that will retrieve backup files from external storage")
 	printVersion = flag.Bool("version", false, "Print version number and exit")
 	quiet = flag.Bool("quiet", false, "Suppress non-warning, non-error log messages")
 	redirect = flag.String("redirect", "", "Restore to the specified database instead of the database that was backed up")
 	restoreGlobals = flag.Bool("globals", false, "Restore global metadata")
 	timestamp = flag.String("timestamp", "", "The timestamp to be restored, in the format YYYYMMDDHHMMSS")
 	verbose = flag.Bool("verbose", false, "Print verbose log messages")
+	verify = flag.Bool("verify", false, "Verify that the backup can be restored, without restoring it.  Statistics are verified if --with-stats is also specified.")
 	withStats = flag.Bool("with-stats", false, "Restore query plan statistics")
 }
 
@@ -55,19 +65,34 @@ func DoValidation() {
 		os.Exit(0)
 	}
 	ValidateFlagCombinations()
+	utils.ValidateDiskSpaceWarningThreshold(*diskSpaceWarningThreshold)
 	utils.ValidateBackupDir(*backupDir)
+	utils.ValidateFilterPatterns("include-schema-pattern", includeSchemaPatterns)
+	utils.ValidateFilterPatterns("include-table-pattern", includeTablePatterns)
 	if !utils.IsValidTimestamp(*timestamp) {
-		logger.Fatal(errors.Errorf("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", *timestamp), "")
+		logger.Fatal(utils.NewFlagError("Timestamp %s is invalid.  Timestamps must be in the format YYYYMMDDHHMMSS.", *timestamp), "")
 	}
 }
 
 // This function handles setup that must be done after parsing flags.
 func DoSetup() {
 	SetLoggerVerbosity()
+	utils.InitializeSignalHandler(CancelQueriesInProgress, "restore")
+	if *verify {
+		verifyStartTime = time.Now()
+	} else {
+		restoreStartTime = time.Now()
+	}
 	logger.Info("Restore Key = %s", *timestamp)
 
 	InitializeConnection("postgres")
 	DoPostgresValidation()
+	if *verify {
+		return
+	}
+	if !backupConfig.MetadataOnly && !*noDiskSpaceCheck {
+		CheckDiskSpaceForRestore()
+	}
 	metadataFilename := globalCluster.GetMetadataFilePath()
 	if !backupConfig.DataOnly {
 		logger.Verbose("Metadata will be restored from %s", metadataFilename)
@@ -91,6 +116,10 @@ func DoSetup() {
 }
 
 func DoRestore() {
+	if *verify {
+		DoVerify()
+		return
+	}
 	gucStatements := setGUCsForConnection(nil, 0)
 	metadataFilename := globalCluster.GetMetadataFilePath()
 	if !backupConfig.DataOnly {
@@ -98,19 +127,17 @@ func DoRestore() {
 	}
 
 	if !backupConfig.MetadataOnly {
-		backupFileCount := 2 // 1 for the actual data file, 1 for the segment TOC file
-		if !backupConfig.SingleDataFile {
-			backupFileCount = len(globalTOC.DataEntries)
-		}
-		globalCluster.VerifyBackupFileCountOnSegments(backupFileCount)
+		utils.CheckCanceled()
 		restoreData(gucStatements)
 	}
 
 	if !backupConfig.DataOnly && !backupConfig.TableFiltered {
+		utils.CheckCanceled()
 		restorePostdata(metadataFilename)
 	}
 
 	if *withStats && backupConfig.WithStatistics {
+		utils.CheckCanceled()
 		restoreStatistics()
 	}
 }
@@ -144,47 +171,104 @@ func restorePredata(metadataFilename string) {
 	logger.Info("Pre-data metadata restore complete")
 }
 
+/*
+ * The data for an incremental backup may be spread across several backups, so
+ * we restore the data from each backup in the restore plan in turn.
+ */
 func restoreData(gucStatements []utils.StatementWithType) {
-	if backupConfig.SingleDataFile {
-		globalCluster.CopySegmentTOCs()
-		defer globalCluster.CleanUpSegmentTOCs()
-	}
 	logger.Info("Restoring data")
 
-	filteredMasterDataEntries := globalTOC.GetDataEntriesMatching(includeSchemas, includeTables)
-	totalTables := len(filteredMasterDataEntries)
+	restorePlan := GetRestorePlan()
+	planClusters := make([]utils.Cluster, len(restorePlan))
+	planTOCs := make([]*utils.TOC, len(restorePlan))
+	planEntries := make([][]utils.MasterDataEntry, len(restorePlan))
+	totalTables := 0
+	for i, planEntry := range restorePlan {
+		planClusters[i], planTOCs[i] = GetClusterAndTOCForTimestamp(planEntry.Timestamp)
+		planEntries[i] = FilterExternalDataEntries(GetDataEntriesForRestorePlanEntry(planTOCs[i], planEntry))
+		CreateExternalDataTargets(planEntries[i])
+		totalTables += len(planEntries[i])
+		WarnAboutPartialTableData(planEntries[i])
+	}
 	dataProgressBar := utils.NewProgressBar(totalTables, "Tables restored: ", utils.PB_INFO)
 	dataProgressBar.Start()
 
+	var tableNum uint32 = 1
+	for i := range restorePlan {
+		if len(planEntries[i]) == 0 {
+			continue
+		}
+		restoreDataFromTimestamp(planClusters[i], planTOCs[i], planEntries[i], gucStatements, &tableNum, totalTables, dataProgressBar)
+	}
+	dataProgressBar.Finish()
+	logger.Info("Data restore complete")
+}
+
+func restoreDataFromTimestamp(cluster utils.Cluster, toc *utils.TOC, dataEntries []utils.MasterDataEntry, gucStatements []utils.StatementWithType, tableNum *uint32, totalTables int, dataProgressBar utils.ProgressBar) {
+	if cluster.Timestamp != globalCluster.Timestamp {
+		logger.Verbose("Restoring data for %d tables from backup %s", len(dataEntries), cluster.Timestamp)
+	}
+	if backupConfig.SingleDataFile && pluginConfig != nil {
+		pluginConfig.RestoreSegmentTOCsAndDataFiles(cluster)
+	}
+	backupFileCount := 2 // 1 for the actual data file, 1 for the segment TOC file
+	if !backupConfig.SingleDataFile {
+		backupFileCount = len(toc.DataEntries)
+	}
+	// Data files are streamed directly from the plugin, so they are not present on the segments
+	if backupConfig.SingleDataFile || pluginConfig == nil {
+		cluster.VerifyBackupFileCountOnSegments(backupFileCount)
+	}
+	if !backupConfig.SingleDataFile && pluginConfig == nil {
+		cluster.VerifyDataFileChecksums(dataEntries)
+	}
+	if backupConfig.SingleDataFile {
+		cluster.VerifySegmentTOCChecksums(toc.SegmentTOCChecksums)
+		cluster.CopySegmentTOCs()
+		defer cluster.CleanUpSegmentTOCs()
+		cluster.CreateSegmentPipesOnAllHosts()
+		defer cluster.CleanUpSegmentPipesOnAllHosts()
+		cluster.StartRestoreHelperAgents()
+		defer cluster.CleanUpHelperAgents()
+	}
+
 	if connection.NumConns == 1 {
-		for i, entry := range filteredMasterDataEntries {
-			restoreSingleTableData(entry, uint32(i)+1, totalTables, 0)
+		for _, entry := range dataEntries {
+			utils.CheckCanceled()
+			restoreSingleTableData(cluster, entry, atomic.LoadUint32(tableNum), totalTables, 0)
+			atomic.AddUint32(tableNum, 1)
 			dataProgressBar.Increment()
 		}
 	} else {
-		var tableNum uint32 = 1
-		tasks := make(chan utils.MasterDataEntry, totalTables)
+		tasks := make(chan utils.MasterDataEntry, len(dataEntries))
 		var workerPool sync.WaitGroup
+		var workerPanic utils.WorkerPanic
 		for i := 0; i < connection.NumConns; i++ {
 			workerPool.Add(1)
 			go func(whichConn int) {
+				defer workerPool.Done()
+				defer workerPanic.Recover()
 				setGUCsForConnection(gucStatements, whichConn)
 				for entry := range tasks {
-					restoreSingleTableData(entry, tableNum, totalTables, whichConn)
-					atomic.AddUint32(&tableNum, 1)
+					if workerPanic.ShouldStop() {
+						return
+					}
+					restoreSingleTableData(cluster, entry, atomic.LoadUint32(tableNum), totalTables, whichConn)
+					atomic.AddUint32(tableNum, 1)
 					dataProgressBar.Increment()
 				}
-				workerPool.Done()
 			}(i)
 		}
-		for _, entry := range filteredMasterDataEntries {
+		for _, entry := range dataEntries {
 			tasks <- entry
 		}
 		close(tasks)
 		workerPool.Wait()
+		workerPanic.Repanic()
+	}
+	if backupConfig.SingleDataFile {
+		cluster.StopHelperAgents()
 	}
-	dataProgressBar.Finish()
-	logger.Info("Data restore complete")
 }
 
 func restorePostdata(metadataFilename string) {
@@ -203,22 +287,36 @@ func restoreStatistics() {
 }
 
 func DoTeardown() {
-	errStr := ""
-	if err := recover(); err != nil {
-		errStr = fmt.Sprintf("%v", err)
+	err := recover()
+	errMsg, exitCode := utils.ParseErrorMessage(err)
+	if err != nil {
+		hint := ""
 		if connection != nil {
-			if strings.Contains(errStr, fmt.Sprintf(`Database "%s" does not exist`, connection.DBName)) {
-				errStr = fmt.Sprintf(`%s.  Use the --createdb flag to create "%s" as part of the restore process.`, errStr, connection.DBName)
-			} else if strings.Contains(errStr, fmt.Sprintf(`Database "%s" already exists`, connection.DBName)) {
-				errStr = fmt.Sprintf(`%s.  Run gprestore again without the --createdb flag.`, errStr)
+			if strings.Contains(errMsg, fmt.Sprintf(`Database "%s" does not exist`, connection.DBName)) {
+				hint = fmt.Sprintf(`.  Use the --createdb flag to create "%s" as part of the restore process.`, connection.DBName)
+			} else if strings.Contains(errMsg, fmt.Sprintf(`Database "%s" already exists`, connection.DBName)) {
+				hint = `.  Run gprestore again without the --createdb flag.`
 			}
 		}
-		fmt.Println(errStr)
+		errMsg += hint
+		fmt.Printf("%v%s\n", err, hint)
+	} else if numStatementErrors > 0 {
+		exitCode = utils.EXIT_COMPLETED_WITH_ERRORS
 	}
-	_, exitCode := utils.ParseErrorMessage(errStr)
 	if connection != nil {
 		connection.Close()
 	}
+	if *verify && backupConfig != nil {
+		WriteVerifyReport(errMsg, exitCode)
+	} else if backupConfig != nil {
+		WriteRestoreReports(errMsg, exitCode)
+	}
+	if usingEncryption, _ := utils.GetEncryptionParameters(); usingEncryption {
+		utils.CleanUpEncryptionKeyOnAllHosts(globalCluster)
+	}
+	if pluginConfig != nil {
+		pluginConfig.CleanupPluginForRestore(globalCluster)
+	}
 
 	os.Exit(exitCode)
 }
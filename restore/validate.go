@@ -2,10 +2,10 @@ package restore
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/greenplum-db/gpbackup/utils"
-	"github.com/pkg/errors"
 )
 
 /*
@@ -68,7 +68,7 @@ func ValidateFilterSchemasInBackupSet(schemaList utils.ArrayFlags) {
 		keys[i] = k
 		i++
 	}
-	logger.Fatal(errors.Errorf("Could not find the following schema(s) in the backup set: %s", strings.Join(keys, ", ")), "")
+	logger.Fatal(utils.NewFlagError("Could not find the following schema(s) in the backup set: %s", strings.Join(keys, ", ")), "")
 }
 
 func ValidateFilterTablesInRestoreDatabase(connection *utils.DBConn, tableList utils.ArrayFlags) {
@@ -128,13 +128,21 @@ func ValidateFilterTablesInBackupSet(tableList utils.ArrayFlags) {
 		keys[i] = k
 		i++
 	}
-	logger.Fatal(errors.Errorf("Could not find the following table(s) in the backup set: %s", strings.Join(keys, ", ")), "")
+	logger.Fatal(utils.NewFlagError("Could not find the following table(s) in the backup set: %s", strings.Join(keys, ", ")), "")
 }
 
+/*
+ * The helper agent on each segment can only serve several tables at once when
+ * it can read the data for each table directly from the data file, which an
+ * encrypted data file or one compressed as a single stream does not allow.
+ */
 func ValidateBackupFlagCombinations() {
-	if backupConfig.SingleDataFile {
-		if *numJobs != 1 {
-			logger.Fatal(errors.Errorf("Cannot use jobs flag when restoring backups with a single data file per segment."), "")
+	if backupConfig.SingleDataFile && *numJobs != 1 {
+		if backupConfig.Encrypted {
+			logger.Fatal(utils.NewFlagError("Cannot use jobs flag when restoring encrypted backups with a single data file per segment."), "")
+		}
+		if backupConfig.Compressed && !backupConfig.CompressedFrames {
+			logger.Fatal(utils.NewFlagError("Cannot use jobs flag when restoring compressed backups with a single data file per segment taken before each table was compressed separately."), "")
 		}
 	}
 }
@@ -143,4 +151,35 @@ func ValidateFlagCombinations() {
 	utils.CheckMandatoryFlags("timestamp")
 	utils.CheckExclusiveFlags("debug", "quiet", "verbose")
 	utils.CheckExclusiveFlags("include-table-file", "include-schema")
+	utils.CheckExclusiveFlags("include-table-file", "include-schema-pattern")
+	utils.CheckExclusiveFlags("include-table-pattern", "include-schema")
+	utils.CheckExclusiveFlags("include-table-pattern", "include-schema-pattern")
+	utils.CheckExclusiveFlags("encryption-key-command", "encryption-key-file")
+	utils.CheckExclusiveFlags("verify", "createdb")
+	utils.CheckExclusiveFlags("verify", "globals")
+	utils.CheckExclusiveFlags("verify", "redirect")
+	utils.CheckExclusiveFlags("no-disk-space-check", "disk-space-warning-threshold")
+	if *externalDataTargetFile != "" && !*includeExternalData {
+		logger.Fatal(utils.NewFlagError("Cannot use --external-data-target-file without --include-external-data"), "")
+	}
+}
+
+// Every table in the external data target file must be an external table whose data is in the backup
+func ValidateExternalDataTargets(dataEntries []utils.MasterDataEntry) {
+	externalTables := make(map[string]bool, 0)
+	for _, entry := range dataEntries {
+		if entry.IsExternal {
+			externalTables[utils.MakeFQN(entry.Schema, entry.Name)] = true
+		}
+	}
+	sources := make([]string, 0, len(externalDataTargets))
+	for source := range externalDataTargets {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		if !externalTables[source] {
+			logger.Fatal(utils.NewFlagError("Table %s in the external data target file is not an external table whose data is in the backup", source), "")
+		}
+	}
 }
@@ -7,6 +7,7 @@ import (
 	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
 )
 
 var _ = Describe("restore/validate tests", func() {
@@ -162,4 +163,72 @@ var _ = Describe("restore/validate tests", func() {
 			restore.ValidateFilterTablesInBackupSet(filterList)
 		})
 	})
+	Describe("GetSchemasAndTablesInBackupSet", func() {
+		var toc *utils.TOC
+		var backupfile *utils.FileWithByteCount
+		BeforeEach(func() {
+			toc, backupfile = testutils.InitializeTestTOC(buffer, "predata")
+			toc.AddPredataEntry("schema1", "schema1", "SCHEMA", 0, backupfile)
+			toc.AddPredataEntry("schema1", "table1", "TABLE", 0, backupfile)
+			toc.AddPredataEntry("schema1", "somesequence", "SEQUENCE", 0, backupfile)
+			toc.AddPredataEntry("schema2", "table2", "TABLE", 0, backupfile)
+			toc.AddPredataEntry("", "plpgsql", "PROCEDURAL LANGUAGE", 0, backupfile)
+			toc.AddMasterDataEntry("schema2", "table2", 2, "(j)")
+			restore.SetTOC(toc)
+		})
+		It("returns the schemas and tables with metadata in a normal backup", func() {
+			restore.SetBackupConfig(&utils.BackupConfig{})
+			schemas, tables := restore.GetSchemasAndTablesInBackupSet()
+			Expect(schemas).To(Equal([]string{"schema1", "schema2"}))
+			Expect(tables).To(Equal([]string{"schema1.table1", "schema2.table2"}))
+		})
+		It("returns the schemas and tables with data in a data-only backup", func() {
+			restore.SetBackupConfig(&utils.BackupConfig{DataOnly: true})
+			schemas, tables := restore.GetSchemasAndTablesInBackupSet()
+			Expect(schemas).To(Equal([]string{"schema2"}))
+			Expect(tables).To(Equal([]string{"schema2.table2"}))
+		})
+	})
+	Describe("ValidateBackupFlagCombinations", func() {
+		AfterEach(func() {
+			restore.SetNumJobs(1)
+		})
+		It("allows jobs for an uncompressed backup with a single data file", func() {
+			restore.SetBackupConfig(&utils.BackupConfig{SingleDataFile: true})
+			restore.SetNumJobs(4)
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("allows jobs for a backup with a single data file whose tables were compressed separately", func() {
+			restore.SetBackupConfig(&utils.BackupConfig{SingleDataFile: true, Compressed: true, CompressionType: "gzip", CompressedFrames: true})
+			restore.SetNumJobs(4)
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("panics with jobs for an encrypted backup with a single data file", func() {
+			restore.SetBackupConfig(&utils.BackupConfig{SingleDataFile: true, Encrypted: true})
+			restore.SetNumJobs(4)
+			defer testutils.ShouldPanicWithMessage("Cannot use jobs flag when restoring encrypted backups with a single data file per segment.")
+			restore.ValidateBackupFlagCombinations()
+		})
+		It("panics with jobs for a backup with a single data file compressed as a single stream", func() {
+			restore.SetBackupConfig(&utils.BackupConfig{SingleDataFile: true, Compressed: true, CompressionType: "gzip"})
+			restore.SetNumJobs(4)
+			defer testutils.ShouldPanicWithMessage("Cannot use jobs flag when restoring compressed backups with a single data file per segment taken before each table was compressed separately.")
+			restore.ValidateBackupFlagCombinations()
+		})
+	})
+	Describe("ValidateExternalDataTargets", func() {
+		entries := []utils.MasterDataEntry{{Schema: "public", Name: "foo"}, {Schema: "public", Name: "ext", IsExternal: true}}
+		AfterEach(func() {
+			restore.SetExternalDataTargets(nil)
+		})
+		It("does not panic if every source table is an external table in the backup", func() {
+			restore.SetExternalDataTargets(map[string]string{"public.ext": "public.ext_copy"})
+			restore.ValidateExternalDataTargets(entries)
+		})
+		It("panics if a source table is not an external table in the backup", func() {
+			restore.SetExternalDataTargets(map[string]string{"public.ext": "public.ext_copy", "public.foo": "public.foo_copy"})
+			defer testutils.ShouldPanicWithMessage("Table public.foo in the external data target file is not an external table whose data is in the backup")
+			restore.ValidateExternalDataTargets(entries)
+		})
+	})
 })
@@ -0,0 +1,200 @@
+package restore
+
+/*
+ * This file contains functions related to verifying that a backup can be
+ * restored, without restoring anything.
+ */
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Problems are collected rather than treated as fatal, so that a single run
+ * reports every problem with the backup.  By the time this is called, the
+ * config, TOC, and metadata files have been read and their checksums verified.
+ */
+func DoVerify() {
+	logger.Info("Verifying backup %s", globalCluster.Timestamp)
+	verifyProblems = make([]string, 0)
+	if !backupConfig.DataOnly {
+		verifyMetadataFile(globalCluster.GetMetadataFilePath(), "global", "predata", "postdata")
+	}
+	if *withStats && backupConfig.WithStatistics {
+		verifyMetadataFile(globalCluster.GetStatisticsFilePath(), "statistics")
+	}
+	if !backupConfig.MetadataOnly {
+		verifyData()
+	}
+	if len(verifyProblems) > 0 {
+		for _, problem := range verifyProblems {
+			logger.Error(problem)
+		}
+		logger.Fatal(errors.Errorf("Found %d problem(s) with backup %s. See %s for details.", len(verifyProblems), globalCluster.Timestamp, globalCluster.GetVerifyReportFilePath(verifyStartTime.Format("20060102150405"))), "")
+	}
+	logger.Info("Backup %s verified successfully", globalCluster.Timestamp)
+}
+
+func verifyMetadataFile(filename string, sections ...string) {
+	logger.Verbose("Verifying table of contents entries for %s", filename)
+	contents := utils.MustReadBackupFile(filename)
+	for _, section := range sections {
+		verifyProblems = append(verifyProblems, globalTOC.VerifyMetadataEntries(section, contents)...)
+	}
+}
+
+func verifyData() {
+	for _, planEntry := range GetRestorePlan() {
+		cluster, toc := GetClusterAndTOCForTimestamp(planEntry.Timestamp)
+		dataEntries := GetDataEntriesForRestorePlanEntry(toc, planEntry)
+		if len(dataEntries) == 0 {
+			continue
+		}
+		logger.Verbose("Verifying data files for %d tables from backup %s", len(dataEntries), cluster.Timestamp)
+		if backupConfig.SingleDataFile {
+			if pluginConfig != nil {
+				pluginConfig.RestoreSegmentTOCsAndDataFiles(cluster)
+			}
+			for contentID, output := range cluster.CheckSegmentTOCChecksums(toc.SegmentTOCChecksums) {
+				verifyProblems = append(verifyProblems, fmt.Sprintf("Segment %d: Checksum verification failed for backup %s:\n%s", contentID, cluster.Timestamp, output))
+			}
+			VerifySingleDataFiles(cluster, dataEntries)
+		} else {
+			VerifyTableDataFiles(cluster, dataEntries)
+			if pluginConfig == nil {
+				for contentID, output := range cluster.CheckDataFileChecksums(dataEntries) {
+					verifyProblems = append(verifyProblems, fmt.Sprintf("Segment %d: Checksum verification failed for backup %s:\n%s", contentID, cluster.Timestamp, output))
+				}
+			}
+		}
+	}
+}
+
+/*
+ * This returns a shell command that reads the given data file, from the plugin
+ * if one is in use, and writes its decrypted and decompressed contents to stdout.
+ */
+func getReadDataFileCommand(backupFile string, readFromPlugin bool) string {
+	usingCompression, compressionProgram := utils.GetCompressionParameters()
+	usingEncryption, encryptionProgram := utils.GetEncryptionParameters()
+	commands := make([]string, 0)
+	if readFromPlugin {
+		commands = append(commands, pluginConfig.GetRestoreDataCommand(backupFile))
+	} else {
+		commands = append(commands, fmt.Sprintf("cat %s", backupFile))
+	}
+	if usingEncryption {
+		commands = append(commands, encryptionProgram.DecryptCommand)
+	}
+	if usingCompression {
+		commands = append(commands, compressionProgram.DecompressCommand)
+	}
+	return utils.ConstructPipeline(commands)
+}
+
+/*
+ * Each segment reads every data file it should have in full, so that missing,
+ * truncated, and corrupted files are all detected, and prints the name of
+ * each file it cannot read.  The names of the files are read from a list
+ * copied to each host, since they may not all fit on one command line.
+ */
+func VerifyTableDataFiles(cluster utils.Cluster, dataEntries []utils.MasterDataEntry) {
+	fileLists := make(map[int][]string, len(cluster.ContentIDs))
+	for _, contentID := range cluster.ContentIDs {
+		if contentID == -1 {
+			continue
+		}
+		for _, entry := range dataEntries {
+			fileLists[contentID] = append(fileLists[contentID], cluster.GetTableBackupFilePath(contentID, entry.Oid, false))
+		}
+	}
+	listFile := cluster.CopySegmentListsToAllHosts("Copying lists of data files to all hosts", "data_files", fileLists)
+	defer cluster.CleanUpSegmentListOnAllHosts(listFile)
+	readCommand := getReadDataFileCommand(`"$backupFile"`, pluginConfig != nil)
+	remoteOutput := cluster.GenerateAndExecuteCommand("Verifying data files on all segments", func(contentID int) string {
+		return fmt.Sprintf(`%s | while read -r backupFile; do (%s) < /dev/null > /dev/null 2>&1 || echo "$backupFile"; done`, utils.GetSegmentListCommand(listFile, contentID), readCommand)
+	})
+	for contentID, stdout := range remoteOutput.Stdouts {
+		for _, backupFile := range strings.Fields(stdout) {
+			verifyProblems = append(verifyProblems, fmt.Sprintf("Segment %d: Data file %s is missing or cannot be read", contentID, backupFile))
+		}
+	}
+	for contentID, err := range remoteOutput.Errors {
+		if err != nil {
+			verifyProblems = append(verifyProblems, fmt.Sprintf("Segment %d: Unable to verify data files: %s", contentID, strings.TrimSpace(remoteOutput.Stderrs[contentID])))
+		}
+	}
+}
+
+var helperResultPattern = regexp.MustCompile(`^(OK|FAILED) ?(\d*):? ?(.*)$`)
+
+/*
+ * gpbackup_helper reads the whole data file for each segment and checks every
+ * byte range in the segment TOC against it; see helper.VerifyDataAgainstTOC.
+ */
+func VerifySingleDataFiles(cluster utils.Cluster, dataEntries []utils.MasterDataEntry) {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Verifying single data files on all segments", func(contentID int) string {
+		backupFile := cluster.GetTableBackupFilePath(contentID, 0, true)
+		tocFile := cluster.GetSegmentTOCFilePath(cluster.GetDirForContent(contentID), fmt.Sprintf("%d", contentID))
+		return utils.ConstructPipeline([]string{getReadDataFileCommand(backupFile, false), fmt.Sprintf("$GPHOME/bin/gpbackup_helper --verify --toc-file=%s --content=%d", tocFile, contentID)})
+	})
+	tableNames := make(map[uint32]string, len(dataEntries))
+	for _, entry := range dataEntries {
+		tableNames[entry.Oid] = utils.MakeFQN(entry.Schema, entry.Name)
+	}
+	for contentID, stdout := range remoteOutput.Stdouts {
+		if remoteOutput.Errors[contentID] != nil {
+			verifyProblems = append(verifyProblems, fmt.Sprintf("Segment %d: Unable to read data file %s: %s", contentID, cluster.GetTableBackupFilePath(contentID, 0, true), strings.TrimSpace(remoteOutput.Stderrs[contentID])))
+			continue
+		}
+		verifyProblems = append(verifyProblems, ParseSingleDataFileVerifyOutput(contentID, stdout, tableNames)...)
+	}
+}
+
+func ParseSingleDataFileVerifyOutput(contentID int, output string, tableNames map[uint32]string) []string {
+	problems := make([]string, 0)
+	found := make(map[uint32]bool, len(tableNames))
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		matches := helperResultPattern.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		if matches[2] == "" {
+			if matches[1] == "FAILED" {
+				problems = append(problems, fmt.Sprintf("Segment %d: %s", contentID, matches[3]))
+			}
+			continue
+		}
+		oid, _ := strconv.ParseUint(matches[2], 10, 32)
+		found[uint32(oid)] = true
+		name, ok := tableNames[uint32(oid)]
+		if matches[1] == "FAILED" && ok {
+			problems = append(problems, fmt.Sprintf("Segment %d: Data for table %s: %s", contentID, name, matches[3]))
+		}
+	}
+	missing := make([]string, 0)
+	for oid, name := range tableNames {
+		if !found[oid] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		problems = append(problems, fmt.Sprintf("Segment %d: No data for table %s in segment table of contents", contentID, name))
+	}
+	return problems
+}
+
+func WriteVerifyReport(errMsg string, exitCode int) {
+	reportFilename := globalCluster.GetVerifyReportFilePath(verifyStartTime.Format("20060102150405"))
+	logger.Info("Writing verification report to %s", reportFilename)
+	utils.WriteVerifyReportFile(reportFilename, globalCluster.Timestamp, backupConfig, version, verifyStartTime, time.Now(), verifyProblems, errMsg, exitCode)
+}
@@ -0,0 +1,52 @@
+package restore_test
+
+import (
+	"os/user"
+
+	"github.com/greenplum-db/gpbackup/restore"
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("restore/verify tests", func() {
+	Describe("ParseSingleDataFileVerifyOutput", func() {
+		tableNames := map[uint32]string{1: "public.foo", 2: "public.bar"}
+		It("returns no problems if every table was verified", func() {
+			problems := restore.ParseSingleDataFileVerifyOutput(0, "OK 1\nOK 2\n", tableNames)
+			Expect(problems).To(BeEmpty())
+		})
+		It("returns a problem for each table that failed verification", func() {
+			problems := restore.ParseSingleDataFileVerifyOutput(0, "OK 1\nFAILED 2: checksum of byte range 5-10 does not match\n", tableNames)
+			Expect(problems).To(Equal([]string{"Segment 0: Data for table public.bar: checksum of byte range 5-10 does not match"}))
+		})
+		It("returns a problem for the data file as a whole", func() {
+			problems := restore.ParseSingleDataFileVerifyOutput(1, "OK 1\nOK 2\nFAILED: found 7 bytes of data, but the table of contents records 10 bytes\n", tableNames)
+			Expect(problems).To(Equal([]string{"Segment 1: found 7 bytes of data, but the table of contents records 10 bytes"}))
+		})
+		It("returns a problem for each table missing from the segment TOC", func() {
+			problems := restore.ParseSingleDataFileVerifyOutput(0, "OK 3\n", tableNames)
+			Expect(problems).To(Equal([]string{
+				"Segment 0: No data for table public.bar in segment table of contents",
+				"Segment 0: No data for table public.foo in segment table of contents",
+			}))
+		})
+	})
+	Describe("VerifyTableDataFiles", func() {
+		It("reads the data files named in a list copied to each host", func() {
+			utils.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+			testExecutor := &testutils.TestExecutor{ClusterOutput: &utils.RemoteOutput{}}
+			testCluster := utils.NewCluster([]utils.SegConfig{{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"}, {ContentID: 0, Hostname: "remotehost1", DataDir: "/data/gpseg0"}}, "", "20170101010101", "gpseg")
+			testCluster.Executor = testExecutor
+			utils.SetCompressionParameters(false, utils.Compression{})
+			restore.SetPluginConfig(nil)
+
+			restore.VerifyTableDataFiles(testCluster, []utils.MasterDataEntry{{Schema: "public", Name: "foo", Oid: 1}, {Schema: "public", Name: "bar", Oid: 2}})
+			Expect(testExecutor.ClusterCommands).To(HaveLen(3))
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("remotehost1:/tmp/20170101010101_gpbackup_data_files"))
+			Expect(testExecutor.ClusterCommands[1][0][4]).To(Equal(`sed -n 's/^0 //p' /tmp/20170101010101_gpbackup_data_files | while read -r backupFile; do (cat "$backupFile") < /dev/null > /dev/null 2>&1 || echo "$backupFile"; done`))
+		})
+	})
+})
@@ -1,7 +1,11 @@
 package restore
 
 import (
+	"strings"
+	"time"
+
 	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
 )
 
 /*
@@ -28,6 +32,7 @@ func SetLoggerVerbosity() {
 func InitializeConnection(dbname string) {
 	connection = utils.NewDBConn(dbname)
 	connection.Connect(*numJobs)
+	connection.RecordBackendPIDs()
 	connection.MustExec("SET application_name TO 'gprestore'")
 	connection.SetDatabaseVersion()
 	connection.MustExec("SET search_path TO pg_catalog")
@@ -35,17 +40,36 @@ func InitializeConnection(dbname string) {
 	connection.MustExec("SET gp_default_storage_options='';")
 }
 
+// This is called by the signal handler when the restore is canceled
+func CancelQueriesInProgress() {
+	if connection == nil {
+		return
+	}
+	err := connection.CancelQueries()
+	if err != nil {
+		logger.Warn("Unable to cancel queries in progress: %v", err)
+	}
+}
+
 func InitializeBackupConfig() {
 	backupConfig = utils.ReadConfigFile(globalCluster.GetConfigFilePath())
-	utils.InitializeCompressionParameters(backupConfig.Compressed, 0)
+	utils.InitializeCompressionParameters(backupConfig.Compressed, backupConfig.CompressionType, 0)
+	utils.SetCompressedFrames(backupConfig.CompressedFrames)
 	utils.EnsureBackupVersionCompatibility(backupConfig.BackupVersion, version)
 	utils.EnsureDatabaseVersionCompatibility(backupConfig.DatabaseVersion, connection.Version)
+	format := backupConfig.GetDataFormat()
+	utils.EnsureDataFormatIsSupported(format, connection.Version)
+	utils.SetDataFormat(format)
 }
 
 func InitializeFilterLists() {
 	if *includeTableFile != "" {
 		includeTables = utils.ReadLinesFromFile(*includeTableFile)
 	}
+	externalDataTargets = make(map[string]string, 0)
+	if *externalDataTargetFile != "" {
+		externalDataTargets = ParseExternalDataTargetLines(utils.ReadLinesFromFile(*externalDataTargetFile))
+	}
 }
 
 /*
@@ -59,27 +83,176 @@ func DoPostgresValidation() {
 	segConfig := utils.GetSegmentConfiguration(connection)
 	globalCluster = utils.NewCluster(segConfig, *backupDir, *timestamp, "")
 	globalCluster.UserSpecifiedSegPrefix = utils.ParseSegPrefix(*backupDir)
-	globalCluster.VerifyBackupDirectoriesExistOnAllHosts()
+	if *nativeSSH {
+		globalCluster.Executor = utils.NewSSHExecutor()
+	}
+	if *pluginConfigFile != "" {
+		InitializePlugin()
+	} else {
+		globalCluster.VerifyBackupDirectoriesExistOnAllHosts()
+	}
 
 	InitializeBackupConfig()
 	ValidateBackupFlagCombinations()
+	if backupConfig.Encrypted {
+		InitializeEncryption()
+	}
+	if pluginConfig != nil {
+		RestoreMetadataFilesWithPlugin()
+	}
 	globalCluster.VerifyMetadataFilePaths(backupConfig.DataOnly, *withStats)
 
 	tocFilename := globalCluster.GetTOCFilePath()
+	utils.VerifyFileChecksum(tocFilename, backupConfig.TOCChecksum)
 	globalTOC = utils.NewTOC(tocFilename)
 	globalTOC.InitializeEntryMap()
+	VerifyMetadataFileChecksums()
 
+	if backupConfig.Incremental {
+		VerifyIncrementalBackupsExist()
+	}
+	ResolveFilterPatterns()
 	validateFilterListsInBackupSet()
+	ValidateExternalDataTargets(globalTOC.DataEntries)
 }
 
-func ConnectToRestoreDatabase() {
-	restoreDatabase := ""
-	if *redirect != "" {
-		restoreDatabase = *redirect
+/*
+ * Pattern filters are resolved against the schemas and tables in the backup
+ * set, using the same table of contents entries as the filter validation, and
+ * the matching names are added to the corresponding filter lists.
+ */
+func ResolveFilterPatterns() {
+	if len(includeSchemaPatterns) == 0 && len(includeTablePatterns) == 0 {
+		return
+	}
+	schemas, tables := GetSchemasAndTablesInBackupSet()
+	includeSchemas, _ = utils.AddPatternMatchesToFilterList(includeSchemas, "include-schema-pattern", includeSchemaPatterns, schemas)
+	includeTables, _ = utils.AddPatternMatchesToFilterList(includeTables, "include-table-pattern", includeTablePatterns, tables)
+}
+
+func GetSchemasAndTablesInBackupSet() ([]string, []string) {
+	schemas := make([]string, 0)
+	tables := make([]string, 0)
+	seenSchemas := make(map[string]bool, 0)
+	addEntry := func(schema string, name string, isTable bool) {
+		if schema != "" && !seenSchemas[schema] {
+			seenSchemas[schema] = true
+			schemas = append(schemas, schema)
+		}
+		if isTable {
+			tables = append(tables, utils.MakeFQN(schema, name))
+		}
+	}
+	if !backupConfig.DataOnly {
+		for _, entry := range globalTOC.PredataEntries {
+			addEntry(entry.Schema, entry.Name, entry.ObjectType == "TABLE")
+		}
 	} else {
-		restoreDatabase = backupConfig.DatabaseName
+		for _, entry := range globalTOC.DataEntries {
+			addEntry(entry.Schema, entry.Name, true)
+		}
+	}
+	return schemas, tables
+}
+
+func VerifyMetadataFileChecksums() {
+	if !backupConfig.DataOnly {
+		utils.VerifyFileChecksum(globalCluster.GetMetadataFilePath(), globalTOC.MetadataChecksum)
+	}
+	if *withStats && backupConfig.WithStatistics {
+		utils.VerifyFileChecksum(globalCluster.GetStatisticsFilePath(), globalTOC.StatisticsChecksum)
+	}
+}
+
+/*
+ * Before we start restoring anything, ensure that every backup whose data is
+ * needed to restore an incremental backup is present.
+ */
+func VerifyIncrementalBackupsExist() {
+	logger.Verbose("Verifying that all backups in the incremental backup chain exist")
+	for _, planEntry := range backupConfig.RestorePlan {
+		if planEntry.Timestamp == globalCluster.Timestamp || len(planEntry.TableFQNs) == 0 {
+			continue
+		}
+		planCluster := globalCluster
+		planCluster.Timestamp = planEntry.Timestamp
+		tocFilename := planCluster.GetTOCFilePath()
+		if pluginConfig != nil {
+			planCluster.CreateBackupDirectoriesOnAllHosts()
+			pluginConfig.RestoreFile(planCluster, tocFilename)
+		} else {
+			planCluster.VerifyBackupDirectoriesExistOnAllHosts()
+		}
+		if !utils.FileExistsAndIsReadable(tocFilename) {
+			logger.Fatal(errors.Errorf("Cannot access table of contents file %s for backup %s, which is required to restore incremental backup %s", tocFilename, planEntry.Timestamp, globalCluster.Timestamp), "")
+		}
+		utils.VerifyFileChecksum(tocFilename, planEntry.TOCChecksum)
+	}
+}
+
+/*
+ * The backup directories are created locally so that files retrieved by the
+ * plugin can be written to the same paths from which they were backed up.
+ */
+func InitializePlugin() {
+	pluginConfig = utils.ReadPluginConfig(*pluginConfigFile)
+	pluginConfig.CheckPluginExistsOnAllHosts(globalCluster)
+	pluginConfig.CopyPluginConfigToAllHosts(globalCluster, *pluginConfigFile)
+	globalCluster.CreateBackupDirectoriesOnAllHosts()
+	pluginConfig.SetupPluginForRestore(globalCluster)
+	pluginConfig.RestoreFile(globalCluster, globalCluster.GetConfigFilePath())
+}
+
+func InitializeEncryption() {
+	if *encryptionKeyFile == "" && *encryptionKeyCommand == "" {
+		logger.Fatal(errors.Errorf("Backup %s is encrypted; specify its encryption key with --encryption-key-file or --encryption-key-command", globalCluster.Timestamp), "")
+	}
+	utils.InitializeEncryption(globalCluster, *encryptionKeyFile, *encryptionKeyCommand)
+	if !utils.MatchEncryptionKeyFingerprint(backupConfig.EncryptionKeyFingerprint) {
+		logger.Fatal(errors.Errorf("The encryption key provided does not match the key with which backup %s was encrypted", globalCluster.Timestamp), "")
+	}
+}
+
+func RestoreMetadataFilesWithPlugin() {
+	pluginConfig.RestoreFile(globalCluster, globalCluster.GetTOCFilePath())
+	if !backupConfig.DataOnly {
+		pluginConfig.RestoreFile(globalCluster, globalCluster.GetMetadataFilePath())
+	}
+	if *withStats && backupConfig.WithStatistics {
+		pluginConfig.RestoreFile(globalCluster, globalCluster.GetStatisticsFilePath())
+	}
+}
+
+func GetRestoreDatabaseName() string {
+	if *redirect != "" {
+		return *redirect
+	}
+	return backupConfig.DatabaseName
+}
+
+func ConnectToRestoreDatabase() {
+	InitializeConnection(GetRestoreDatabaseName())
+}
+
+/*
+ * The space required on each segment is the estimate recorded by gpbackup,
+ * which covers all of the tables in the backup set, so it may be larger than
+ * needed for a filtered restore.  Backups taken before the estimate was
+ * recorded cannot be checked.
+ */
+func CheckDiskSpaceForRestore() {
+	if backupConfig.SegmentDataSizes == nil {
+		logger.Verbose("Backup %s does not include data size estimates; skipping disk space check", *timestamp)
+		return
+	}
+	freeSpace := globalCluster.GetFreeSpaceForDataDirectories()
+	problems, warnings := utils.CheckDiskSpace(globalCluster, backupConfig.SegmentDataSizes, freeSpace, *diskSpaceWarningThreshold)
+	for _, warning := range warnings {
+		logger.Warn(warning)
+	}
+	if len(problems) > 0 {
+		logger.Fatal(utils.NewDiskSpaceError("Not enough free disk space for the restore:\n%s\nUse --no-disk-space-check to restore anyway.", strings.Join(problems, "\n")), "")
 	}
-	InitializeConnection(restoreDatabase)
 }
 
 func DoRestoreDatabaseValidation() {
@@ -91,7 +264,7 @@ func DoRestoreDatabaseValidation() {
  */
 
 func GetRestoreMetadataStatements(section string, filename string, objectTypes []string, includeSchemas []string, includeTables []string) []utils.StatementWithType {
-	metadataFile := utils.MustOpenFileForReading(filename)
+	metadataFile := utils.MustOpenBackupFileForReading(filename)
 	var statements []utils.StatementWithType
 	if len(objectTypes) > 0 || len(includeSchemas) > 0 || len(includeTables) > 0 {
 		statements = globalTOC.GetSQLStatementForObjectTypes(section, metadataFile, objectTypes, includeSchemas, includeTables)
@@ -130,14 +303,78 @@ func setGUCsForConnection(gucStatements []utils.StatementWithType, whichConn int
 	return gucStatements
 }
 
-func restoreSingleTableData(entry utils.MasterDataEntry, tableNum uint32, totalTables int, whichConn int) {
+/*
+ * Backups taken before restore plans were recorded in the config file contain
+ * all of their data in their own backup directories.
+ */
+func GetRestorePlan() []utils.RestorePlanEntry {
+	if len(backupConfig.RestorePlan) > 0 {
+		return backupConfig.RestorePlan
+	}
+	tableFQNs := make([]string, 0, len(globalTOC.DataEntries))
+	for _, entry := range globalTOC.DataEntries {
+		tableFQNs = append(tableFQNs, utils.MakeFQN(entry.Schema, entry.Name))
+	}
+	return []utils.RestorePlanEntry{{Timestamp: globalCluster.Timestamp, TableFQNs: tableFQNs}}
+}
+
+func GetClusterAndTOCForTimestamp(timestamp string) (utils.Cluster, *utils.TOC) {
+	if timestamp == globalCluster.Timestamp {
+		return globalCluster, globalTOC
+	}
+	planCluster := globalCluster
+	planCluster.Timestamp = timestamp
+	planTOC := utils.NewTOC(planCluster.GetTOCFilePath())
+	return planCluster, planTOC
+}
+
+func GetDataEntriesForRestorePlanEntry(toc *utils.TOC, planEntry utils.RestorePlanEntry) []utils.MasterDataEntry {
+	planTableFQNs := make(map[string]bool, len(planEntry.TableFQNs))
+	for _, fqn := range planEntry.TableFQNs {
+		planTableFQNs[fqn] = true
+	}
+	dataEntries := make([]utils.MasterDataEntry, 0)
+	for _, entry := range toc.GetDataEntriesMatching(includeSchemas, includeTables) {
+		if planTableFQNs[utils.MakeFQN(entry.Schema, entry.Name)] {
+			dataEntries = append(dataEntries, entry)
+		}
+	}
+	return dataEntries
+}
+
+func restoreSingleTableData(cluster utils.Cluster, entry utils.MasterDataEntry, tableNum uint32, totalTables int, whichConn int) {
 	name := utils.MakeFQN(entry.Schema, entry.Name)
+	if entry.IsExternal {
+		name = externalDataTargets[name]
+	}
 	if logger.GetVerbosity() > utils.LOGINFO {
 		// No progress bar at this log level, so we note table count here
 		logger.Verbose("Reading data for table %s from file (table %d of %d)", name, tableNum, totalTables)
 	} else {
 		logger.Verbose("Reading data for table %s from file", name)
 	}
-	backupFile := globalCluster.GetTableBackupFilePathForCopyCommand(entry.Oid, backupConfig.SingleDataFile)
-	CopyTableIn(connection, name, entry.AttributeString, backupFile, backupConfig.SingleDataFile, entry.Oid, whichConn)
+	backupFile := cluster.GetTableBackupFilePathForCopyCommand(entry.Oid, backupConfig.SingleDataFile)
+	if backupConfig.SingleDataFile {
+		backupFile = cluster.GetSegmentPipePathForCopyCommand()
+	}
+	numRows := CopyTableIn(connection, name, entry.AttributeString, backupFile, backupConfig.SingleDataFile, entry.Oid, whichConn)
+	RecordTableDataRestored(entry, name, numRows)
+}
+
+/*
+ * Report functions
+ */
+
+func WriteRestoreReports(errMsg string, exitCode int) {
+	endTime := time.Now()
+	restoreTimestamp := restoreStartTime.Format("20060102150405")
+	reportFilename := globalCluster.GetRestoreReportFilePath(restoreTimestamp)
+	logger.Info("Writing restore report to %s", reportFilename)
+	utils.WriteRestoreReportFile(reportFilename, globalCluster.Timestamp, backupConfig, version, GetRestoreDatabaseName(), restoreStartTime, endTime, tablesRestored, errMsg, exitCode)
+	jsonReport := utils.NewJSONReport("gprestore", version, globalCluster.Timestamp, restoreStartTime, endTime, tablesRestored, errMsg, exitCode)
+	jsonReport.BackupVersion = backupConfig.BackupVersion
+	jsonReport.DatabaseName = backupConfig.DatabaseName
+	jsonReport.DatabaseVersion = backupConfig.DatabaseVersion
+	jsonReport.RestoreDatabase = GetRestoreDatabaseName()
+	jsonReport.WriteToFile(globalCluster.GetRestoreJSONReportFilePath(restoreTimestamp))
 }
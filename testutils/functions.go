@@ -11,6 +11,7 @@ import (
 	"github.com/blang/semver"
 	"github.com/greenplum-db/gpbackup/backup"
 	"github.com/greenplum-db/gpbackup/helper"
+	"github.com/greenplum-db/gpbackup/manager"
 	"github.com/greenplum-db/gpbackup/restore"
 	"github.com/greenplum-db/gpbackup/utils"
 
@@ -61,6 +62,7 @@ func SetupTestLogger() (*utils.Logger, *gbytes.Buffer, *gbytes.Buffer, *gbytes.B
 	restore.SetLogger(testLogger)
 	utils.SetLogger(testLogger)
 	helper.SetLogger(testLogger)
+	manager.SetLogger(testLogger)
 	return testLogger, testStdout, testStderr, testLogfile
 }
 
@@ -68,6 +70,7 @@ func SetupTestCluster() {
 	testCluster := SetDefaultSegmentConfiguration()
 	backup.SetCluster(testCluster)
 	restore.SetCluster(testCluster)
+	manager.SetCluster(testCluster)
 }
 
 func CreateMockDB() (*sqlx.DB, sqlmock.Sqlmock) {
@@ -45,6 +45,7 @@ type TestExecutor struct {
 	LocalError      error
 	LocalCommands   []string
 	ClusterOutput   *utils.RemoteOutput
+	ClusterOutputs  []*utils.RemoteOutput // If set, the output of each call to ExecuteClusterCommand in turn
 	ClusterCommands []map[int][]string
 	ErrorOnExecNum  int // Throw the specified error after this many executions of Execute[...]Command(); 0 means always return error
 	NumExecutions   int
@@ -62,6 +63,9 @@ func (executor *TestExecutor) ExecuteLocalCommand(commandStr string) error {
 func (executor *TestExecutor) ExecuteClusterCommand(commandMap map[int][]string) *utils.RemoteOutput {
 	executor.NumExecutions++
 	executor.ClusterCommands = append(executor.ClusterCommands, commandMap)
+	if executor.ClusterOutputs != nil {
+		return executor.ClusterOutputs[executor.NumExecutions-1]
+	}
 	if executor.ErrorOnExecNum == 0 || executor.NumExecutions == executor.ErrorOnExecNum {
 		return executor.ClusterOutput
 	}
@@ -0,0 +1,179 @@
+package utils
+
+/*
+ * This file contains functions related to computing and verifying checksums
+ * of backup files, so that corrupted or truncated files are detected before
+ * anything is restored from them.
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+/*
+ * The checksums of the master files of an encrypted backup are HMACs keyed with
+ * a key derived from the encryption key.  The config file is not encrypted, so
+ * this ensures that the TOC file, and through the checksums it contains the
+ * other backup files, cannot be modified without detection.
+ */
+func GetFileChecksum(filename string) string {
+	file, err := os.Open(filename)
+	if err != nil {
+		logger.Fatal(err, "Unable to open file %s to compute checksum", filename)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if usingEncryption && checksumKey != nil {
+		hash = hmac.New(sha256.New, checksumKey)
+	}
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		logger.Fatal(err, "Unable to compute checksum for file %s", filename)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// Backups taken before checksums were introduced have no checksums to verify
+func VerifyFileChecksum(filename string, expectedChecksum string) {
+	if expectedChecksum == "" {
+		return
+	}
+	logger.Verbose("Verifying checksum for file %s", filename)
+	checksum := GetFileChecksum(filename)
+	if checksum != expectedChecksum {
+		logger.Fatal(errors.Errorf("Checksum verification failed for file %s: expected %s, found %s", filename, expectedChecksum, checksum), "")
+	}
+}
+
+/*
+ * This computes the checksum of every data file written by this backup on each
+ * segment, returning a map from content ID to a map from file name to checksum.
+ */
+func (cluster *Cluster) GetDataFileChecksums() map[int]map[string]string {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Computing checksums for segment data files", func(contentID int) string {
+		return fmt.Sprintf("sha256sum %s/gpbackup_%d_%s_*", cluster.GetDirForContent(contentID), contentID, cluster.Timestamp)
+	})
+	cluster.CheckClusterError(remoteOutput, "Unable to compute checksums for segment data files", func(contentID int) string {
+		return "Unable to compute checksums for segment data files"
+	})
+	checksums := make(map[int]map[string]string, len(remoteOutput.Stdouts))
+	for contentID, stdout := range remoteOutput.Stdouts {
+		checksums[contentID] = make(map[string]string, 0)
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			checksums[contentID][path.Base(fields[1])] = fields[0]
+		}
+	}
+	return checksums
+}
+
+/*
+ * Single-data-file backups record the checksum of the data for each table in
+ * the segment TOC files, so only the checksums of those files are recorded in
+ * the master TOC.  This returns a map from content ID to checksum.
+ */
+func (cluster *Cluster) GetSegmentTOCChecksums() map[int]string {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Computing checksums for segment table of contents files", func(contentID int) string {
+		return fmt.Sprintf("sha256sum %s", cluster.GetSegmentTOCFilePath(cluster.GetDirForContent(contentID), fmt.Sprintf("%d", contentID)))
+	})
+	cluster.CheckClusterError(remoteOutput, "Unable to compute checksums for segment table of contents files", func(contentID int) string {
+		return "Unable to compute checksum for segment table of contents file"
+	})
+	checksums := make(map[int]string, len(remoteOutput.Stdouts))
+	for contentID, stdout := range remoteOutput.Stdouts {
+		if fields := strings.Fields(stdout); len(fields) > 0 {
+			checksums[contentID] = fields[0]
+		}
+	}
+	return checksums
+}
+
+/*
+ * This verifies the checksums of the data files for the given tables on every
+ * segment at once, before any of them are restored, and logs every file that
+ * fails verification on each segment.
+ */
+func (cluster *Cluster) VerifyDataFileChecksums(entries []MasterDataEntry) {
+	cluster.logChecksumFailures(cluster.CheckDataFileChecksums(entries), "data files")
+}
+
+func (cluster *Cluster) VerifySegmentTOCChecksums(checksums map[int]string) {
+	cluster.logChecksumFailures(cluster.CheckSegmentTOCChecksums(checksums), "segment table of contents files")
+}
+
+func (cluster *Cluster) logChecksumFailures(failures map[int]string, fileType string) {
+	for contentID, output := range failures {
+		logger.Error("Checksum verification failed for %s on segment %d on host %s:\n%s", fileType, contentID, cluster.GetHostForContent(contentID), output)
+	}
+	if len(failures) > 0 {
+		cluster.LogFatalError(fmt.Sprintf("Checksum verification failed for %s from backup %s", fileType, cluster.Timestamp), len(failures))
+	}
+}
+
+/*
+ * This returns a map from content ID to the output of the checksum verification
+ * for each segment on which verification failed.
+ *
+ * Every data file is read in full before any data is restored, so verifying
+ * the checksums adds a read of the whole backup to the time taken to restore
+ * it.  Data files stored with a plugin are not checksummed, since they are
+ * streamed to and from the plugin without being written to the segments.
+ */
+func (cluster *Cluster) CheckDataFileChecksums(entries []MasterDataEntry) map[int]string {
+	checksumLists := make(map[int][]string, 0)
+	for _, entry := range entries {
+		for contentID, checksum := range entry.Checksums {
+			backupFile := cluster.GetTableBackupFilePath(contentID, entry.Oid, false)
+			checksumLists[contentID] = append(checksumLists[contentID], fmt.Sprintf("%s  %s", checksum, backupFile))
+		}
+	}
+	if len(checksumLists) == 0 {
+		return make(map[int]string, 0)
+	}
+	for _, checksumList := range checksumLists {
+		sort.Strings(checksumList)
+	}
+	checksumFile := cluster.CopySegmentListsToAllHosts("Copying data file checksums to all hosts", "checksums", checksumLists)
+	defer cluster.CleanUpSegmentListOnAllHosts(checksumFile)
+	remoteOutput := cluster.GenerateAndExecuteCommand("Verifying checksums for segment data files", func(contentID int) string {
+		if len(checksumLists[contentID]) == 0 {
+			return "true"
+		}
+		return fmt.Sprintf("%s | sha256sum --check --quiet -", GetSegmentListCommand(checksumFile, contentID))
+	})
+	return getChecksumFailures(remoteOutput)
+}
+
+// Backups taken before segment TOC checksums were introduced have none to check
+func (cluster *Cluster) CheckSegmentTOCChecksums(checksums map[int]string) map[int]string {
+	if len(checksums) == 0 {
+		return make(map[int]string, 0)
+	}
+	remoteOutput := cluster.GenerateAndExecuteCommand("Verifying checksums for segment table of contents files", func(contentID int) string {
+		tocFile := cluster.GetSegmentTOCFilePath(cluster.GetDirForContent(contentID), fmt.Sprintf("%d", contentID))
+		return fmt.Sprintf("echo '%s  %s' | sha256sum --check --quiet -", checksums[contentID], tocFile)
+	})
+	return getChecksumFailures(remoteOutput)
+}
+
+func getChecksumFailures(remoteOutput *RemoteOutput) map[int]string {
+	failures := make(map[int]string, 0)
+	for contentID, err := range remoteOutput.Errors {
+		if err != nil {
+			failures[contentID] = strings.TrimSpace(remoteOutput.Stdouts[contentID] + remoteOutput.Stderrs[contentID])
+		}
+	}
+	return failures
+}
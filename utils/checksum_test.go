@@ -0,0 +1,140 @@
+package utils_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// This records the contents of the first file copied to the hosts, before it is removed
+type listCopyingExecutor struct {
+	*testutils.TestExecutor
+	list string
+}
+
+func (executor *listCopyingExecutor) ExecuteClusterCommand(commandMap map[int][]string) *utils.RemoteOutput {
+	if command := commandMap[-1]; executor.list == "" && len(command) > 0 && command[0] == "scp" {
+		contents, _ := ioutil.ReadFile(command[3])
+		executor.list = string(contents)
+	}
+	return executor.TestExecutor.ExecuteClusterCommand(commandMap)
+}
+
+var _ = Describe("utils/checksum tests", func() {
+	masterSeg := utils.SegConfig{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"}
+	localSegOne := utils.SegConfig{ContentID: 0, Hostname: "localhost", DataDir: "/data/gpseg0"}
+	remoteSegOne := utils.SegConfig{ContentID: 1, Hostname: "remotehost1", DataDir: "/data/gpseg1"}
+	var (
+		testCluster  utils.Cluster
+		testExecutor *testutils.TestExecutor
+	)
+
+	BeforeEach(func() {
+		utils.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+		testExecutor = &testutils.TestExecutor{}
+		testCluster = utils.NewCluster([]utils.SegConfig{masterSeg, localSegOne, remoteSegOne}, "", "20170101010101", "gpseg")
+		testCluster.Executor = testExecutor
+	})
+	Describe("GetFileChecksum and VerifyFileChecksum", func() {
+		var filename string
+		BeforeEach(func() {
+			file, _ := ioutil.TempFile("", "checksum")
+			file.WriteString("some text\n")
+			file.Close()
+			filename = file.Name()
+		})
+		AfterEach(func() {
+			os.Remove(filename)
+		})
+		It("returns the SHA-256 checksum of the file", func() {
+			Expect(utils.GetFileChecksum(filename)).To(Equal("a23e5fdcd7b276bdd81aa1a0b7b963101863dd3f61ff57935f8c5ba462681ea6"))
+		})
+		It("does not panic if the checksum matches", func() {
+			utils.VerifyFileChecksum(filename, "a23e5fdcd7b276bdd81aa1a0b7b963101863dd3f61ff57935f8c5ba462681ea6")
+		})
+		It("does not panic if there is no checksum to verify", func() {
+			utils.VerifyFileChecksum(filename, "")
+		})
+		It("panics if the checksum does not match", func() {
+			defer testutils.ShouldPanicWithMessage("Checksum verification failed for file " + filename)
+			utils.VerifyFileChecksum(filename, "0000")
+		})
+	})
+	Describe("GetDataFileChecksums", func() {
+		It("returns the checksum of each data file on each segment", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{Stdouts: map[int]string{
+				0: "abc  /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234\ndef  /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_5678\n",
+				1: "ghi  /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_1234\n",
+			}}
+			checksums := testCluster.GetDataFileChecksums()
+			Expect(testExecutor.ClusterCommands[0][0]).To(Equal([]string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@localhost", "sha256sum /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_*"}))
+			Expect(checksums).To(Equal(map[int]map[string]string{
+				0: {"gpbackup_0_20170101010101_1234": "abc", "gpbackup_0_20170101010101_5678": "def"},
+				1: {"gpbackup_1_20170101010101_1234": "ghi"},
+			}))
+		})
+	})
+	Describe("VerifyDataFileChecksums", func() {
+		entries := []utils.MasterDataEntry{{Schema: "public", Name: "foo", Oid: 1234, Checksums: map[int]string{0: "abc", 1: "ghi"}}}
+		It("checks the data files for the given tables on each segment against a list copied to each host", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{}
+			testCluster.VerifyDataFileChecksums(entries)
+			Expect(testExecutor.ClusterCommands).To(HaveLen(3))
+			Expect(testExecutor.ClusterCommands[0][-1][4]).To(Equal("localhost:/tmp/20170101010101_gpbackup_checksums"))
+			Expect(testExecutor.ClusterCommands[0][1][4]).To(Equal("remotehost1:/tmp/20170101010101_gpbackup_checksums"))
+			Expect(testExecutor.ClusterCommands[1][0]).To(Equal([]string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@localhost", "sed -n 's/^0 //p' /tmp/20170101010101_gpbackup_checksums | sha256sum --check --quiet -"}))
+			Expect(testExecutor.ClusterCommands[2][1]).To(Equal([]string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@remotehost1", "rm -f /tmp/20170101010101_gpbackup_checksums"}))
+		})
+		It("writes the checksum of each data file to the list for its segment", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{}
+			executor := &listCopyingExecutor{TestExecutor: testExecutor}
+			testCluster.Executor = executor
+			testCluster.VerifyDataFileChecksums(entries)
+			Expect(executor.list).To(Equal("0 abc  /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_1234\n1 ghi  /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_1234\n"))
+		})
+		It("does nothing if there are no checksums to verify", func() {
+			testCluster.VerifyDataFileChecksums([]utils.MasterDataEntry{{Schema: "public", Name: "foo", Oid: 1234}})
+			Expect(testExecutor.ClusterCommands).To(BeEmpty())
+		})
+		It("panics if verification fails on any segment", func() {
+			failedOutput := &utils.RemoteOutput{NumErrors: 1, Errors: map[int]error{1: errors.New("exit status 1")}, Stdouts: map[int]string{1: "gpbackup_1_20170101010101_1234: FAILED"}}
+			testExecutor.ClusterOutputs = []*utils.RemoteOutput{{}, failedOutput, {}}
+			defer testutils.ShouldPanicWithMessage("Checksum verification failed for data files from backup 20170101010101 on 1 segment")
+			testCluster.VerifyDataFileChecksums(entries)
+		})
+	})
+	Describe("GetSegmentTOCChecksums", func() {
+		It("returns the checksum of the segment TOC file on each segment", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{Stdouts: map[int]string{
+				0: "abc  /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101_toc.yaml\n",
+				1: "def  /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_toc.yaml\n",
+			}}
+			checksums := testCluster.GetSegmentTOCChecksums()
+			Expect(testExecutor.ClusterCommands[0][1]).To(Equal([]string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@remotehost1", "sha256sum /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_toc.yaml"}))
+			Expect(checksums).To(Equal(map[int]string{0: "abc", 1: "def"}))
+		})
+	})
+	Describe("VerifySegmentTOCChecksums", func() {
+		It("checks the segment TOC file on each segment", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{}
+			testCluster.VerifySegmentTOCChecksums(map[int]string{0: "abc", 1: "def"})
+			Expect(testExecutor.ClusterCommands[0][1]).To(Equal([]string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@remotehost1", "echo 'def  /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101_toc.yaml' | sha256sum --check --quiet -"}))
+		})
+		It("does nothing for a backup without segment TOC checksums", func() {
+			testCluster.VerifySegmentTOCChecksums(nil)
+			Expect(testExecutor.ClusterCommands).To(BeEmpty())
+		})
+		It("panics if verification fails on any segment", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{NumErrors: 1, Errors: map[int]error{0: errors.New("exit status 1")}}
+			defer testutils.ShouldPanicWithMessage("Checksum verification failed for segment table of contents files from backup 20170101010101 on 1 segment")
+			testCluster.VerifySegmentTOCChecksums(map[int]string{0: "abc", 1: "def"})
+		})
+	})
+})
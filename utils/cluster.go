@@ -8,6 +8,8 @@ package utils
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path"
 	"strconv"
@@ -17,26 +19,60 @@ import (
 )
 
 var (
-	usingCompression   = true
-	compressionProgram Compression
+	usingCompression      = true
+	usingCompressedFrames = false
+	compressionProgram    Compression
 )
 
 type Compression struct {
 	Name              string
+	Level             int
 	CompressCommand   string
 	DecompressCommand string
 	Extension         string
 }
 
-func InitializeCompressionParameters(compress bool, compressionLevel int) {
+/*
+ * Data is compressed and decompressed on the segments by gpbackup_helper for
+ * every compression type; see NewCompressionWriter.  The maximum level is the
+ * highest level supported by the library used for each type, and the
+ * corresponding command-line utility can still read the data files.  All types
+ * default to level 1, as that is the fastest level and data compression is
+ * usually CPU-bound on the segments.
+ */
+var compressionTypes = map[string]struct {
+	extension string
+	maxLevel  int
+}{
+	"gzip": {".gz", 9},
+	"lz4":  {".lz4", 9},
+	"zstd": {".zst", 19},
+}
+
+func GetMaxCompressionLevel(compressionType string) (int, bool) {
+	compressionInfo, ok := compressionTypes[compressionType]
+	return compressionInfo.maxLevel, ok
+}
+
+func InitializeCompressionParameters(compress bool, compressionType string, compressionLevel int) {
+	// Backups taken before compression types were configurable always used gzip
+	if compressionType == "" {
+		compressionType = "gzip"
+	} else if compressionType == "none" {
+		compress = false
+		compressionType = "gzip"
+	}
+	compressionInfo, ok := compressionTypes[compressionType]
+	if !ok {
+		logger.Fatal(NewFlagError("Unknown compression type %s", compressionType), "")
+	}
 	usingCompression = compress
-	compressCommand := ""
 	if compressionLevel == 0 {
-		compressCommand = "gzip -c -1"
-	} else {
-		compressCommand = fmt.Sprintf("gzip -c -%d", compressionLevel)
+		compressionLevel = 1
 	}
-	compressionProgram = Compression{Name: "gzip", CompressCommand: compressCommand, DecompressCommand: "gzip -d -c", Extension: ".gz"}
+	compressCommand := fmt.Sprintf("$GPHOME/bin/gpbackup_helper --compress --compression-type=%s --compression-level=%d", compressionType, compressionLevel)
+	decompressCommand := fmt.Sprintf("$GPHOME/bin/gpbackup_helper --decompress --compression-type=%s", compressionType)
+	compressionProgram = Compression{Name: compressionType, Level: compressionLevel, CompressCommand: compressCommand, DecompressCommand: decompressCommand, Extension: compressionInfo.extension}
 }
 
 func GetCompressionParameters() (bool, Compression) {
@@ -48,6 +84,22 @@ func SetCompressionParameters(compress bool, compression Compression) {
 	compressionProgram = compression
 }
 
+/*
+ * In compressed single-data-file backups, gpbackup_helper compresses the data
+ * for each table as a separate frame, so that a table can be restored without
+ * decompressing the data for the tables before it.  Each format decompresses
+ * a sequence of frames as a single stream, so the data file as a whole can
+ * still be read with the decompress command.  Backups taken before frames were
+ * written compress the whole data file as one stream instead.
+ */
+func GetCompressedFrames() bool {
+	return usingCompressedFrames
+}
+
+func SetCompressedFrames(compressedFrames bool) {
+	usingCompressedFrames = compressedFrames
+}
+
 type Executor interface {
 	ExecuteLocalCommand(commandStr string) error
 	ExecuteClusterCommand(commandMap map[int][]string) *RemoteOutput
@@ -141,6 +193,18 @@ func NewRemoteOutput(numIDs int) *RemoteOutput {
 }
 
 func (executor *GPDBExecutor) ExecuteClusterCommand(commandMap map[int][]string) *RemoteOutput {
+	return executeCommandsInParallel(commandMap, executeCommand)
+}
+
+func executeCommand(command []string) (string, string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	return string(out), stderr.String(), err
+}
+
+func executeCommandsInParallel(commandMap map[int][]string, runCommand func(command []string) (string, string, error)) *RemoteOutput {
 	length := len(commandMap)
 	finished := make(chan int)
 	contentIDs := make([]int, length)
@@ -155,13 +219,7 @@ func (executor *GPDBExecutor) ExecuteClusterCommand(commandMap map[int][]string)
 	errors := make([]error, length)
 	for i, contentID := range contentIDs {
 		go func(index int, segCommand []string) {
-			var stderr bytes.Buffer
-			cmd := exec.Command(segCommand[0], segCommand[1:]...)
-			cmd.Stderr = &stderr
-			out, err := cmd.Output()
-			stdouts[index] = string(out)
-			stderrs[index] = stderr.String()
-			errors[index] = err
+			stdouts[index], stderrs[index], errors[index] = runCommand(segCommand)
 			finished <- index
 		}(i, commandMap[contentID])
 	}
@@ -178,6 +236,17 @@ func (executor *GPDBExecutor) ExecuteClusterCommand(commandMap map[int][]string)
 	return output
 }
 
+/*
+ * This joins the given shell commands into a pipeline that fails if any of the
+ * commands in it fails.
+ */
+func ConstructPipeline(commands []string) string {
+	if len(commands) == 1 {
+		return commands[0]
+	}
+	return fmt.Sprintf("set -o pipefail; %s", strings.Join(commands, " | "))
+}
+
 /*
  * GenerateAndExecuteCommand and CheckClusterError are generic wrapper functions
  * to simplify execution of shell commands on remote hosts.
@@ -227,9 +296,71 @@ func (cluster *Cluster) CreateBackupDirectoriesOnAllHosts() {
 	})
 }
 
+/*
+ * The file is copied once to each host, rather than once per segment, so that
+ * multiple segments on a host do not write to the same file at the same time.
+ */
+func (cluster *Cluster) CopyFileToAllHosts(verboseMsg string, sourceFile string, destFile string) {
+	logger.Verbose(verboseMsg)
+	commandMap := make(map[int][]string, 0)
+	hostsSeen := make(map[string]bool, 0)
+	for _, contentID := range cluster.ContentIDs {
+		host := cluster.GetHostForContent(contentID)
+		if hostsSeen[host] {
+			continue
+		}
+		hostsSeen[host] = true
+		commandMap[contentID] = []string{"scp", "-o", "StrictHostKeyChecking=no", sourceFile, fmt.Sprintf("%s:%s", host, destFile)}
+	}
+	remoteOutput := cluster.ExecuteClusterCommand(commandMap)
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to copy file %s to all hosts", sourceFile), func(contentID int) string {
+		return fmt.Sprintf("Unable to copy file %s to %s", sourceFile, destFile)
+	})
+}
+
+/*
+ * A list of files to be checked on each segment is written to a file that is
+ * copied to every host, rather than passed on the command line, since the list
+ * for a backup with many tables can exceed the maximum length of an argument.
+ * Each line of the file begins with the content ID of the segment to which it
+ * applies, which is removed by the command from GetSegmentListCommand.
+ */
+func (cluster *Cluster) CopySegmentListsToAllHosts(verboseMsg string, listName string, lists map[int][]string) string {
+	tempFile, err := ioutil.TempFile("", fmt.Sprintf("gpbackup_%s", listName))
+	CheckError(err)
+	defer os.Remove(tempFile.Name())
+	for _, contentID := range cluster.ContentIDs {
+		for _, line := range lists[contentID] {
+			MustPrintf(tempFile, "%d %s\n", contentID, line)
+		}
+	}
+	err = tempFile.Close()
+	CheckError(err)
+	listFile := fmt.Sprintf("/tmp/%s_gpbackup_%s", cluster.Timestamp, listName)
+	cluster.CopyFileToAllHosts(verboseMsg, tempFile.Name(), listFile)
+	return listFile
+}
+
+func GetSegmentListCommand(listFile string, contentID int) string {
+	return fmt.Sprintf("sed -n 's/^%d //p' %s", contentID, listFile)
+}
+
+func (cluster *Cluster) CleanUpSegmentListOnAllHosts(listFile string) {
+	remoteOutput := cluster.GenerateAndExecuteCommand(fmt.Sprintf("Removing file %s from all hosts", listFile), func(contentID int) string {
+		return fmt.Sprintf("rm -f %s", listFile)
+	}, true)
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to remove file %s from all hosts", listFile), func(contentID int) string {
+		return fmt.Sprintf("Unable to remove file %s", listFile)
+	}, true)
+}
+
+/*
+ * Only the control pipe of each segment's helper agent is created here; the
+ * pipe for each table's data is created by the COPY command for that table.
+ */
 func (cluster *Cluster) CreateSegmentPipesOnAllHosts() {
 	remoteOutput := cluster.GenerateAndExecuteCommand("Creating segment data pipes", func(contentID int) string {
-		return fmt.Sprintf("mkfifo %s", cluster.GetSegmentPipeFilePath(contentID))
+		return fmt.Sprintf("mkfifo %s_control", cluster.GetSegmentPipeFilePath(contentID))
 	})
 	cluster.CheckClusterError(remoteOutput, "Unable to create segment data pipes", func(contentID int) string {
 		return "Unable to create segment data pipe"
@@ -239,7 +370,7 @@ func (cluster *Cluster) CreateSegmentPipesOnAllHosts() {
 func (cluster *Cluster) CleanUpSegmentPipesOnAllHosts() {
 	remoteOutput := cluster.GenerateAndExecuteCommand("Cleaning up segment data pipes", func(contentID int) string {
 		pipePath := cluster.GetSegmentPipeFilePath(contentID)
-		// This cleans up both the pipe itself as well as any gpbackup_helper process associated with it
+		// This cleans up the pipes and the agent's files as well as any gpbackup_helper process associated with them
 		return fmt.Sprintf("set -o pipefail; rm -f %s* && ps ux | grep %s | grep -v grep | awk '{print $2}' | xargs kill -9 || true", pipePath, pipePath)
 	})
 	cluster.CheckClusterError(remoteOutput, "Unable to clean up segment data pipes", func(contentID int) string {
@@ -247,29 +378,251 @@ func (cluster *Cluster) CleanUpSegmentPipesOnAllHosts() {
 	})
 }
 
-func (cluster *Cluster) ReadFromSegmentPipes() {
-	remoteOutput := cluster.GenerateAndExecuteCommand("Reading from segment data pipes", func(contentID int) string {
-		usingCompression, compressionProgram := GetCompressionParameters()
+/*
+ * This returns the program run by COPY to pass a table's data to or from the
+ * helper agent on each segment.  The program creates a pipe for the table,
+ * sends the table's oid to the agent on its control pipe, and then writes the
+ * data to or reads it from the table's pipe.  It fails without waiting for the
+ * agent if the agent has already failed or exited, and fails after passing the
+ * table's data if the agent has exited since, or if the agent could not restore
+ * the table, in which case it writes an error file for the table.
+ *
+ * Opening either end of a pipe waits until the other end is opened, so COPY
+ * must not be left waiting for an agent that has exited.  The control pipe is
+ * opened for reading as well as writing, which never waits.  Once the agent's
+ * status file shows that it has exited, the table's pipe is opened without
+ * waiting in the background, so that the open by COPY returns and COPY fails.
+ * Before exiting with an error, the agent itself opens the pipes of the tables
+ * it has not processed in the same way.
+ */
+func ConstructHelperAgentCopyProgram(pipeFile string, tableOid uint32, restore bool) string {
+	tablePipe := fmt.Sprintf("%s_%d", pipeFile, tableOid)
+	releasePipe := fmt.Sprintf("dd if=%s of=/dev/null iflag=nonblock", tablePipe)
+	if restore {
+		releasePipe = fmt.Sprintf("dd if=/dev/null of=%s oflag=nonblock conv=nocreat", tablePipe)
+	}
+	watchAgent := fmt.Sprintf("{ (while test -p %[1]s && test ! -e %[2]s_status; do sleep 1; done; test -p %[1]s && %[3]s) < /dev/null > /dev/null 2>&1 & }", tablePipe, pipeFile, releasePipe)
+	program := fmt.Sprintf("test ! -e %[1]s_error && test ! -e %[1]s_status && mkfifo %[2]s && %[3]s && echo %[4]d 1<> %[1]s_control", pipeFile, tablePipe, watchAgent, tableOid)
+	if restore {
+		return fmt.Sprintf("%[1]s && cat %[2]s && rm -f %[2]s && test ! -e %[2]s_error && test ! -e %[3]s_error && test ! -e %[3]s_status", program, tablePipe, pipeFile)
+	}
+	return fmt.Sprintf("%[1]s && cat > %[2]s && rm -f %[2]s && test ! -e %[3]s_status", program, tablePipe, pipeFile)
+}
+
+/*
+ * The helper agent on each segment is started in the background along with
+ * the commands reading or writing the segment's data file, and the exit status
+ * of the commands as a whole is written to the agent's status file once they
+ * have all finished.  Their output is discarded so that the remote command
+ * returns without waiting for them; the agent logs to its own log file.
+ */
+func getStartHelperAgentCommand(pipeFile string, commands []string) string {
+	return fmt.Sprintf("(%s; echo $? > %s_status) > /dev/null 2>&1 &", ConstructPipeline(commands), pipeFile)
+}
+
+/*
+ * The data for each table is passed to the helper agent, which writes it to
+ * the segment data file, or to the plugin if a plugin is being used.
+ */
+func (cluster *Cluster) StartBackupHelperAgents(pluginConfig *PluginConfig) {
+	cluster.startBackupHelperAgents(pluginConfig, nil)
+}
+
+/*
+ * When a single-data-file backup is resumed, the data kept from the interrupted
+ * backup is read back from the file set aside by PrepareSegmentDataFilesForResume
+ * ahead of the data written by the helper agent, so that the new data file is
+ * written as a single stream, just as if the backup had never been interrupted.
+ */
+func (cluster *Cluster) StartBackupHelperAgentsForResume(resumeByteCounts map[int]ResumeByteCount) {
+	cluster.startBackupHelperAgents(nil, resumeByteCounts)
+}
+
+func (cluster *Cluster) startBackupHelperAgents(pluginConfig *PluginConfig, resumeByteCounts map[int]ResumeByteCount) {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Starting segment helper agents", func(contentID int) string {
+		pipeFile := cluster.GetSegmentPipeFilePath(contentID)
+		/*
+		 * The segment TOC files are always written to the segment data directory for
+		 * performance reasons, in case the user-specified directory is on a mounted
+		 * drive.  It will be copied to a user-specified directory, if any, once all
+		 * of the data is backed up.
+		 */
+		tocFile := cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID))
+		backupFile := cluster.GetTableBackupFilePath(contentID, 0, true)
+		readCommand := fmt.Sprintf("nohup $GPHOME/bin/gpbackup_helper --backup-agent --toc-file=%s --pipe-file=%s --content=%d", tocFile, pipeFile, contentID)
+		// The helper compresses the data for each table as a separate frame
+		if usingCompressedFrames {
+			readCommand += fmt.Sprintf(" --compression-type=%s --compression-level=%d", compressionProgram.Name, compressionProgram.Level)
+		}
+		if byteCount := resumeByteCounts[contentID].fileBytes(); byteCount > 0 {
+			readCommand = fmt.Sprintf("(%s | head -c %d; rm -f %s.resume; %s)", cluster.getReadResumeFileCommand(backupFile+".resume"), byteCount, backupFile, readCommand)
+		}
+		commands := []string{readCommand}
+		if usingCompression && !usingCompressedFrames {
+			commands = append(commands, compressionProgram.CompressCommand)
+		}
+		if usingEncryption {
+			commands = append(commands, encryptionProgram.EncryptCommand)
+		}
+		if pluginConfig != nil {
+			commands = append(commands, pluginConfig.GetBackupDataCommand(backupFile))
+		} else {
+			commands[len(commands)-1] += fmt.Sprintf(" > %s", backupFile)
+		}
+		return getStartHelperAgentCommand(pipeFile, commands)
+	})
+	cluster.CheckClusterError(remoteOutput, "Unable to start segment helper agents", func(contentID int) string {
+		return "Unable to start segment helper agent"
+	})
+}
+
+/*
+ * An unencrypted data file is read directly by the helper agent, so that it
+ * can seek to the data for each table, while an encrypted one is decrypted as
+ * a stream.  The encryption is not framed, so the agent must read through the
+ * decrypted data for every table before the ones requested instead of seeking
+ * past it.  The agent stops reading once it has restored the data for the last
+ * table requested, so the decryption may fail with a broken pipe.
+ */
+func (cluster *Cluster) StartRestoreHelperAgents() {
+	// Error code returned for broken pipe
+	const SIGPIPE = "141"
+	remoteOutput := cluster.GenerateAndExecuteCommand("Starting segment helper agents", func(contentID int) string {
 		pipeFile := cluster.GetSegmentPipeFilePath(contentID)
-		compress := compressionProgram.CompressCommand
+		tocFile := cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID))
 		backupFile := cluster.GetTableBackupFilePath(contentID, 0, true)
-		if usingCompression {
-			return fmt.Sprintf("set -o pipefail; nohup tail -n +1 -f %s | %s > %s &", pipeFile, compress, backupFile)
+		helperCommand := fmt.Sprintf("nohup $GPHOME/bin/gpbackup_helper --restore-agent --toc-file=%s --pipe-file=%s --content=%d", tocFile, pipeFile, contentID)
+		// Data compressed as a single stream by earlier versions of gpbackup is decompressed by the helper as it is read
+		if usingCompression && !usingCompressedFrames {
+			helperCommand += fmt.Sprintf(" --compression-type=%s", compressionProgram.Name)
+		}
+		if !usingEncryption {
+			return getStartHelperAgentCommand(pipeFile, []string{fmt.Sprintf("%s --data-file=%s", helperCommand, backupFile)})
+		}
+		commands := []string{fmt.Sprintf("%s < %s", encryptionProgram.DecryptCommand, backupFile), helperCommand}
+		commands[1] += fmt.Sprintf(" || test $? -eq %s", SIGPIPE)
+		return getStartHelperAgentCommand(pipeFile, commands)
+	})
+	cluster.CheckClusterError(remoteOutput, "Unable to start segment helper agents", func(contentID int) string {
+		return "Unable to start segment helper agent"
+	})
+}
+
+/*
+ * This tells the helper agent on each segment that all of the tables have been
+ * processed, and waits for the agent and the commands reading or writing the
+ * data file along with it to finish.  An agent that failed reports its error
+ * in its error file.
+ */
+func (cluster *Cluster) StopHelperAgents() {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Stopping segment helper agents", func(contentID int) string {
+		pipeFile := cluster.GetSegmentPipeFilePath(contentID)
+		return fmt.Sprintf("test -s %[1]s_status || echo done > %[1]s_control; while test ! -s %[1]s_status; do sleep 0.1; done; if test -s %[1]s_error; then cat %[1]s_error >&2; exit 1; fi; exit $(cat %[1]s_status)", pipeFile)
+	})
+	cluster.CheckClusterError(remoteOutput, "Segment helper agents failed", func(contentID int) string {
+		if errMsg := strings.TrimSpace(remoteOutput.Stderrs[contentID]); errMsg != "" {
+			return fmt.Sprintf("Helper agent failed: %s", errMsg)
 		}
-		return fmt.Sprintf("nohup tail -n +1 -f %s > %s &", pipeFile, backupFile)
+		return "Helper agent or the commands reading or writing its data file failed"
 	})
-	cluster.CheckClusterError(remoteOutput, "Unable to read from segment data pipes", func(contentID int) string {
-		return "Unable to read from segment data pipe"
+}
+
+/*
+ * If the backup or restore fails, any helper agent still running is stopped,
+ * which for a backup writes the segment TOC for the tables backed up so far,
+ * and the error of any agent that failed or any table it could not restore is
+ * logged.
+ */
+func (cluster *Cluster) CleanUpHelperAgents() {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Cleaning up segment helper agents", func(contentID int) string {
+		pipeFile := cluster.GetSegmentPipeFilePath(contentID)
+		return fmt.Sprintf("if test -p %[1]s_control && test ! -s %[1]s_status; then kill $(cat %[1]s_pid 2>/dev/null) 2>/dev/null; for i in $(seq 50); do test -s %[1]s_status && break; sleep 0.1; done; fi; cat %[1]s_error %[1]s_*_error 2>/dev/null; true", pipeFile)
 	})
+	cluster.CheckClusterError(remoteOutput, "Unable to clean up segment helper agents", func(contentID int) string {
+		return "Unable to clean up segment helper agent"
+	}, true)
+	for contentID, stdout := range remoteOutput.Stdouts {
+		if errMsg := strings.TrimSpace(stdout); errMsg != "" {
+			logger.Error("Helper agent on segment %d on host %s failed: %s", contentID, cluster.GetHostForContent(contentID), errMsg)
+		}
+	}
+}
+
+// Errors from a data file truncated by the interruption are ignored here; the byte count is checked instead
+func (cluster *Cluster) getReadResumeFileCommand(filename string) string {
+	commands := []string{fmt.Sprintf("cat %s", filename)}
+	if usingEncryption {
+		commands = append(commands, encryptionProgram.DecryptCommand)
+	}
+	if usingCompression && !usingCompressedFrames {
+		commands = append(commands, compressionProgram.DecompressCommand)
+	}
+	return strings.Join(commands, " | ") + " 2>/dev/null"
 }
 
-func (cluster *Cluster) CleanUpSegmentTailProcesses() {
-	remoteOutput := cluster.GenerateAndExecuteCommand("Cleaning up segment tail processes", func(contentID int) string {
-		filePattern := fmt.Sprintf("gpbackup_%d_%s", contentID, cluster.Timestamp) // Matches pipe name for backup and file name for restore
-		return fmt.Sprintf(`ps ux | grep "tail -n +1 -f" | grep "%s" | grep -v "grep" | awk '{print $2}' | xargs kill -9`, filePattern)
+func (cluster *Cluster) ReadSegmentTOCs() map[int]*SegmentTOC {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Reading segment table of contents files", func(contentID int) string {
+		return fmt.Sprintf("cat %s", cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID)))
 	})
-	cluster.CheckClusterError(remoteOutput, "Unable to clean up tail processes", func(contentID int) string {
-		return "Unable to clean up tail process"
+	cluster.CheckClusterError(remoteOutput, "Unable to read segment table of contents files", func(contentID int) string {
+		return fmt.Sprintf("Unable to read file %s", cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID)))
+	})
+	tocs := make(map[int]*SegmentTOC, len(remoteOutput.Stdouts))
+	for contentID, stdout := range remoteOutput.Stdouts {
+		toc := &SegmentTOC{}
+		tocFile := cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID))
+		mustParseYAMLFile([]byte(stdout), "Table of contents file", tocFile, cluster.GetHostForContent(contentID), toc)
+		tocs[contentID] = toc
+	}
+	return tocs
+}
+
+/*
+ * The data kept from an interrupted single-data-file backup on a segment, which
+ * ends with the data for the last table that was backed up on every segment.
+ * With compressed frames, the frames are kept as they are, so the data file is
+ * only read up to the end of the last frame kept.
+ */
+type ResumeByteCount struct {
+	Bytes           uint64
+	CompressedBytes uint64
+}
+
+func (count ResumeByteCount) fileBytes() uint64 {
+	if usingCompressedFrames {
+		return count.CompressedBytes
+	}
+	return count.Bytes
+}
+
+/*
+ * resumeByteCounts maps each content ID to the amount of data to keep from the
+ * interrupted backup.  The data file is set aside to be read back by
+ * StartBackupHelperAgentsForResume, and the segment TOC is updated so that the
+ * helper records the remaining data after the data being kept.  If no data is
+ * to be kept, resumeByteCounts is nil and the segment TOCs are removed instead.
+ */
+func (cluster *Cluster) PrepareSegmentDataFilesForResume(resumeByteCounts map[int]ResumeByteCount) {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Preparing segment data files for resumed backup", func(contentID int) string {
+		pipeFile := cluster.GetSegmentPipeFilePath(contentID)
+		tocFile := cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID))
+		backupFile := cluster.GetTableBackupFilePath(contentID, 0, true)
+		if resumeByteCounts == nil {
+			return fmt.Sprintf("rm -f %s* %s", pipeFile, tocFile)
+		}
+		byteCount := resumeByteCounts[contentID].fileBytes()
+		updateTOCCommand := fmt.Sprintf("sed -i 's/^lastbyteread: .*/lastbyteread: %d/' %s", resumeByteCounts[contentID].Bytes, tocFile)
+		if usingCompressedFrames {
+			updateTOCCommand = fmt.Sprintf("sed -i -e 's/^lastbyteread: .*/lastbyteread: %d/' -e 's/^lastcompressedbyteread: .*/lastcompressedbyteread: %d/' %s", resumeByteCounts[contentID].Bytes, byteCount, tocFile)
+		}
+		if byteCount == 0 {
+			return fmt.Sprintf("rm -f %s* && %s", pipeFile, updateTOCCommand)
+		}
+		checkCommand := fmt.Sprintf("test \"$(%s | head -c %d | wc -c)\" -eq %d", cluster.getReadResumeFileCommand(backupFile), byteCount, byteCount)
+		return fmt.Sprintf("rm -f %s* && %s && mv %s %s.resume && %s", pipeFile, checkCommand, backupFile, backupFile, updateTOCCommand)
+	})
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to prepare segment data files to resume backup %s", cluster.Timestamp), func(contentID int) string {
+		return fmt.Sprintf("Data file %s is missing or does not contain the data for all tables already backed up", cluster.GetTableBackupFilePath(contentID, 0, true))
 	})
 }
 
@@ -286,8 +639,8 @@ func (cluster *Cluster) MoveSegmentTOCsAndMakeReadOnly() {
 func (cluster *Cluster) CopySegmentTOCs() {
 	remoteOutput := cluster.GenerateAndExecuteCommand("Copying segment table of contents files from backup directories", func(contentID int) string {
 		tocFile := cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID))
-		tocFilename := fmt.Sprintf("gpbackup_%d_%s_toc.yaml", contentID, cluster.Timestamp)
-		return fmt.Sprintf("cp -f %s/%s %s", cluster.GetDirForContent(contentID), tocFilename, tocFile)
+		backupTOCFile := cluster.GetSegmentTOCFilePath(cluster.GetDirForContent(contentID), fmt.Sprintf("%d", contentID))
+		return fmt.Sprintf("cp -f %s %s", backupTOCFile, tocFile)
 	})
 	cluster.CheckClusterError(remoteOutput, "Unable to copy segment table of contents files from backup directories", func(contentID int) string {
 		return fmt.Sprintf("Unable to copy file %s", cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID)))
@@ -319,6 +672,72 @@ func (cluster *Cluster) VerifyBackupFileCountOnSegments(fileCount int) {
 	}
 }
 
+// This returns the size in bytes of each data file on each segment, keyed by file name
+func (cluster *Cluster) GetDataFileSizes() map[int]map[string]uint64 {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Getting sizes of segment data files", func(contentID int) string {
+		return fmt.Sprintf("stat -c '%%s %%n' %s/gpbackup_%d_%s_*", cluster.GetDirForContent(contentID), contentID, cluster.Timestamp)
+	})
+	cluster.CheckClusterError(remoteOutput, "Unable to get sizes of segment data files", func(contentID int) string {
+		return "Unable to get sizes of segment data files"
+	})
+	sizes := make(map[int]map[string]uint64, len(remoteOutput.Stdouts))
+	for contentID, stdout := range remoteOutput.Stdouts {
+		sizes[contentID] = make(map[string]uint64, 0)
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			size, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				continue
+			}
+			sizes[contentID][path.Base(fields[1])] = size
+		}
+	}
+	return sizes
+}
+
+/*
+ * In a compressed backup with a data file per table, gpbackup_helper appends
+ * the sizes of each table's data before and after compression to a file in each
+ * segment data directory, which is removed once it has been read.  A table
+ * backed up again by a resumed backup has a line for each time, of which the
+ * last is kept.
+ */
+type DataByteCount struct {
+	Bytes           uint64
+	CompressedBytes uint64
+}
+
+func (cluster *Cluster) ReadSegmentByteCounts() map[int]map[uint32]DataByteCount {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Reading sizes of segment data", func(contentID int) string {
+		byteCountFile := cluster.GetSegmentByteCountFilePath(contentID)
+		return fmt.Sprintf("if test -e %[1]s; then cat %[1]s && rm -f %[1]s; fi", byteCountFile)
+	})
+	cluster.CheckClusterError(remoteOutput, "Unable to read sizes of segment data", func(contentID int) string {
+		return fmt.Sprintf("Unable to read file %s", cluster.GetSegmentByteCountFilePath(contentID))
+	})
+	byteCounts := make(map[int]map[uint32]DataByteCount, len(remoteOutput.Stdouts))
+	for contentID, stdout := range remoteOutput.Stdouts {
+		byteCounts[contentID] = make(map[uint32]DataByteCount, 0)
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			oid, oidErr := strconv.ParseUint(fields[0], 10, 32)
+			numBytes, bytesErr := strconv.ParseUint(fields[1], 10, 64)
+			numCompressedBytes, compressedErr := strconv.ParseUint(fields[2], 10, 64)
+			if oidErr != nil || bytesErr != nil || compressedErr != nil {
+				continue
+			}
+			byteCounts[contentID][uint32(oid)] = DataByteCount{Bytes: numBytes, CompressedBytes: numCompressedBytes}
+		}
+	}
+	return byteCounts
+}
+
 func (cluster *Cluster) CleanUpSegmentTOCs() {
 	remoteOutput := cluster.GenerateAndExecuteCommand("Removing segment table of contents files from segment data directories", func(contentID int) string {
 		tocFile := cluster.GetSegmentTOCFilePath(cluster.SegDirMap[contentID], fmt.Sprintf("%d", contentID))
@@ -337,7 +756,7 @@ func (cluster *Cluster) LogFatalError(errMessage string, numErrors int) {
 	if numErrors != 1 {
 		s = "s"
 	}
-	logger.Fatal(errors.Errorf("%s on %d segment%s. See %s for a complete list of segments with errors.", errMessage, numErrors, s, logger.GetLogFilePath()), "")
+	logger.Fatal(NewSegmentError("%s on %d segment%s. See %s for a complete list of segments with errors.", errMessage, numErrors, s, logger.GetLogFilePath()), "")
 }
 
 func (cluster *Cluster) GetContentList() []int {
@@ -370,6 +789,15 @@ func (cluster *Cluster) GetSegmentPipePathForCopyCommand() string {
 	return fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_%s_pipe", cluster.Timestamp)
 }
 
+func (cluster *Cluster) GetSegmentByteCountFilePath(contentID int) string {
+	templateFilePath := cluster.GetSegmentByteCountPathForCopyCommand()
+	return cluster.replaceCopyFormatStringsInPath(templateFilePath, contentID)
+}
+
+func (cluster *Cluster) GetSegmentByteCountPathForCopyCommand() string {
+	return fmt.Sprintf("<SEG_DATA_DIR>/gpbackup_<SEGID>_%s_byte_counts", cluster.Timestamp)
+}
+
 func (cluster *Cluster) GetTableBackupFilePath(contentID int, tableOid uint32, singleDataFile bool) string {
 	templateFilePath := cluster.GetTableBackupFilePathForCopyCommand(tableOid, singleDataFile)
 	return cluster.replaceCopyFormatStringsInPath(templateFilePath, contentID)
@@ -395,11 +823,17 @@ func (cluster *Cluster) GetTableBackupFilePathForCopyCommand(tableOid uint32, si
  */
 
 var metadataFilenameMap = map[string]string{
-	"config":            "config.yaml",
-	"metadata":          "metadata.sql",
-	"statistics":        "statistics.sql",
-	"table of contents": "toc.yaml",
-	"report":            "report",
+	"config":              "config.yaml",
+	"metadata":            "metadata.sql",
+	"statistics":          "statistics.sql",
+	"table of contents":   "toc.yaml",
+	"report":              "report",
+	"json report":         "report.json",
+	"restore report":      "restore_report",
+	"restore json report": "restore_report.json",
+	"verify report":       "verify_report",
+	"progress":            "progress.yaml",
+	"data progress":       "data_progress",
 }
 
 func (cluster *Cluster) GetBackupFilePath(filetype string) string {
@@ -422,6 +856,31 @@ func (cluster *Cluster) GetReportFilePath() string {
 	return cluster.GetBackupFilePath("report")
 }
 
+func (cluster *Cluster) GetJSONReportFilePath() string {
+	return cluster.GetBackupFilePath("json report")
+}
+
+/*
+ * A backup may be restored or verified any number of times, so the reports of
+ * gprestore are named for the time at which it started as well as for the
+ * backup, as gpbackup_<backup timestamp>_<restore timestamp>_restore_report.
+ */
+func (cluster *Cluster) getRestoreFilePath(filetype string, restoreTimestamp string) string {
+	return path.Join(cluster.GetDirForContent(-1), fmt.Sprintf("gpbackup_%s_%s_%s", cluster.Timestamp, restoreTimestamp, metadataFilenameMap[filetype]))
+}
+
+func (cluster *Cluster) GetRestoreReportFilePath(restoreTimestamp string) string {
+	return cluster.getRestoreFilePath("restore report", restoreTimestamp)
+}
+
+func (cluster *Cluster) GetRestoreJSONReportFilePath(restoreTimestamp string) string {
+	return cluster.getRestoreFilePath("restore json report", restoreTimestamp)
+}
+
+func (cluster *Cluster) GetVerifyReportFilePath(verifyTimestamp string) string {
+	return cluster.getRestoreFilePath("verify report", verifyTimestamp)
+}
+
 func (cluster *Cluster) GetConfigFilePath() string {
 	return cluster.GetBackupFilePath("config")
 }
@@ -15,6 +15,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 )
 
 var _ = Describe("utils/cluster tests", func() {
@@ -293,6 +294,14 @@ var _ = Describe("utils/cluster tests", func() {
 			Expect(cluster.GetReportFilePath()).To(Equal("/foo/bar/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_report"))
 		})
 	})
+	Describe("GetRestoreReportFilePath, GetRestoreJSONReportFilePath, and GetVerifyReportFilePath", func() {
+		It("returns report file paths named for the backup and the start of the restore", func() {
+			cluster := utils.NewCluster([]utils.SegConfig{masterSeg}, "", "20170101010101", "gpseg")
+			Expect(cluster.GetRestoreReportFilePath("20170102030405")).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_20170102030405_restore_report"))
+			Expect(cluster.GetRestoreJSONReportFilePath("20170102030405")).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_20170102030405_restore_report.json"))
+			Expect(cluster.GetVerifyReportFilePath("20170102030405")).To(Equal("/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_20170102030405_verify_report"))
+		})
+	})
 	Describe("GetTableBackupFilePath", func() {
 		It("returns table file path", func() {
 			cluster := utils.NewCluster([]utils.SegConfig{masterSeg}, "", "20170101010101", "gpseg")
@@ -416,6 +425,139 @@ var _ = Describe("utils/cluster tests", func() {
 			testCluster.CreateBackupDirectoriesOnAllHosts()
 		})
 	})
+	Describe("ReadSegmentTOCs", func() {
+		It("reads and parses the table of contents file on each segment", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{Stdouts: map[int]string{
+				0: "lastbyteread: 20\ndataentries:\n  1234:\n    startbyte: 0\n    endbyte: 20\n",
+				1: "lastbyteread: 0\ndataentries: {}\n",
+			}}
+			tocs := testCluster.ReadSegmentTOCs()
+			Expect(testExecutor.ClusterCommands[0][0]).To(Equal([]string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@localhost", "cat /data/gpseg0/gpbackup_0_20170101010101_toc.yaml"}))
+			Expect(tocs[0].LastByteRead).To(Equal(uint64(20)))
+			Expect(tocs[0].DataEntries[1234]).To(Equal(utils.SegmentDataEntry{StartByte: 0, EndByte: 20}))
+			Expect(tocs[1].DataEntries).To(BeEmpty())
+		})
+	})
+	Describe("ReadSegmentByteCounts", func() {
+		It("reads and removes the byte count file on each segment", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{Stdouts: map[int]string{
+				0: "1234 100 10\n5678 200 20\n1234 300 30\n",
+				1: "",
+			}}
+			byteCounts := testCluster.ReadSegmentByteCounts()
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("if test -e /data/gpseg0/gpbackup_0_20170101010101_byte_counts; then cat /data/gpseg0/gpbackup_0_20170101010101_byte_counts && rm -f /data/gpseg0/gpbackup_0_20170101010101_byte_counts; fi"))
+			Expect(byteCounts[0]).To(Equal(map[uint32]utils.DataByteCount{1234: {Bytes: 300, CompressedBytes: 30}, 5678: {Bytes: 200, CompressedBytes: 20}}))
+			Expect(byteCounts[1]).To(BeEmpty())
+		})
+	})
+	Describe("PrepareSegmentDataFilesForResume", func() {
+		BeforeEach(func() {
+			utils.SetCompressionParameters(false, utils.Compression{})
+			testExecutor.ClusterOutput = &utils.RemoteOutput{}
+		})
+		It("sets aside each data file and updates each segment TOC to keep the data already backed up", func() {
+			testCluster.PrepareSegmentDataFilesForResume(map[int]utils.ResumeByteCount{0: {Bytes: 100}, 1: {}})
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal(`rm -f /data/gpseg0/gpbackup_0_20170101010101_pipe* && test "$(cat /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101 2>/dev/null | head -c 100 | wc -c)" -eq 100 && mv /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101 /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.resume && sed -i 's/^lastbyteread: .*/lastbyteread: 100/' /data/gpseg0/gpbackup_0_20170101010101_toc.yaml`))
+			Expect(testExecutor.ClusterCommands[0][1][4]).To(Equal(`rm -f /data/gpseg1/gpbackup_1_20170101010101_pipe* && sed -i 's/^lastbyteread: .*/lastbyteread: 0/' /data/gpseg1/gpbackup_1_20170101010101_toc.yaml`))
+		})
+		It("keeps the compressed frames already backed up", func() {
+			utils.InitializeCompressionParameters(true, "gzip", 1)
+			defer utils.SetCompressionParameters(false, utils.Compression{})
+			utils.SetCompressedFrames(true)
+			defer utils.SetCompressedFrames(false)
+			testCluster.PrepareSegmentDataFilesForResume(map[int]utils.ResumeByteCount{0: {Bytes: 100, CompressedBytes: 40}, 1: {}})
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal(`rm -f /data/gpseg0/gpbackup_0_20170101010101_pipe* && test "$(cat /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz 2>/dev/null | head -c 40 | wc -c)" -eq 40 && mv /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz.resume && sed -i -e 's/^lastbyteread: .*/lastbyteread: 100/' -e 's/^lastcompressedbyteread: .*/lastcompressedbyteread: 40/' /data/gpseg0/gpbackup_0_20170101010101_toc.yaml`))
+		})
+		It("removes the segment TOCs if no data is to be kept", func() {
+			testCluster.PrepareSegmentDataFilesForResume(nil)
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("rm -f /data/gpseg0/gpbackup_0_20170101010101_pipe* /data/gpseg0/gpbackup_0_20170101010101_toc.yaml"))
+		})
+		It("panics if a data file does not contain the data to be kept", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{NumErrors: 1, Errors: map[int]error{1: errors.Errorf("exit status 1")}}
+			defer testutils.ShouldPanicWithMessage("Unable to prepare segment data files to resume backup 20170101010101 on 1 segment")
+			testCluster.PrepareSegmentDataFilesForResume(map[int]utils.ResumeByteCount{0: {Bytes: 100}, 1: {Bytes: 100}})
+		})
+	})
+	Describe("helper agents", func() {
+		BeforeEach(func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{}
+		})
+		AfterEach(func() {
+			utils.SetCompressionParameters(false, utils.Compression{})
+			utils.SetEncryptionParameters(false, utils.Encryption{})
+		})
+		It("creates the control pipe for each segment's helper agent", func() {
+			testCluster.CreateSegmentPipesOnAllHosts()
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("mkfifo /data/gpseg0/gpbackup_0_20170101010101_pipe_control"))
+		})
+		It("constructs the programs with which COPY passes a table's data to and from a helper agent", func() {
+			pipeFile := "<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe"
+			Expect(utils.ConstructHelperAgentCopyProgram(pipeFile, 3456, false)).To(Equal("test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status && mkfifo <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && { (while test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status; do sleep 1; done; test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && dd if=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 of=/dev/null iflag=nonblock) < /dev/null > /dev/null 2>&1 & } && echo 3456 1<> <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_control && cat > <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && rm -f <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status"))
+			Expect(utils.ConstructHelperAgentCopyProgram(pipeFile, 3456, true)).To(Equal("test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status && mkfifo <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && { (while test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status; do sleep 1; done; test -p <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && dd if=/dev/null of=<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 oflag=nonblock conv=nocreat) < /dev/null > /dev/null 2>&1 & } && echo 3456 1<> <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_control && cat <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && rm -f <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456 && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_3456_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_error && test ! -e <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_pipe_status"))
+		})
+		It("starts a backup helper agent writing to the data file on each segment", func() {
+			testCluster.StartBackupHelperAgents(nil)
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("(nohup $GPHOME/bin/gpbackup_helper --backup-agent --toc-file=/data/gpseg0/gpbackup_0_20170101010101_toc.yaml --pipe-file=/data/gpseg0/gpbackup_0_20170101010101_pipe --content=0 > /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101; echo $? > /data/gpseg0/gpbackup_0_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+			Expect(testExecutor.ClusterCommands[0][1][4]).To(Equal("(nohup $GPHOME/bin/gpbackup_helper --backup-agent --toc-file=/data/gpseg1/gpbackup_1_20170101010101_toc.yaml --pipe-file=/data/gpseg1/gpbackup_1_20170101010101_pipe --content=1 > /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101; echo $? > /data/gpseg1/gpbackup_1_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+		})
+		It("starts backup helper agents compressing each table as a frame and writing to a plugin through encryption", func() {
+			utils.InitializeCompressionParameters(true, "gzip", 1)
+			utils.SetCompressedFrames(true)
+			defer utils.SetCompressedFrames(false)
+			utils.SetEncryptionParameters(true, utils.Encryption{EncryptCommand: "gpg --encrypt"})
+			testCluster.StartBackupHelperAgents(&utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", ConfigPath: "/tmp/plugin_config.yaml"})
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("(set -o pipefail; nohup $GPHOME/bin/gpbackup_helper --backup-agent --toc-file=/data/gpseg0/gpbackup_0_20170101010101_toc.yaml --pipe-file=/data/gpseg0/gpbackup_0_20170101010101_pipe --content=0 --compression-type=gzip --compression-level=1 | gpg --encrypt | /tmp/plugin.sh backup_data /tmp/plugin_config.yaml /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz; echo $? > /data/gpseg0/gpbackup_0_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+		})
+		It("reads the data kept from the interrupted backup before the data from the helper agent", func() {
+			utils.InitializeCompressionParameters(true, "gzip", 1)
+			testCluster.StartBackupHelperAgentsForResume(map[int]utils.ResumeByteCount{0: {Bytes: 100}, 1: {}})
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("(set -o pipefail; (cat /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz.resume | $GPHOME/bin/gpbackup_helper --decompress --compression-type=gzip 2>/dev/null | head -c 100; rm -f /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz.resume; nohup $GPHOME/bin/gpbackup_helper --backup-agent --toc-file=/data/gpseg0/gpbackup_0_20170101010101_toc.yaml --pipe-file=/data/gpseg0/gpbackup_0_20170101010101_pipe --content=0) | $GPHOME/bin/gpbackup_helper --compress --compression-type=gzip --compression-level=1 > /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz; echo $? > /data/gpseg0/gpbackup_0_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+			Expect(testExecutor.ClusterCommands[0][1][4]).To(Equal("(set -o pipefail; nohup $GPHOME/bin/gpbackup_helper --backup-agent --toc-file=/data/gpseg1/gpbackup_1_20170101010101_toc.yaml --pipe-file=/data/gpseg1/gpbackup_1_20170101010101_pipe --content=1 | $GPHOME/bin/gpbackup_helper --compress --compression-type=gzip --compression-level=1 > /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101.gz; echo $? > /data/gpseg1/gpbackup_1_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+		})
+		It("reads compressed frames kept from the interrupted backup without compressing them again", func() {
+			utils.InitializeCompressionParameters(true, "gzip", 1)
+			utils.SetCompressedFrames(true)
+			defer utils.SetCompressedFrames(false)
+			testCluster.StartBackupHelperAgentsForResume(map[int]utils.ResumeByteCount{0: {Bytes: 100, CompressedBytes: 40}, 1: {}})
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("((cat /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz.resume 2>/dev/null | head -c 40; rm -f /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz.resume; nohup $GPHOME/bin/gpbackup_helper --backup-agent --toc-file=/data/gpseg0/gpbackup_0_20170101010101_toc.yaml --pipe-file=/data/gpseg0/gpbackup_0_20170101010101_pipe --content=0 --compression-type=gzip --compression-level=1) > /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz; echo $? > /data/gpseg0/gpbackup_0_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+			Expect(testExecutor.ClusterCommands[0][1][4]).To(Equal("(nohup $GPHOME/bin/gpbackup_helper --backup-agent --toc-file=/data/gpseg1/gpbackup_1_20170101010101_toc.yaml --pipe-file=/data/gpseg1/gpbackup_1_20170101010101_pipe --content=1 --compression-type=gzip --compression-level=1 > /data/gpseg1/backups/20170101/20170101010101/gpbackup_1_20170101010101.gz; echo $? > /data/gpseg1/gpbackup_1_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+		})
+		It("starts a restore helper agent reading the data file on each segment", func() {
+			utils.InitializeCompressionParameters(true, "gzip", 1)
+			utils.SetCompressedFrames(true)
+			defer utils.SetCompressedFrames(false)
+			testCluster.StartRestoreHelperAgents()
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("(nohup $GPHOME/bin/gpbackup_helper --restore-agent --toc-file=/data/gpseg0/gpbackup_0_20170101010101_toc.yaml --pipe-file=/data/gpseg0/gpbackup_0_20170101010101_pipe --content=0 --data-file=/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz; echo $? > /data/gpseg0/gpbackup_0_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+		})
+		It("starts restore helper agents decompressing data compressed as a single stream", func() {
+			utils.InitializeCompressionParameters(true, "gzip", 1)
+			testCluster.StartRestoreHelperAgents()
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("(nohup $GPHOME/bin/gpbackup_helper --restore-agent --toc-file=/data/gpseg0/gpbackup_0_20170101010101_toc.yaml --pipe-file=/data/gpseg0/gpbackup_0_20170101010101_pipe --content=0 --compression-type=gzip --data-file=/data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101.gz; echo $? > /data/gpseg0/gpbackup_0_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+		})
+		It("starts restore helper agents reading an encrypted data file as a stream", func() {
+			utils.SetEncryptionParameters(true, utils.Encryption{DecryptCommand: "gpg --decrypt"})
+			testCluster.StartRestoreHelperAgents()
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("(set -o pipefail; gpg --decrypt < /data/gpseg0/backups/20170101/20170101010101/gpbackup_0_20170101010101 | nohup $GPHOME/bin/gpbackup_helper --restore-agent --toc-file=/data/gpseg0/gpbackup_0_20170101010101_toc.yaml --pipe-file=/data/gpseg0/gpbackup_0_20170101010101_pipe --content=0 || test $? -eq 141; echo $? > /data/gpseg0/gpbackup_0_20170101010101_pipe_status) > /dev/null 2>&1 &"))
+		})
+		It("stops the helper agents and waits for them to finish", func() {
+			testCluster.StopHelperAgents()
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("test -s /data/gpseg0/gpbackup_0_20170101010101_pipe_status || echo done > /data/gpseg0/gpbackup_0_20170101010101_pipe_control; while test ! -s /data/gpseg0/gpbackup_0_20170101010101_pipe_status; do sleep 0.1; done; if test -s /data/gpseg0/gpbackup_0_20170101010101_pipe_error; then cat /data/gpseg0/gpbackup_0_20170101010101_pipe_error >&2; exit 1; fi; exit $(cat /data/gpseg0/gpbackup_0_20170101010101_pipe_status)"))
+		})
+		It("panics if a helper agent failed", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{NumErrors: 1, Errors: map[int]error{1: errors.Errorf("exit status 1")}, Stderrs: map[int]string{1: "Segment 1: Checksum verification failed for table with oid 2\n"}}
+			defer func() {
+				Expect(logfile).To(gbytes.Say("Helper agent failed: Segment 1: Checksum verification failed for table with oid 2 on segment 1 on host"))
+			}()
+			defer testutils.ShouldPanicWithMessage("Segment helper agents failed on 1 segment")
+			testCluster.StopHelperAgents()
+		})
+		It("stops any helper agent still running when cleaning up and logs any agent's error", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{Stdouts: map[int]string{0: "", 1: "Segment 1: Error copying table with oid 2: unexpected EOF\n"}}
+			testCluster.CleanUpHelperAgents()
+			Expect(testExecutor.ClusterCommands[0][0][4]).To(Equal("if test -p /data/gpseg0/gpbackup_0_20170101010101_pipe_control && test ! -s /data/gpseg0/gpbackup_0_20170101010101_pipe_status; then kill $(cat /data/gpseg0/gpbackup_0_20170101010101_pipe_pid 2>/dev/null) 2>/dev/null; for i in $(seq 50); do test -s /data/gpseg0/gpbackup_0_20170101010101_pipe_status && break; sleep 0.1; done; fi; cat /data/gpseg0/gpbackup_0_20170101010101_pipe_error /data/gpseg0/gpbackup_0_20170101010101_pipe_*_error 2>/dev/null; true"))
+			Expect(logfile).To(gbytes.Say("Helper agent on segment 1 on host remotehost1 failed: Segment 1: Error copying table with oid 2: unexpected EOF"))
+		})
+	})
 	Describe("ParseSegPrefix", func() {
 		AfterEach(func() {
 			utils.System.Glob = filepath.Glob
@@ -446,11 +588,12 @@ var _ = Describe("utils/cluster tests", func() {
 			defer utils.SetCompressionParameters(useCompress, compression)
 			expectedCompress := utils.Compression{
 				Name:              "gzip",
-				CompressCommand:   "gzip -c -3",
-				DecompressCommand: "gzip -d -c",
+				Level:             3,
+				CompressCommand:   "$GPHOME/bin/gpbackup_helper --compress --compression-type=gzip --compression-level=3",
+				DecompressCommand: "$GPHOME/bin/gpbackup_helper --decompress --compression-type=gzip",
 				Extension:         ".gz",
 			}
-			utils.InitializeCompressionParameters(false, 3)
+			utils.InitializeCompressionParameters(false, "gzip", 3)
 			resultUseCompress, resultCompression := utils.GetCompressionParameters()
 			Expect(resultUseCompress).To(BeFalse())
 			testutils.ExpectStructsToMatch(&expectedCompress, &resultCompression)
@@ -460,11 +603,12 @@ var _ = Describe("utils/cluster tests", func() {
 			defer utils.SetCompressionParameters(useCompress, compression)
 			expectedCompress := utils.Compression{
 				Name:              "gzip",
-				CompressCommand:   "gzip -c -7",
-				DecompressCommand: "gzip -d -c",
+				Level:             7,
+				CompressCommand:   "$GPHOME/bin/gpbackup_helper --compress --compression-type=gzip --compression-level=7",
+				DecompressCommand: "$GPHOME/bin/gpbackup_helper --decompress --compression-type=gzip",
 				Extension:         ".gz",
 			}
-			utils.InitializeCompressionParameters(true, 7)
+			utils.InitializeCompressionParameters(true, "gzip", 7)
 			resultUseCompress, resultCompression := utils.GetCompressionParameters()
 			Expect(resultUseCompress).To(BeTrue())
 			testutils.ExpectStructsToMatch(&expectedCompress, &resultCompression)
@@ -474,14 +618,73 @@ var _ = Describe("utils/cluster tests", func() {
 			defer utils.SetCompressionParameters(useCompress, compression)
 			expectedCompress := utils.Compression{
 				Name:              "gzip",
-				CompressCommand:   "gzip -c -1",
-				DecompressCommand: "gzip -d -c",
+				Level:             1,
+				CompressCommand:   "$GPHOME/bin/gpbackup_helper --compress --compression-type=gzip --compression-level=1",
+				DecompressCommand: "$GPHOME/bin/gpbackup_helper --decompress --compression-type=gzip",
+				Extension:         ".gz",
+			}
+			utils.InitializeCompressionParameters(true, "gzip", 0)
+			resultUseCompress, resultCompression := utils.GetCompressionParameters()
+			Expect(resultUseCompress).To(BeTrue())
+			testutils.ExpectStructsToMatch(&expectedCompress, &resultCompression)
+		})
+		It("defaults to gzip when passed no compression type", func() {
+			useCompress, compression := utils.GetCompressionParameters()
+			defer utils.SetCompressionParameters(useCompress, compression)
+			expectedCompress := utils.Compression{
+				Name:              "gzip",
+				Level:             1,
+				CompressCommand:   "$GPHOME/bin/gpbackup_helper --compress --compression-type=gzip --compression-level=1",
+				DecompressCommand: "$GPHOME/bin/gpbackup_helper --decompress --compression-type=gzip",
 				Extension:         ".gz",
 			}
-			utils.InitializeCompressionParameters(true, 0)
+			utils.InitializeCompressionParameters(true, "", 0)
+			resultUseCompress, resultCompression := utils.GetCompressionParameters()
+			Expect(resultUseCompress).To(BeTrue())
+			testutils.ExpectStructsToMatch(&expectedCompress, &resultCompression)
+		})
+		It("initializes properly when passed compression type zstd", func() {
+			useCompress, compression := utils.GetCompressionParameters()
+			defer utils.SetCompressionParameters(useCompress, compression)
+			expectedCompress := utils.Compression{
+				Name:              "zstd",
+				Level:             15,
+				CompressCommand:   "$GPHOME/bin/gpbackup_helper --compress --compression-type=zstd --compression-level=15",
+				DecompressCommand: "$GPHOME/bin/gpbackup_helper --decompress --compression-type=zstd",
+				Extension:         ".zst",
+			}
+			utils.InitializeCompressionParameters(true, "zstd", 15)
+			resultUseCompress, resultCompression := utils.GetCompressionParameters()
+			Expect(resultUseCompress).To(BeTrue())
+			testutils.ExpectStructsToMatch(&expectedCompress, &resultCompression)
+		})
+		It("initializes properly when passed compression type lz4", func() {
+			useCompress, compression := utils.GetCompressionParameters()
+			defer utils.SetCompressionParameters(useCompress, compression)
+			expectedCompress := utils.Compression{
+				Name:              "lz4",
+				Level:             1,
+				CompressCommand:   "$GPHOME/bin/gpbackup_helper --compress --compression-type=lz4 --compression-level=1",
+				DecompressCommand: "$GPHOME/bin/gpbackup_helper --decompress --compression-type=lz4",
+				Extension:         ".lz4",
+			}
+			utils.InitializeCompressionParameters(true, "lz4", 0)
 			resultUseCompress, resultCompression := utils.GetCompressionParameters()
 			Expect(resultUseCompress).To(BeTrue())
 			testutils.ExpectStructsToMatch(&expectedCompress, &resultCompression)
 		})
+		It("disables compression when passed compression type none", func() {
+			useCompress, compression := utils.GetCompressionParameters()
+			defer utils.SetCompressionParameters(useCompress, compression)
+			utils.InitializeCompressionParameters(true, "none", 0)
+			resultUseCompress, _ := utils.GetCompressionParameters()
+			Expect(resultUseCompress).To(BeFalse())
+		})
+		It("panics when passed an unknown compression type", func() {
+			useCompress, compression := utils.GetCompressionParameters()
+			defer utils.SetCompressionParameters(useCompress, compression)
+			defer testutils.ShouldPanicWithMessage("Unknown compression type bzip2")
+			utils.InitializeCompressionParameters(true, "bzip2", 0)
+		})
 	})
 })
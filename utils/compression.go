@@ -0,0 +1,90 @@
+package utils
+
+/*
+ * This file contains functions related to compressing and decompressing data
+ * in gpbackup_helper, which does all compression on the segments.
+ */
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+)
+
+/*
+ * The data written to the returned writer is compressed with the given type
+ * and level, and is only completely written once the writer is closed.  The
+ * writer does not close the underlying writer.
+ */
+func NewCompressionWriter(writer io.Writer, compressionType string, compressionLevel int) (io.WriteCloser, error) {
+	switch compressionType {
+	case "gzip":
+		return gzip.NewWriterLevel(writer, compressionLevel)
+	case "lz4":
+		lz4Writer := lz4.NewWriter(writer)
+		err := lz4Writer.Apply(lz4.CompressionLevelOption(getLZ4CompressionLevel(compressionLevel)))
+		return lz4Writer, err
+	case "zstd":
+		return zstd.NewWriter(writer, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compressionLevel)))
+	}
+	return nil, errors.Errorf("Unknown compression type %s", compressionType)
+}
+
+/*
+ * The lz4 utility uses its fast compression at level 1 and high compression at
+ * higher levels.  The library only supports high compression up to level 9, so
+ * higher levels are rejected when the flags are validated.
+ */
+func getLZ4CompressionLevel(compressionLevel int) lz4.CompressionLevel {
+	if compressionLevel <= 1 {
+		return lz4.Fast
+	}
+	return lz4.CompressionLevel(1 << uint(8+compressionLevel))
+}
+
+/*
+ * As with the command-line utilities, a sequence of compressed frames is read
+ * as a single stream.
+ */
+func NewDecompressionReader(reader io.Reader, compressionType string) (io.ReadCloser, error) {
+	switch compressionType {
+	case "gzip":
+		return gzip.NewReader(reader)
+	case "lz4":
+		bufferedReader := bufio.NewReader(reader)
+		return ioutil.NopCloser(&lz4FramesReader{lz4.NewReader(bufferedReader), bufferedReader}), nil
+	case "zstd":
+		zstdReader, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader.IOReadCloser(), nil
+	}
+	return nil, errors.Errorf("Unknown compression type %s", compressionType)
+}
+
+// The lz4 library stops reading at the end of the first frame
+type lz4FramesReader struct {
+	reader         *lz4.Reader
+	bufferedReader *bufio.Reader
+}
+
+func (r *lz4FramesReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	if _, peekErr := r.bufferedReader.Peek(1); peekErr != nil {
+		return n, io.EOF
+	}
+	r.reader.Reset(r.bufferedReader)
+	if n > 0 {
+		return n, nil
+	}
+	return r.Read(p)
+}
@@ -0,0 +1,52 @@
+package utils_test
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/compression tests", func() {
+	compress := func(compressionType string, level int, data string) []byte {
+		var compressed bytes.Buffer
+		writer, err := utils.NewCompressionWriter(&compressed, compressionType, level)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = writer.Write([]byte(data))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.Close()).To(Succeed())
+		return compressed.Bytes()
+	}
+	decompress := func(compressionType string, compressed []byte) string {
+		reader, err := utils.NewDecompressionReader(bytes.NewReader(compressed), compressionType)
+		Expect(err).ToNot(HaveOccurred())
+		defer reader.Close()
+		contents, err := ioutil.ReadAll(reader)
+		Expect(err).ToNot(HaveOccurred())
+		return string(contents)
+	}
+	for _, compressionType := range []string{"gzip", "lz4", "zstd"} {
+		compressionType := compressionType
+		Describe(compressionType, func() {
+			It("decompresses the data it compresses at each level", func() {
+				maxLevel, _ := utils.GetMaxCompressionLevel(compressionType)
+				for level := 1; level <= maxLevel; level++ {
+					Expect(decompress(compressionType, compress(compressionType, level, "some text\n"))).To(Equal("some text\n"))
+				}
+			})
+			It("decompresses a sequence of frames as a single stream", func() {
+				frames := append(compress(compressionType, 1, "some "), compress(compressionType, 1, "text\n")...)
+				Expect(decompress(compressionType, frames)).To(Equal("some text\n"))
+			})
+		})
+	}
+	It("returns an error for an unknown compression type", func() {
+		_, err := utils.NewCompressionWriter(&bytes.Buffer{}, "bzip2", 1)
+		Expect(err).To(MatchError("Unknown compression type bzip2"))
+		_, err = utils.NewDecompressionReader(&bytes.Buffer{}, "bzip2")
+		Expect(err).To(MatchError("Unknown compression type bzip2"))
+	})
+})
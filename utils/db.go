@@ -43,8 +43,10 @@ type DBConn struct {
 	DBName   string
 	Host     string
 	Port     int
-	Tx       *sqlx.Tx
+	Tx       []*sqlx.Tx
 	Version  GPDBVersion
+
+	BackendPIDs []int
 }
 
 func NewDBConn(dbname string) *DBConn {
@@ -74,33 +76,26 @@ func NewDBConn(dbname string) *DBConn {
 }
 
 /*
- * This function doesn't allow Begin()ing if there are multiple connections,
- * since for example Connect()ing with a pool of 2 connections and trying to
- * Select() on connection 1 doesn't really make sense given the "automatic
- * transaction" behavior of Get/Exec/Select, as explained in the relevant
- * comment below.
- *
- * TODO: Refactor transaction logic to allow starting multiple transactions
- * on different connections while leaving other connections transaction-less,
- * in such a way that one cannot easily accidentally execute a query intended
- * to be part of a transaction on a transaction-less connection or vice versa.
+ * Each connection in the pool may have at most one transaction in progress, and
+ * the Exec, Select, and Get functions will execute a query as part of the
+ * transaction on the given connection if there is one.  Transactions must be
+ * begun and committed on each connection individually, so connections used for
+ * work outside of a transaction can coexist with those that are in one.
  */
-func (dbconn *DBConn) Begin() {
-	if dbconn.NumConns > 1 {
-		logger.Fatal(errors.New("Cannot begin transaction; the connection was initialized with a connection pool size larger than 1"), "")
-	}
-	if dbconn.Tx != nil {
+func (dbconn *DBConn) Begin(whichConn ...int) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	if dbconn.Tx[connNum] != nil {
 		logger.Fatal(errors.New("Cannot begin transaction; there is already a transaction in progress"), "")
 	}
 	var err error
-	dbconn.Tx, err = dbconn.ConnPool[0].Beginx()
+	dbconn.Tx[connNum], err = dbconn.ConnPool[connNum].Beginx()
 	CheckError(err)
 	/*
 	 * This uses a SERIALIZABLE transaction so the backup can effectively take a
 	 * "snapshot" of the database via MVCC, to keep backups consistent without
 	 * requiring a pg_class lock.
 	 */
-	dbconn.MustExec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")
+	dbconn.MustExec("SET TRANSACTION ISOLATION LEVEL SERIALIZABLE", connNum)
 }
 
 func (dbconn *DBConn) Close() {
@@ -111,17 +106,19 @@ func (dbconn *DBConn) Close() {
 			}
 		}
 		dbconn.ConnPool = nil
+		dbconn.Tx = nil
 		dbconn.NumConns = 0
 	}
 }
 
-func (dbconn *DBConn) Commit() {
-	if dbconn.Tx == nil {
+func (dbconn *DBConn) Commit(whichConn ...int) {
+	connNum := dbconn.ValidateConnNum(whichConn...)
+	if dbconn.Tx[connNum] == nil {
 		logger.Fatal(errors.New("Cannot commit transaction; there is no transaction in progress"), "")
 	}
-	err := dbconn.Tx.Commit()
+	err := dbconn.Tx[connNum].Commit()
 	CheckError(err)
-	dbconn.Tx = nil
+	dbconn.Tx[connNum] = nil
 }
 
 func (dbconn *DBConn) Connect(numConns int) {
@@ -131,12 +128,10 @@ func (dbconn *DBConn) Connect(numConns int) {
 	if dbconn.ConnPool != nil {
 		logger.Fatal(errors.Errorf("The database connection must be closed before reusing the connection"), "")
 	}
-	dbname := escapeConnectionParam(dbconn.DBName)
-	user := escapeConnectionParam(dbconn.User)
-	connStr := fmt.Sprintf(`user='%s' dbname='%s' host=%s port=%d sslmode=disable`, user, dbname, dbconn.Host, dbconn.Port)
 	dbconn.ConnPool = make([]*sqlx.DB, numConns)
+	dbconn.Tx = make([]*sqlx.Tx, numConns)
 	for i := 0; i < numConns; i++ {
-		conn, err := dbconn.Driver.Connect("postgres", connStr)
+		conn, err := dbconn.Driver.Connect("postgres", dbconn.connectionString())
 		dbconn.handleConnectionError(err)
 		conn.SetMaxOpenConns(1)
 		conn.SetMaxIdleConns(1)
@@ -145,21 +140,63 @@ func (dbconn *DBConn) Connect(numConns int) {
 	dbconn.NumConns = numConns
 }
 
+func (dbconn *DBConn) connectionString() string {
+	dbname := escapeConnectionParam(dbconn.DBName)
+	user := escapeConnectionParam(dbconn.User)
+	return fmt.Sprintf(`user='%s' dbname='%s' host=%s port=%d sslmode=disable`, user, dbname, dbconn.Host, dbconn.Port)
+}
+
+/*
+ * A query cannot be canceled using the connection that is executing it, so the
+ * backend process ID of each connection is recorded for CancelQueries() to
+ * cancel its queries from a separate connection.
+ */
+func (dbconn *DBConn) RecordBackendPIDs() {
+	pids := make([]int, dbconn.NumConns)
+	for connNum := 0; connNum < dbconn.NumConns; connNum++ {
+		err := dbconn.Get(&pids[connNum], "SELECT pg_backend_pid()", connNum)
+		CheckError(err)
+	}
+	dbconn.BackendPIDs = pids
+}
+
+/*
+ * This is called from the signal handler goroutine, in which a panic could not
+ * be recovered, so errors are returned instead of being fatal.
+ */
+func (dbconn *DBConn) CancelQueries() error {
+	if len(dbconn.BackendPIDs) == 0 {
+		return nil
+	}
+	cancelConn, err := dbconn.Driver.Connect("postgres", dbconn.connectionString())
+	if err != nil {
+		return err
+	}
+	defer cancelConn.Close()
+	for _, pid := range dbconn.BackendPIDs {
+		_, err = cancelConn.Exec(fmt.Sprintf("SELECT pg_cancel_backend(%d)", pid))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (dbconn *DBConn) handleConnectionError(err error) {
 	if err != nil {
 		if strings.Contains(err.Error(), "does not exist") {
 			if strings.Contains(err.Error(), "pq: role") {
-				logger.Fatal(errors.Errorf(`Role "%s" does not exist, exiting`, dbconn.User), "")
+				logger.Fatal(NewConnectionError(`Role "%s" does not exist, exiting`, dbconn.User), "")
 			} else if strings.Contains(err.Error(), "pq: database") {
-				logger.Fatal(errors.Errorf(`Database "%s" does not exist, exiting`, dbconn.DBName), "")
+				logger.Fatal(NewConnectionError(`Database "%s" does not exist, exiting`, dbconn.DBName), "")
 			}
 		} else if strings.Contains(err.Error(), "connection refused") {
-			logger.Fatal(errors.Errorf(`could not connect to server: Connection refused
+			logger.Fatal(NewConnectionError(`could not connect to server: Connection refused
 	Is the server running on host "%s" and accepting
 	TCP/IP connections on port %d?`, dbconn.Host, dbconn.Port), "")
 		}
+		logger.Fatal(NewExitCodeError(EXIT_CONNECTION_ERROR, err), "")
 	}
-	CheckError(err)
 }
 
 /*
@@ -170,10 +207,10 @@ func (dbconn *DBConn) handleConnectionError(err error) {
  */
 
 func (dbconn *DBConn) Exec(query string, whichConn ...int) (sql.Result, error) {
-	if dbconn.Tx != nil {
-		return dbconn.Tx.Exec(query)
-	}
 	connNum := dbconn.ValidateConnNum(whichConn...)
+	if dbconn.Tx[connNum] != nil {
+		return dbconn.Tx[connNum].Exec(query)
+	}
 	return dbconn.ConnPool[connNum].Exec(query)
 }
 
@@ -183,18 +220,18 @@ func (dbconn *DBConn) MustExec(query string, whichConn ...int) {
 }
 
 func (dbconn *DBConn) Get(destination interface{}, query string, whichConn ...int) error {
-	if dbconn.Tx != nil {
-		return dbconn.Tx.Get(destination, query)
-	}
 	connNum := dbconn.ValidateConnNum(whichConn...)
+	if dbconn.Tx[connNum] != nil {
+		return dbconn.Tx[connNum].Get(destination, query)
+	}
 	return dbconn.ConnPool[connNum].Get(destination, query)
 }
 
 func (dbconn *DBConn) Select(destination interface{}, query string, whichConn ...int) error {
-	if dbconn.Tx != nil {
-		return dbconn.Tx.Select(destination, query)
-	}
 	connNum := dbconn.ValidateConnNum(whichConn...)
+	if dbconn.Tx[connNum] != nil {
+		return dbconn.Tx[connNum].Select(destination, query)
+	}
 	return dbconn.ConnPool[connNum].Select(destination, query)
 }
 
@@ -227,7 +227,7 @@ var _ = Describe("utils/db tests", func() {
 		It("successfully executes a BEGIN outside a transaction", func() {
 			testutils.ExpectBegin(mock)
 			connection.Begin()
-			Expect(connection.Tx).To(Not(BeNil()))
+			Expect(connection.Tx[0]).To(Not(BeNil()))
 		})
 		It("panics if it executes a BEGIN in a transaction", func() {
 			testutils.ExpectBegin(mock)
@@ -235,6 +235,13 @@ var _ = Describe("utils/db tests", func() {
 			defer testutils.ShouldPanicWithMessage("Cannot begin transaction; there is already a transaction in progress")
 			connection.Begin()
 		})
+		It("begins a transaction on only the specified connection", func() {
+			connection, mock = testutils.CreateAndConnectMockDB(2)
+			testutils.ExpectBegin(mock)
+			connection.Begin(1)
+			Expect(connection.Tx[0]).To(BeNil())
+			Expect(connection.Tx[1]).To(Not(BeNil()))
+		})
 	})
 	Describe("DBConn.Commit", func() {
 		It("successfully executes a COMMIT in a transaction", func() {
@@ -242,7 +249,7 @@ var _ = Describe("utils/db tests", func() {
 			mock.ExpectCommit()
 			connection.Begin()
 			connection.Commit()
-			Expect(connection.Tx).To(BeNil())
+			Expect(connection.Tx[0]).To(BeNil())
 		})
 		It("panics if it executes a COMMIT outside a transaction", func() {
 			defer testutils.ShouldPanicWithMessage("Cannot commit transaction; there is no transaction in progress")
@@ -316,6 +323,34 @@ var _ = Describe("utils/db tests", func() {
 			connection.ValidateConnNum(4)
 		})
 	})
+	Describe("DBConn.RecordBackendPIDs", func() {
+		It("records the backend process ID of each connection", func() {
+			connection, mock = testutils.CreateAndConnectMockDB(2)
+			mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(1234))
+			mock.ExpectQuery("SELECT pg_backend_pid()").WillReturnRows(sqlmock.NewRows([]string{"pg_backend_pid"}).AddRow(5678))
+			connection.RecordBackendPIDs()
+			Expect(connection.BackendPIDs).To(Equal([]int{1234, 5678}))
+		})
+	})
+	Describe("DBConn.CancelQueries", func() {
+		It("cancels the queries on each connection from a separate connection", func() {
+			connection.BackendPIDs = []int{1234, 5678}
+			mock.ExpectExec(`SELECT pg_cancel_backend\(1234\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(`SELECT pg_cancel_backend\(5678\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+			Expect(connection.CancelQueries()).To(Succeed())
+			Expect(mock.ExpectationsWereMet()).To(Succeed())
+		})
+		It("does not connect if no backend process IDs were recorded", func() {
+			connection.BackendPIDs = nil
+			connection.Driver = testutils.TestDriver{ErrToReturn: fmt.Errorf("pq: connection refused")}
+			Expect(connection.CancelQueries()).To(Succeed())
+		})
+		It("returns an error instead of panicking if it cannot connect", func() {
+			connection.BackendPIDs = []int{1234}
+			connection.Driver = testutils.TestDriver{ErrToReturn: fmt.Errorf("pq: connection refused")}
+			Expect(connection.CancelQueries()).To(MatchError("pq: connection refused"))
+		})
+	})
 	Describe("SelectString", func() {
 		header := []string{"string"}
 		rowOne := []driver.Value{"one"}
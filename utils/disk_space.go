@@ -0,0 +1,121 @@
+package utils
+
+/*
+ * This file contains structs and functions related to checking that there is
+ * enough free disk space on the segments for a backup or restore.
+ */
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type FilesystemSpace struct {
+	MountPoint     string
+	TotalBytes     uint64
+	AvailableBytes uint64
+}
+
+/*
+ * The backup directories may not exist yet, so the free space is checked on
+ * the filesystem containing the nearest existing parent of each directory.
+ */
+func (cluster *Cluster) GetFreeSpaceForBackupDirectories() map[int]FilesystemSpace {
+	return cluster.getFreeSpaceOnSegments("backup", cluster.GetDirForContent)
+}
+
+func (cluster *Cluster) GetFreeSpaceForDataDirectories() map[int]FilesystemSpace {
+	return cluster.getFreeSpaceOnSegments("data", func(contentID int) string {
+		return cluster.SegDirMap[contentID]
+	})
+}
+
+func (cluster *Cluster) getFreeSpaceOnSegments(dirType string, getDir func(contentID int) string) map[int]FilesystemSpace {
+	remoteOutput := cluster.GenerateAndExecuteCommand(fmt.Sprintf("Checking free space in %s directories", dirType), func(contentID int) string {
+		return fmt.Sprintf(`dir=%s; while [ ! -d "$dir" ]; do dir=$(dirname "$dir"); done; df -Pk "$dir" | tail -n 1`, getDir(contentID))
+	})
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to check free space in %s directories", dirType), func(contentID int) string {
+		return fmt.Sprintf("Unable to check free space for %s directory %s", dirType, getDir(contentID))
+	})
+	freeSpace := make(map[int]FilesystemSpace, len(remoteOutput.Stdouts))
+	for contentID, output := range remoteOutput.Stdouts {
+		space, err := ParseDiskFreeOutput(output)
+		if err != nil {
+			logger.Fatal(err, "Unable to check free space for %s directory %s on segment %d", dirType, getDir(contentID), contentID)
+		}
+		freeSpace[contentID] = space
+	}
+	return freeSpace
+}
+
+func ValidateDiskSpaceWarningThreshold(threshold int) {
+	if threshold < 1 || threshold > 100 {
+		logger.Fatal(NewFlagError("Invalid disk space warning threshold %d.  The threshold must be a percentage between 1 and 100.", threshold), "")
+	}
+}
+
+// This parses a line of `df -Pk` output, in which sizes are given in kilobytes
+func ParseDiskFreeOutput(output string) (FilesystemSpace, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 6 {
+		return FilesystemSpace{}, errors.Errorf("Unexpected output from df: %s", strings.TrimSpace(output))
+	}
+	totalKB, totalErr := strconv.ParseUint(fields[1], 10, 64)
+	availableKB, availableErr := strconv.ParseUint(fields[3], 10, 64)
+	if totalErr != nil || availableErr != nil {
+		return FilesystemSpace{}, errors.Errorf("Unexpected output from df: %s", strings.TrimSpace(output))
+	}
+	return FilesystemSpace{MountPoint: fields[5], TotalBytes: totalKB * 1024, AvailableBytes: availableKB * 1024}, nil
+}
+
+/*
+ * Segments on the same host may share a filesystem, so the space required by
+ * all of the segments on each filesystem is added together before comparing it
+ * to the free space on that filesystem.  This returns a problem for each
+ * filesystem without enough free space, and a warning for each filesystem
+ * that would be at least warningThreshold percent full afterwards.
+ */
+func CheckDiskSpace(cluster Cluster, requiredSpace map[int]uint64, freeSpace map[int]FilesystemSpace, warningThreshold int) ([]string, []string) {
+	type filesystem struct {
+		host       string
+		mountPoint string
+	}
+	required := make(map[filesystem]uint64, 0)
+	space := make(map[filesystem]FilesystemSpace, 0)
+	segments := make(map[filesystem][]string, 0)
+	contentIDs := make([]int, 0, len(freeSpace))
+	for contentID := range freeSpace {
+		contentIDs = append(contentIDs, contentID)
+	}
+	sort.Ints(contentIDs)
+	filesystems := make([]filesystem, 0)
+	for _, contentID := range contentIDs {
+		fs := filesystem{host: cluster.GetHostForContent(contentID), mountPoint: freeSpace[contentID].MountPoint}
+		if _, ok := space[fs]; !ok {
+			filesystems = append(filesystems, fs)
+		}
+		required[fs] += requiredSpace[contentID]
+		space[fs] = freeSpace[contentID]
+		segments[fs] = append(segments[fs], strconv.Itoa(contentID))
+	}
+	problems := make([]string, 0)
+	warnings := make([]string, 0)
+	for _, fs := range filesystems {
+		summary := fmt.Sprintf("Segments %s on host %s require %s on %s, which has %s available of %s", strings.Join(segments[fs], ", "), fs.host, FormatSize(required[fs]), fs.mountPoint, FormatSize(space[fs].AvailableBytes), FormatSize(space[fs].TotalBytes))
+		logger.Verbose(summary)
+		if required[fs] > space[fs].AvailableBytes {
+			problems = append(problems, summary)
+		} else if space[fs].TotalBytes > 0 {
+			usedBytes := space[fs].TotalBytes - space[fs].AvailableBytes + required[fs]
+			percentUsed := float64(usedBytes) / float64(space[fs].TotalBytes) * 100
+			if percentUsed >= float64(warningThreshold) {
+				warnings = append(warnings, fmt.Sprintf("%s, leaving it %.0f%% full", summary, percentUsed))
+			}
+		}
+	}
+	return problems, warnings
+}
@@ -0,0 +1,107 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/disk_space tests", func() {
+	masterSeg := utils.SegConfig{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"}
+	localSegOne := utils.SegConfig{ContentID: 0, Hostname: "localhost", DataDir: "/data/gpseg0"}
+	localSegTwo := utils.SegConfig{ContentID: 1, Hostname: "localhost", DataDir: "/data/gpseg1"}
+	remoteSegOne := utils.SegConfig{ContentID: 2, Hostname: "remotehost1", DataDir: "/data/gpseg2"}
+	var (
+		testCluster  utils.Cluster
+		testExecutor *testutils.TestExecutor
+	)
+
+	BeforeEach(func() {
+		testExecutor = &testutils.TestExecutor{}
+		testCluster = utils.NewCluster([]utils.SegConfig{masterSeg, localSegOne, localSegTwo, remoteSegOne}, "", "20170101010101", "gpseg")
+		testCluster.Executor = testExecutor
+	})
+	Describe("GetFreeSpaceForBackupDirectories", func() {
+		It("returns the free space on the filesystem of each backup directory", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{
+				Stdouts: map[int]string{
+					0: "/dev/sda1 104857600 52428800 52428800 50% /data\n",
+					1: "/dev/sdb1 209715200 10485760 199229440 5% /data2\n",
+				},
+			}
+			freeSpace := testCluster.GetFreeSpaceForBackupDirectories()
+			Expect(freeSpace).To(Equal(map[int]utils.FilesystemSpace{
+				0: {MountPoint: "/data", TotalBytes: 104857600 * 1024, AvailableBytes: 52428800 * 1024},
+				1: {MountPoint: "/data2", TotalBytes: 209715200 * 1024, AvailableBytes: 199229440 * 1024},
+			}))
+		})
+		It("panics if it cannot check the free space on some segments", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{
+				NumErrors: 1,
+				Errors: map[int]error{
+					1: errors.Errorf("exit status 1"),
+				},
+			}
+			defer testutils.ShouldPanicWithMessage("Unable to check free space in backup directories on 1 segment")
+			testCluster.GetFreeSpaceForBackupDirectories()
+		})
+	})
+	Describe("GetFreeSpaceForDataDirectories", func() {
+		It("panics if it cannot check the free space on some segments", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{
+				NumErrors: 1,
+				Errors: map[int]error{
+					0: errors.Errorf("exit status 1"),
+				},
+			}
+			defer testutils.ShouldPanicWithMessage("Unable to check free space in data directories on 1 segment")
+			testCluster.GetFreeSpaceForDataDirectories()
+		})
+	})
+	Describe("ParseDiskFreeOutput", func() {
+		It("parses a line of df output", func() {
+			space, err := utils.ParseDiskFreeOutput("/dev/sda1 1024 512 512 50% /data")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(space).To(Equal(utils.FilesystemSpace{MountPoint: "/data", TotalBytes: 1024 * 1024, AvailableBytes: 512 * 1024}))
+		})
+		It("returns an error if the output cannot be parsed", func() {
+			_, err := utils.ParseDiskFreeOutput("df: no such file or directory")
+			Expect(err).To(MatchError("Unexpected output from df: df: no such file or directory"))
+		})
+	})
+	Describe("CheckDiskSpace", func() {
+		It("returns no problems or warnings if there is enough free space", func() {
+			freeSpace := map[int]utils.FilesystemSpace{
+				0: {MountPoint: "/data1", TotalBytes: 10240, AvailableBytes: 8192},
+				1: {MountPoint: "/data2", TotalBytes: 10240, AvailableBytes: 8192},
+				2: {MountPoint: "/data1", TotalBytes: 10240, AvailableBytes: 8192},
+			}
+			problems, warnings := utils.CheckDiskSpace(testCluster, map[int]uint64{0: 1024, 1: 1024, 2: 1024}, freeSpace, 90)
+			Expect(problems).To(BeEmpty())
+			Expect(warnings).To(BeEmpty())
+		})
+		It("adds together the sizes for segments on the same host sharing a filesystem", func() {
+			freeSpace := map[int]utils.FilesystemSpace{
+				0: {MountPoint: "/data", TotalBytes: 10240, AvailableBytes: 3072},
+				1: {MountPoint: "/data", TotalBytes: 10240, AvailableBytes: 3072},
+				2: {MountPoint: "/data", TotalBytes: 10240, AvailableBytes: 3072},
+			}
+			problems, warnings := utils.CheckDiskSpace(testCluster, map[int]uint64{0: 2048, 1: 2048, 2: 2048}, freeSpace, 100)
+			Expect(problems).To(Equal([]string{"Segments 0, 1 on host localhost require 4.0 KB on /data, which has 3.0 KB available of 10.0 KB"}))
+			Expect(warnings).To(BeEmpty())
+		})
+		It("warns if a filesystem would be at least as full as the threshold", func() {
+			freeSpace := map[int]utils.FilesystemSpace{
+				0: {MountPoint: "/data1", TotalBytes: 10240, AvailableBytes: 2048},
+				1: {MountPoint: "/data2", TotalBytes: 10240, AvailableBytes: 8192},
+				2: {MountPoint: "/data1", TotalBytes: 10240, AvailableBytes: 8192},
+			}
+			problems, warnings := utils.CheckDiskSpace(testCluster, map[int]uint64{0: 1024, 1: 1024, 2: 1024}, freeSpace, 90)
+			Expect(problems).To(BeEmpty())
+			Expect(warnings).To(Equal([]string{"Segments 0 on host localhost require 1.0 KB on /data1, which has 2.0 KB available of 10.0 KB, leaving it 90% full"}))
+		})
+	})
+})
@@ -0,0 +1,278 @@
+package utils
+
+/*
+ * This file contains structs and functions related to encrypting backup files
+ * at rest.
+ */
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// The number of PBKDF2 iterations used to derive keys from the encryption key
+const ENCRYPTION_KDF_ITERATIONS = 100000
+
+var (
+	usingEncryption   = false
+	encryptionProgram Encryption
+	encryptionKey     []byte
+	checksumKey       []byte
+)
+
+/*
+ * Data and metadata files are encrypted with openssl, using a passphrase derived
+ * from the encryption key, so that the same commands can be used on the master
+ * and in COPY commands on the segments and so that a backup can be decrypted by
+ * hand if necessary.  The passphrase is written to a key file that is copied to
+ * the same path on every host for the duration of the backup or restore.
+ *
+ * openssl does not authenticate the files it encrypts, so the checksums of the
+ * backup files are used for that instead; see GetFileChecksum.
+ */
+type Encryption struct {
+	KeyFile        string
+	EncryptCommand string
+	DecryptCommand string
+}
+
+func GetEncryptionParameters() (bool, Encryption) {
+	return usingEncryption, encryptionProgram
+}
+
+func SetEncryptionParameters(encrypt bool, encryption Encryption) {
+	usingEncryption = encrypt
+	encryptionProgram = encryption
+	if !encrypt {
+		encryptionKey = nil
+		checksumKey = nil
+	}
+}
+
+func NewEncryption(keyFile string) Encryption {
+	return Encryption{
+		KeyFile:        keyFile,
+		EncryptCommand: fmt.Sprintf("openssl enc -aes-256-cbc -md sha256 -pbkdf2 -iter %d -salt -pass file:%s", ENCRYPTION_KDF_ITERATIONS, keyFile),
+		DecryptCommand: fmt.Sprintf("openssl enc -d -aes-256-cbc -md sha256 -pbkdf2 -iter %d -pass file:%s", ENCRYPTION_KDF_ITERATIONS, keyFile),
+	}
+}
+
+// The key is read from keyFile if it is set, and from the output of keyCommand otherwise
+func InitializeEncryption(cluster Cluster, keyFile string, keyCommand string) {
+	var key []byte
+	var err error
+	if keyFile != "" {
+		key, err = ioutil.ReadFile(keyFile)
+		if err != nil {
+			logger.Fatal(err, "Unable to read encryption key file %s", keyFile)
+		}
+	} else {
+		key, err = exec.Command("bash", "-c", keyCommand).Output()
+		if err != nil {
+			logger.Fatal(err, "Unable to retrieve encryption key from command %s", keyCommand)
+		}
+	}
+	if len(bytes.TrimSpace(key)) == 0 {
+		logger.Fatal(errors.Errorf("The encryption key must not be empty"), "")
+	}
+	firstLine := bytes.SplitN(key, []byte("\n"), 2)[0]
+	if len(bytes.TrimSpace(firstLine)) == 0 {
+		logger.Fatal(errors.Errorf("The first line of the encryption key must not be empty"), "")
+	}
+
+	/*
+	 * The passphrase is written to a temporary file and copied from there, rather
+	 * than copied directly from keyFile, so that no key is ever copied onto
+	 * itself and so that only the owner can read the copies.
+	 */
+	tempFile, err := ioutil.TempFile("", "gpbackup_encryption_key")
+	CheckError(err)
+	defer os.Remove(tempFile.Name())
+	MustPrintf(tempFile, "%s\n", getEncryptionPassphrase(key))
+	err = tempFile.Close()
+	CheckError(err)
+	hostKeyFile := fmt.Sprintf("/tmp/%s_gpbackup_encryption_key", cluster.Timestamp)
+	cluster.CopyFileToAllHosts("Copying encryption key to all hosts", tempFile.Name(), hostKeyFile)
+	SetEncryptionParameters(true, NewEncryption(hostKeyFile))
+	encryptionKey = key
+}
+
+/*
+ * openssl reads only the first line of a passphrase file, so the passphrase is
+ * the hex SHA-256 digest of the whole key rather than the key itself; otherwise
+ * keys that share a first line, such as PEM files, would encrypt identically.
+ * This is the output of sha256sum on the key, so a backup can still be
+ * decrypted by hand with "-pass pass:$(sha256sum <key file> | cut -d' ' -f1)".
+ */
+func getEncryptionPassphrase(key []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(key))
+}
+
+/*
+ * The fingerprint of the key is recorded with a backup so that a restore can
+ * check that it is using the key with which the backup was encrypted.  It is
+ * derived from the key with PBKDF2 and a random salt, so that it cannot be used
+ * to test guesses at the key any faster than decrypting a file could be.
+ *
+ * The same derivation yields the key used for the checksums of the backup
+ * files, so the checksums of an encrypted backup can only be computed by
+ * someone with the encryption key.  This returns a new fingerprint for the key
+ * passed to InitializeEncryption, and uses it for the checksums.
+ */
+func NewEncryptionKeyFingerprint() string {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	CheckError(err)
+	fingerprint, derivedChecksumKey := deriveEncryptionKeys(encryptionKey, salt)
+	checksumKey = derivedChecksumKey
+	return fmt.Sprintf("%x:%x", salt, fingerprint)
+}
+
+/*
+ * If the key passed to InitializeEncryption matches the given fingerprint,
+ * this uses the fingerprint for the checksums, as the backup with that
+ * fingerprint did, and returns true.
+ */
+func MatchEncryptionKeyFingerprint(fingerprint string) bool {
+	fields := strings.Split(fingerprint, ":")
+	if len(fields) != 2 {
+		return false
+	}
+	salt, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return false
+	}
+	expectedFingerprint, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return false
+	}
+	keyFingerprint, derivedChecksumKey := deriveEncryptionKeys(encryptionKey, salt)
+	if !hmac.Equal(keyFingerprint, expectedFingerprint) {
+		return false
+	}
+	checksumKey = derivedChecksumKey
+	return true
+}
+
+func deriveEncryptionKeys(key []byte, salt []byte) ([]byte, []byte) {
+	derivedKey := pbkdf2.Key(key, salt, ENCRYPTION_KDF_ITERATIONS, 64, sha256.New)
+	return derivedKey[:32], derivedKey[32:]
+}
+
+func CleanUpEncryptionKeyOnAllHosts(cluster Cluster) {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Removing encryption key from all hosts", func(contentID int) string {
+		return fmt.Sprintf("rm -f %s", encryptionProgram.KeyFile)
+	}, true)
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to remove encryption key %s", encryptionProgram.KeyFile), func(contentID int) string {
+		return fmt.Sprintf("Unable to remove encryption key %s", encryptionProgram.KeyFile)
+	}, true)
+}
+
+/*
+ * These functions are used for the master metadata, statistics, and TOC files,
+ * which are encrypted if encryption is enabled; the config and report files are
+ * never encrypted, so that a restore can tell whether a backup is encrypted.
+ *
+ * When writing an encrypted file, the plaintext is passed directly to openssl,
+ * so it is never written to disk.
+ */
+type encryptingWriter struct {
+	filename string
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+}
+
+func (writer *encryptingWriter) Write(p []byte) (int, error) {
+	return writer.stdin.Write(p)
+}
+
+/*
+ * Callers generally ignore errors from Close, so a failure to encrypt is fatal
+ * here.  The file is written by openssl, so it is synced here once openssl has
+ * finished, rather than by the caller.
+ */
+func (writer *encryptingWriter) Close() error {
+	writer.stdin.Close()
+	err := writer.cmd.Wait()
+	if err == nil {
+		err = syncFile(writer.filename)
+	}
+	if err != nil {
+		logger.Fatal(err, "Unable to encrypt file %s", writer.filename)
+	}
+	return nil
+}
+
+func syncFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+func MustOpenBackupFileForWriting(filename string) io.WriteCloser {
+	if !usingEncryption {
+		return MustOpenFileForWriting(filename)
+	}
+	cmd := exec.Command("bash", "-c", fmt.Sprintf("%s -out %s", encryptionProgram.EncryptCommand, filename))
+	stdin, err := cmd.StdinPipe()
+	CheckError(err)
+	err = cmd.Start()
+	if err != nil {
+		logger.Fatal(err, "Unable to create or open file for writing")
+	}
+	return &encryptingWriter{filename: filename, cmd: cmd, stdin: stdin}
+}
+
+func MustOpenBackupFileForAtomicWriting(filename string) io.WriteCloser {
+	return newAtomicFileWriter(filename, MustOpenBackupFileForWriting)
+}
+
+type bytesReadCloserAt struct {
+	*bytes.Reader
+}
+
+func (reader bytesReadCloserAt) Close() error {
+	return nil
+}
+
+/*
+ * Encrypted files are decrypted into memory, since the metadata file must be
+ * read at arbitrary offsets.
+ */
+func MustOpenBackupFileForReading(filename string) ReadCloserAt {
+	if !usingEncryption {
+		return MustOpenFileForReading(filename)
+	}
+	return bytesReadCloserAt{bytes.NewReader(MustReadBackupFile(filename))}
+}
+
+func MustReadBackupFile(filename string) []byte {
+	if !usingEncryption {
+		contents, err := System.ReadFile(filename)
+		CheckError(err)
+		return contents
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("bash", "-c", fmt.Sprintf("%s -in %s", encryptionProgram.DecryptCommand, filename))
+	cmd.Stderr = &stderr
+	contents, err := cmd.Output()
+	if err != nil {
+		logger.Fatal(errors.Errorf("Unable to decrypt file %s: %s", filename, strings.TrimSpace(stderr.String())), "")
+	}
+	return contents
+}
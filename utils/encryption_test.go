@@ -0,0 +1,156 @@
+package utils_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/*
+ * This executor runs the scp commands from CopyFileToAllHosts as local copies,
+ * so that the key file copied to each host can be checked.
+ */
+type localCopyExecutor struct {
+	testutils.TestExecutor
+}
+
+func (executor *localCopyExecutor) ExecuteClusterCommand(commandMap map[int][]string) *utils.RemoteOutput {
+	for _, command := range commandMap {
+		destFile := command[4][strings.Index(command[4], ":")+1:]
+		exec.Command("cp", command[3], destFile).Run()
+	}
+	return executor.TestExecutor.ExecuteClusterCommand(commandMap)
+}
+
+var _ = Describe("utils/encryption tests", func() {
+	masterSeg := utils.SegConfig{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"}
+	remoteSegOne := utils.SegConfig{ContentID: 1, Hostname: "remotehost1", DataDir: "/data/gpseg1"}
+	var (
+		testCluster  utils.Cluster
+		testExecutor *testutils.TestExecutor
+	)
+
+	BeforeEach(func() {
+		utils.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+		testExecutor = &testutils.TestExecutor{ClusterOutput: &utils.RemoteOutput{}}
+		testCluster = utils.NewCluster([]utils.SegConfig{masterSeg, remoteSegOne}, "", "20170101010101", "gpseg")
+		testCluster.Executor = testExecutor
+	})
+	AfterEach(func() {
+		utils.SetEncryptionParameters(false, utils.Encryption{})
+	})
+	Describe("NewEncryption", func() {
+		It("returns openssl commands using the given key file", func() {
+			encryption := utils.NewEncryption("/tmp/key")
+			Expect(encryption.KeyFile).To(Equal("/tmp/key"))
+			Expect(encryption.EncryptCommand).To(Equal("openssl enc -aes-256-cbc -md sha256 -pbkdf2 -iter 100000 -salt -pass file:/tmp/key"))
+			Expect(encryption.DecryptCommand).To(Equal("openssl enc -d -aes-256-cbc -md sha256 -pbkdf2 -iter 100000 -pass file:/tmp/key"))
+		})
+	})
+	Describe("InitializeEncryption", func() {
+		It("copies the key to all hosts", func() {
+			utils.InitializeEncryption(testCluster, "", "printf abc")
+			Expect(testExecutor.ClusterCommands).To(HaveLen(1))
+			Expect(testExecutor.ClusterCommands[0][1][4]).To(Equal("remotehost1:/tmp/20170101010101_gpbackup_encryption_key"))
+			usingEncryption, encryption := utils.GetEncryptionParameters()
+			Expect(usingEncryption).To(BeTrue())
+			Expect(encryption.KeyFile).To(Equal("/tmp/20170101010101_gpbackup_encryption_key"))
+		})
+		It("panics if the key is empty", func() {
+			defer testutils.ShouldPanicWithMessage("The encryption key must not be empty")
+			utils.InitializeEncryption(testCluster, "", "printf ''")
+		})
+		It("panics if the first line of the key is blank", func() {
+			defer testutils.ShouldPanicWithMessage("The first line of the encryption key must not be empty")
+			utils.InitializeEncryption(testCluster, "", "printf ' \\nabc\\n'")
+		})
+		It("panics if the key file cannot be read", func() {
+			defer testutils.ShouldPanicWithMessage("Unable to read encryption key file /tmp/nonexistent_key")
+			utils.InitializeEncryption(testCluster, "/tmp/nonexistent_key", "")
+		})
+	})
+	Describe("NewEncryptionKeyFingerprint and MatchEncryptionKeyFingerprint", func() {
+		var filename string
+		BeforeEach(func() {
+			file, _ := ioutil.TempFile("", "checksum")
+			file.WriteString("some text\n")
+			file.Close()
+			filename = file.Name()
+		})
+		AfterEach(func() {
+			os.Remove(filename)
+		})
+		It("returns a salted fingerprint that matches only the same key", func() {
+			utils.InitializeEncryption(testCluster, "", "printf abc")
+			fingerprint := utils.NewEncryptionKeyFingerprint()
+			Expect(fingerprint).To(MatchRegexp("^[0-9a-f]{32}:[0-9a-f]{64}$"))
+			Expect(utils.NewEncryptionKeyFingerprint()).ToNot(Equal(fingerprint))
+			Expect(utils.MatchEncryptionKeyFingerprint(fingerprint)).To(BeTrue())
+
+			utils.InitializeEncryption(testCluster, "", "printf abd")
+			Expect(utils.MatchEncryptionKeyFingerprint(fingerprint)).To(BeFalse())
+			Expect(utils.MatchEncryptionKeyFingerprint("not a fingerprint")).To(BeFalse())
+		})
+		It("keys file checksums with the key and the salt of the matched fingerprint", func() {
+			utils.InitializeEncryption(testCluster, "", "printf abc")
+			fingerprint := utils.NewEncryptionKeyFingerprint()
+			checksum := utils.GetFileChecksum(filename)
+			Expect(checksum).ToNot(Equal("a23e5fdcd7b276bdd81aa1a0b7b963101863dd3f61ff57935f8c5ba462681ea6"))
+
+			utils.NewEncryptionKeyFingerprint()
+			Expect(utils.GetFileChecksum(filename)).ToNot(Equal(checksum))
+			Expect(utils.MatchEncryptionKeyFingerprint(fingerprint)).To(BeTrue())
+			Expect(utils.GetFileChecksum(filename)).To(Equal(checksum))
+		})
+	})
+	Describe("MustOpenBackupFileForWriting and MustReadBackupFile", func() {
+		It("encrypts with the whole of a key that spans several lines", func() {
+			localCluster := utils.NewCluster([]utils.SegConfig{masterSeg}, "", "20170101010102", "gpseg")
+			localCluster.Executor = &localCopyExecutor{testutils.TestExecutor{ClusterOutput: &utils.RemoteOutput{}}}
+			hostKeyFile := "/tmp/20170101010102_gpbackup_encryption_key"
+			defer os.Remove(hostKeyFile)
+			dataFile, _ := ioutil.TempFile("", "data")
+			dataFile.Close()
+			defer os.Remove(dataFile.Name())
+
+			utils.InitializeEncryption(localCluster, "", "printf -- '-----BEGIN KEY-----\\nabc\\n-----END KEY-----\\n'")
+			passphrase, _ := ioutil.ReadFile(hostKeyFile)
+			Expect(string(passphrase)).To(MatchRegexp("^[0-9a-f]{64}\\n$"))
+			writer := utils.MustOpenBackupFileForWriting(dataFile.Name())
+			writer.Write([]byte("CREATE TABLE foo(i int);"))
+			writer.Close()
+			Expect(string(utils.MustReadBackupFile(dataFile.Name()))).To(Equal("CREATE TABLE foo(i int);"))
+
+			utils.InitializeEncryption(localCluster, "", "printf -- '-----BEGIN KEY-----\\nabd\\n-----END KEY-----\\n'")
+			defer testutils.ShouldPanicWithMessage(fmt.Sprintf("Unable to decrypt file %s", dataFile.Name()))
+			utils.MustReadBackupFile(dataFile.Name())
+		})
+		It("encrypts a file that can then be decrypted with the same key", func() {
+			keyFile, _ := ioutil.TempFile("", "key")
+			keyFile.WriteString("abc")
+			keyFile.Close()
+			defer os.Remove(keyFile.Name())
+			dataFile, _ := ioutil.TempFile("", "data")
+			dataFile.Close()
+			defer os.Remove(dataFile.Name())
+			utils.SetEncryptionParameters(true, utils.NewEncryption(keyFile.Name()))
+
+			writer := utils.MustOpenBackupFileForWriting(dataFile.Name())
+			writer.Write([]byte("CREATE TABLE foo(i int);"))
+			writer.Close()
+
+			ciphertext, _ := ioutil.ReadFile(dataFile.Name())
+			Expect(string(ciphertext)).ToNot(ContainSubstring("CREATE TABLE"))
+			Expect(string(utils.MustReadBackupFile(dataFile.Name()))).To(Equal("CREATE TABLE foo(i int);"))
+		})
+	})
+})
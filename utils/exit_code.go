@@ -0,0 +1,146 @@
+package utils
+
+/*
+ * This file contains the exit codes used by gpbackup, gprestore, and
+ * gpbackup_manager, and the typed errors that determine which code is used.
+ */
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+/*
+ * Every error that is not in one of the more specific classes below exits with
+ * EXIT_ERROR.  EXIT_COMPLETED_WITH_ERRORS is used when gprestore finishes with
+ * --on-error-continue after some statements failed, and EXIT_CANCELED is used
+ * for any error after a termination signal is received.
+ */
+const (
+	EXIT_SUCCESS               = 0
+	EXIT_ERROR                 = 1
+	EXIT_INVALID_FLAGS         = 2
+	EXIT_CONNECTION_ERROR      = 3
+	EXIT_SEGMENT_ERROR         = 4
+	EXIT_DISK_SPACE_ERROR      = 5
+	EXIT_COMPLETED_WITH_ERRORS = 6
+	EXIT_LOCK_ERROR            = 7
+	EXIT_CANCELED              = 8
+)
+
+// An ExitCodeError causes the program to exit with ExitCode when passed to logger.Fatal
+type ExitCodeError struct {
+	err      error
+	ExitCode int
+}
+
+func (exitErr ExitCodeError) Error() string {
+	return exitErr.err.Error()
+}
+
+func NewExitCodeError(exitCode int, err error) ExitCodeError {
+	return ExitCodeError{err: err, ExitCode: exitCode}
+}
+
+func NewFlagError(format string, args ...interface{}) ExitCodeError {
+	return NewExitCodeError(EXIT_INVALID_FLAGS, errors.Errorf(format, args...))
+}
+
+func NewConnectionError(format string, args ...interface{}) ExitCodeError {
+	return NewExitCodeError(EXIT_CONNECTION_ERROR, errors.Errorf(format, args...))
+}
+
+func NewSegmentError(format string, args ...interface{}) ExitCodeError {
+	return NewExitCodeError(EXIT_SEGMENT_ERROR, errors.Errorf(format, args...))
+}
+
+func NewDiskSpaceError(format string, args ...interface{}) ExitCodeError {
+	return NewExitCodeError(EXIT_DISK_SPACE_ERROR, errors.Errorf(format, args...))
+}
+
+func NewLockError(format string, args ...interface{}) ExitCodeError {
+	return NewExitCodeError(EXIT_LOCK_ERROR, errors.Errorf(format, args...))
+}
+
+const noSpaceMessage = "No space left on device"
+
+/*
+ * Errors raised on a segment name the segment in their message, as in
+ * "(seg0 slice1 sdw1:40000 pid=1234)".  A COPY ... ON SEGMENT whose program
+ * fails raises an external_routine_exception, and one whose file cannot be
+ * written raises a system_error.
+ */
+var segmentErrorPattern = regexp.MustCompile(`\(seg\d+ `)
+
+func isSegmentCopyError(pqErr *pq.Error) bool {
+	class := pqErr.Code.Class()
+	return (class == "38" || class == "58") && segmentErrorPattern.MatchString(pqErr.Message)
+}
+
+/*
+ * Errors returned by the database or the operating system are classified by
+ * their error codes, so that e.g. a lock timeout in any query or a full disk
+ * on any host is reported with the appropriate exit code.  Commands run on the
+ * segments by COPY only report their stderr, so a full disk on a segment is
+ * recognized by its message.
+ */
+func GetExitCode(err error) int {
+	if err == nil {
+		return EXIT_ERROR
+	}
+	if exitErr, ok := err.(ExitCodeError); ok {
+		return exitErr.ExitCode
+	}
+	switch cause := errors.Cause(err).(type) {
+	case ExitCodeError:
+		return cause.ExitCode
+	case *pq.Error:
+		switch {
+		case cause.Code == "55P03" || cause.Code == "40P01": // lock_not_available, deadlock_detected
+			return EXIT_LOCK_ERROR
+		case cause.Code == "53100": // disk_full
+			return EXIT_DISK_SPACE_ERROR
+		case cause.Code.Class() == "08": // connection_exception
+			return EXIT_CONNECTION_ERROR
+		case isSegmentCopyError(cause) && !strings.Contains(cause.Message, noSpaceMessage):
+			return EXIT_SEGMENT_ERROR
+		}
+	case *os.PathError:
+		if cause.Err == syscall.ENOSPC {
+			return EXIT_DISK_SPACE_ERROR
+		}
+	}
+	if strings.Contains(err.Error(), noSpaceMessage) {
+		return EXIT_DISK_SPACE_ERROR
+	}
+	return EXIT_ERROR
+}
+
+/*
+ * Abort() panics with a FatalError, so that the exit code is available when
+ * the panic is recovered in DoTeardown().
+ */
+type FatalError struct {
+	Message  string
+	ExitCode int
+}
+
+func (fatalErr FatalError) Error() string {
+	return fatalErr.Message
+}
+
+func GetStatusString(errMsg string, exitCode int) string {
+	if exitCode == EXIT_CANCELED {
+		return BACKUP_STATUS_CANCELED
+	} else if errMsg != "" {
+		return BACKUP_STATUS_FAILURE
+	} else if exitCode == EXIT_COMPLETED_WITH_ERRORS {
+		return "Completed With Errors"
+	}
+	return BACKUP_STATUS_SUCCESS
+}
@@ -0,0 +1,69 @@
+package utils_test
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/exit_code tests", func() {
+	Describe("GetExitCode", func() {
+		It("returns the exit code of an ExitCodeError", func() {
+			Expect(utils.GetExitCode(utils.NewFlagError("Invalid flag %s", "foo"))).To(Equal(utils.EXIT_INVALID_FLAGS))
+			Expect(utils.GetExitCode(utils.NewConnectionError("Connection refused"))).To(Equal(utils.EXIT_CONNECTION_ERROR))
+			Expect(utils.GetExitCode(utils.NewSegmentError("Segment failed"))).To(Equal(utils.EXIT_SEGMENT_ERROR))
+			Expect(utils.GetExitCode(utils.NewDiskSpaceError("Disk full"))).To(Equal(utils.EXIT_DISK_SPACE_ERROR))
+			Expect(utils.GetExitCode(utils.NewLockError("Backup in progress"))).To(Equal(utils.EXIT_LOCK_ERROR))
+		})
+		It("returns the exit code of a wrapped ExitCodeError", func() {
+			err := errors.Wrap(utils.NewSegmentError("Segment failed"), "Wrapped")
+			Expect(utils.GetExitCode(err)).To(Equal(utils.EXIT_SEGMENT_ERROR))
+		})
+		It("returns the lock exit code for a lock timeout or deadlock", func() {
+			Expect(utils.GetExitCode(&pq.Error{Code: "55P03"})).To(Equal(utils.EXIT_LOCK_ERROR))
+			Expect(utils.GetExitCode(errors.WithStack(&pq.Error{Code: "40P01"}))).To(Equal(utils.EXIT_LOCK_ERROR))
+		})
+		It("returns the disk space exit code for a disk_full database error", func() {
+			Expect(utils.GetExitCode(&pq.Error{Code: "53100"})).To(Equal(utils.EXIT_DISK_SPACE_ERROR))
+		})
+		It("returns the connection exit code for a connection exception", func() {
+			Expect(utils.GetExitCode(&pq.Error{Code: "08006"})).To(Equal(utils.EXIT_CONNECTION_ERROR))
+		})
+		It("returns the segment exit code for a COPY program or file that fails on a segment", func() {
+			Expect(utils.GetExitCode(&pq.Error{Code: "38000", Message: `command error message: gpbackup_helper: not found (seg1 slice1 sdw1:40001 pid=1234)`})).To(Equal(utils.EXIT_SEGMENT_ERROR))
+			Expect(utils.GetExitCode(errors.WithStack(&pq.Error{Code: "58P01", Message: `could not open file "/backups/gpseg0/foo": No such file or directory (seg0 sdw1:40000 pid=1234)`}))).To(Equal(utils.EXIT_SEGMENT_ERROR))
+		})
+		It("returns the disk space exit code for a COPY program that runs out of space on a segment", func() {
+			Expect(utils.GetExitCode(&pq.Error{Code: "38000", Message: `command error message: write error: No space left on device (seg0 slice1 sdw1:40000 pid=1234)`})).To(Equal(utils.EXIT_DISK_SPACE_ERROR))
+		})
+		It("returns the generic exit code for other database errors", func() {
+			Expect(utils.GetExitCode(&pq.Error{Code: "38000", Message: "program failed"})).To(Equal(utils.EXIT_ERROR))
+			Expect(utils.GetExitCode(&pq.Error{Code: "42P01"})).To(Equal(utils.EXIT_ERROR))
+		})
+		It("returns the disk space exit code for a file write that fails with ENOSPC", func() {
+			err := &os.PathError{Op: "write", Path: "/tmp/file", Err: syscall.ENOSPC}
+			Expect(utils.GetExitCode(err)).To(Equal(utils.EXIT_DISK_SPACE_ERROR))
+		})
+		It("returns the disk space exit code for a segment command that ran out of space", func() {
+			err := errors.New(`pq: could not write to COPY program: write error: No space left on device`)
+			Expect(utils.GetExitCode(err)).To(Equal(utils.EXIT_DISK_SPACE_ERROR))
+		})
+		It("returns the generic exit code for other errors", func() {
+			Expect(utils.GetExitCode(errors.New("Something went wrong"))).To(Equal(utils.EXIT_ERROR))
+		})
+	})
+	Describe("GetStatusString", func() {
+		It("returns the status for each outcome", func() {
+			Expect(utils.GetStatusString("", utils.EXIT_SUCCESS)).To(Equal("Success"))
+			Expect(utils.GetStatusString("", utils.EXIT_COMPLETED_WITH_ERRORS)).To(Equal("Completed With Errors"))
+			Expect(utils.GetStatusString("Error", utils.EXIT_ERROR)).To(Equal("Failure"))
+			Expect(utils.GetStatusString("Error", utils.EXIT_CANCELED)).To(Equal("Canceled"))
+		})
+	})
+})
@@ -8,14 +8,21 @@ import (
 	"flag"
 	"regexp"
 	"strings"
-
-	"github.com/pkg/errors"
 )
 
 /*
  * Functions for validating whether flags are set and in what combination
  */
 
+// This returns the value of each flag that was set on the command line
+func GetSetFlags() map[string]string {
+	setFlags := make(map[string]string, 0)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = f.Value.String()
+	})
+	return setFlags
+}
+
 func FlagIsSet(f *flag.Flag) bool {
 	return (*f).Value.String() != (*f).DefValue
 }
@@ -25,7 +32,7 @@ func CheckMandatoryFlags(flagNames ...string) {
 	for _, name := range flagNames {
 		f := flag.Lookup(name)
 		if f == nil || !FlagIsSet(f) {
-			logger.Fatal(errors.Errorf("Flag %s must be set", name), "")
+			logger.Fatal(NewFlagError("Flag %s must be set", name), "")
 		}
 	}
 }
@@ -40,7 +47,17 @@ func CheckExclusiveFlags(flagNames ...string) {
 		}
 	}
 	if numSet > 1 {
-		logger.Fatal(errors.Errorf("The following flags may not be specified together: %s", strings.Join(flagNames, ", ")), "")
+		logger.Fatal(NewFlagError("The following flags may not be specified together: %s", strings.Join(flagNames, ", ")), "")
+	}
+}
+
+// None of the flags passed to this function may be set for the given command
+func CheckFlagsNotSetForCommand(command string, flagNames ...string) {
+	for _, name := range flagNames {
+		f := flag.Lookup(name)
+		if f != nil && FlagIsSet(f) {
+			logger.Fatal(NewFlagError("Flag %s cannot be used with the %s command", name, command), "")
+		}
 	}
 }
 
@@ -71,6 +88,6 @@ func IsValidTimestamp(timestamp string) bool {
 
 func ValidateBackupDir(path string) {
 	if len(path) > 0 && string(path[0]) != "/" {
-		logger.Fatal(errors.Errorf("Absolute path required for backupdir."), "")
+		logger.Fatal(NewFlagError("Absolute path required for backupdir."), "")
 	}
 }
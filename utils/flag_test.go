@@ -85,5 +85,16 @@ var _ = Describe("utils/flag tests", func() {
 				utils.CheckExclusiveFlags("stringFlag", "boolFlag")
 			})
 		})
+		Context("CheckFlagsNotSetForCommand", func() {
+			It("does not panic if no flags in the argument list are set", func() {
+				flag.CommandLine.Parse([]string{"-intFlag", "42"})
+				utils.CheckFlagsNotSetForCommand("list", "stringFlag", "boolFlag")
+			})
+			It("panics if a flag in the argument list is set", func() {
+				flag.CommandLine.Parse([]string{"-boolFlag"})
+				defer testutils.ShouldPanicWithMessage("Flag boolFlag cannot be used with the list command")
+				utils.CheckFlagsNotSetForCommand("list", "stringFlag", "boolFlag")
+			})
+		})
 	})
 })
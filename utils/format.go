@@ -0,0 +1,109 @@
+package utils
+
+/*
+ * This file contains structs and functions related to the format in which
+ * table data is written to and read from backup files.
+ */
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DATA_FORMAT_CSV    = "csv"
+	DATA_FORMAT_TEXT   = "text"
+	DATA_FORMAT_BINARY = "binary"
+)
+
+var (
+	dataFormat = NewDataFormat("", "", "")
+)
+
+/*
+ * Backups taken before the data format was configurable have no format in
+ * their config file and were always written as CSV with a comma delimiter,
+ * so an empty format name is treated as CSV.
+ */
+type DataFormat struct {
+	Name       string
+	Delimiter  string
+	NullString string
+}
+
+func GetDataFormat() DataFormat {
+	return dataFormat
+}
+
+func SetDataFormat(format DataFormat) {
+	dataFormat = format
+}
+
+func NewDataFormat(name string, delimiter string, nullString string) DataFormat {
+	switch name {
+	case DATA_FORMAT_TEXT:
+		if delimiter == "" {
+			delimiter = "\t"
+		}
+		if nullString == "" {
+			nullString = `\N`
+		}
+		return DataFormat{Name: name, Delimiter: delimiter, NullString: nullString}
+	case DATA_FORMAT_BINARY:
+		return DataFormat{Name: name}
+	default:
+		return DataFormat{Name: DATA_FORMAT_CSV, Delimiter: ","}
+	}
+}
+
+func ValidateDataFormat(name string, delimiter string, nullString string) {
+	switch name {
+	case DATA_FORMAT_CSV, DATA_FORMAT_BINARY:
+		if delimiter != "" || nullString != "" {
+			logger.Fatal(NewFlagError("--data-delimiter and --data-null-string may only be specified with --data-format text"), "")
+		}
+	case DATA_FORMAT_TEXT:
+		if delimiter != "" && (len(delimiter) != 1 || strings.ContainsAny(delimiter, "\r\n\\")) {
+			logger.Fatal(NewFlagError("Invalid data delimiter %q.  The delimiter must be a single one-byte character other than a newline, carriage return, or backslash.", delimiter), "")
+		}
+		if strings.ContainsAny(nullString, "\r\n") || (delimiter != "" && strings.Contains(nullString, delimiter)) {
+			logger.Fatal(NewFlagError("Invalid data null string %q.  The null string must not contain a newline, carriage return, or the delimiter.", nullString), "")
+		}
+	default:
+		logger.Fatal(NewFlagError("Invalid data format %s.  Valid formats are csv, text, and binary.", name), "")
+	}
+}
+
+func (config *BackupConfig) GetDataFormat() DataFormat {
+	return NewDataFormat(config.DataFormat, config.DataDelimiter, config.DataNullString)
+}
+
+// Binary COPY ON SEGMENT is not supported before GPDB 6
+func EnsureDataFormatIsSupported(format DataFormat, dbVersion GPDBVersion) {
+	if format.Name == DATA_FORMAT_BINARY && dbVersion.Before("6") {
+		logger.Fatal(NewFlagError("The binary data format is not supported for GPDB version %s", dbVersion.VersionString), "")
+	}
+}
+
+/*
+ * The delimiter and null string are written as escape string literals so
+ * that they are interpreted the same way regardless of the setting of
+ * standard_conforming_strings.
+ */
+func (format DataFormat) CopyOptions() string {
+	switch format.Name {
+	case DATA_FORMAT_TEXT:
+		return fmt.Sprintf("DELIMITER %s NULL %s", escapeStringLiteral(format.Delimiter), escapeStringLiteral(format.NullString))
+	case DATA_FORMAT_BINARY:
+		return "BINARY"
+	default:
+		return fmt.Sprintf("CSV DELIMITER '%s'", format.Delimiter)
+	}
+}
+
+func escapeStringLiteral(str string) string {
+	str = strings.Replace(str, `\`, `\\`, -1)
+	str = strings.Replace(str, "'", "''", -1)
+	str = strings.Replace(str, "\t", `\t`, -1)
+	return fmt.Sprintf("E'%s'", str)
+}
@@ -0,0 +1,58 @@
+package utils_test
+
+import (
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/format tests", func() {
+	Describe("NewDataFormat", func() {
+		It("uses CSV with a comma delimiter if no format is specified", func() {
+			Expect(utils.NewDataFormat("", "", "")).To(Equal(utils.DataFormat{Name: "csv", Delimiter: ","}))
+		})
+		It("uses the default delimiter and null string for the text format if none are specified", func() {
+			Expect(utils.NewDataFormat("text", "", "")).To(Equal(utils.DataFormat{Name: "text", Delimiter: "\t", NullString: `\N`}))
+		})
+		It("uses the specified delimiter and null string for the text format", func() {
+			Expect(utils.NewDataFormat("text", "|", "NULL")).To(Equal(utils.DataFormat{Name: "text", Delimiter: "|", NullString: "NULL"}))
+		})
+	})
+	Describe("ValidateDataFormat", func() {
+		It("does not panic for a valid format", func() {
+			utils.ValidateDataFormat("csv", "", "")
+			utils.ValidateDataFormat("binary", "", "")
+			utils.ValidateDataFormat("text", "|", "")
+		})
+		It("panics for an unknown format", func() {
+			defer testutils.ShouldPanicWithMessage("Invalid data format xml.  Valid formats are csv, text, and binary.")
+			utils.ValidateDataFormat("xml", "", "")
+		})
+		It("panics if a delimiter is specified for a format other than text", func() {
+			defer testutils.ShouldPanicWithMessage("--data-delimiter and --data-null-string may only be specified with --data-format text")
+			utils.ValidateDataFormat("csv", "|", "")
+		})
+		It("panics if the delimiter is longer than one character", func() {
+			defer testutils.ShouldPanicWithMessage(`Invalid data delimiter "||".`)
+			utils.ValidateDataFormat("text", "||", "")
+		})
+		It("panics if the null string contains the delimiter", func() {
+			defer testutils.ShouldPanicWithMessage(`Invalid data null string "a|b".`)
+			utils.ValidateDataFormat("text", "|", "a|b")
+		})
+	})
+	Describe("CopyOptions", func() {
+		It("returns the options for the CSV format", func() {
+			Expect(utils.NewDataFormat("csv", "", "").CopyOptions()).To(Equal("CSV DELIMITER ','"))
+		})
+		It("returns the options for the text format as escape string literals", func() {
+			Expect(utils.NewDataFormat("text", "", "").CopyOptions()).To(Equal(`DELIMITER E'\t' NULL E'\\N'`))
+			Expect(utils.NewDataFormat("text", "'", "").CopyOptions()).To(Equal(`DELIMITER E'''' NULL E'\\N'`))
+		})
+		It("returns the options for the binary format", func() {
+			Expect(utils.NewDataFormat("binary", "", "").CopyOptions()).To(Equal("BINARY"))
+		})
+	})
+})
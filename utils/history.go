@@ -0,0 +1,168 @@
+package utils
+
+/*
+ * This file contains structs and functions related to the backup history file,
+ * which records every backup taken of a cluster so that backups can be listed
+ * and deleted without walking the backup directories.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	BACKUP_STATUS_SUCCESS  = "Success"
+	BACKUP_STATUS_FAILURE  = "Failure"
+	BACKUP_STATUS_DELETED  = "Deleted"
+	BACKUP_STATUS_CANCELED = "Canceled"
+)
+
+type BackupHistory struct {
+	Backups []BackupHistoryEntry
+}
+
+/*
+ * BackupDir is the user-specified backup directory, if any, and is recorded
+ * along with the segment prefix so that the backup directories can be found
+ * again when the backup is deleted.
+ */
+type BackupHistoryEntry struct {
+	Timestamp    string
+	Status       string
+	CommandLine  string
+	BackupDir    string
+	SegPrefix    string
+	PluginConfig string
+	EndTime      string
+	SizeInBytes  uint64
+	BackupConfig `yaml:",inline"`
+}
+
+func GetBackupHistoryFilePath(cluster Cluster) string {
+	return path.Join(cluster.SegDirMap[-1], "gpbackup_history.yaml")
+}
+
+func ReadBackupHistory(filename string) *BackupHistory {
+	history := &BackupHistory{Backups: make([]BackupHistoryEntry, 0)}
+	if !FileExistsAndIsReadable(filename) {
+		return history
+	}
+	contents, err := System.ReadFile(filename)
+	CheckError(err)
+	err = yaml.Unmarshal(contents, history)
+	if err != nil {
+		logger.Fatal(err, "Unable to parse backup history file %s", filename)
+	}
+	return history
+}
+
+func (history *BackupHistory) WriteToFile(filename string) {
+	historyFile := MustOpenFileForAtomicWriting(filename)
+	contents, _ := yaml.Marshal(history)
+	MustPrintBytes(historyFile, contents)
+	historyFile.Close()
+}
+
+/*
+ * Several backups may finish at once, so the history file is locked for the
+ * duration of each update.
+ */
+func UpdateBackupHistory(filename string, update func(history *BackupHistory)) {
+	lockFile, err := os.OpenFile(filename+".lck", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		logger.Fatal(err, "Unable to open lock file for backup history file %s", filename)
+	}
+	defer lockFile.Close()
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		logger.Fatal(err, "Unable to lock backup history file %s", filename)
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	history := ReadBackupHistory(filename)
+	update(history)
+	history.WriteToFile(filename)
+}
+
+// A resumed backup replaces the entry recorded for the attempt it resumed
+func (history *BackupHistory) AddEntry(entry BackupHistoryEntry) {
+	if existing := history.FindEntry(entry.Timestamp); existing != nil {
+		*existing = entry
+		return
+	}
+	history.Backups = append(history.Backups, entry)
+	sort.Slice(history.Backups, func(i, j int) bool {
+		return history.Backups[i].Timestamp < history.Backups[j].Timestamp
+	})
+}
+
+func (history *BackupHistory) FindEntry(timestamp string) *BackupHistoryEntry {
+	for i := range history.Backups {
+		if history.Backups[i].Timestamp == timestamp {
+			return &history.Backups[i]
+		}
+	}
+	return nil
+}
+
+func (history *BackupHistory) MustFindEntry(timestamp string) *BackupHistoryEntry {
+	entry := history.FindEntry(timestamp)
+	if entry == nil {
+		logger.Fatal(errors.Errorf("Backup %s was not found in the backup history", timestamp), "")
+	}
+	return entry
+}
+
+/*
+ * An incremental backup needs the data from every backup in its restore plan,
+ * so a backup cannot be deleted while any incremental backup that depends on
+ * it remains.
+ */
+func (history *BackupHistory) GetDependentBackups(timestamp string) []string {
+	dependents := make([]string, 0)
+	for _, entry := range history.Backups {
+		if entry.Timestamp == timestamp || entry.Status == BACKUP_STATUS_DELETED {
+			continue
+		}
+		for _, planEntry := range entry.RestorePlan {
+			if planEntry.Timestamp == timestamp {
+				dependents = append(dependents, entry.Timestamp)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+func (cluster *Cluster) GetBackupSizeOnAllHosts() uint64 {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Computing backup size on all hosts", func(contentID int) string {
+		return fmt.Sprintf("du -sk %s | cut -f1", cluster.GetDirForContent(contentID))
+	}, true)
+	cluster.CheckClusterError(remoteOutput, "Unable to compute backup size", func(contentID int) string {
+		return "Unable to compute backup size"
+	}, true)
+	var size uint64
+	for _, stdout := range remoteOutput.Stdouts {
+		var kilobytes uint64
+		fmt.Sscanf(stdout, "%d", &kilobytes)
+		size += kilobytes * 1024
+	}
+	return size
+}
+
+func (cluster *Cluster) DeleteBackupDirectoriesOnAllHosts() {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Deleting backup directories on all hosts", func(contentID int) string {
+		backupDir := cluster.GetDirForContent(contentID)
+		// The date directory is only removed if no other backups from that date remain
+		return fmt.Sprintf("rm -rf %s && (rmdir %s 2>/dev/null || true)", backupDir, path.Dir(backupDir))
+	}, true)
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to delete backup directories for backup %s", cluster.Timestamp), func(contentID int) string {
+		return fmt.Sprintf("Unable to delete backup directory %s", cluster.GetDirForContent(contentID))
+	})
+}
@@ -0,0 +1,87 @@
+package utils_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/history tests", func() {
+	var history *utils.BackupHistory
+	BeforeEach(func() {
+		history = &utils.BackupHistory{}
+		history.AddEntry(utils.BackupHistoryEntry{Timestamp: "20170102010101", Status: utils.BACKUP_STATUS_SUCCESS, BackupConfig: utils.BackupConfig{Incremental: true, RestorePlan: []utils.RestorePlanEntry{{Timestamp: "20170101010101"}, {Timestamp: "20170102010101"}}}})
+		history.AddEntry(utils.BackupHistoryEntry{Timestamp: "20170101010101", Status: utils.BACKUP_STATUS_SUCCESS, BackupConfig: utils.BackupConfig{RestorePlan: []utils.RestorePlanEntry{{Timestamp: "20170101010101"}}}})
+	})
+	Describe("AddEntry", func() {
+		It("keeps the entries sorted by timestamp", func() {
+			Expect(history.Backups[0].Timestamp).To(Equal("20170101010101"))
+			Expect(history.Backups[1].Timestamp).To(Equal("20170102010101"))
+		})
+		It("replaces an existing entry with the same timestamp", func() {
+			history.AddEntry(utils.BackupHistoryEntry{Timestamp: "20170101010101", Status: utils.BACKUP_STATUS_FAILURE})
+			Expect(history.Backups).To(HaveLen(2))
+			Expect(history.Backups[0].Status).To(Equal(utils.BACKUP_STATUS_FAILURE))
+		})
+	})
+	Describe("MustFindEntry", func() {
+		It("returns the entry with the given timestamp", func() {
+			Expect(history.MustFindEntry("20170102010101").Incremental).To(BeTrue())
+		})
+		It("panics if there is no entry with the given timestamp", func() {
+			defer testutils.ShouldPanicWithMessage("Backup 20170103010101 was not found in the backup history")
+			history.MustFindEntry("20170103010101")
+		})
+	})
+	Describe("GetDependentBackups", func() {
+		It("returns the incremental backups that depend on a backup", func() {
+			Expect(history.GetDependentBackups("20170101010101")).To(Equal([]string{"20170102010101"}))
+		})
+		It("does not return deleted backups", func() {
+			history.Backups[1].Status = utils.BACKUP_STATUS_DELETED
+			Expect(history.GetDependentBackups("20170101010101")).To(BeEmpty())
+		})
+	})
+	Describe("ReadBackupHistory and UpdateBackupHistory", func() {
+		var dir string
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "history")
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+		It("returns an empty history if there is no history file", func() {
+			Expect(utils.ReadBackupHistory(path.Join(dir, "gpbackup_history.yaml")).Backups).To(BeEmpty())
+		})
+		It("writes the updated history to the history file", func() {
+			filename := path.Join(dir, "gpbackup_history.yaml")
+			utils.UpdateBackupHistory(filename, func(fileHistory *utils.BackupHistory) {
+				*fileHistory = *history
+			})
+			utils.UpdateBackupHistory(filename, func(fileHistory *utils.BackupHistory) {
+				fileHistory.AddEntry(utils.BackupHistoryEntry{Timestamp: "20170103010101", Status: utils.BACKUP_STATUS_FAILURE})
+			})
+			result := utils.ReadBackupHistory(filename)
+			Expect(result.Backups).To(HaveLen(3))
+			Expect(result.Backups[1].RestorePlan[0].Timestamp).To(Equal("20170101010101"))
+			Expect(result.Backups[2].Status).To(Equal(utils.BACKUP_STATUS_FAILURE))
+		})
+	})
+	Describe("GetBackupSizeOnAllHosts", func() {
+		It("returns the total size of the backup directories on all hosts", func() {
+			utils.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+			testExecutor := &testutils.TestExecutor{ClusterOutput: &utils.RemoteOutput{Stdouts: map[int]string{-1: "4\n", 0: "8\n", 1: "8\n"}}}
+			testCluster := testutils.SetDefaultSegmentConfiguration()
+			testCluster.Executor = testExecutor
+			Expect(testCluster.GetBackupSizeOnAllHosts()).To(Equal(uint64(20 * 1024)))
+			Expect(testExecutor.ClusterCommands[0][-1]).To(Equal([]string{"bash", "-c", "du -sk gpseg-1/backups/20170101/20170101010101 | cut -f1"}))
+		})
+	})
+})
@@ -7,13 +7,16 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 
 	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
 )
 
 var (
@@ -54,6 +57,9 @@ func MustOpenFileForWriting(filename string, allowAppend ...bool) io.WriteCloser
 	flags := os.O_CREATE | os.O_WRONLY
 	if len(allowAppend) == 1 && allowAppend[0] {
 		flags = os.O_APPEND | flags
+	} else {
+		// Files such as the temporary file left by an interrupted atomic write are replaced when written again
+		flags = os.O_TRUNC | flags
 	}
 	fileHandle, err := System.OpenFileWrite(filename, flags, 0644)
 	if err != nil {
@@ -62,6 +68,118 @@ func MustOpenFileForWriting(filename string, allowAppend ...bool) io.WriteCloser
 	return fileHandle
 }
 
+/*
+ * Files such as the TOC, config, and report files are written to a temporary
+ * file, which is synced to disk and renamed over the file only once the writer
+ * is closed, so that a process stopped while writing one never leaves it
+ * truncated or followed by the end of an earlier, longer version of the file.
+ * The directory is synced as well once the file has been renamed, so that the
+ * rename itself survives a crash.  If writing the temporary file fails, it is
+ * removed rather than left behind.
+ *
+ * Close should not be deferred, since a file whose writing failed partway must
+ * not replace the file.
+ */
+type atomicFileWriter struct {
+	filename     string
+	tempFilename string
+	file         io.WriteCloser
+}
+
+func MustOpenFileForAtomicWriting(filename string) io.WriteCloser {
+	return newAtomicFileWriter(filename, func(tempFilename string) io.WriteCloser {
+		return MustOpenFileForWriting(tempFilename)
+	})
+}
+
+func newAtomicFileWriter(filename string, openFile func(filename string) io.WriteCloser) io.WriteCloser {
+	tempFilename := filename + ".tmp"
+	return &atomicFileWriter{filename: filename, tempFilename: tempFilename, file: openFile(tempFilename)}
+}
+
+// Callers stop writing once a write fails, so the temporary file is removed here
+func (writer *atomicFileWriter) Write(p []byte) (int, error) {
+	n, err := writer.file.Write(p)
+	if err != nil {
+		writer.file.Close()
+		System.Remove(writer.tempFilename)
+	}
+	return n, err
+}
+
+// Callers generally ignore errors from Close, so a failure to replace the file is fatal here
+func (writer *atomicFileWriter) Close() error {
+	var err error
+	if syncer, ok := writer.file.(interface{ Sync() error }); ok {
+		err = syncer.Sync()
+	}
+	if closeErr := writer.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = System.Rename(writer.tempFilename, writer.filename)
+	}
+	if err != nil {
+		System.Remove(writer.tempFilename)
+		logger.Fatal(err, "Unable to write file %s", writer.filename)
+	}
+	if err = syncDirectory(path.Dir(writer.filename)); err != nil {
+		logger.Fatal(err, "Unable to write file %s", writer.filename)
+	}
+	return nil
+}
+
+func syncDirectory(dirname string) error {
+	dir, err := os.Open(dirname)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+/*
+ * The TOC and config files are written atomically, but a file copied or stored
+ * incompletely may still be truncated or corrupt.  A truncated YAML file often
+ * still parses, even when it ends between two entries, so each file is written
+ * between a header and an end marker, which are ignored when it is parsed, and
+ * a file with the header must end with the end marker.  Files written by
+ * earlier versions have neither, so each file is also checked for the values
+ * that any complete file would have.
+ */
+type yamlFile interface {
+	validate() error
+}
+
+const (
+	yamlFileHeader    = "fileformat: 1\n"
+	yamlFileEndMarker = "endoffile: true\n"
+)
+
+func marshalYAMLFile(file yamlFile) []byte {
+	contents, _ := yaml.Marshal(file)
+	return []byte(yamlFileHeader + string(contents) + yamlFileEndMarker)
+}
+
+func mustParseYAMLFile(contents []byte, fileType string, filename string, host string, file yamlFile) {
+	err := errors.New("the file is empty")
+	if len(bytes.TrimSpace(contents)) > 0 {
+		err = yaml.Unmarshal(contents, file)
+		if err == nil && bytes.HasPrefix(contents, []byte(yamlFileHeader)) && !bytes.HasSuffix(contents, []byte(yamlFileEndMarker)) {
+			err = errors.New("the end of file marker is missing")
+		}
+		if err == nil {
+			err = file.validate()
+		}
+	}
+	if err != nil {
+		logger.Fatal(errors.Errorf("%s %s on host %s is truncated or corrupt: %s", fileType, filename, host, err.Error()), "")
+	}
+}
+
 func MustOpenFileForReading(filename string) ReadCloserAt {
 	fileHandle, err := System.OpenFileRead(filename, os.O_RDONLY, 0644)
 	if err != nil {
@@ -83,12 +201,13 @@ func FileExistsAndIsReadable(filename string) bool {
 	return false
 }
 
-func CreateBackupLockFile(timestamp string) {
+func CreateBackupLockFile(timestamp string) string {
 	timestampLockFile := fmt.Sprintf("/tmp/%s.lck", timestamp)
 	_, err := System.OpenFileWrite(timestampLockFile, os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
-		logger.Fatal(errors.Errorf("A backup with timestamp %s is already in progress. Wait 1 second and try the backup again.", timestamp), "")
+		logger.Fatal(NewLockError("A backup with timestamp %s is already in progress. Wait 1 second and try the backup again.", timestamp), "")
 	}
+	return timestampLockFile
 }
 
 func GetUserAndHostInfo() (string, string, string) {
@@ -165,13 +284,14 @@ func NewFileWithByteCount(writer io.Writer) *FileWithByteCount {
 }
 
 func NewFileWithByteCountFromFile(filename string) *FileWithByteCount {
-	file := MustOpenFileForWriting(filename)
+	file := MustOpenBackupFileForWriting(filename)
 	return &FileWithByteCount{filename, file, file, 0}
 }
 
 func (file *FileWithByteCount) Close() {
 	if file.closer != nil {
 		file.closer.Close()
+		file.closer = nil
 		if file.Filename != "" {
 			System.Chmod(file.Filename, 0444)
 		}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 
 	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
@@ -118,6 +120,38 @@ var _ = Describe("utils/io tests", func() {
 			utils.MustOpenFileForWriting("filename")
 		})
 	})
+	Describe("MustOpenFileForAtomicWriting", func() {
+		var tempDir, filename string
+		BeforeEach(func() {
+			tempDir, _ = ioutil.TempDir("", "io_test")
+			filename = path.Join(tempDir, "file.yaml")
+			Expect(ioutil.WriteFile(filename, []byte("old contents that are longer\n"), 0644)).To(Succeed())
+		})
+		AfterEach(func() {
+			os.RemoveAll(tempDir)
+		})
+		It("replaces the file only once the writer is closed", func() {
+			file := utils.MustOpenFileForAtomicWriting(filename)
+			utils.MustPrintf(file, "new contents\n")
+			Expect(ioutil.ReadFile(filename)).To(Equal([]byte("old contents that are longer\n")))
+
+			Expect(file.Close()).To(Succeed())
+			Expect(ioutil.ReadFile(filename)).To(Equal([]byte("new contents\n")))
+			_, err := os.Stat(filename + ".tmp")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("panics if the file cannot be replaced", func() {
+			utils.System.Rename = func(oldpath string, newpath string) error { return errors.New("Permission denied") }
+			file := utils.MustOpenFileForAtomicWriting(filename)
+			utils.MustPrintf(file, "new contents\n")
+			defer func() {
+				Expect(ioutil.ReadFile(filename)).To(Equal([]byte("old contents that are longer\n")))
+				Expect(filename + ".tmp").ToNot(BeAnExistingFile())
+			}()
+			defer testutils.ShouldPanicWithMessage(fmt.Sprintf("Unable to write file %s: Permission denied", filename))
+			file.Close()
+		})
+	})
 	Describe("MustOpenFileForReading", func() {
 		It("creates or opens the file for reading", func() {
 			utils.System.OpenFileRead = func(name string, flag int, perm os.FileMode) (utils.ReadCloserAt, error) { return os.Stdin, nil }
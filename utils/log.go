@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -64,6 +65,10 @@ type Logger struct {
 	logFileName string
 	verbosity   int
 	header      string
+
+	// Warnings are kept so that they can be listed in the report files
+	warnings     []string
+	warningsLock sync.Mutex
 }
 
 /*
@@ -138,6 +143,14 @@ func (logger *Logger) SetVerbosity(verbosity int) {
 	logger.verbosity = verbosity
 }
 
+func (logger *Logger) GetWarnings() []string {
+	logger.warningsLock.Lock()
+	defer logger.warningsLock.Unlock()
+	warnings := make([]string, len(logger.warnings))
+	copy(warnings, logger.warnings)
+	return warnings
+}
+
 /*
  * Log output functions, as described above
  */
@@ -151,7 +164,11 @@ func (logger *Logger) Info(s string, v ...interface{}) {
 }
 
 func (logger *Logger) Warn(s string, v ...interface{}) {
-	message := logger.GetLogPrefix("WARNING") + fmt.Sprintf(s, v...)
+	warning := fmt.Sprintf(s, v...)
+	logger.warningsLock.Lock()
+	logger.warnings = append(logger.warnings, warning)
+	logger.warningsLock.Unlock()
+	message := logger.GetLogPrefix("WARNING") + warning
 	logger.logFile.Output(1, message)
 	logger.logStdout.Output(1, message)
 }
@@ -189,10 +206,16 @@ func (logger *Logger) Fatal(err error, s string, v ...interface{}) {
 		stackTraceStr = formatStackTrace(errors.WithStack(err))
 	}
 	logger.logFile.Output(1, message+stackTraceStr)
+	exitCode := EXIT_ERROR
+	if WasCanceled() {
+		exitCode = EXIT_CANCELED
+	} else if err != nil {
+		exitCode = GetExitCode(err)
+	}
 	if logger.verbosity >= LOGVERBOSE {
-		Abort(message + stackTraceStr)
+		AbortWithExitCode(exitCode, message+stackTraceStr)
 	} else {
-		Abort(message)
+		AbortWithExitCode(exitCode, message)
 	}
 }
 
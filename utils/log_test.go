@@ -14,6 +14,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/pkg/errors"
 )
 
@@ -187,6 +188,14 @@ var _ = Describe("utils/log tests", func() {
 					defer testutils.ShouldPanicWithMessage(expectedMessage)
 					logger.Fatal(errors.New(expectedMessage), "")
 				})
+				It("panics with the exit code of the error", func() {
+					defer func() {
+						fatalErr, ok := recover().(utils.FatalError)
+						Expect(ok).To(BeTrue())
+						Expect(fatalErr.ExitCode).To(Equal(utils.EXIT_INVALID_FLAGS))
+					}()
+					logger.Fatal(utils.NewFlagError("invalid flag"), "")
+				})
 			})
 		})
 		Describe("Verbosity set to Info", func() {
@@ -379,6 +388,15 @@ var _ = Describe("utils/log tests", func() {
 			})
 		})
 	})
+	Describe("GetWarnings", func() {
+		It("returns the messages logged as warnings, without their prefixes", func() {
+			warnLogger := utils.NewLogger(gbytes.NewBuffer(), gbytes.NewBuffer(), gbytes.NewBuffer(), "testLogFile", utils.LOGINFO, "testProgram:testUser:testHost:000000-[%s]:-")
+			warnLogger.Info("not a warning")
+			warnLogger.Warn("first warning")
+			warnLogger.Warn("warning %d", 2)
+			Expect(warnLogger.GetWarnings()).To(Equal([]string{"first warning", "warning 2"}))
+		})
+	})
 	Describe("NewProgressBar", func() {
 		Context("PB_NONE", func() {
 			It("will not print when passed a none value", func() {
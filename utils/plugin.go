@@ -0,0 +1,168 @@
+package utils
+
+/*
+ * This file contains structs and functions related to storing backup files
+ * in external storage by way of an executable plugin.
+ */
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+ * A plugin is an executable that must be present at the same path on every host
+ * in the cluster, and that is invoked with one of the following commands and
+ * the path to a copy of the plugin configuration file on that host:
+ *
+ * setup_plugin_for_backup, setup_plugin_for_restore, cleanup_plugin_for_backup,
+ * and cleanup_plugin_for_restore are run for the master and for each segment,
+ * with the path of its local backup directory as an additional argument.
+ *
+ * backup_file and restore_file are passed the path of a local backup file, and
+ * must respectively store that file or retrieve it to that path.
+ *
+ * backup_data and restore_data are passed the path of a backup file that is
+ * never written locally, and must respectively store the data read from stdin
+ * under that name or write the stored data to stdout.
+ *
+ * See plugins/README.md for details and plugins/example_plugin.bash for a
+ * reference implementation.
+ */
+type PluginConfig struct {
+	ExecutablePath string
+	ConfigPath     string            `yaml:"-"`
+	Options        map[string]string `yaml:",omitempty"`
+}
+
+func ReadPluginConfig(configFile string) *PluginConfig {
+	logger.Info("Reading plugin config %s", configFile)
+	config := &PluginConfig{}
+	contents, err := ioutil.ReadFile(configFile)
+	CheckError(err)
+	err = yaml.Unmarshal(contents, config)
+	CheckError(err)
+	if config.ExecutablePath == "" {
+		logger.Fatal(errors.Errorf("The plugin config file %s must specify an executablepath", configFile), "")
+	}
+	return config
+}
+
+/*
+ * The plugin config file is copied to the same location on every host, so the
+ * plugin can be invoked identically everywhere; this sets ConfigPath to that
+ * location.
+ */
+func (plugin *PluginConfig) CopyPluginConfigToAllHosts(cluster Cluster, configFile string) {
+	plugin.ConfigPath = fmt.Sprintf("/tmp/%s_%s", cluster.Timestamp, filepath.Base(configFile))
+	cluster.CopyFileToAllHosts("Copying plugin config to all hosts", configFile, plugin.ConfigPath)
+}
+
+func (plugin *PluginConfig) CheckPluginExistsOnAllHosts(cluster Cluster) {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Checking that plugin exists on all hosts", func(contentID int) string {
+		return fmt.Sprintf("test -x %s", plugin.ExecutablePath)
+	}, true)
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Plugin %s does not exist or is not executable", plugin.ExecutablePath), func(contentID int) string {
+		return fmt.Sprintf("Plugin %s does not exist or is not executable", plugin.ExecutablePath)
+	})
+}
+
+func (plugin *PluginConfig) SetupPluginForBackup(cluster Cluster) {
+	plugin.executeOnAllHosts(cluster, "setup_plugin_for_backup")
+}
+
+func (plugin *PluginConfig) SetupPluginForRestore(cluster Cluster) {
+	plugin.executeOnAllHosts(cluster, "setup_plugin_for_restore")
+}
+
+func (plugin *PluginConfig) CleanupPluginForBackup(cluster Cluster) {
+	plugin.executeOnAllHosts(cluster, "cleanup_plugin_for_backup", true)
+	plugin.removePluginConfigFromAllHosts(cluster)
+}
+
+func (plugin *PluginConfig) CleanupPluginForRestore(cluster Cluster) {
+	plugin.executeOnAllHosts(cluster, "cleanup_plugin_for_restore", true)
+	plugin.removePluginConfigFromAllHosts(cluster)
+}
+
+func (plugin *PluginConfig) executeOnAllHosts(cluster Cluster, command string, noFatal ...bool) {
+	remoteOutput := cluster.GenerateAndExecuteCommand(fmt.Sprintf("Running plugin command %s on all hosts", command), func(contentID int) string {
+		return fmt.Sprintf("%s %s %s %s", plugin.ExecutablePath, command, plugin.ConfigPath, cluster.GetDirForContent(contentID))
+	}, true)
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to run plugin command %s", command), func(contentID int) string {
+		return fmt.Sprintf("Unable to run plugin command %s", command)
+	}, noFatal...)
+}
+
+func (plugin *PluginConfig) removePluginConfigFromAllHosts(cluster Cluster) {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Removing plugin config from all hosts", func(contentID int) string {
+		return fmt.Sprintf("rm -f %s", plugin.ConfigPath)
+	}, true)
+	cluster.CheckClusterError(remoteOutput, fmt.Sprintf("Unable to remove plugin config %s", plugin.ConfigPath), func(contentID int) string {
+		return fmt.Sprintf("Unable to remove plugin config %s", plugin.ConfigPath)
+	}, true)
+}
+
+func (plugin *PluginConfig) BackupFile(cluster Cluster, filenamePath string, noFatal ...bool) {
+	plugin.executeForFile(cluster, "backup_file", filenamePath, noFatal...)
+}
+
+func (plugin *PluginConfig) RestoreFile(cluster Cluster, filenamePath string) {
+	plugin.executeForFile(cluster, "restore_file", filenamePath)
+}
+
+func (plugin *PluginConfig) executeForFile(cluster Cluster, command string, filenamePath string, noFatal ...bool) {
+	logger.Verbose("Running plugin command %s for file %s", command, filenamePath)
+	err := cluster.ExecuteLocalCommand(fmt.Sprintf("%s %s %s %s", plugin.ExecutablePath, command, plugin.ConfigPath, filenamePath))
+	if err != nil {
+		errMsg := fmt.Sprintf("Plugin command %s failed for file %s: %v", command, filenamePath, err)
+		if len(noFatal) == 1 && noFatal[0] == true {
+			logger.Error(errMsg)
+			return
+		}
+		logger.Fatal(errors.New(errMsg), "")
+	}
+}
+
+/*
+ * In single-data-file mode, the segment data files are written by a process
+ * reading from the segment pipes rather than by COPY, and the segment TOC files
+ * are only complete once all data has been backed up, so these files are
+ * stored and retrieved separately from the table data.
+ */
+func (plugin *PluginConfig) BackupSegmentTOCs(cluster Cluster) {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Backing up segment table of contents files with plugin", func(contentID int) string {
+		tocFile := cluster.GetSegmentTOCFilePath(cluster.GetDirForContent(contentID), fmt.Sprintf("%d", contentID))
+		return fmt.Sprintf("%s backup_file %s %s", plugin.ExecutablePath, plugin.ConfigPath, tocFile)
+	})
+	cluster.CheckClusterError(remoteOutput, "Unable to back up segment table of contents files with plugin", func(contentID int) string {
+		return "Unable to back up segment table of contents file with plugin"
+	})
+}
+
+func (plugin *PluginConfig) RestoreSegmentTOCsAndDataFiles(cluster Cluster) {
+	remoteOutput := cluster.GenerateAndExecuteCommand("Restoring segment table of contents and data files with plugin", func(contentID int) string {
+		tocFile := cluster.GetSegmentTOCFilePath(cluster.GetDirForContent(contentID), fmt.Sprintf("%d", contentID))
+		dataFile := cluster.GetTableBackupFilePath(contentID, 0, true)
+		return fmt.Sprintf("%s restore_file %s %s && %s restore_file %s %s", plugin.ExecutablePath, plugin.ConfigPath, tocFile, plugin.ExecutablePath, plugin.ConfigPath, dataFile)
+	})
+	cluster.CheckClusterError(remoteOutput, "Unable to restore segment table of contents and data files with plugin", func(contentID int) string {
+		return "Unable to restore segment table of contents and data files with plugin"
+	})
+}
+
+/*
+ * These return shell commands to be used in COPY ... PROGRAM statements, so the
+ * data file path may contain the <SEG_DATA_DIR> and <SEGID> format strings.
+ */
+func (plugin *PluginConfig) GetBackupDataCommand(backupFile string) string {
+	return fmt.Sprintf("%s backup_data %s %s", plugin.ExecutablePath, plugin.ConfigPath, backupFile)
+}
+
+func (plugin *PluginConfig) GetRestoreDataCommand(backupFile string) string {
+	return fmt.Sprintf("%s restore_data %s %s", plugin.ExecutablePath, plugin.ConfigPath, backupFile)
+}
@@ -0,0 +1,105 @@
+package utils_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/plugin tests", func() {
+	masterSeg := utils.SegConfig{ContentID: -1, Hostname: "localhost", DataDir: "/data/gpseg-1"}
+	localSegOne := utils.SegConfig{ContentID: 0, Hostname: "localhost", DataDir: "/data/gpseg0"}
+	remoteSegOne := utils.SegConfig{ContentID: 1, Hostname: "remotehost1", DataDir: "/data/gpseg1"}
+	var (
+		testCluster  utils.Cluster
+		testExecutor *testutils.TestExecutor
+		plugin       utils.PluginConfig
+	)
+
+	BeforeEach(func() {
+		utils.System.CurrentUser = func() (*user.User, error) { return &user.User{Username: "testUser", HomeDir: "testDir"}, nil }
+		testExecutor = &testutils.TestExecutor{}
+		testCluster = utils.NewCluster([]utils.SegConfig{masterSeg, localSegOne, remoteSegOne}, "", "20170101010101", "gpseg")
+		testCluster.Executor = testExecutor
+		plugin = utils.PluginConfig{ExecutablePath: "/tmp/plugin.sh", ConfigPath: "/tmp/20170101010101_plugin_config.yaml"}
+	})
+	Describe("ReadPluginConfig", func() {
+		var configFile string
+		BeforeEach(func() {
+			file, _ := ioutil.TempFile("", "plugin_config")
+			configFile = file.Name()
+			file.Close()
+		})
+		AfterEach(func() {
+			os.Remove(configFile)
+		})
+		It("reads the executable path and options from the config file", func() {
+			ioutil.WriteFile(configFile, []byte("executablepath: /tmp/plugin.sh\noptions:\n  remote_directory: /tmp/remote\n"), 0644)
+			config := utils.ReadPluginConfig(configFile)
+			Expect(config.ExecutablePath).To(Equal("/tmp/plugin.sh"))
+			Expect(config.Options).To(Equal(map[string]string{"remote_directory": "/tmp/remote"}))
+		})
+		It("panics if the config file does not specify an executable path", func() {
+			ioutil.WriteFile(configFile, []byte("options:\n  remote_directory: /tmp/remote\n"), 0644)
+			defer testutils.ShouldPanicWithMessage("must specify an executablepath")
+			utils.ReadPluginConfig(configFile)
+		})
+	})
+	Describe("CopyPluginConfigToAllHosts", func() {
+		It("copies the config file once to each host", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{}
+			plugin.CopyPluginConfigToAllHosts(testCluster, "/home/gpadmin/plugin_config.yaml")
+			Expect(plugin.ConfigPath).To(Equal("/tmp/20170101010101_plugin_config.yaml"))
+			Expect(testExecutor.ClusterCommands).To(HaveLen(1))
+			Expect(testExecutor.ClusterCommands[0]).To(Equal(map[int][]string{
+				-1: {"scp", "-o", "StrictHostKeyChecking=no", "/home/gpadmin/plugin_config.yaml", "localhost:/tmp/20170101010101_plugin_config.yaml"},
+				1:  {"scp", "-o", "StrictHostKeyChecking=no", "/home/gpadmin/plugin_config.yaml", "remotehost1:/tmp/20170101010101_plugin_config.yaml"},
+			}))
+		})
+	})
+	Describe("SetupPluginForBackup", func() {
+		It("runs the setup command for every segment with its backup directory", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{}
+			plugin.SetupPluginForBackup(testCluster)
+			Expect(testExecutor.ClusterCommands[0][-1]).To(Equal([]string{"bash", "-c", "/tmp/plugin.sh setup_plugin_for_backup /tmp/20170101010101_plugin_config.yaml /data/gpseg-1/backups/20170101/20170101010101"}))
+			Expect(testExecutor.ClusterCommands[0][1]).To(Equal([]string{"ssh", "-o", "StrictHostKeyChecking=no", "testUser@remotehost1", "/tmp/plugin.sh setup_plugin_for_backup /tmp/20170101010101_plugin_config.yaml /data/gpseg1/backups/20170101/20170101010101"}))
+		})
+		It("panics if the setup command fails", func() {
+			testExecutor.ClusterOutput = &utils.RemoteOutput{NumErrors: 1, Errors: map[int]error{1: errors.New("exit status 1")}}
+			defer testutils.ShouldPanicWithMessage("Unable to run plugin command setup_plugin_for_backup on 1 segment")
+			plugin.SetupPluginForBackup(testCluster)
+		})
+	})
+	Describe("BackupFile", func() {
+		It("runs the backup_file command on the master", func() {
+			plugin.BackupFile(testCluster, "/data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_metadata.sql")
+			Expect(testExecutor.LocalCommands).To(Equal([]string{"/tmp/plugin.sh backup_file /tmp/20170101010101_plugin_config.yaml /data/gpseg-1/backups/20170101/20170101010101/gpbackup_20170101010101_metadata.sql"}))
+		})
+		It("panics if the backup_file command fails", func() {
+			testExecutor.LocalError = errors.New("exit status 1")
+			defer testutils.ShouldPanicWithMessage("Plugin command backup_file failed for file /tmp/file: exit status 1")
+			plugin.BackupFile(testCluster, "/tmp/file")
+		})
+		It("does not panic if the backup_file command fails and noFatal is set", func() {
+			testExecutor.LocalError = errors.New("exit status 1")
+			plugin.BackupFile(testCluster, "/tmp/file", true)
+		})
+	})
+	Describe("GetBackupDataCommand", func() {
+		It("returns a backup_data command for the given file", func() {
+			Expect(plugin.GetBackupDataCommand("<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_1234")).To(Equal("/tmp/plugin.sh backup_data /tmp/20170101010101_plugin_config.yaml <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_1234"))
+		})
+	})
+	Describe("GetRestoreDataCommand", func() {
+		It("returns a restore_data command for the given file", func() {
+			Expect(plugin.GetRestoreDataCommand("<SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_1234")).To(Equal("/tmp/plugin.sh restore_data /tmp/20170101010101_plugin_config.yaml <SEG_DATA_DIR>/gpbackup_<SEGID>_20170101010101_1234"))
+		})
+	})
+})
@@ -1,32 +1,56 @@
 package utils
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"regexp"
 	"sort"
 	"strings"
 	"time"
 
-	yaml "gopkg.in/yaml.v2"
-
 	"github.com/blang/semver"
 	"github.com/pkg/errors"
 )
 
 type BackupConfig struct {
-	BackupVersion   string
-	DatabaseName    string
-	DatabaseVersion string
-	Compressed      bool
-	DataOnly        bool
-	SchemaFiltered  bool
-	TableFiltered   bool
-	MetadataOnly    bool
-	WithStatistics  bool
-	SingleDataFile  bool
+	BackupVersion            string
+	DatabaseName             string
+	DatabaseVersion          string
+	Compressed               bool
+	CompressionType          string
+	DataOnly                 bool
+	Encrypted                bool
+	EncryptionKeyFingerprint string
+	SchemaFiltered           bool
+	TableFiltered            bool
+	MetadataOnly             bool
+	WithStatistics           bool
+	SingleDataFile           bool
+	CompressedFrames         bool
+	LeafPartitionData        bool
+	Incremental              bool
+	IncludeExternalData      bool
+	MaskingRulesChecksum     string `yaml:",omitempty"`
+	DataFormat               string
+	DataDelimiter            string
+	DataNullString           string
+	SegmentDataSizes         map[int]uint64 `yaml:",omitempty"`
+	TOCChecksum              string
+	RestorePlan              []RestorePlanEntry
+}
+
+/*
+ * A restore plan lists, for each backup in an incremental backup chain, the
+ * tables whose data should be restored from that backup.  A full backup has
+ * a restore plan with a single entry for its own timestamp.  The checksum of
+ * the TOC file of each earlier backup in the chain is recorded with its entry.
+ */
+type RestorePlanEntry struct {
+	Timestamp   string
+	TableFQNs   []string
+	TOCChecksum string `yaml:",omitempty"`
 }
 
 /*
@@ -37,17 +61,128 @@ type BackupConfig struct {
 type Report struct {
 	BackupParamsString string
 	DatabaseSize       string
+	ResumedSnapshots   []ResumedSnapshot
+	PatternMatches     []PatternMatch
+	TablePredicates    map[string]string
+	MaskedColumns      map[string]string
 	BackupConfig
 }
 
-func ParseErrorMessage(errStr string) (string, int) {
+/*
+ * Pattern filters are resolved to lists of schemas or tables when the backup
+ * starts, so the report lists the objects matched by each pattern flag.
+ */
+type PatternMatch struct {
+	Flag     string   `json:"flag"`
+	Patterns []string `json:"patterns"`
+	Matches  []string `json:"matches"`
+}
+
+/*
+ * When an interrupted backup is resumed, the data for the tables not yet
+ * backed up is read in a new snapshot, so the report lists those tables
+ * along with the time at which each snapshot was taken.
+ */
+type ResumedSnapshot struct {
+	StartTime string   `json:"start_time"`
+	Tables    []string `json:"tables"`
+}
+
+/*
+ * The JSON report files hold the information in the text report files, along
+ * with the flags, tables, and warnings for the run, in a form that can be read
+ * by other programs.  Times are in RFC 3339 format.
+ */
+type JSONReport struct {
+	Utility          string            `json:"utility"`
+	Version          string            `json:"version"`
+	BackupVersion    string            `json:"backup_version,omitempty"`
+	Timestamp        string            `json:"timestamp"`
+	DatabaseName     string            `json:"database_name"`
+	DatabaseVersion  string            `json:"database_version"`
+	RestoreDatabase  string            `json:"restore_database,omitempty"`
+	CommandLine      string            `json:"command_line"`
+	Flags            map[string]string `json:"flags"`
+	StartTime        string            `json:"start_time"`
+	EndTime          string            `json:"end_time"`
+	DurationSeconds  int64             `json:"duration_seconds"`
+	Status           string            `json:"status"`
+	ExitCode         int               `json:"exit_code"`
+	ErrorMessage     string            `json:"error_message,omitempty"`
+	DatabaseSize     string            `json:"database_size,omitempty"`
+	ObjectCounts     map[string]int    `json:"object_counts,omitempty"`
+	Tables           []TableReport     `json:"tables"`
+	PatternMatches   []PatternMatch    `json:"pattern_matches,omitempty"`
+	MaskedColumns    map[string]string `json:"masked_columns,omitempty"`
+	ResumedSnapshots []ResumedSnapshot `json:"resumed_snapshots,omitempty"`
+	Warnings         []string          `json:"warnings"`
+}
+
+/*
+ * SegmentBytes is omitted when the size of the data for the table on each
+ * segment is not known, such as when uncompressed data is stored with a plugin.
+ */
+type TableReport struct {
+	Name         string         `json:"name"`
+	Rows         int64          `json:"rows"`
+	SegmentBytes map[int]uint64 `json:"segment_bytes,omitempty"`
+	Predicate    string         `json:"predicate,omitempty"`
+	External     bool           `json:"external,omitempty"`
+}
+
+func NewJSONReport(utility string, version string, timestamp string, startTime time.Time, endTime time.Time, tables []TableReport, errMsg string, exitCode int) *JSONReport {
+	if tables == nil {
+		tables = []TableReport{}
+	}
+	return &JSONReport{
+		Utility:         utility,
+		Version:         version,
+		Timestamp:       timestamp,
+		CommandLine:     strings.Join(os.Args, " "),
+		Flags:           GetSetFlags(),
+		StartTime:       startTime.Format(time.RFC3339),
+		EndTime:         endTime.Format(time.RFC3339),
+		DurationSeconds: int64(endTime.Sub(startTime) / time.Second),
+		Status:          GetStatusString(errMsg, exitCode),
+		ExitCode:        exitCode,
+		ErrorMessage:    errMsg,
+		Tables:          tables,
+		Warnings:        logger.GetWarnings(),
+	}
+}
+
+func (report *JSONReport) WriteToFile(filename string) {
+	reportFile := MustOpenFileForAtomicWriting(filename)
+	contents, err := json.MarshalIndent(report, "", "  ")
+	CheckError(err)
+	MustPrintBytes(reportFile, append(contents, '\n'))
+	reportFile.Close()
+}
+
+func (report *JSONReport) WriteToFileAndMakeReadOnly(filename string) {
+	defer System.Chmod(filename, 0444)
+	report.WriteToFile(filename)
+}
+
+/*
+ * This takes the value recovered from a panic, which is a FatalError if the
+ * panic came from Abort(), and returns the error message and exit code.
+ */
+func ParseErrorMessage(err interface{}) (string, int) {
+	if err == nil {
+		return "", EXIT_SUCCESS
+	}
+	errStr := fmt.Sprintf("%v", err)
 	if errStr == "" {
-		return "", 0
+		return "", EXIT_SUCCESS
 	}
 	errLevelStr := "[CRITICAL]:-"
 	headerIndex := strings.Index(errStr, errLevelStr)
 	errMsg := errStr[headerIndex+len(errLevelStr):]
-	exitCode := 1 // TODO: Define different error codes for different kinds of errors
+	exitCode := EXIT_ERROR
+	if fatalErr, ok := err.(FatalError); ok {
+		exitCode = fatalErr.ExitCode
+	}
 	return errMsg, exitCode
 }
 
@@ -64,6 +199,7 @@ func (report *Report) ConstructBackupParamsStringFromFlags(dataOnly bool, ddlOnl
 	compressed, program := GetCompressionParameters()
 	if compressed {
 		report.Compressed = true
+		report.CompressionType = program.Name
 		compressStr = program.Name
 	}
 	sectionStr := "All Sections"
@@ -80,6 +216,7 @@ func (report *Report) ConstructBackupParamsStringFromFlags(dataOnly bool, ddlOnl
 		filesStr = "No Data Files"
 	} else if singleDataFile {
 		report.SingleDataFile = true
+		report.CompressedFrames = compressed
 		filesStr = "Single Data File Per Segment"
 	}
 	statsStr := "No"
@@ -97,23 +234,30 @@ Data File Format: %s`
 
 func ReadConfigFile(filename string) *BackupConfig {
 	config := &BackupConfig{}
-	contents, err := ioutil.ReadFile(filename)
-	CheckError(err)
-	err = yaml.Unmarshal(contents, config)
+	contents, err := System.ReadFile(filename)
 	CheckError(err)
+	hostname, _ := System.Hostname()
+	mustParseYAMLFile(contents, "Config file", filename, hostname, config)
 	return config
 }
 
+// These are written first in the config file, so a file missing any of them is incomplete
+func (config *BackupConfig) validate() error {
+	if config.BackupVersion == "" || config.DatabaseName == "" || config.DatabaseVersion == "" {
+		return errors.New("the backup version, database name, or database version is missing")
+	}
+	return nil
+}
+
 func (report *Report) WriteConfigFile(configFilename string) {
-	configFile := MustOpenFileForWriting(configFilename)
+	configFile := MustOpenFileForAtomicWriting(configFilename)
 	defer System.Chmod(configFilename, 0444)
-	config := report.BackupConfig
-	configContents, _ := yaml.Marshal(config)
-	MustPrintBytes(configFile, configContents)
+	MustPrintBytes(configFile, marshalYAMLFile(&report.BackupConfig))
+	configFile.Close()
 }
 
-func (report *Report) WriteReportFile(reportFilename string, timestamp string, objectCounts map[string]int, endTime time.Time, errMsg string) {
-	reportFile := MustOpenFileForWriting(reportFilename)
+func (report *Report) WriteReportFile(reportFilename string, timestamp string, objectCounts map[string]int, endTime time.Time, errMsg string, exitCode int) {
+	reportFile := MustOpenFileForAtomicWriting(reportFilename)
 	defer System.Chmod(reportFilename, 0444)
 	reportFileTemplate := `Greenplum Database Backup Report
 
@@ -134,7 +278,7 @@ Backup Status: %s
 
 	gpbackupCommandLine := strings.Join(os.Args, " ")
 	start, end, duration := GetBackupTimeInfo(timestamp, endTime)
-	backupStatus, dbSizeStr := getStatusAndSizeStrings(errMsg, report.DatabaseSize)
+	backupStatus, dbSizeStr := getStatusAndSizeStrings(errMsg, exitCode, report.DatabaseSize)
 
 	MustPrintf(reportFile, reportFileTemplate,
 		timestamp, report.DatabaseVersion, report.BackupVersion,
@@ -142,7 +286,168 @@ Backup Status: %s
 		start, end, duration,
 		backupStatus, dbSizeStr)
 
+	report.PrintPatternMatches(reportFile)
+	report.PrintTablePredicates(reportFile)
+	report.PrintMaskedColumns(reportFile)
+	report.PrintResumedSnapshots(reportFile)
 	PrintObjectCounts(reportFile, objectCounts)
+	reportFile.Close()
+}
+
+func (report *Report) ConstructJSONReport(timestamp string, objectCounts map[string]int, tables []TableReport, endTime time.Time, errMsg string, exitCode int) *JSONReport {
+	startTime, _ := time.ParseInLocation("20060102150405", timestamp, System.Local)
+	jsonReport := NewJSONReport("gpbackup", report.BackupVersion, timestamp, startTime, endTime, tables, errMsg, exitCode)
+	jsonReport.DatabaseName = report.DatabaseName
+	jsonReport.DatabaseVersion = report.DatabaseVersion
+	jsonReport.DatabaseSize = report.DatabaseSize
+	jsonReport.ObjectCounts = objectCounts
+	jsonReport.PatternMatches = report.PatternMatches
+	jsonReport.MaskedColumns = report.MaskedColumns
+	jsonReport.ResumedSnapshots = report.ResumedSnapshots
+	return jsonReport
+}
+
+func (report *Report) PrintPatternMatches(reportFile io.Writer) {
+	for _, match := range report.PatternMatches {
+		MustPrintf(reportFile, "\nThe following objects matched --%s %s:\n", match.Flag, strings.Join(match.Patterns, ", "))
+		for _, name := range match.Matches {
+			MustPrintf(reportFile, "%s\n", name)
+		}
+	}
+}
+
+func (report *Report) PrintTablePredicates(reportFile io.Writer) {
+	if len(report.TablePredicates) == 0 {
+		return
+	}
+	tables := make([]string, 0, len(report.TablePredicates))
+	for table := range report.TablePredicates {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	MustPrintf(reportFile, "\nOnly the rows matching the following predicates were backed up, so the data for these tables is a subset:\n")
+	for _, table := range tables {
+		MustPrintf(reportFile, "%s WHERE %s\n", table, report.TablePredicates[table])
+	}
+}
+
+func (report *Report) PrintMaskedColumns(reportFile io.Writer) {
+	if len(report.MaskedColumns) == 0 {
+		return
+	}
+	columns := make([]string, 0, len(report.MaskedColumns))
+	for column := range report.MaskedColumns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	MustPrintf(reportFile, "\nThe data for the following columns was masked with the specified transforms:\n")
+	for _, column := range columns {
+		MustPrintf(reportFile, "%s: %s\n", column, report.MaskedColumns[column])
+	}
+}
+
+func (report *Report) PrintResumedSnapshots(reportFile io.Writer) {
+	for _, snapshot := range report.ResumedSnapshots {
+		MustPrintf(reportFile, "\nData for the following tables was backed up in a different snapshot, taken when the backup was resumed at %s:\n", snapshot.StartTime)
+		for _, table := range snapshot.Tables {
+			MustPrintf(reportFile, "%s\n", table)
+		}
+	}
+}
+
+/*
+ * The verification report records every problem found, rather than only the
+ * first, so that a single run shows everything that must be fixed.  Unlike the
+ * backup report, it is not made read-only, since a backup may be verified
+ * any number of times.
+ */
+func WriteVerifyReportFile(reportFilename string, timestamp string, config *BackupConfig, restoreVersion string, startTime time.Time, endTime time.Time, problems []string, errMsg string, exitCode int) {
+	reportFile := MustOpenFileForAtomicWriting(reportFilename)
+	reportFileTemplate := `Greenplum Database Backup Verification Report
+
+Timestamp Key: %s
+GPDB Version: %s
+gpbackup Version: %s
+gprestore Version: %s
+
+Database Name: %s
+Command Line: %s
+
+Start Time: %s
+End Time: %s
+Duration: %s
+
+Verification Status: %s
+Exit Code: %d
+`
+
+	gprestoreCommandLine := strings.Join(os.Args, " ")
+	verifyStatus := "Success"
+	if errMsg != "" {
+		verifyStatus = fmt.Sprintf("Failure\nVerification Error: %s", errMsg)
+	} else if len(problems) > 0 {
+		verifyStatus = "Failure"
+	}
+
+	MustPrintf(reportFile, reportFileTemplate,
+		timestamp, config.DatabaseVersion, config.BackupVersion, restoreVersion,
+		config.DatabaseName, gprestoreCommandLine,
+		startTime.Format("2006-01-02 15:04:05"), endTime.Format("2006-01-02 15:04:05"), reformatDuration(endTime.Sub(startTime)),
+		verifyStatus, exitCode)
+
+	if len(problems) > 0 {
+		MustPrintf(reportFile, "\nProblems Found:\n")
+		for _, problem := range problems {
+			MustPrintf(reportFile, "%s\n", problem)
+		}
+	}
+	reportFile.Close()
+}
+
+/*
+ * The restore report is replaced each time a backup is restored, like the
+ * verification report, and lists the tables whose data was restored.
+ */
+func WriteRestoreReportFile(reportFilename string, timestamp string, config *BackupConfig, restoreVersion string, restoreDatabase string, startTime time.Time, endTime time.Time, tables []TableReport, errMsg string, exitCode int) {
+	reportFile := MustOpenFileForAtomicWriting(reportFilename)
+	reportFileTemplate := `Greenplum Database Restore Report
+
+Timestamp Key: %s
+GPDB Version: %s
+gpbackup Version: %s
+gprestore Version: %s
+
+Database Name: %s
+Restore Database Name: %s
+Command Line: %s
+
+Start Time: %s
+End Time: %s
+Duration: %s
+
+Restore Status: %s
+Exit Code: %d
+`
+
+	gprestoreCommandLine := strings.Join(os.Args, " ")
+	restoreStatus := GetStatusString(errMsg, exitCode)
+	if errMsg != "" {
+		restoreStatus = fmt.Sprintf("%s\nRestore Error: %s", restoreStatus, errMsg)
+	}
+
+	MustPrintf(reportFile, reportFileTemplate,
+		timestamp, config.DatabaseVersion, config.BackupVersion, restoreVersion,
+		config.DatabaseName, restoreDatabase, gprestoreCommandLine,
+		startTime.Format("2006-01-02 15:04:05"), endTime.Format("2006-01-02 15:04:05"), reformatDuration(endTime.Sub(startTime)),
+		restoreStatus, exitCode)
+
+	if len(tables) > 0 {
+		MustPrintf(reportFile, "\nData Restored:\n")
+		for _, table := range tables {
+			MustPrintf(reportFile, "%-60s %d rows\n", table.Name, table.Rows)
+		}
+	}
+	reportFile.Close()
 }
 
 func GetBackupTimeInfo(timestamp string, endTime time.Time) (string, string, string) {
@@ -163,11 +468,12 @@ func reformatDuration(duration time.Duration) string {
 	return fmt.Sprintf("%d:%02d:%02d", hour, min, sec)
 }
 
-func getStatusAndSizeStrings(errMsg string, dbSize string) (string, string) {
-	backupStatus := "Success"
+func getStatusAndSizeStrings(errMsg string, exitCode int, dbSize string) (string, string) {
+	backupStatus := GetStatusString(errMsg, exitCode)
 	if errMsg != "" {
-		backupStatus = fmt.Sprintf("Failure\nBackup Error: %s", errMsg)
+		backupStatus = fmt.Sprintf("%s\nBackup Error: %s", backupStatus, errMsg)
 	}
+	backupStatus = fmt.Sprintf("%s\nExit Code: %d", backupStatus, exitCode)
 	dbSizeStr := ""
 	if dbSize != "" {
 		dbSizeStr = fmt.Sprintf("\nDatabase Size: %s", dbSize)
@@ -1,8 +1,11 @@
 package utils_test
 
 import (
+	"encoding/json"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"time"
 
 	"github.com/blang/semver"
@@ -23,12 +26,63 @@ var _ = Describe("utils/report tests", func() {
 			Expect(errMsg).To(Equal("Error Message"))
 			Expect(exitCode).To(Equal(1))
 		})
+		It("Parses a FatalError and returns its exit code", func() {
+			fatalErr := utils.FatalError{Message: "testProgram:testUser:testHost:000000-[CRITICAL]:-Invalid flag", ExitCode: utils.EXIT_INVALID_FLAGS}
+			errMsg, exitCode := utils.ParseErrorMessage(fatalErr)
+			Expect(errMsg).To(Equal("Invalid flag"))
+			Expect(exitCode).To(Equal(2))
+		})
+		It("Returns error code 0 for no error", func() {
+			errMsg, exitCode := utils.ParseErrorMessage(nil)
+			Expect(errMsg).To(Equal(""))
+			Expect(exitCode).To(Equal(0))
+		})
 		It("Returns error code 0 for an empty error message", func() {
 			errMsg, exitCode := utils.ParseErrorMessage("")
 			Expect(errMsg).To(Equal(""))
 			Expect(exitCode).To(Equal(0))
 		})
 	})
+	Describe("ReadConfigFile", func() {
+		BeforeEach(func() {
+			utils.System.Hostname = func() (string, error) { return "testhost", nil }
+		})
+		It("reads a config file", func() {
+			utils.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte("backupversion: 1.0.0\ndatabasename: testdb\ndatabaseversion: 5.0.0\nsingledatafile: true\n"), nil
+			}
+			config := utils.ReadConfigFile("filename")
+			Expect(*config).To(Equal(utils.BackupConfig{BackupVersion: "1.0.0", DatabaseName: "testdb", DatabaseVersion: "5.0.0", SingleDataFile: true}))
+		})
+		It("panics if a config file is missing the values written first", func() {
+			utils.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte("backupversion: 1.0.0\ndatabasename: testdb\n"), nil
+			}
+			defer testutils.ShouldPanicWithMessage("Config file filename on host testhost is truncated or corrupt: the backup version, database name, or database version is missing")
+			utils.ReadConfigFile("filename")
+		})
+		It("reads a config file written by WriteConfigFile", func() {
+			tempDir, _ := ioutil.TempDir("", "report_test")
+			defer os.RemoveAll(tempDir)
+			filename := path.Join(tempDir, "gpbackup_20170101010101_config.yaml")
+			report := &utils.Report{BackupConfig: utils.BackupConfig{BackupVersion: "1.0.0", DatabaseName: "testdb", DatabaseVersion: "5.0.0", SingleDataFile: true, RestorePlan: []utils.RestorePlanEntry{{Timestamp: "20170101010101", TableFQNs: []string{"public.foo"}}}}}
+			report.WriteConfigFile(filename)
+			utils.System.ReadFile = ioutil.ReadFile
+			Expect(*utils.ReadConfigFile(filename)).To(Equal(report.BackupConfig))
+		})
+		It("panics if a config file ends without the end of file marker", func() {
+			utils.System.ReadFile = func(filename string) ([]byte, error) {
+				return []byte("fileformat: 1\nbackupversion: 1.0.0\ndatabasename: testdb\ndatabaseversion: 5.0.0\n"), nil
+			}
+			defer testutils.ShouldPanicWithMessage("Config file filename on host testhost is truncated or corrupt: the end of file marker is missing")
+			utils.ReadConfigFile("filename")
+		})
+		It("panics if a config file is empty", func() {
+			utils.System.ReadFile = func(filename string) ([]byte, error) { return []byte{}, nil }
+			defer testutils.ShouldPanicWithMessage("Config file filename on host testhost is truncated or corrupt: the file is empty")
+			utils.ReadConfigFile("filename")
+		})
+	})
 	Describe("WriteReportFile", func() {
 		timestamp := "20170101010101"
 		config := utils.BackupConfig{
@@ -52,10 +106,11 @@ Data File Format: Single Data File Per Segment`,
 			utils.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
 				return buffer, nil
 			}
+			utils.System.Rename = func(oldpath string, newpath string) error { return nil }
 		})
 
 		It("writes a report for a successful backup", func() {
-			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "")
+			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "", 0)
 			Expect(buffer).To(gbytes.Say(`Greenplum Database Backup Report
 
 Timestamp Key: 20170101010101
@@ -75,6 +130,7 @@ End Time: 2017-01-01 05:04:03
 Duration: 4:03:02
 
 Backup Status: Success
+Exit Code: 0
 
 Database Size: 42 MB
 Count of Database Objects in Backup:
@@ -83,7 +139,7 @@ tables                       42
 types                        1000`))
 		})
 		It("writes a report for a failed backup", func() {
-			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "Cannot access /tmp/backups: Permission denied")
+			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "Cannot access /tmp/backups: Permission denied", 1)
 			Expect(buffer).To(gbytes.Say(`Greenplum Database Backup Report
 
 Timestamp Key: 20170101010101
@@ -104,16 +160,70 @@ Duration: 4:03:02
 
 Backup Status: Failure
 Backup Error: Cannot access /tmp/backups: Permission denied
+Exit Code: 1
 
 Database Size: 42 MB
 Count of Database Objects in Backup:
 sequences                    1
 tables                       42
 types                        1000`))
+		})
+		It("writes a report listing the tables backed up in resumed snapshots", func() {
+			backupReport.ResumedSnapshots = []utils.ResumedSnapshot{{StartTime: "2017-01-01 03:02:01", Tables: []string{"public.foo", "public.bar"}}}
+			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "", 0)
+			Expect(buffer).To(gbytes.Say(`Backup Status: Success
+Exit Code: 0
+
+Database Size: 42 MB
+Data for the following tables was backed up in a different snapshot, taken when the backup was resumed at 2017-01-01 03:02:01:
+public\.foo
+public\.bar
+
+Count of Database Objects in Backup:
+sequences                    1`))
+		})
+		It("writes a report listing the objects matched by each pattern filter", func() {
+			backupReport.PatternMatches = []utils.PatternMatch{
+				{Flag: "include-schema-pattern", Patterns: []string{"sales_*"}, Matches: []string{"sales_2017", "sales_2018"}},
+				{Flag: "exclude-table-pattern", Patterns: []string{"staging.tmp_*", "staging.old_*"}, Matches: []string{}},
+			}
+			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "", 0)
+			Expect(buffer).To(gbytes.Say(`Backup Status: Success
+Exit Code: 0
+
+Database Size: 42 MB
+The following objects matched --include-schema-pattern sales_\*:
+sales_2017
+sales_2018
+
+The following objects matched --exclude-table-pattern staging\.tmp_\*, staging\.old_\*:
+
+Count of Database Objects in Backup:
+sequences                    1`))
+		})
+		It("writes a report listing the tables backed up with a predicate", func() {
+			backupReport.TablePredicates = map[string]string{"sales.orders": "order_date > '2017-01-01'", "public.foo": "i > 10"}
+			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "", 0)
+			Expect(buffer).To(gbytes.Say(`Database Size: 42 MB
+Only the rows matching the following predicates were backed up, so the data for these tables is a subset:
+public\.foo WHERE i > 10
+sales\.orders WHERE order_date > '2017-01-01'
+
+Count of Database Objects in Backup:`))
+		})
+		It("writes a report listing the masked columns", func() {
+			backupReport.MaskedColumns = map[string]string{"public.customers.ssn": "null", "public.customers.email": "hash"}
+			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "", 0)
+			Expect(buffer).To(gbytes.Say(`Database Size: 42 MB
+The data for the following columns was masked with the specified transforms:
+public\.customers\.email: hash
+public\.customers\.ssn: null
+
+Count of Database Objects in Backup:`))
 		})
 		It("writes a report without database size information", func() {
 			backupReport.DatabaseSize = ""
-			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "")
+			backupReport.WriteReportFile("filename", timestamp, objectCounts, endTime, "", 0)
 			Expect(buffer).To(gbytes.Say(`Greenplum Database Backup Report
 
 Timestamp Key: 20170101010101
@@ -133,6 +243,7 @@ End Time: 2017-01-01 05:04:03
 Duration: 4:03:02
 
 Backup Status: Success
+Exit Code: 0
 
 Count of Database Objects in Backup:
 sequences                    1
@@ -140,16 +251,130 @@ tables                       42
 types                        1000`))
 		})
 	})
+	Describe("ConstructJSONReport", func() {
+		backupReport := &utils.Report{
+			DatabaseSize:  "42 MB",
+			MaskedColumns: map[string]string{"public.customers.ssn": "null"},
+			BackupConfig: utils.BackupConfig{
+				BackupVersion:   "0.1.0",
+				DatabaseName:    "testdb",
+				DatabaseVersion: "5.0.0 build test",
+			},
+		}
+		endTime := time.Date(2017, 1, 1, 5, 4, 3, 2, time.Local)
+		objectCounts := map[string]int{"tables": 42}
+		tables := []utils.TableReport{{Name: "public.foo", Rows: 10, SegmentBytes: map[int]uint64{0: 100, 1: 200}, Predicate: "i > 5"}}
+
+		It("constructs a report for a successful backup", func() {
+			jsonReport := backupReport.ConstructJSONReport("20170101010101", objectCounts, tables, endTime, "", 0)
+			Expect(jsonReport.Utility).To(Equal("gpbackup"))
+			Expect(jsonReport.Version).To(Equal("0.1.0"))
+			Expect(jsonReport.DatabaseName).To(Equal("testdb"))
+			Expect(jsonReport.DatabaseSize).To(Equal("42 MB"))
+			Expect(jsonReport.StartTime).To(Equal(time.Date(2017, 1, 1, 1, 1, 1, 0, time.Local).Format(time.RFC3339)))
+			Expect(jsonReport.EndTime).To(Equal(endTime.Format(time.RFC3339)))
+			Expect(jsonReport.DurationSeconds).To(Equal(int64(14582)))
+			Expect(jsonReport.Status).To(Equal("Success"))
+			Expect(jsonReport.ErrorMessage).To(Equal(""))
+			Expect(jsonReport.ExitCode).To(Equal(0))
+			Expect(jsonReport.ObjectCounts).To(Equal(objectCounts))
+			Expect(jsonReport.Tables).To(Equal(tables))
+			Expect(jsonReport.MaskedColumns).To(Equal(backupReport.MaskedColumns))
+		})
+		It("constructs a report for a failed backup", func() {
+			jsonReport := backupReport.ConstructJSONReport("20170101010101", objectCounts, nil, endTime, "Cannot access /tmp/backups: Permission denied", 1)
+			Expect(jsonReport.Status).To(Equal("Failure"))
+			Expect(jsonReport.ErrorMessage).To(Equal("Cannot access /tmp/backups: Permission denied"))
+			Expect(jsonReport.ExitCode).To(Equal(1))
+			Expect(jsonReport.Tables).To(Equal([]utils.TableReport{}))
+		})
+	})
+	Describe("JSONReport.WriteToFile", func() {
+		BeforeEach(func() {
+			utils.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+				return buffer, nil
+			}
+			utils.System.Rename = func(oldpath string, newpath string) error { return nil }
+		})
+		It("writes the report as JSON", func() {
+			jsonReport := &utils.JSONReport{
+				Utility:  "gprestore",
+				Status:   "Success",
+				Tables:   []utils.TableReport{{Name: "public.foo", Rows: 10, External: true}},
+				Warnings: []string{"something happened"},
+			}
+			jsonReport.WriteToFile("filename")
+			readReport := utils.JSONReport{}
+			Expect(json.Unmarshal(buffer.Contents(), &readReport)).To(Succeed())
+			Expect(readReport).To(Equal(*jsonReport))
+			Expect(string(buffer.Contents())).To(ContainSubstring(`"external": true`))
+			Expect(string(buffer.Contents())).ToNot(ContainSubstring("segment_bytes"))
+		})
+	})
+	Describe("WriteRestoreReportFile", func() {
+		config := &utils.BackupConfig{
+			BackupVersion:   "0.1.0",
+			DatabaseName:    "testdb",
+			DatabaseVersion: "5.0.0 build test",
+		}
+		startTime := time.Date(2017, 1, 2, 1, 1, 1, 0, time.Local)
+		endTime := time.Date(2017, 1, 2, 1, 3, 4, 0, time.Local)
+		BeforeEach(func() {
+			utils.System.OpenFileWrite = func(name string, flag int, perm os.FileMode) (io.WriteCloser, error) {
+				return buffer, nil
+			}
+			utils.System.Rename = func(oldpath string, newpath string) error { return nil }
+		})
+		It("writes a report for a successful restore", func() {
+			tables := []utils.TableReport{{Name: "public.foo", Rows: 10}, {Name: "public.bar", Rows: 0}}
+			utils.WriteRestoreReportFile("filename", "20170101010101", config, "0.2.0", "newdb", startTime, endTime, tables, "", 0)
+			Expect(buffer).To(gbytes.Say(`Greenplum Database Restore Report
+
+Timestamp Key: 20170101010101
+GPDB Version: 5\.0\.0 build test
+gpbackup Version: 0\.1\.0
+gprestore Version: 0\.2\.0
+
+Database Name: testdb
+Restore Database Name: newdb
+Command Line: .*
+
+Start Time: 2017-01-02 01:01:01
+End Time: 2017-01-02 01:03:04
+Duration: 0:02:03
+
+Restore Status: Success
+Exit Code: 0
+
+Data Restored:
+public\.foo +10 rows
+public\.bar +0 rows`))
+		})
+		It("writes a report for a failed restore", func() {
+			utils.WriteRestoreReportFile("filename", "20170101010101", config, "0.2.0", "testdb", startTime, endTime, nil, "Error loading data into table public.foo", 1)
+			Expect(buffer).To(gbytes.Say(`Restore Status: Failure
+Restore Error: Error loading data into table public\.foo
+Exit Code: 1
+`))
+			Expect(buffer).ToNot(gbytes.Say("Data Restored"))
+		})
+		It("writes a report for a restore that completed with errors", func() {
+			utils.WriteRestoreReportFile("filename", "20170101010101", config, "0.2.0", "testdb", startTime, endTime, nil, "", utils.EXIT_COMPLETED_WITH_ERRORS)
+			Expect(buffer).To(gbytes.Say(`Restore Status: Completed With Errors
+Exit Code: 6
+`))
+		})
+	})
 	Describe("ConstructBackupParamStringFromFlags", func() {
 		var backupReport *utils.Report
 		BeforeEach(func() {
 			backupReport = &utils.Report{}
 		})
 		AfterEach(func() {
-			utils.InitializeCompressionParameters(false, 0)
+			utils.InitializeCompressionParameters(false, "gzip", 0)
 		})
 		DescribeTable("Backup type classification", func(dataOnly bool, ddlOnly bool, noCompression bool, isSchemaFiltered bool, isTableFiltered bool, singleDataFile bool, withStats bool, expectedType string) {
-			utils.InitializeCompressionParameters(!noCompression, 0)
+			utils.InitializeCompressionParameters(!noCompression, "gzip", 0)
 			backupReport.ConstructBackupParamsStringFromFlags(dataOnly, ddlOnly, isSchemaFiltered, isTableFiltered, singleDataFile, withStats)
 			Expect(backupReport.BackupParamsString).To(Equal(expectedType))
 		},
@@ -209,14 +434,14 @@ Includes Statistics: No
 Data File Format: Multiple Data Files Per Segment`),
 		)
 		It("sets properties on the report struct with various flag combinations", func() {
-			utils.InitializeCompressionParameters(false, 0)
+			utils.InitializeCompressionParameters(false, "gzip", 0)
 			backupReport.ConstructBackupParamsStringFromFlags(true, false, false, true, true, false)
 			expectedBackupConfig := utils.BackupConfig{Compressed: false, DataOnly: true, SchemaFiltered: false, TableFiltered: true, MetadataOnly: false, SingleDataFile: true, WithStatistics: false}
 			testutils.ExpectStructsToMatch(expectedBackupConfig, backupReport.BackupConfig)
 			backupReport = &utils.Report{}
-			utils.InitializeCompressionParameters(true, 0)
+			utils.InitializeCompressionParameters(true, "gzip", 0)
 			backupReport.ConstructBackupParamsStringFromFlags(false, true, true, false, false, true)
-			expectedBackupConfig = utils.BackupConfig{Compressed: true, DataOnly: false, SchemaFiltered: true, TableFiltered: false, MetadataOnly: true, SingleDataFile: false, WithStatistics: true}
+			expectedBackupConfig = utils.BackupConfig{Compressed: true, CompressionType: "gzip", DataOnly: false, SchemaFiltered: true, TableFiltered: false, MetadataOnly: true, SingleDataFile: false, WithStatistics: true}
 			testutils.ExpectStructsToMatch(expectedBackupConfig, backupReport.BackupConfig)
 		})
 	})
@@ -2,9 +2,14 @@ package utils
 
 /*
  * This file contains an implementation of a set as a wrapper around a map[string]bool
- * for use in filtering lists.
+ * for use in filtering lists, along with functions for filtering on patterns.
  */
 
+import (
+	"path"
+	"strings"
+)
+
 /*
  * This set implementation can be used in one of two ways.  An "include" set
  * returns true if an item is in the map and false otherwise, while an "exclude"
@@ -74,3 +79,56 @@ func (s *FilterSet) Delete(item string) {
 		s.AlwaysMatchesFilter = true
 	}
 }
+
+/*
+ * Filter patterns are shell-style glob patterns, as accepted by path.Match,
+ * and must match the whole name being filtered on, so "staging.tmp_*" matches
+ * every table in the staging schema whose name begins with "tmp_".
+ */
+func ValidateFilterPatterns(flagName string, patterns []string) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			logger.Fatal(NewFlagError("Invalid pattern %s for --%s", pattern, flagName), "")
+		}
+	}
+}
+
+func GetNamesMatchingPatterns(names []string, patterns []string) []string {
+	matches := make([]string, 0)
+	for _, name := range names {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, name); matched {
+				matches = append(matches, name)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+/*
+ * The names matching the patterns are added to the filter list, and are also
+ * returned so that callers can report them.  An include pattern that matches
+ * nothing is an error, as an empty include list would otherwise cause
+ * everything to be backed up or restored.
+ */
+func AddPatternMatchesToFilterList(filterList []string, flagName string, patterns []string, names []string) ([]string, []string) {
+	if len(patterns) == 0 {
+		return filterList, []string{}
+	}
+	matches := GetNamesMatchingPatterns(names, patterns)
+	if len(matches) == 0 && strings.HasPrefix(flagName, "include") && len(filterList) == 0 {
+		logger.Fatal(NewFlagError("No objects matched --%s %s", flagName, strings.Join(patterns, ", ")), "")
+	}
+	logger.Verbose("%d objects matched --%s %s: %s", len(matches), flagName, strings.Join(patterns, ", "), strings.Join(matches, ", "))
+	inFilterList := make(map[string]bool, len(filterList))
+	for _, name := range filterList {
+		inFilterList[name] = true
+	}
+	for _, name := range matches {
+		if !inFilterList[name] {
+			filterList = append(filterList, name)
+		}
+	}
+	return filterList, matches
+}
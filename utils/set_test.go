@@ -1,6 +1,7 @@
 package utils_test
 
 import (
+	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
 
 	. "github.com/onsi/ginkgo"
@@ -96,4 +97,58 @@ var _ = Describe("utils/set tests", func() {
 			Expect(inc.AlwaysMatchesFilter).To(BeTrue())
 		})
 	})
+	Describe("ValidateFilterPatterns", func() {
+		It("does not panic if all patterns are valid", func() {
+			utils.ValidateFilterPatterns("include-table-pattern", []string{"public.foo", "staging.tmp_*", "public.ba?", "public.[a-c]*"})
+		})
+		It("panics if a pattern is invalid", func() {
+			defer testutils.ShouldPanicWithMessage("Invalid pattern public.[a-c for --include-table-pattern")
+			utils.ValidateFilterPatterns("include-table-pattern", []string{"public.foo", "public.[a-c"})
+		})
+	})
+	Describe("GetNamesMatchingPatterns", func() {
+		names := []string{"public.foo", "staging.tmp_1", "staging.tmp_2", "staging.keep"}
+		It("returns the names matching any pattern, in order", func() {
+			matches := utils.GetNamesMatchingPatterns(names, []string{"staging.tmp_*", "public.fo?"})
+			Expect(matches).To(Equal([]string{"public.foo", "staging.tmp_1", "staging.tmp_2"}))
+		})
+		It("only matches whole names", func() {
+			matches := utils.GetNamesMatchingPatterns(names, []string{"tmp_*", "staging.tmp"})
+			Expect(matches).To(BeEmpty())
+		})
+		It("returns an empty list if there are no patterns", func() {
+			Expect(utils.GetNamesMatchingPatterns(names, []string{})).To(BeEmpty())
+		})
+	})
+	Describe("AddPatternMatchesToFilterList", func() {
+		names := []string{"public.foo", "staging.tmp_1", "staging.tmp_2", "staging.keep"}
+		It("returns the filter list unchanged if there are no patterns", func() {
+			filterList, _ := utils.AddPatternMatchesToFilterList([]string{"public.foo"}, "exclude-table-pattern", []string{}, names)
+			Expect(filterList).To(Equal([]string{"public.foo"}))
+		})
+		It("adds the names matching the patterns to the filter list", func() {
+			filterList, _ := utils.AddPatternMatchesToFilterList([]string{}, "exclude-table-pattern", []string{"staging.tmp_*"}, names)
+			Expect(filterList).To(Equal([]string{"staging.tmp_1", "staging.tmp_2"}))
+		})
+		It("does not add names that are already in the filter list", func() {
+			filterList, _ := utils.AddPatternMatchesToFilterList([]string{"staging.tmp_2", "public.bar"}, "include-table-pattern", []string{"staging.tmp_*"}, names)
+			Expect(filterList).To(Equal([]string{"staging.tmp_2", "public.bar", "staging.tmp_1"}))
+		})
+		It("does not panic if an exclude pattern matches nothing", func() {
+			filterList, _ := utils.AddPatternMatchesToFilterList([]string{}, "exclude-table-pattern", []string{"staging.old_*"}, names)
+			Expect(filterList).To(BeEmpty())
+		})
+		It("does not panic if an include pattern matches nothing but other objects are included by name", func() {
+			filterList, _ := utils.AddPatternMatchesToFilterList([]string{"public.foo"}, "include-table-pattern", []string{"staging.old_*"}, names)
+			Expect(filterList).To(Equal([]string{"public.foo"}))
+		})
+		It("returns the names matching the patterns", func() {
+			_, matches := utils.AddPatternMatchesToFilterList([]string{"staging.tmp_2"}, "exclude-table-pattern", []string{"staging.tmp_*"}, names)
+			Expect(matches).To(Equal([]string{"staging.tmp_1", "staging.tmp_2"}))
+		})
+		It("panics if an include pattern matches nothing", func() {
+			defer testutils.ShouldPanicWithMessage("No objects matched --include-table-pattern staging.old_*, archive.*")
+			utils.AddPatternMatchesToFilterList([]string{}, "include-table-pattern", []string{"staging.old_*", "archive.*"}, names)
+		})
+	})
 })
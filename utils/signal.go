@@ -0,0 +1,102 @@
+package utils
+
+/*
+ * This file contains structs and functions related to canceling a backup or
+ * restore when gpbackup or gprestore receives SIGINT or SIGTERM.
+ */
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	canceled int32
+)
+
+func WasCanceled() bool {
+	return atomic.LoadInt32(&canceled) == 1
+}
+
+func SetCanceled(wasCanceled bool) {
+	if wasCanceled {
+		atomic.StoreInt32(&canceled, 1)
+	} else {
+		atomic.StoreInt32(&canceled, 0)
+	}
+}
+
+/*
+ * The signal handler does not clean up itself, as the main goroutine could be
+ * in the middle of creating the very files and processes to be cleaned up.
+ * Instead, cancelFunc cancels the queries in progress so that the main
+ * goroutine fails out of whatever it is doing, and the cleanup is done by the
+ * deferred functions and DoTeardown() as for any other error.  Any further
+ * signals are ignored so that they do not interrupt the cleanup.
+ */
+func InitializeSignalHandler(cancelFunc func(), procDesc string) {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range signalChan {
+			if !atomic.CompareAndSwapInt32(&canceled, 0, 1) {
+				logger.Warn("Received %s while canceling %s; cleanup is already in progress", sig, procDesc)
+				continue
+			}
+			fmt.Println() // Move the warning to its own line, after the "^C" printed by the terminal
+			logger.Warn("Received %s, canceling %s", sig, procDesc)
+			cancelFunc()
+		}
+	}()
+}
+
+// This is called between tables and statements, as a query is only canceled if it is in progress
+func CheckCanceled() {
+	if WasCanceled() {
+		logger.Fatal(errors.New("Canceled by a termination signal"), "")
+	}
+}
+
+/*
+ * A panic in a worker goroutine cannot be recovered by DoTeardown(), which
+ * runs in the main goroutine, so it would exit the program without cleaning
+ * up.  Each worker instead defers Recover(), which stops the other workers
+ * from starting new tasks, and the main goroutine calls Repanic() once all of
+ * the workers have finished.
+ */
+type WorkerPanic struct {
+	lock   sync.Mutex
+	value  interface{}
+	failed int32
+}
+
+func (workerPanic *WorkerPanic) Recover() {
+	if value := recover(); value != nil {
+		workerPanic.lock.Lock()
+		defer workerPanic.lock.Unlock()
+		if workerPanic.value == nil {
+			workerPanic.value = value
+		}
+		atomic.StoreInt32(&workerPanic.failed, 1)
+	}
+}
+
+func (workerPanic *WorkerPanic) ShouldStop() bool {
+	return atomic.LoadInt32(&workerPanic.failed) == 1 || WasCanceled()
+}
+
+func (workerPanic *WorkerPanic) Repanic() {
+	workerPanic.lock.Lock()
+	value := workerPanic.value
+	workerPanic.lock.Unlock()
+	if value != nil {
+		panic(value)
+	}
+	CheckCanceled()
+}
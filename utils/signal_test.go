@@ -0,0 +1,67 @@
+package utils_test
+
+import (
+	"sync"
+
+	"github.com/greenplum-db/gpbackup/testutils"
+	"github.com/greenplum-db/gpbackup/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("utils/signal tests", func() {
+	AfterEach(func() {
+		utils.SetCanceled(false)
+	})
+	Describe("CheckCanceled", func() {
+		It("does nothing if no termination signal was received", func() {
+			utils.CheckCanceled()
+		})
+		It("panics with the canceled exit code if a termination signal was received", func() {
+			utils.SetCanceled(true)
+			defer func() {
+				fatalErr, ok := recover().(utils.FatalError)
+				Expect(ok).To(BeTrue())
+				Expect(fatalErr.Message).To(ContainSubstring("Canceled by a termination signal"))
+				Expect(fatalErr.ExitCode).To(Equal(utils.EXIT_CANCELED))
+			}()
+			utils.CheckCanceled()
+		})
+	})
+	Describe("WorkerPanic", func() {
+		It("re-panics in the calling goroutine with the value recovered in a worker", func() {
+			var workerPanic utils.WorkerPanic
+			var workerPool sync.WaitGroup
+			workerPool.Add(1)
+			go func() {
+				defer workerPool.Done()
+				defer workerPanic.Recover()
+				utils.Abort("worker failed")
+			}()
+			workerPool.Wait()
+			Expect(workerPanic.ShouldStop()).To(BeTrue())
+			defer testutils.ShouldPanicWithMessage("worker failed")
+			workerPanic.Repanic()
+		})
+		It("does not panic if no worker panicked", func() {
+			var workerPanic utils.WorkerPanic
+			var workerPool sync.WaitGroup
+			workerPool.Add(1)
+			go func() {
+				defer workerPool.Done()
+				defer workerPanic.Recover()
+			}()
+			workerPool.Wait()
+			Expect(workerPanic.ShouldStop()).To(BeFalse())
+			workerPanic.Repanic()
+		})
+		It("stops the workers and panics once they finish if a termination signal was received", func() {
+			var workerPanic utils.WorkerPanic
+			utils.SetCanceled(true)
+			Expect(workerPanic.ShouldStop()).To(BeTrue())
+			defer testutils.ShouldPanicWithMessage("Canceled by a termination signal")
+			workerPanic.Repanic()
+		})
+	})
+})
@@ -0,0 +1,436 @@
+package utils
+
+/*
+ * This file contains structs and functions related to executing commands on
+ * the segment hosts over SSH connections made by gpbackup itself, instead of
+ * by running ssh once for each command on each segment.
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+/*
+ * OpenSSH servers allow 10 sessions on each connection by default, so the
+ * commands for any further segments on a host wait for a session to finish.
+ */
+const MAX_SSH_SESSIONS_PER_HOST = 10
+
+/*
+ * The SSHExecutor keeps one connection open to each host for as long as the
+ * program runs, and runs the command for each segment in its own session on
+ * the connection to that segment's host.  The commands are generated as for
+ * the GPDBExecutor, so that the two are interchangeable; commands for the
+ * master are still run locally, and ssh and scp commands are run over the
+ * connections instead of by the ssh and scp programs.
+ *
+ * The HostName, Port, User, IdentityFile, and ProxyJump settings for each host
+ * are read from HostConfig, if it is set; Port is used for hosts without one.
+ */
+type SSHExecutor struct {
+	GPDBExecutor
+	ClientConfig *ssh.ClientConfig
+	HostConfig   SSHHostConfig
+	Port         int
+	hosts        map[string]*sshHost
+	lock         sync.Mutex
+}
+
+// This is implemented by ssh_config.UserSettings, which reads ~/.ssh/config and /etc/ssh/ssh_config
+type SSHHostConfig interface {
+	Get(alias string, key string) string
+	GetAll(alias string, key string) []string
+}
+
+type sshHost struct {
+	client       *ssh.Client
+	err          error
+	sessions     chan struct{}
+	openSessions int32
+	lock         sync.Mutex
+}
+
+// A host to connect to, with the user and address resolved from the SSH config
+type sshTarget struct {
+	alias   string
+	user    string
+	address string
+}
+
+/*
+ * Unlike the ssh program as run by the GPDBExecutor, the host keys of the
+ * segment hosts are checked against the known_hosts files.  The client is
+ * authenticated with the keys in the user's SSH agent, if any, and with the
+ * user's unencrypted default private keys.
+ */
+func NewSSHExecutor() *SSHExecutor {
+	_, homeDir, _ := GetUserAndHostInfo()
+	knownHostsFiles := make([]string, 0)
+	for _, filename := range []string{path.Join(homeDir, ".ssh", "known_hosts"), "/etc/ssh/ssh_known_hosts"} {
+		if _, err := System.Stat(filename); err == nil {
+			knownHostsFiles = append(knownHostsFiles, filename)
+		}
+	}
+	if len(knownHostsFiles) == 0 {
+		logger.Fatal(errors.Errorf("No known_hosts file found in %s or /etc/ssh, so the segment host keys cannot be verified", path.Join(homeDir, ".ssh")), "")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFiles...)
+	if err != nil {
+		logger.Fatal(err, "Unable to read known_hosts files")
+	}
+	authMethods := getSSHAuthMethods(homeDir)
+	if len(authMethods) == 0 {
+		logger.Fatal(errors.Errorf("No SSH agent or unencrypted private key found in %s with which to connect to the segment hosts", path.Join(homeDir, ".ssh")), "")
+	}
+	config := &ssh.ClientConfig{
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+	}
+	return &SSHExecutor{ClientConfig: config, HostConfig: ssh_config.DefaultUserSettings, Port: 22}
+}
+
+func getSSHAuthMethods(homeDir string) []ssh.AuthMethod {
+	authMethods := make([]ssh.AuthMethod, 0)
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		agentConn, err := net.Dial("unix", socket)
+		if err == nil {
+			authMethods = append(authMethods, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		} else {
+			logger.Verbose("Unable to connect to SSH agent: %v", err)
+		}
+	}
+	keyFiles := make([]string, 0)
+	for _, keyFile := range []string{"id_rsa", "id_ecdsa", "id_ed25519"} {
+		keyFiles = append(keyFiles, path.Join(homeDir, ".ssh", keyFile))
+	}
+	if signers := readPrivateKeys(keyFiles); len(signers) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeys(signers...))
+	}
+	return authMethods
+}
+
+// Key files that are missing, encrypted, or invalid are skipped
+func readPrivateKeys(keyFiles []string) []ssh.Signer {
+	signers := make([]ssh.Signer, 0)
+	for _, keyFile := range keyFiles {
+		keyBytes, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			logger.Verbose("Skipping private key %s: %v", keyFile, err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+func (executor *SSHExecutor) ExecuteClusterCommand(commandMap map[int][]string) *RemoteOutput {
+	return executeCommandsInParallel(commandMap, executor.executeCommand)
+}
+
+func (executor *SSHExecutor) executeCommand(command []string) (string, string, error) {
+	if user, host, commandStr, ok := parseSSHCommand(command); ok {
+		return executor.executeRemoteCommand(user, host, commandStr, nil)
+	}
+	if sourceFile, host, destFile, ok := parseSCPCommand(command); ok {
+		return executor.copyFile(sourceFile, host, destFile)
+	}
+	return executeCommand(command)
+}
+
+// This parses a command generated by ConstructSSHCommand
+func parseSSHCommand(command []string) (string, string, string, bool) {
+	if len(command) != 5 || command[0] != "ssh" {
+		return "", "", "", false
+	}
+	userAndHost := strings.SplitN(command[3], "@", 2)
+	if len(userAndHost) != 2 {
+		return "", "", "", false
+	}
+	return userAndHost[0], userAndHost[1], command[4], true
+}
+
+// This parses a command generated by CopyFileToAllHosts
+func parseSCPCommand(command []string) (string, string, string, bool) {
+	if len(command) != 5 || command[0] != "scp" {
+		return "", "", "", false
+	}
+	hostAndFile := strings.SplitN(command[4], ":", 2)
+	if len(hostAndFile) != 2 {
+		return "", "", "", false
+	}
+	return command[3], hostAndFile[0], hostAndFile[1], true
+}
+
+/*
+ * The file is created with the permissions of the source file, as scp does,
+ * and is not readable by anyone else while it is being written, as the files
+ * copied include encryption keys.
+ */
+func (executor *SSHExecutor) copyFile(sourceFile string, host string, destFile string) (string, string, error) {
+	file, err := os.Open(sourceFile)
+	if err != nil {
+		return "", err.Error(), err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err.Error(), err
+	}
+	currentUser, _, _ := GetUserAndHostInfo()
+	quotedFile := fmt.Sprintf("'%s'", strings.Replace(destFile, "'", `'\''`, -1))
+	commandStr := fmt.Sprintf("umask 077 && cat > %s && chmod %o %s", quotedFile, info.Mode().Perm(), quotedFile)
+	return executor.executeRemoteCommand(currentUser, host, commandStr, file)
+}
+
+func (executor *SSHExecutor) executeRemoteCommand(user string, host string, commandStr string, stdin io.Reader) (string, string, error) {
+	sshHost := executor.getHost(user, host)
+	sshHost.sessions <- struct{}{}
+	defer func() { <-sshHost.sessions }()
+	session, err := sshHost.newSession(executor, user, host)
+	if err != nil {
+		return "", err.Error(), err
+	}
+	defer atomic.AddInt32(&sshHost.openSessions, -1)
+	defer session.Close()
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	session.Stdin = stdin
+	err = session.Run(commandStr)
+	return stdout.String(), stderr.String(), err
+}
+
+func (executor *SSHExecutor) getHost(user string, host string) *sshHost {
+	executor.lock.Lock()
+	defer executor.lock.Unlock()
+	if executor.hosts == nil {
+		executor.hosts = make(map[string]*sshHost, 0)
+	}
+	key := fmt.Sprintf("%s@%s", user, host)
+	if _, ok := executor.hosts[key]; !ok {
+		executor.hosts[key] = &sshHost{sessions: make(chan struct{}, MAX_SSH_SESSIONS_PER_HOST)}
+	}
+	return executor.hosts[key]
+}
+
+/*
+ * The connection to each host is made by the first session on it.  If the
+ * connection fails, the error is returned for every later session as well,
+ * rather than trying to connect again for each segment on the host.
+ *
+ * A server may refuse a session on a working connection, for instance if its
+ * MaxSessions setting is lower than MAX_SSH_SESSIONS_PER_HOST, so in that case
+ * we wait for our other sessions on the connection to finish and try again.
+ * The server may not have cleaned up a session as soon as it finishes, so we
+ * try a few more times once none of our sessions are open before giving up.
+ * The connection is only reopened if it has been closed.
+ */
+func (sshHost *sshHost) newSession(executor *SSHExecutor, user string, host string) (*ssh.Session, error) {
+	idleRetries := 0
+	for {
+		session, err := sshHost.tryNewSession(executor, user, host)
+		if _, refused := err.(*ssh.OpenChannelError); !refused {
+			return session, err
+		}
+		if atomic.LoadInt32(&sshHost.openSessions) == 0 {
+			if idleRetries == 10 {
+				return session, err
+			}
+			idleRetries++
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (sshHost *sshHost) tryNewSession(executor *SSHExecutor, user string, host string) (*ssh.Session, error) {
+	sshHost.lock.Lock()
+	defer sshHost.lock.Unlock()
+	if sshHost.client == nil && sshHost.err == nil {
+		sshHost.client, sshHost.err = executor.dial(user, host)
+	}
+	if sshHost.err != nil {
+		return nil, sshHost.err
+	}
+	session, err := sshHost.client.NewSession()
+	if err != nil && !isConnectionAlive(sshHost.client) {
+		sshHost.client.Close()
+		sshHost.client, sshHost.err = executor.dial(user, host)
+		if sshHost.err != nil {
+			return nil, sshHost.err
+		}
+		session, err = sshHost.client.NewSession()
+	}
+	if err == nil {
+		atomic.AddInt32(&sshHost.openSessions, 1)
+	}
+	return session, err
+}
+
+// A server replies to a keepalive request, even to refuse it, on any open connection
+func isConnectionAlive(client *ssh.Client) bool {
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+	return err == nil
+}
+
+func (executor *SSHExecutor) getHostConfig(alias string, key string) string {
+	if executor.HostConfig == nil {
+		return ""
+	}
+	return executor.HostConfig.Get(alias, key)
+}
+
+/*
+ * The user, host, and port given for a jump host in ProxyJump take precedence
+ * over its own settings, while for the segment hosts the User setting takes
+ * precedence over the user running the command.
+ */
+func (executor *SSHExecutor) resolveTarget(user string, host string, port string, isJumpHost bool) sshTarget {
+	if configUser := executor.getHostConfig(host, "User"); configUser != "" && (user == "" || !isJumpHost) {
+		user = configUser
+	}
+	if user == "" {
+		user, _, _ = GetUserAndHostInfo()
+	}
+	if port == "" {
+		port = executor.getHostConfig(host, "Port")
+	}
+	if port == "" {
+		port = strconv.Itoa(executor.Port)
+	}
+	hostname := host
+	if configHostname := executor.getHostConfig(host, "HostName"); configHostname != "" {
+		hostname = strings.Replace(configHostname, "%h", host, -1)
+	}
+	return sshTarget{alias: host, user: user, address: net.JoinHostPort(hostname, port)}
+}
+
+// This parses a ProxyJump setting, a comma-separated list of hosts in the format [user@]host[:port]
+func (executor *SSHExecutor) getJumpHosts(host string) []sshTarget {
+	proxyJump := executor.getHostConfig(host, "ProxyJump")
+	jumpHosts := make([]sshTarget, 0)
+	if proxyJump == "" || strings.ToLower(proxyJump) == "none" {
+		return jumpHosts
+	}
+	for _, jump := range strings.Split(proxyJump, ",") {
+		jump = strings.TrimPrefix(strings.TrimSpace(jump), "ssh://")
+		user := ""
+		if i := strings.LastIndex(jump, "@"); i != -1 {
+			user, jump = jump[:i], jump[i+1:]
+		}
+		jumpHost, port, err := net.SplitHostPort(jump)
+		if err != nil {
+			jumpHost, port = jump, ""
+		}
+		jumpHosts = append(jumpHosts, executor.resolveTarget(user, jumpHost, port, true))
+	}
+	return jumpHosts
+}
+
+/*
+ * The connection to a host with ProxyJump set is made through a connection to
+ * each of its jump hosts in turn, which are closed when it is closed.
+ */
+func (executor *SSHExecutor) dial(user string, host string) (*ssh.Client, error) {
+	targets := append(executor.getJumpHosts(host), executor.resolveTarget(user, host, "", false))
+	var client *ssh.Client
+	for _, target := range targets {
+		nextClient, err := executor.dialTarget(client, target)
+		if err != nil {
+			if client != nil {
+				client.Close()
+			}
+			return nil, err
+		}
+		if client != nil {
+			go func(jumpClient *ssh.Client) {
+				nextClient.Wait()
+				jumpClient.Close()
+			}(client)
+		}
+		client = nextClient
+	}
+	return client, nil
+}
+
+/*
+ * A server may offer a type of host key other than the ones recorded for it in
+ * known_hosts, which fails the host key check, so in that case we connect again
+ * asking only for the types of key that are recorded.
+ */
+func (executor *SSHExecutor) dialTarget(jumpClient *ssh.Client, target sshTarget) (*ssh.Client, error) {
+	config := *executor.ClientConfig
+	config.User = target.user
+	if executor.HostConfig != nil {
+		identityFiles := make([]string, 0)
+		for _, identityFile := range executor.HostConfig.GetAll(target.alias, "IdentityFile") {
+			if strings.HasPrefix(identityFile, "~/") {
+				_, homeDir, _ := GetUserAndHostInfo()
+				identityFile = path.Join(homeDir, identityFile[2:])
+			}
+			identityFiles = append(identityFiles, identityFile)
+		}
+		if signers := readPrivateKeys(identityFiles); len(signers) > 0 {
+			config.Auth = append([]ssh.AuthMethod{ssh.PublicKeys(signers...)}, config.Auth...)
+		}
+	}
+	knownKeys := make([]knownhosts.KnownKey, 0)
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := executor.ClientConfig.HostKeyCallback(hostname, remote, key)
+		if keyErr, ok := err.(*knownhosts.KeyError); ok {
+			knownKeys = keyErr.Want
+		}
+		return err
+	}
+	client, err := dialSSH(jumpClient, target.address, &config)
+	if err != nil && len(knownKeys) > 0 && len(config.HostKeyAlgorithms) == 0 {
+		for _, knownKey := range knownKeys {
+			if knownKey.Key.Type() == ssh.KeyAlgoRSA {
+				config.HostKeyAlgorithms = append(config.HostKeyAlgorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+			}
+			config.HostKeyAlgorithms = append(config.HostKeyAlgorithms, knownKey.Key.Type())
+		}
+		config.HostKeyCallback = executor.ClientConfig.HostKeyCallback
+		client, err = dialSSH(jumpClient, target.address, &config)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to connect to %s", target.address)
+	}
+	return client, nil
+}
+
+func dialSSH(jumpClient *ssh.Client, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if jumpClient == nil {
+		return ssh.Dial("tcp", address, config)
+	}
+	conn, err := jumpClient.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	clientConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(clientConn, channels, requests), nil
+}
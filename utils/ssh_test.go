@@ -0,0 +1,339 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"sync/atomic"
+
+	"github.com/greenplum-db/gpbackup/utils"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+/*
+ * A minimal SSH server that accepts one client key and runs each "exec"
+ * request with bash, for testing the SSHExecutor without a real sshd.
+ */
+type testSSHServer struct {
+	listener       net.Listener
+	config         *ssh.ServerConfig
+	connections    int32
+	sessions       int32
+	maxSessions    int32
+	refusedSession int32
+}
+
+func newTestSSHServer(hostKey ssh.Signer, clientKey ssh.PublicKey) *testSSHServer {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	server := &testSSHServer{listener: listener, config: config}
+	go server.serve()
+	return server
+}
+
+func (server *testSSHServer) Port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (server *testSSHServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, channels, requests, err := ssh.NewServerConn(conn, server.config)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&server.connections, 1)
+			go ssh.DiscardRequests(requests)
+			for newChannel := range channels {
+				if newChannel.ChannelType() == "direct-tcpip" {
+					go serveDirectTCPIP(newChannel)
+					continue
+				}
+				maxSessions := atomic.LoadInt32(&server.maxSessions)
+				if maxSessions > 0 && atomic.AddInt32(&server.sessions, 1) > maxSessions {
+					atomic.AddInt32(&server.sessions, -1)
+					atomic.StoreInt32(&server.refusedSession, 1)
+					newChannel.Reject(ssh.Prohibited, "too many sessions")
+					continue
+				}
+				channel, channelRequests, err := newChannel.Accept()
+				if err != nil {
+					continue
+				}
+				go func() {
+					serveExec(channel, channelRequests)
+					if maxSessions > 0 {
+						atomic.AddInt32(&server.sessions, -1)
+					}
+				}()
+			}
+		}()
+	}
+}
+
+// This forwards a connection for a client using this server as a jump host
+func serveDirectTCPIP(newChannel ssh.NewChannel) {
+	payload := newChannel.ExtraData()
+	hostLength := binary.BigEndian.Uint32(payload[:4])
+	host := string(payload[4 : 4+hostLength])
+	port := binary.BigEndian.Uint32(payload[4+hostLength : 8+hostLength])
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(channel, conn)
+		channel.Close()
+	}()
+	io.Copy(conn, channel)
+	conn.Close()
+}
+
+// This returns the settings for each host from a map of host to key to values
+type testSSHHostConfig map[string]map[string][]string
+
+func (config testSSHHostConfig) Get(alias string, key string) string {
+	if values := config.GetAll(alias, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (config testSSHHostConfig) GetAll(alias string, key string) []string {
+	return config[alias][key]
+}
+
+func serveExec(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for request := range requests {
+		if request.Type != "exec" {
+			request.Reply(false, nil)
+			continue
+		}
+		request.Reply(true, nil)
+		commandLength := binary.BigEndian.Uint32(request.Payload[:4])
+		command := exec.Command("bash", "-c", string(request.Payload[4:4+commandLength]))
+		command.Stdin = channel
+		command.Stdout = channel
+		command.Stderr = channel.Stderr()
+		exitStatus := 0
+		if err := command.Run(); err != nil {
+			exitStatus = 1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitStatus = exitErr.ExitCode()
+			}
+		}
+		status := make([]byte, 4)
+		binary.BigEndian.PutUint32(status, uint32(exitStatus))
+		channel.SendRequest("exit-status", false, status)
+		return
+	}
+}
+
+var _ = Describe("utils/ssh tests", func() {
+	var (
+		server     *testSSHServer
+		executor   *utils.SSHExecutor
+		tempDir    string
+		clientKey  ssh.Signer
+		hostKey    ssh.Signer
+		knownHosts string
+
+		clientPrivateKey ed25519.PrivateKey
+	)
+	BeforeEach(func() {
+		var err error
+		_, hostPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+		hostKey, err = ssh.NewSignerFromKey(hostPrivateKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, clientPrivateKey, _ = ed25519.GenerateKey(rand.Reader)
+		clientKey, err = ssh.NewSignerFromKey(clientPrivateKey)
+		Expect(err).ToNot(HaveOccurred())
+		server = newTestSSHServer(hostKey, clientKey.PublicKey())
+
+		tempDir, err = ioutil.TempDir("", "ssh_test")
+		Expect(err).ToNot(HaveOccurred())
+		knownHosts = path.Join(tempDir, "known_hosts")
+		address := knownhosts.Normalize(fmt.Sprintf("127.0.0.1:%d", server.Port()))
+		err = ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{address}, hostKey.PublicKey())+"\n"), 0600)
+		Expect(err).ToNot(HaveOccurred())
+		hostKeyCallback, err := knownhosts.New(knownHosts)
+		Expect(err).ToNot(HaveOccurred())
+
+		executor = &utils.SSHExecutor{
+			ClientConfig: &ssh.ClientConfig{
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientKey)},
+				HostKeyCallback: hostKeyCallback,
+			},
+			Port: server.Port(),
+		}
+	})
+	AfterEach(func() {
+		server.listener.Close()
+		os.RemoveAll(tempDir)
+	})
+	Describe("ExecuteClusterCommand", func() {
+		It("runs the commands for all segments on a host over one connection", func() {
+			commandMap := map[int][]string{
+				0: utils.ConstructSSHCommand("127.0.0.1", "echo segment 0"),
+				1: utils.ConstructSSHCommand("127.0.0.1", "echo segment 1"),
+				2: utils.ConstructSSHCommand("127.0.0.1", "echo segment 2"),
+			}
+			output := executor.ExecuteClusterCommand(commandMap)
+			Expect(output.NumErrors).To(Equal(0))
+			Expect(output.Stdouts[0]).To(Equal("segment 0\n"))
+			Expect(output.Stdouts[1]).To(Equal("segment 1\n"))
+			Expect(output.Stdouts[2]).To(Equal("segment 2\n"))
+
+			output = executor.ExecuteClusterCommand(commandMap)
+			Expect(output.NumErrors).To(Equal(0))
+			Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(1)))
+		})
+		It("returns the output and error of a command that fails", func() {
+			commandMap := map[int][]string{
+				0: utils.ConstructSSHCommand("127.0.0.1", "echo output; echo failure >&2; exit 3"),
+				1: utils.ConstructSSHCommand("127.0.0.1", "true"),
+			}
+			output := executor.ExecuteClusterCommand(commandMap)
+			Expect(output.NumErrors).To(Equal(1))
+			Expect(output.Stdouts[0]).To(Equal("output\n"))
+			Expect(output.Stderrs[0]).To(Equal("failure\n"))
+			exitErr, ok := output.Errors[0].(*ssh.ExitError)
+			Expect(ok).To(BeTrue())
+			Expect(exitErr.ExitStatus()).To(Equal(3))
+			Expect(output.Errors[1]).ToNot(HaveOccurred())
+		})
+		It("copies a file to a host with its permissions", func() {
+			sourceFile := path.Join(tempDir, "source")
+			destFile := path.Join(tempDir, "dest")
+			Expect(ioutil.WriteFile(sourceFile, []byte("file contents"), 0640)).To(Succeed())
+			commandMap := map[int][]string{
+				0: {"scp", "-o", "StrictHostKeyChecking=no", sourceFile, fmt.Sprintf("127.0.0.1:%s", destFile)},
+			}
+			output := executor.ExecuteClusterCommand(commandMap)
+			Expect(output.NumErrors).To(Equal(0))
+			contents, err := ioutil.ReadFile(destFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(contents)).To(Equal("file contents"))
+			info, err := os.Stat(destFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0640)))
+		})
+		It("runs commands for the master locally", func() {
+			commandMap := map[int][]string{
+				-1: {"bash", "-c", "echo master"},
+			}
+			output := executor.ExecuteClusterCommand(commandMap)
+			Expect(output.NumErrors).To(Equal(0))
+			Expect(output.Stdouts[-1]).To(Equal("master\n"))
+			Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(0)))
+		})
+		It("fails every command for a host whose key is not in known_hosts", func() {
+			_, otherPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+			otherKey, _ := ssh.NewSignerFromKey(otherPrivateKey)
+			address := knownhosts.Normalize(fmt.Sprintf("127.0.0.1:%d", server.Port()))
+			Expect(ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{address}, otherKey.PublicKey())+"\n"), 0600)).To(Succeed())
+			hostKeyCallback, err := knownhosts.New(knownHosts)
+			Expect(err).ToNot(HaveOccurred())
+			executor.ClientConfig.HostKeyCallback = hostKeyCallback
+			commandMap := map[int][]string{
+				0: utils.ConstructSSHCommand("127.0.0.1", "echo segment 0"),
+				1: utils.ConstructSSHCommand("127.0.0.1", "echo segment 1"),
+			}
+			output := executor.ExecuteClusterCommand(commandMap)
+			Expect(output.NumErrors).To(Equal(2))
+			Expect(output.Stderrs[0]).To(ContainSubstring("key mismatch"))
+			Expect(output.Stdouts[0]).To(Equal(""))
+			Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(0)))
+		})
+		It("waits for another session to finish if the server refuses a session", func() {
+			atomic.StoreInt32(&server.maxSessions, 1)
+			commandMap := map[int][]string{
+				0: utils.ConstructSSHCommand("127.0.0.1", "sleep 0.2; echo segment 0"),
+				1: utils.ConstructSSHCommand("127.0.0.1", "sleep 0.2; echo segment 1"),
+				2: utils.ConstructSSHCommand("127.0.0.1", "sleep 0.2; echo segment 2"),
+			}
+			output := executor.ExecuteClusterCommand(commandMap)
+			Expect(output.NumErrors).To(Equal(0))
+			Expect(output.Stdouts[0]).To(Equal("segment 0\n"))
+			Expect(output.Stdouts[1]).To(Equal("segment 1\n"))
+			Expect(output.Stdouts[2]).To(Equal("segment 2\n"))
+			Expect(atomic.LoadInt32(&server.refusedSession)).To(Equal(int32(1)))
+			Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(1)))
+		})
+	})
+	Describe("SSH config", func() {
+		It("connects to the host name, port, and user given in the SSH config", func() {
+			executor.Port = 1
+			executor.HostConfig = testSSHHostConfig{"sdw1": {
+				"HostName": {"127.0.0.1"},
+				"Port":     {fmt.Sprintf("%d", server.Port())},
+				"User":     {"gpadmin"},
+			}}
+			var connectedUser string
+			publicKeyCallback := server.config.PublicKeyCallback
+			server.config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+				connectedUser = conn.User()
+				return publicKeyCallback(conn, key)
+			}
+			output := executor.ExecuteClusterCommand(map[int][]string{0: utils.ConstructSSHCommand("sdw1", "echo segment 0")})
+			Expect(output.NumErrors).To(Equal(0))
+			Expect(output.Stdouts[0]).To(Equal("segment 0\n"))
+			Expect(connectedUser).To(Equal("gpadmin"))
+		})
+		It("authenticates with the identity files given in the SSH config", func() {
+			keyBlock, err := ssh.MarshalPrivateKey(clientPrivateKey, "")
+			Expect(err).ToNot(HaveOccurred())
+			identityFile := path.Join(tempDir, "id_segments")
+			Expect(ioutil.WriteFile(identityFile, pem.EncodeToMemory(keyBlock), 0600)).To(Succeed())
+			executor.ClientConfig.Auth = []ssh.AuthMethod{}
+			executor.HostConfig = testSSHHostConfig{"127.0.0.1": {"IdentityFile": {path.Join(tempDir, "missing"), identityFile}}}
+			output := executor.ExecuteClusterCommand(map[int][]string{0: utils.ConstructSSHCommand("127.0.0.1", "echo segment 0")})
+			Expect(output.NumErrors).To(Equal(0))
+			Expect(output.Stdouts[0]).To(Equal("segment 0\n"))
+		})
+		It("connects through the jump hosts given by ProxyJump", func() {
+			executor.HostConfig = testSSHHostConfig{"sdw1": {
+				"HostName":  {"127.0.0.1"},
+				"ProxyJump": {fmt.Sprintf("127.0.0.1:%d", server.Port())},
+			}}
+			output := executor.ExecuteClusterCommand(map[int][]string{0: utils.ConstructSSHCommand("sdw1", "echo segment 0")})
+			Expect(output.NumErrors).To(Equal(0))
+			Expect(output.Stdouts[0]).To(Equal("segment 0\n"))
+			Expect(atomic.LoadInt32(&server.connections)).To(Equal(int32(2)))
+		})
+	})
+})
@@ -84,6 +84,8 @@ type SystemFunctions struct {
 	OpenFileRead  func(name string, flag int, perm os.FileMode) (ReadCloserAt, error)
 	OpenFileWrite func(name string, flag int, perm os.FileMode) (io.WriteCloser, error)
 	ReadFile      func(filename string) ([]byte, error)
+	Remove        func(name string) error
+	Rename        func(oldpath string, newpath string) error
 	Stat          func(name string) (os.FileInfo, error)
 	Stdin         ReadCloserAt
 	Stdout        io.WriteCloser
@@ -104,6 +106,8 @@ func InitializeSystemFunctions() *SystemFunctions {
 		OpenFileRead:  OpenFileRead,
 		OpenFileWrite: OpenFileWrite,
 		ReadFile:      ioutil.ReadFile,
+		Remove:        os.Remove,
+		Rename:        os.Rename,
 		Stat:          os.Stat,
 		Stdin:         os.Stdin,
 		Stdout:        os.Stdout,
@@ -4,22 +4,34 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strings"
 
-	yaml "gopkg.in/yaml.v2"
+	"github.com/pkg/errors"
 )
 
 type TOC struct {
-	metadataEntryMap  map[string]*[]MetadataEntry
-	GlobalEntries     []MetadataEntry
-	PredataEntries    []MetadataEntry
-	PostdataEntries   []MetadataEntry
-	StatisticsEntries []MetadataEntry
-	DataEntries       []MasterDataEntry
+	metadataEntryMap    map[string]*[]MetadataEntry
+	GlobalEntries       []MetadataEntry
+	PredataEntries      []MetadataEntry
+	PostdataEntries     []MetadataEntry
+	StatisticsEntries   []MetadataEntry
+	DataEntries         []MasterDataEntry
+	IncrementalMetadata IncrementalEntries
+	MetadataChecksum    string         `yaml:",omitempty"`
+	StatisticsChecksum  string         `yaml:",omitempty"`
+	SegmentTOCChecksums map[int]string `yaml:",omitempty"`
 }
 
+/*
+ * CompressionType is only set for compressed single-data-file backups whose
+ * data is written in frames, for which LastCompressedByteRead is the length of
+ * the data file and LastByteRead the length of the decompressed data.
+ */
 type SegmentTOC struct {
-	LastByteRead uint64
-	DataEntries  map[uint]SegmentDataEntry
+	LastByteRead           uint64
+	LastCompressedByteRead uint64 `yaml:",omitempty"`
+	CompressionType        string `yaml:",omitempty"`
+	DataEntries            map[uint]SegmentDataEntry
 }
 
 type MetadataEntry struct {
@@ -30,24 +42,57 @@ type MetadataEntry struct {
 	EndByte    uint64
 }
 
+/*
+ * In compressed backups with a data file per table, Bytes and CompressedBytes
+ * are the sizes of the table's data on each segment before and after
+ * compression, as counted by gpbackup_helper.
+ */
 type MasterDataEntry struct {
 	Schema          string
 	Name            string
 	Oid             uint32
 	AttributeString string
+	Checksums       map[int]string `yaml:",omitempty"`
+	Bytes           map[int]uint64 `yaml:",omitempty"`
+	CompressedBytes map[int]uint64 `yaml:",omitempty"`
+	Predicate       string         `yaml:",omitempty"`
+	IsExternal      bool           `yaml:",omitempty"`
 }
 
+/*
+ * In single-data-file backups, Checksum is the checksum of the uncompressed
+ * data for the table, which gpbackup_helper computes as it writes the data and
+ * verifies as it reads the data back.  StartByte and EndByte are offsets in the
+ * uncompressed data, and CompressedStartByte and CompressedEndByte are the
+ * offsets in the data file of the frame holding the table's compressed data.
+ */
 type SegmentDataEntry struct {
-	StartByte uint64
-	EndByte   uint64
+	StartByte           uint64
+	EndByte             uint64
+	CompressedStartByte uint64 `yaml:",omitempty"`
+	CompressedEndByte   uint64 `yaml:",omitempty"`
+	Checksum            string `yaml:",omitempty"`
+}
+
+/*
+ * These structs record the state of each append-optimized table at the time of
+ * the backup, so that a later incremental backup can determine which tables
+ * have been modified and need their data backed up again.
+ */
+type IncrementalEntries struct {
+	AO map[string]AOEntry
+}
+
+type AOEntry struct {
+	Modcount         int64
+	LastDDLTimestamp string
 }
 
 func NewTOC(filename string) *TOC {
 	toc := &TOC{}
-	contents, err := System.ReadFile(filename)
-	CheckError(err)
-	err = yaml.Unmarshal(contents, toc)
-	CheckError(err)
+	contents := MustReadBackupFile(filename)
+	hostname, _ := System.Hostname()
+	mustParseYAMLFile(contents, "Table of contents file", filename, hostname, toc)
 	return toc
 }
 
@@ -55,26 +100,60 @@ func NewSegmentTOC(filename string) *SegmentTOC {
 	toc := &SegmentTOC{}
 	contents, err := System.ReadFile(filename)
 	CheckError(err)
-	err = yaml.Unmarshal(contents, toc)
-	CheckError(err)
+	hostname, _ := System.Hostname()
+	mustParseYAMLFile(contents, "Table of contents file", filename, hostname, toc)
 	return toc
 }
 
+func (toc *TOC) validate() error {
+	for _, entries := range [][]MetadataEntry{toc.GlobalEntries, toc.PredataEntries, toc.PostdataEntries, toc.StatisticsEntries} {
+		for _, entry := range entries {
+			if entry.ObjectType == "" || entry.StartByte > entry.EndByte {
+				return errors.Errorf("the metadata entry for %s.%s is incomplete", entry.Schema, entry.Name)
+			}
+		}
+	}
+	for _, entry := range toc.DataEntries {
+		if entry.Oid == 0 || entry.Name == "" {
+			return errors.Errorf("the data entry for table %s.%s is incomplete", entry.Schema, entry.Name)
+		}
+	}
+	return nil
+}
+
+/*
+ * The entries are not checked against LastByteRead, since the segment TOC of a
+ * backup being resumed keeps the entries for tables to be backed up again.
+ * Every compressed frame holds at least its header, so it is never empty.
+ */
+func (toc *SegmentTOC) validate() error {
+	for oid, entry := range toc.DataEntries {
+		if entry.StartByte > entry.EndByte {
+			return errors.Errorf("byte range %d-%d for table with oid %d is invalid", entry.StartByte, entry.EndByte, oid)
+		}
+		if toc.CompressionType != "" && entry.CompressedStartByte >= entry.CompressedEndByte {
+			return errors.Errorf("compressed byte range %d-%d for table with oid %d is invalid", entry.CompressedStartByte, entry.CompressedEndByte, oid)
+		}
+	}
+	return nil
+}
+
 func (toc *TOC) WriteToFileAndMakeReadOnly(filename string) {
 	defer System.Chmod(filename, 0444)
 	toc.WriteToFile(filename)
 }
 
 func (toc *TOC) WriteToFile(filename string) {
-	tocFile := MustOpenFileForWriting(filename)
-	tocContents, _ := yaml.Marshal(toc)
-	MustPrintBytes(tocFile, tocContents)
+	tocFile := MustOpenBackupFileForAtomicWriting(filename)
+	MustPrintBytes(tocFile, marshalYAMLFile(toc))
+	tocFile.Close()
 }
 
+// The segment TOC is rewritten each time the helper agent finishes or is stopped
 func (toc *SegmentTOC) WriteToFile(filename string) {
-	tocFile := MustOpenFileForWriting(filename)
-	tocContents, _ := yaml.Marshal(toc)
-	MustPrintBytes(tocFile, tocContents)
+	tocFile := MustOpenFileForAtomicWriting(filename)
+	MustPrintBytes(tocFile, marshalYAMLFile(toc))
+	tocFile.Close()
 }
 
 type StatementWithType struct {
@@ -115,6 +194,30 @@ func (toc *TOC) GetAllSQLStatements(filename string, metadataFile io.ReaderAt) [
 	return statements
 }
 
+/*
+ * This checks that every entry in the given section points at a complete SQL
+ * statement in the file contents, returning a description of each entry that
+ * does not.
+ */
+func (toc *TOC) VerifyMetadataEntries(section string, contents []byte) []string {
+	problems := make([]string, 0)
+	for _, entry := range *toc.metadataEntryMap[section] {
+		objectStr := fmt.Sprintf("%s %s", entry.ObjectType, entry.Name)
+		if entry.Schema != "" {
+			objectStr = fmt.Sprintf("%s %s", entry.ObjectType, MakeFQN(entry.Schema, entry.Name))
+		}
+		if entry.StartByte > entry.EndByte || entry.EndByte > uint64(len(contents)) {
+			problems = append(problems, fmt.Sprintf("The %s entry for %s has byte range %d-%d, which does not fit in a file of %d bytes", section, objectStr, entry.StartByte, entry.EndByte, len(contents)))
+			continue
+		}
+		statement := strings.TrimSpace(string(contents[entry.StartByte:entry.EndByte]))
+		if statement == "" || !strings.HasSuffix(statement, ";") {
+			problems = append(problems, fmt.Sprintf("The %s entry for %s at byte range %d-%d does not contain a complete statement", section, objectStr, entry.StartByte, entry.EndByte))
+		}
+	}
+	return problems
+}
+
 func (toc *TOC) GetDataEntriesMatching(includeSchemas []string, includeTables []string) []MasterDataEntry {
 	restoreAllSchemas := len(includeSchemas) == 0
 	var schemaHashes map[string]bool
@@ -188,10 +291,14 @@ func (toc *TOC) AddStatisticsEntry(schema string, name string, objectType string
 }
 
 func (toc *TOC) AddMasterDataEntry(schema string, name string, oid uint32, attributeString string) {
-	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{schema, name, oid, attributeString})
+	toc.DataEntries = append(toc.DataEntries, MasterDataEntry{Schema: schema, Name: name, Oid: oid, AttributeString: attributeString})
 }
 
-func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64) {
+func (toc *SegmentTOC) AddSegmentDataEntry(oid uint, startByte uint64, endByte uint64, checksum string) {
 	// We use uint for oid since the flags package does not have a uint32 flag
-	toc.DataEntries[oid] = SegmentDataEntry{startByte, endByte}
+	toc.DataEntries[oid] = SegmentDataEntry{StartByte: startByte, EndByte: endByte, Checksum: checksum}
+}
+
+func (toc *SegmentTOC) AddCompressedSegmentDataEntry(oid uint, startByte uint64, endByte uint64, compressedStartByte uint64, compressedEndByte uint64, checksum string) {
+	toc.DataEntries[oid] = SegmentDataEntry{StartByte: startByte, EndByte: endByte, CompressedStartByte: compressedStartByte, CompressedEndByte: compressedEndByte, Checksum: checksum}
 }
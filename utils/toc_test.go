@@ -2,6 +2,9 @@ package utils_test
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
 
 	"github.com/greenplum-db/gpbackup/testutils"
 	"github.com/greenplum-db/gpbackup/utils"
@@ -27,6 +30,82 @@ var _ = Describe("utils/toc tests", func() {
 	BeforeEach(func() {
 		toc, backupfile = testutils.InitializeTestTOC(buffer, "global")
 	})
+	Describe("NewTOC and NewSegmentTOC", func() {
+		readFile := func(contents string) {
+			utils.System.ReadFile = func(filename string) ([]byte, error) { return []byte(contents), nil }
+			utils.System.Hostname = func() (string, error) { return "testhost", nil }
+		}
+		It("reads a segment table of contents file", func() {
+			readFile("lastbyteread: 10\ndataentries:\n  1:\n    startbyte: 0\n    endbyte: 10\n")
+			segmentTOC := utils.NewSegmentTOC("filename")
+			Expect(segmentTOC.LastByteRead).To(Equal(uint64(10)))
+			Expect(segmentTOC.DataEntries).To(Equal(map[uint]utils.SegmentDataEntry{1: {StartByte: 0, EndByte: 10}}))
+		})
+		It("reads a segment table of contents file written by WriteToFile", func() {
+			tempDir, _ := ioutil.TempDir("", "toc_test")
+			defer os.RemoveAll(tempDir)
+			filename := path.Join(tempDir, "gpbackup_0_20170101010101_toc.yaml")
+			segmentTOC := &utils.SegmentTOC{LastByteRead: 10, DataEntries: map[uint]utils.SegmentDataEntry{1: {StartByte: 0, EndByte: 10, Checksum: "abc"}}}
+			segmentTOC.WriteToFile(filename)
+			Expect(utils.NewSegmentTOC(filename)).To(Equal(segmentTOC))
+		})
+		It("panics if a segment table of contents file is empty", func() {
+			readFile("")
+			defer testutils.ShouldPanicWithMessage("Table of contents file filename on host testhost is truncated or corrupt: the file is empty")
+			utils.NewSegmentTOC("filename")
+		})
+		It("panics if a segment table of contents file cannot be parsed", func() {
+			readFile("lastbyteread: 10\ndataentries:\n  1:\n    startbyte: 0\n    endbyte: 10\n  2: {startbyte: 10, endb")
+			defer testutils.ShouldPanicWithMessage("Table of contents file filename on host testhost is truncated or corrupt: yaml: ")
+			utils.NewSegmentTOC("filename")
+		})
+		It("panics if a segment table of contents file has a byte range that ends before it starts", func() {
+			readFile("lastbyteread: 30\ndataentries:\n  1:\n    startbyte: 0\n    endbyte: 10\n  2:\n    startbyte: 10\n    endbyte: 3\n")
+			defer testutils.ShouldPanicWithMessage("Table of contents file filename on host testhost is truncated or corrupt: byte range 10-3 for table with oid 2 is invalid")
+			utils.NewSegmentTOC("filename")
+		})
+		It("panics if a compressed segment table of contents file has no compressed byte range for a table", func() {
+			readFile("lastbyteread: 10\nlastcompressedbyteread: 30\ncompressiontype: gzip\ndataentries:\n  1:\n    startbyte: 0\n    endbyte: 10\n")
+			defer testutils.ShouldPanicWithMessage("Table of contents file filename on host testhost is truncated or corrupt: compressed byte range 0-0 for table with oid 1 is invalid")
+			utils.NewSegmentTOC("filename")
+		})
+		It("reads a table of contents file", func() {
+			readFile("dataentries:\n- schema: public\n  name: foo\n  oid: 1\n  attributestring: (i)\n")
+			masterTOC := utils.NewTOC("filename")
+			Expect(masterTOC.DataEntries).To(Equal([]utils.MasterDataEntry{{Schema: "public", Name: "foo", Oid: 1, AttributeString: "(i)"}}))
+		})
+		It("reads a table of contents file written by WriteToFile", func() {
+			tempDir, _ := ioutil.TempDir("", "toc_test")
+			defer os.RemoveAll(tempDir)
+			filename := path.Join(tempDir, "gpbackup_20170101010101_toc.yaml")
+			masterTOC := &utils.TOC{DataEntries: []utils.MasterDataEntry{{Schema: "public", Name: "foo", Oid: 1, AttributeString: "(i)"}}}
+			masterTOC.WriteToFile(filename)
+			contents, _ := ioutil.ReadFile(filename)
+			Expect(string(contents)).To(HavePrefix("fileformat: 1\n"))
+			Expect(string(contents)).To(HaveSuffix("endoffile: true\n"))
+			Expect(utils.NewTOC(filename).DataEntries).To(Equal(masterTOC.DataEntries))
+		})
+		It("panics if a table of contents file ends between two entries", func() {
+			readFile("fileformat: 1\ndataentries:\n- schema: public\n  name: foo\n  oid: 1\n  attributestring: (i)\n")
+			defer testutils.ShouldPanicWithMessage("Table of contents file filename on host testhost is truncated or corrupt: the end of file marker is missing")
+			utils.NewTOC("filename")
+		})
+		It("panics if a segment table of contents file ends between two entries", func() {
+			readFile("fileformat: 1\nlastbyteread: 20\ndataentries:\n  1:\n    startbyte: 0\n    endbyte: 10\n")
+			defer testutils.ShouldPanicWithMessage("Table of contents file filename on host testhost is truncated or corrupt: the end of file marker is missing")
+			utils.NewSegmentTOC("filename")
+		})
+		It("panics if a table of contents file ends partway through a data entry", func() {
+			readFile("dataentries:\n- schema: public\n  name: foo\n  oid: 1\n- schema: public\n  name: bar\n")
+			defer testutils.ShouldPanicWithMessage("Table of contents file filename on host testhost is truncated or corrupt: the data entry for table public.bar is incomplete")
+			utils.NewTOC("filename")
+		})
+		It("panics if a table of contents file ends partway through a metadata entry", func() {
+			readFile("predataentries:\n- schema: public\n  name: foo\n  objecttype: TABLE\n  startbyte: 0\n  endbyte: 20\n- schema: public\n  name: bar\n")
+			defer testutils.ShouldPanicWithMessage("Table of contents file filename on host testhost is truncated or corrupt: the metadata entry for public.bar is incomplete")
+			utils.NewTOC("filename")
+		})
+	})
 	Context("GetSqlStatementForObjectTypes", func() {
 		It("returns statement for a single object type", func() {
 			backupfile.ByteCount = commentLen + createLen
@@ -202,4 +281,32 @@ COMMENT ON DATABASE "db-special-chär$" IS 'this is a database comment';`}
 `))
 		})
 	})
+	Context("VerifyMetadataEntries", func() {
+		It("returns no problems if every entry points at a complete statement", func() {
+			backupfile.ByteCount = commentLen + createLen
+			toc.AddMetadataEntry("", "somedatabase", "DATABASE", commentLen, backupfile, "global")
+			backupfile.ByteCount += role1Len
+			toc.AddMetadataEntry("", "somerole1", "ROLE", commentLen+createLen, backupfile, "global")
+
+			problems := toc.VerifyMetadataEntries("global", []byte(comment.Statement+create.Statement+role1.Statement))
+
+			Expect(problems).To(BeEmpty())
+		})
+		It("returns a problem for an entry that does not fit in the file", func() {
+			backupfile.ByteCount = commentLen + createLen
+			toc.AddMetadataEntry("", "somedatabase", "DATABASE", commentLen, backupfile, "global")
+
+			problems := toc.VerifyMetadataEntries("global", []byte(comment.Statement))
+
+			Expect(problems).To(Equal([]string{"The global entry for DATABASE somedatabase has byte range 21-51, which does not fit in a file of 21 bytes"}))
+		})
+		It("returns a problem for an entry that does not contain a complete statement", func() {
+			backupfile.ByteCount = commentLen
+			toc.AddMetadataEntry("", "somedatabase", "DATABASE", 0, backupfile, "global")
+
+			problems := toc.VerifyMetadataEntries("global", []byte(comment.Statement+create.Statement))
+
+			Expect(problems).To(Equal([]string{"The global entry for DATABASE somedatabase at byte range 0-21 does not contain a complete statement"}))
+		})
+	})
 })
@@ -7,9 +7,9 @@ package utils
 
 import (
 	"fmt"
-	"github.com/pkg/errors"
 	"regexp"
 	"strings"
+	"unicode"
 )
 
 /*
@@ -22,6 +22,10 @@ import (
  */
 
 func Abort(output ...interface{}) {
+	AbortWithExitCode(EXIT_ERROR, output...)
+}
+
+func AbortWithExitCode(exitCode int, output ...interface{}) {
 	errStr := ""
 	if len(output) > 0 {
 		errStr = fmt.Sprintf("%v", output[0])
@@ -29,7 +33,7 @@ func Abort(output ...interface{}) {
 			errStr = fmt.Sprintf(errStr, output[1:]...)
 		}
 	}
-	panic(errStr)
+	panic(FatalError{Message: errStr, ExitCode: exitCode})
 }
 
 func CheckError(err error) {
@@ -68,6 +72,20 @@ func DollarQuoteString(literal string) string {
 	return quoteStr + literal + quoteStr
 }
 
+func FormatSize(bytes uint64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size := float64(bytes)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", bytes, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
 // This function assumes that all identifiers are already appropriately quoted
 func MakeFQN(schema string, object string) string {
 	return fmt.Sprintf("%s.%s", schema, object)
@@ -80,7 +98,61 @@ func ValidateFQNs(fqns []string) {
 	var matches []string
 	for _, fqn := range fqns {
 		if matches = validFormat.FindStringSubmatch(fqn); len(matches) == 0 {
-			logger.Fatal(errors.Errorf(`Table %s is not correctly fully-qualified.  Please ensure that it is in the format schema.table, it is quoted appropriately, and it has no preceding or trailing whitespace.`, fqn), "")
+			logger.Fatal(NewFlagError(`Table %s is not correctly fully-qualified.  Please ensure that it is in the format schema.table, it is quoted appropriately, and it has no preceding or trailing whitespace.`, fqn), "")
+		}
+	}
+}
+
+/*
+ * Quoted identifiers may contain any character, so the functions below only
+ * split names on characters outside of double quotes.  A doubled quote inside
+ * a quoted identifier toggles the quoting twice, so it needs no special case.
+ */
+func indexOutsideQuotes(str string, matches func(rune) bool) int {
+	inQuotes := false
+	for i, char := range str {
+		if char == '"' {
+			inQuotes = !inQuotes
+		} else if !inQuotes && matches(char) {
+			return i
+		}
+	}
+	return -1
+}
+
+// This splits a list of column names, such as that of a distribution policy, on commas outside quotes
+func SplitColumnList(list string) []string {
+	columns := make([]string, 0)
+	isComma := func(char rune) bool { return char == ',' }
+	for i := indexOutsideQuotes(list, isComma); i != -1; i = indexOutsideQuotes(list, isComma) {
+		columns = append(columns, strings.TrimSpace(list[:i]))
+		list = list[i+1:]
+	}
+	return append(columns, strings.TrimSpace(list))
+}
+
+// This returns the text before the first whitespace outside quotes, and the trimmed text after it
+func SplitAtWhitespaceOutsideQuotes(line string) (string, string) {
+	i := indexOutsideQuotes(line, unicode.IsSpace)
+	if i == -1 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i:])
+}
+
+// This returns an empty list if any part of the name is empty
+func SplitQualifiedName(name string) []string {
+	parts := make([]string, 0)
+	isPeriod := func(char rune) bool { return char == '.' }
+	for i := indexOutsideQuotes(name, isPeriod); i != -1; i = indexOutsideQuotes(name, isPeriod) {
+		parts = append(parts, name[:i])
+		name = name[i+1:]
+	}
+	parts = append(parts, name)
+	for _, part := range parts {
+		if part == "" {
+			return []string{}
 		}
 	}
+	return parts
 }
@@ -45,6 +45,15 @@ var _ = Describe("utils/util tests", func() {
 			Expect(actual).To(Equal(expected))
 		})
 	})
+	Describe("FormatSize", func() {
+		It("formats a size in bytes", func() {
+			Expect(utils.FormatSize(512)).To(Equal("512 B"))
+		})
+		It("formats a size in larger units", func() {
+			Expect(utils.FormatSize(1536)).To(Equal("1.5 KB"))
+			Expect(utils.FormatSize(3 * 1024 * 1024 * 1024)).To(Equal("3.0 GB"))
+		})
+	})
 	Describe("ValidateFQNs", func() {
 		It("validates an unquoted string", func() {
 			testStrings := []string{`schemaname.tablename`}
@@ -83,4 +92,40 @@ var _ = Describe("utils/util tests", func() {
 			utils.ValidateFQNs(testStrings)
 		})
 	})
+	Describe("SplitColumnList", func() {
+		It("splits a list of columns on commas outside quotes", func() {
+			Expect(utils.SplitColumnList(`a, "b, c", "d""e"`)).To(Equal([]string{"a", `"b, c"`, `"d""e"`}))
+		})
+		It("returns a single column for a list without commas", func() {
+			Expect(utils.SplitColumnList("a")).To(Equal([]string{"a"}))
+		})
+	})
+	Describe("SplitQualifiedName", func() {
+		It("splits a name on periods", func() {
+			Expect(utils.SplitQualifiedName("public.foo.bar")).To(Equal([]string{"public", "foo", "bar"}))
+		})
+		It("does not split on periods inside double quotes", func() {
+			Expect(utils.SplitQualifiedName(`"my.schema".foo."a.b"`)).To(Equal([]string{`"my.schema"`, "foo", `"a.b"`}))
+		})
+		It("returns an empty list if any part of the name is empty", func() {
+			Expect(utils.SplitQualifiedName("public..bar")).To(BeEmpty())
+		})
+	})
+	Describe("SplitAtWhitespaceOutsideQuotes", func() {
+		It("splits a line at the first whitespace", func() {
+			first, rest := utils.SplitAtWhitespaceOutsideQuotes("public.foo  WHERE i > 10 ")
+			Expect(first).To(Equal("public.foo"))
+			Expect(rest).To(Equal("WHERE i > 10"))
+		})
+		It("does not split on whitespace inside double quotes", func() {
+			first, rest := utils.SplitAtWhitespaceOutsideQuotes(`"my schema"."my table"	public.bar`)
+			Expect(first).To(Equal(`"my schema"."my table"`))
+			Expect(rest).To(Equal("public.bar"))
+		})
+		It("returns the whole line if it contains no whitespace outside quotes", func() {
+			first, rest := utils.SplitAtWhitespaceOutsideQuotes(`public."my table"`)
+			Expect(first).To(Equal(`public."my table"`))
+			Expect(rest).To(Equal(""))
+		})
+	})
 })